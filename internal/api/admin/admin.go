@@ -1,6 +1,10 @@
 package admin
 
 import (
+	"fmt"
+	"net/http"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
 )
 
@@ -13,3 +17,15 @@ func New(b *telegram.TipBot) Service {
 		bot: b,
 	}
 }
+
+// actor names the caller of an admin endpoint in the audit log.
+func actor(r *http.Request) string {
+	if actor := audit.Actor(r.Context()); actor != "" {
+		return actor
+	}
+	return fmt.Sprintf("admin@%s", r.RemoteAddr)
+}
+
+func record(r *http.Request, action string, target string, result string) {
+	audit.Record(audit.Entry{Actor: actor(r), Action: action, Target: target, Result: result})
+}
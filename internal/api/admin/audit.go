@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/api"
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
+	log "github.com/sirupsen/logrus"
+)
+
+const auditDefaultLimit = 1000
+
+// AuditLog returns the audit entries of a user in a time range.
+// Query parameters: user (telegram id), from and to (RFC 3339), limit.
+func (s Service) AuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		log.Errorf("[ADMIN] invalid audit query: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if audit.L == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	entries, err := audit.L.Query(filter)
+	if err != nil {
+		log.Errorf("[ADMIN] could not query audit log: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = api.WriteResponse(w, entries)
+	if err != nil {
+		log.Errorf("[ADMIN] could not write audit log: %v", err)
+	}
+}
+
+// VerifyAuditLog checks the hash chain of the audit log.
+func (s Service) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	if audit.L == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	broken, err := audit.L.Verify()
+	if err != nil {
+		log.Errorf("[ADMIN] could not verify audit log: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if broken != 0 {
+		log.Errorf("[ADMIN] audit log is broken at entry %d", broken)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = api.WriteResponse(w, map[string]interface{}{"intact": broken == 0, "broken_entry": broken})
+	if err != nil {
+		log.Errorf("[ADMIN] could not write audit verification: %v", err)
+	}
+}
+
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{User: query.Get("user"), Limit: auditDefaultLimit}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+			return filter, fmt.Errorf("invalid limit")
+		}
+	}
+	return filter, nil
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
 	"github.com/gorilla/mux"
@@ -15,11 +16,13 @@ func (s Service) UnbanUser(w http.ResponseWriter, r *http.Request) {
 	user, err := s.getUserByTelegramId(r)
 	if err != nil {
 		log.Errorf("[ADMIN] could not ban user: %v", err)
+		record(r, "unban", mux.Vars(r)["id"], audit.ResultFailed)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	if !user.Banned && !strings.HasPrefix(user.Wallet.Adminkey, "banned_") {
 		log.Infof("[ADMIN] user is not banned. Aborting.")
+		record(r, "unban", user.Name, audit.ResultFailed)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -29,8 +32,10 @@ func (s Service) UnbanUser(w http.ResponseWriter, r *http.Request) {
 	err = telegram.UpdateUserRecord(user, *s.bot)
 	if err != nil {
 		log.Errorf("[ADMIN] could not update user: %v", err)
+		record(r, "unban", user.Name, audit.ResultFailed)
 		return
 	}
+	record(r, "unban", user.Name, audit.ResultOk)
 	log.Infof("[ADMIN] Unbanned user (%s)", user.ID)
 	w.WriteHeader(http.StatusOK)
 }
@@ -39,10 +44,12 @@ func (s Service) BanUser(w http.ResponseWriter, r *http.Request) {
 	user, err := s.getUserByTelegramId(r)
 	if err != nil {
 		log.Errorf("[ADMIN] could not ban user: %v", err)
+		record(r, "ban", mux.Vars(r)["id"], audit.ResultFailed)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	if user.Banned {
+		record(r, "ban", user.Name, audit.ResultFailed)
 		w.WriteHeader(http.StatusBadRequest)
 		log.Infof("[ADMIN] user is already banned. Aborting.")
 		return
@@ -55,9 +62,10 @@ func (s Service) BanUser(w http.ResponseWriter, r *http.Request) {
 	err = telegram.UpdateUserRecord(user, *s.bot)
 	if err != nil {
 		log.Errorf("[ADMIN] could not update user: %v", err)
+		record(r, "ban", user.Name, audit.ResultFailed)
 		return
 	}
-
+	record(r, "ban", user.Name, audit.ResultOk)
 	log.Infof("[ADMIN] Banned user (%s)", user.ID)
 	w.WriteHeader(http.StatusOK)
 }
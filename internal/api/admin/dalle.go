@@ -1,14 +1,18 @@
 package admin
 
 import (
-	"github.com/LightningTipBot/LightningTipBot/internal/dalle"
 	"net/http"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
+	"github.com/LightningTipBot/LightningTipBot/internal/dalle"
 )
 
 func (s Service) DisableDalle(w http.ResponseWriter, r *http.Request) {
 	dalle.Enabled = false
+	record(r, "dalle_disable", "", audit.ResultOk)
 }
 
 func (s Service) EnableDalle(w http.ResponseWriter, r *http.Request) {
 	dalle.Enabled = true
+	record(r, "dalle_enable", "", audit.ResultOk)
 }
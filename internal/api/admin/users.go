@@ -0,0 +1,210 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/api"
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const transactionsDefaultLimit = 50
+
+// UserResponse is what support sees of a user. It never contains wallet keys.
+type UserResponse struct {
+	Name       string              `json:"name"`
+	TelegramID int64               `json:"telegram_id"`
+	Username   string              `json:"username"`
+	WalletID   string              `json:"wallet_id"`
+	Banned     bool                `json:"banned"`
+	StateKey   lnbits.UserStateKey `json:"state_key"`
+	StateData  string              `json:"state_data"`
+	Balance    int64               `json:"balance"`
+	Settings   *SettingsResponse   `json:"settings"`
+	CreatedAt  time.Time           `json:"created"`
+	UpdatedAt  time.Time           `json:"updated"`
+}
+
+// SettingsResponse are the settings of a user without the credentials of
+// their own node.
+type SettingsResponse struct {
+	Display  lnbits.DisplaySettings `json:"display"`
+	Nostr    lnbits.NostrSettings   `json:"nostr"`
+	Limits   lnbits.LimitSettings   `json:"limits"`
+	NodeType string                 `json:"nodetype"`
+}
+
+func settingsResponse(settings *lnbits.Settings) *SettingsResponse {
+	if settings == nil {
+		return nil
+	}
+	return &SettingsResponse{
+		Display:  settings.Display,
+		Nostr:    settings.Nostr,
+		Limits:   settings.Limits,
+		NodeType: settings.Node.NodeType,
+	}
+}
+
+type AdjustBalanceRequest struct {
+	// Amount in sat, positive amounts credit the user, negative amounts debit
+	Amount int64  `json:"amount"`
+	Reason string `json:"reason"`
+	// IdempotencyKey identifies the adjustment. A retry with the same key
+	// returns the first adjustment instead of booking it again.
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+func writeJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := api.WriteResponse(w, response)
+	if err != nil {
+		log.Errorf("[ADMIN] could not write response: %v", err)
+	}
+}
+
+// userResponse loads the settings and the balance of user.
+func (s Service) userResponse(user *lnbits.User) (UserResponse, error) {
+	if user.Telegram == nil || user.Wallet == nil {
+		return UserResponse{}, fmt.Errorf("user %s has no wallet", user.Name)
+	}
+	withSettings, err := telegram.GetLnbitsUserWithSettings(user.Telegram, *s.bot)
+	if err != nil {
+		return UserResponse{}, err
+	}
+	balance, err := s.bot.GetUserBalance(withSettings)
+	if err != nil {
+		return UserResponse{}, err
+	}
+	return UserResponse{
+		Name:       withSettings.Name,
+		TelegramID: withSettings.Telegram.ID,
+		Username:   withSettings.Telegram.Username,
+		WalletID:   withSettings.Wallet.ID,
+		Banned:     withSettings.Banned,
+		StateKey:   withSettings.StateKey,
+		StateData:  withSettings.StateData,
+		Balance:    balance,
+		Settings:   settingsResponse(withSettings.Settings),
+		CreatedAt:  withSettings.CreatedAt,
+		UpdatedAt:  withSettings.UpdatedAt,
+	}, nil
+}
+
+// LookupUser finds a user by one of the query parameters telegram_id, username or wallet_id.
+func (s Service) LookupUser(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	user := &lnbits.User{}
+	tx := s.bot.DB.Users
+	switch {
+	case query.Get("telegram_id") != "":
+		tx = tx.Where("telegram_id = ?", query.Get("telegram_id")).First(user)
+	case query.Get("username") != "":
+		tx = tx.Where("telegram_username = ? COLLATE NOCASE", strings.TrimPrefix(query.Get("username"), "@")).First(user)
+	case query.Get("wallet_id") != "":
+		tx = tx.Where("wallet_id = ?", query.Get("wallet_id")).First(user)
+	default:
+		http.Error(w, "telegram_id, username or wallet_id required", http.StatusBadRequest)
+		return
+	}
+	if tx.Error != nil {
+		log.Warnf("[ADMIN] user lookup %s: %v", r.URL.RawQuery, tx.Error)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	response, err := s.userResponse(user)
+	if err != nil {
+		log.Errorf("[ADMIN] could not load user %s: %v", user.Name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	record(r, "user_lookup", user.Name, audit.ResultOk)
+	writeJSON(w, response)
+}
+
+// UserTransactions lists the latest transactions of a user. Query parameter: limit.
+func (s Service) UserTransactions(w http.ResponseWriter, r *http.Request) {
+	user, err := s.getUserByTelegramId(r)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	limit := transactionsDefaultLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	transactions := make([]telegram.Transaction, 0)
+	tx := s.bot.DB.Transactions.
+		Where("from_id = ? OR to_id = ?", user.Telegram.ID, user.Telegram.ID).
+		Order("id desc").Limit(limit).Find(&transactions)
+	if tx.Error != nil {
+		log.Errorf("[ADMIN] could not load transactions of %s: %v", user.Name, tx.Error)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, transactions)
+}
+
+// ResetUserState clears a stuck state key of a user.
+func (s Service) ResetUserState(w http.ResponseWriter, r *http.Request) {
+	user, err := s.getUserByTelegramId(r)
+	if err != nil {
+		record(r, "reset_state", mux.Vars(r)["id"], audit.ResultFailed)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	telegram.ResetUserState(user, s.bot)
+	record(r, "reset_state", user.Name, audit.ResultOk)
+	log.Infof("[ADMIN] Reset state of user (%s)", user.Name)
+	response, err := s.userResponse(user)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, response)
+}
+
+// AdjustBalance credits or debits a user from the bot wallet. Body: AdjustBalanceRequest.
+func (s Service) AdjustBalance(w http.ResponseWriter, r *http.Request) {
+	user, err := s.getUserByTelegramId(r)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	request := AdjustBalanceRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Amount == 0 || strings.TrimSpace(request.Reason) == "" || strings.TrimSpace(request.IdempotencyKey) == "" {
+		http.Error(w, "amount, reason and idempotency_key required", http.StatusBadRequest)
+		return
+	}
+	entry := audit.Entry{Actor: actor(r), Action: "adjust_balance", Target: user.Name, Amount: request.Amount, Detail: request.Reason}
+	t, err := s.bot.AdjustBalance(user, request.Amount, request.Reason, request.IdempotencyKey)
+	if err != nil {
+		log.Errorf("[ADMIN] could not adjust balance of %s by %d: %v", user.Name, request.Amount, err)
+		entry.Result, entry.Detail = audit.ResultFailed, fmt.Sprintf("%s: %v", request.Reason, err)
+		audit.Record(entry)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entry.Result = audit.ResultOk
+	audit.Record(entry)
+	writeJSON(w, map[string]interface{}{
+		"id":        t.ID,
+		"from_user": t.FromUser,
+		"to_user":   t.ToUser,
+		"amount":    t.Amount,
+		"memo":      t.Memo,
+		"status":    t.Status,
+	})
+}
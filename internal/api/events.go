@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/events"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// streamKeepAlive is the interval of keep-alive comments. A failing write ends the stream.
+const streamKeepAlive = 20 * time.Second
+
+// streamWriteTimeout bounds every single write to a stream.
+const streamWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{
+	// API clients authenticate with their keys, not with cookies
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// eventFilter reads the filter query parameters direction (incoming or outgoing),
+// min_amount and memo_prefix.
+func eventFilter(r *http.Request) (events.Filter, error) {
+	query := r.URL.Query()
+	filter := events.Filter{MemoPrefix: query.Get("memo_prefix")}
+	switch query.Get("direction") {
+	case "":
+	case "incoming":
+		filter.IncomingOnly = true
+	case "outgoing":
+		filter.OutgoingOnly = true
+	default:
+		return filter, fmt.Errorf("direction must be incoming or outgoing")
+	}
+	if minAmount := query.Get("min_amount"); minAmount != "" {
+		amount, err := strconv.ParseInt(minAmount, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid min_amount")
+		}
+		filter.MinAmount = amount
+	}
+	return filter, nil
+}
+
+// EventStream streams the payments of the user as server-sent events or, if the
+// client asks for an upgrade, over a WebSocket.
+func (s Service) EventStream(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	filter, err := eventFilter(r)
+	if err != nil {
+		RespondError(w, err.Error())
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		s.eventWebSocket(w, r, user.Name, filter)
+		return
+	}
+	serveEvents(w, r, user.Name, filter, func(e events.Event) interface{} { return e })
+}
+
+func (s Service) eventWebSocket(w http.ResponseWriter, r *http.Request, user string, filter events.Filter) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debugf("[api] websocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	subscription := events.B.Subscribe(user, filter)
+	defer subscription.Close()
+
+	// the reader notices when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case e := <-subscription.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(map[string]interface{}{"event": e.Name(), "data": e}); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// serveEvents writes the events of user as server-sent events until the client goes away.
+func serveEvents(w http.ResponseWriter, r *http.Request, user string, filter events.Filter, data func(events.Event) interface{}) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+	subscription := events.B.Subscribe(user, filter)
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	setWriteDeadline(r, time.Now().Add(streamWriteTimeout))
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-subscription.C:
+			payload, err := json.Marshal(data(e))
+			if err != nil {
+				log.Errorf("[api] could not encode event: %v", err)
+				continue
+			}
+			setWriteDeadline(r, time.Now().Add(streamWriteTimeout))
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Name(), payload); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			setWriteDeadline(r, time.Now().Add(streamWriteTimeout))
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/export"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	log "github.com/sirupsen/logrus"
+)
+
+// Export returns the transactions of the wallet as csv, json or ofx.
+// Query parameters: format, from and to (YYYY-MM-DD).
+func (s Service) Export(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	query := r.URL.Query()
+	format, err := export.ParseFormat(query.Get("format"))
+	if err != nil {
+		RespondError(w, err.Error())
+		return
+	}
+	from, to, err := telegram.ParseExportRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		RespondError(w, err.Error())
+		return
+	}
+	statement, err := s.Bot.ExportStatement(user, from, to)
+	if err != nil {
+		log.Errorf("[api] could not export transactions of %s: %v", user.Name, err)
+		RespondError(w, "export failed")
+		return
+	}
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"transactions-%s.%s\"", time.Now().UTC().Format("2006-01-02"), format))
+	w.WriteHeader(http.StatusOK)
+	if err := export.Write(w, format, statement); err != nil {
+		log.Errorf("[api] could not write export: %v", err)
+	}
+}
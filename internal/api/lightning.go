@@ -4,13 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/apikeys"
+	"github.com/LightningTipBot/LightningTipBot/internal/events"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
 	"github.com/gorilla/mux"
-	"github.com/r3labs/sse"
+	log "github.com/sirupsen/logrus"
 )
 
 type Service struct {
@@ -79,7 +80,7 @@ func (s Service) PayInvoice(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: payInvoiceRequest.PayRequest}, s.Bot.Client)
+	invoice, err := s.pay(r, user, payInvoiceRequest.PayRequest)
 	if err != nil {
 		RespondError(w, "could not pay invoice: "+err.Error())
 		return
@@ -96,6 +97,31 @@ func (s Service) PayInvoice(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(payment)
 }
 
+// pay pays bolt11 from the wallet of user. Every endpoint that spends from the
+// wallet has to pay through here, so that the spending cap of the api key holds.
+// The reserved amount is only given back if the payment definitely failed.
+func (s Service) pay(r *http.Request, user *lnbits.User, bolt11 string) (lnbits.Invoice, error) {
+	key, capped := apikeys.FromContext(r.Context())
+	var amount int64
+	if capped {
+		var err error
+		amount, err = lnbits.InvoiceAmount(bolt11)
+		if err != nil {
+			return lnbits.Invoice{}, fmt.Errorf("invalid invoice")
+		}
+		if !apikeys.Reserve(s.Bot.DB.Users, key, amount) {
+			return lnbits.Invoice{}, fmt.Errorf("spending cap of api key exceeded")
+		}
+	}
+	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: bolt11}, s.Bot.Client)
+	if err != nil && capped && lnbits.PaymentFailed(err) {
+		if err := apikeys.Release(s.Bot.DB.Users, key, amount); err != nil {
+			log.Errorf("[api] could not release spending cap of key %s: %v", key.ID, err)
+		}
+	}
+	return invoice, err
+}
+
 func (s Service) PaymentStatus(w http.ResponseWriter, r *http.Request) {
 	user := telegram.LoadUser(r.Context())
 	payment_hash := mux.Vars(r)["payment_hash"]
@@ -141,48 +167,17 @@ type InvoiceStream struct {
 	WebhookStatus interface{} `json:"webhook_status"`
 }
 
+// InvoiceStream streams incoming payments in the format of the LNbits payments SSE.
 func (s Service) InvoiceStream(w http.ResponseWriter, r *http.Request) {
 	user := telegram.LoadUser(r.Context())
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
-		return
-	}
-	client := sse.NewClient(fmt.Sprintf("%s/api/v1/payments/sse", internal.Configuration.Lnbits.Url))
-	client.Connection.Transport = &http.Transport{DisableCompression: true}
-	client.Headers = map[string]string{"X-Api-Key": user.Wallet.Inkey}
-	c := make(chan *sse.Event)
-	err := client.SubscribeChan("", c)
-	if err != nil {
-		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
-		return
-	}
-out:
-	for {
-		select {
-		case msg := <-c:
-			select {
-			case <-r.Context().Done():
-				client.Unsubscribe(c)
-				break out
-			default:
-				written, err := fmt.Fprintf(w, "event: %s\n", string(msg.Event))
-				if err != nil || written == 0 {
-					break out
-				}
-				written, err = fmt.Fprintf(w, "data: %s\n", string(msg.Data))
-				if err != nil || written == 0 {
-					break out
-				}
-				written, err = fmt.Fprint(w, "\n")
-				if err != nil || written == 0 {
-					break out
-				}
-				flusher.Flush()
-			}
+	serveEvents(w, r, user.Name, events.Filter{IncomingOnly: true}, func(e events.Event) interface{} {
+		return InvoiceStream{
+			CheckingID:  e.PaymentHash,
+			Amount:      int(e.Amount * 1000),
+			Memo:        e.Memo,
+			Time:        int(e.Time.Unix()),
+			PaymentHash: e.PaymentHash,
+			WalletID:    e.WalletID,
 		}
-
-	}
-	close(c)
-	time.Sleep(time.Second * 5)
+	})
 }
@@ -2,12 +2,17 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"strings"
+	"time"
 
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/apikeys"
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
 	"gorm.io/gorm"
@@ -33,13 +38,15 @@ var AuthTypeBasic = AuthType{Type: "Basic"}
 var AuthTypeBearerBase64 = AuthType{Type: "Bearer", Decoder: base64.StdEncoding.DecodeString}
 var AuthTypeNone = AuthType{}
 
-// invoice key or admin key requirement
+// invoice key or admin key requirement. Bot-issued api keys need the scope instead.
 type AccessKeyType struct {
-	Type string
+	Type  string
+	Scope apikeys.Scope
 }
 
-var AccessKeyTypeInvoice = AccessKeyType{Type: "invoice"}
-var AccessKeyTypeAdmin = AccessKeyType{Type: "admin"}
+var AccessKeyTypeInvoice = AccessKeyType{Type: "invoice", Scope: apikeys.ScopeRead}
+var AccessKeyTypeReceive = AccessKeyType{Type: "invoice", Scope: apikeys.ScopeReceive}
+var AccessKeyTypeAdmin = AccessKeyType{Type: "admin", Scope: apikeys.ScopeSend}
 var AccessKeyTypeNone = AccessKeyType{Type: "none"} // no authorization required
 
 func AuthorizationMiddleware(database *gorm.DB, authType AuthType, accessType AccessKeyType, next http.HandlerFunc) http.HandlerFunc {
@@ -66,6 +73,35 @@ func AuthorizationMiddleware(database *gorm.DB, authType AuthType, accessType Ac
 			log.Warnf("[api] Banned user %s. Not forwarding request", password)
 			return
 		}
+		// bot-issued api keys are mapped to their owner
+		if strings.HasPrefix(password, apikeys.Prefix) {
+			key, err := apikeys.Authenticate(database, password)
+			if err != nil {
+				w.WriteHeader(401)
+				return
+			}
+			if !key.Has(accessType.Scope) {
+				log.Warnf("[api] api key %s lacks scope %s for %s", key.ID, accessType.Scope, r.URL.Path)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			user := &lnbits.User{}
+			if err := database.Where("name = ?", key.Owner).First(user).Error; err != nil || user.Banned {
+				w.WriteHeader(401)
+				return
+			}
+			log.Debugf("[api] User: %s Key: %s Endpoint: %s %s %s", telegram.GetUserStr(user.Telegram), key.ID, r.Method, r.URL.Path, r.URL.RawQuery)
+			ctx := context.WithValue(r.Context(), "user", user)
+			r = r.WithContext(apikeys.ContextWithKey(ctx, key))
+			next.ServeHTTP(w, r)
+			return
+		}
+		// raw wallet keys are only accepted within the deprecation window
+		if !internal.Configuration.API.LegacyWalletKeysAccepted(time.Now()) {
+			log.Warnf("[api] raw wallet key rejected for %s, use an /api key", r.URL.Path)
+			w.WriteHeader(401)
+			return
+		}
 		// then we check whether the "normal" password provided is in the database (it should be not if the user is banned)
 
 		user := &lnbits.User{}
@@ -85,12 +121,60 @@ func AuthorizationMiddleware(database *gorm.DB, authType AuthType, accessType Ac
 			return
 		}
 
-		log.Debugf("[api] User: %s Endpoint: %s %s %s", telegram.GetUserStr(user.Telegram), r.Method, r.URL.Path, r.URL.RawQuery)
+		log.Warnf("[api] User: %s Endpoint: %s %s %s with a deprecated raw wallet key", telegram.GetUserStr(user.Telegram), r.Method, r.URL.Path, r.URL.RawQuery)
 		r = r.WithContext(context.WithValue(r.Context(), "user", user))
 		next.ServeHTTP(w, r)
 	}
 }
 
+// AdminScope is a permission of an admin api token.
+type AdminScope string
+
+const (
+	// AdminScopeRead allows to look at users, transactions, the audit log and the runtime
+	AdminScopeRead AdminScope = "read"
+	// AdminScopeModeration allows to ban users, reset states, toggle features and unlock mutexes
+	AdminScopeModeration AdminScope = "moderation"
+	// AdminScopeFunds allows to move funds
+	AdminScopeFunds AdminScope = "funds"
+)
+
+// AdminAuthorizationMiddleware only passes requests with an admin api token from the
+// configuration that has the scope. The name of the token is the actor in the audit log.
+func AdminAuthorizationMiddleware(scope AdminScope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, password, ok := parseAuth(AuthType{Type: "Bearer"}, r.Header.Get("Authorization"))
+		if !ok || password == "" {
+			log.Warnf("[api] admin request without token from %s to %s", r.RemoteAddr, r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var token *internal.AdminTokenConfiguration
+		for i, t := range internal.Configuration.Bot.AdminAPITokens {
+			if subtle.ConstantTimeCompare([]byte(password), []byte(t.Token)) == 1 {
+				token = &internal.Configuration.Bot.AdminAPITokens[i]
+			}
+		}
+		if token == nil {
+			log.Warnf("[api] admin request with invalid token from %s to %s", r.RemoteAddr, r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		allowed := false
+		for _, s := range token.Scopes {
+			allowed = allowed || AdminScope(s) == scope
+		}
+		if !allowed {
+			log.Warnf("[api] admin token %s lacks scope %s for %s", token.Name, scope, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		log.Debugf("[api] Admin token: %s Endpoint: %s %s %s", token.Name, r.Method, r.URL.Path, r.URL.RawQuery)
+		r = r.WithContext(audit.ContextWithActor(r.Context(), fmt.Sprintf("admin:%s@%s", token.Name, r.RemoteAddr)))
+		next.ServeHTTP(w, r)
+	}
+}
+
 // parseAuth parses an HTTP Basic Authentication string.
 // "Bearer QWxhZGRpbjpvcGVuIHNlc2FtZQ==" returns ("Aladdin", "open sesame", true).
 func parseAuth(authType AuthType, auth string) (username, password string, ok bool) {
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 	"time"
 
@@ -21,12 +23,26 @@ const (
 	StatusOk    = "OK"
 )
 
+type connContextKey struct{}
+
+// setWriteDeadline sets the write deadline of the connection that serves r. The
+// deadline set by the server's WriteTimeout would end long-lived streams.
+func setWriteDeadline(r *http.Request, deadline time.Time) {
+	if conn, ok := r.Context().Value(connContextKey{}).(net.Conn); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+}
+
 func NewServer(address string) *Server {
 	srv := &http.Server{
 		Addr: address,
 		// Good practice: enforce timeouts for servers you create!
 		WriteTimeout: 90 * time.Second,
 		ReadTimeout:  90 * time.Second,
+		// streams replace the write timeout with their own deadline per write
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, connContextKey{}, c)
+		},
 	}
 	apiServer := &Server{
 		httpServer: srv,
@@ -50,6 +66,19 @@ func (w *Server) AppendAuthorizedRoute(path string, authType AuthType, accessTyp
 		r.Methods(methods...)
 	}
 }
+
+// AppendAdminRoute adds a route that needs an admin api token with the scope.
+func (w *Server) AppendAdminRoute(path string, scope AdminScope, handler func(http.ResponseWriter, *http.Request), methods ...string) {
+	r := w.router.HandleFunc(path, LoggingMiddleware("ADMIN", AdminAuthorizationMiddleware(scope, handler)))
+	if len(methods) > 0 {
+		r.Methods(methods...)
+	}
+}
+
+// AdminPathPrefix is PathPrefix for handlers that need an admin api token with the scope.
+func (w *Server) AdminPathPrefix(path string, scope AdminScope, handler http.Handler) {
+	w.router.PathPrefix(path).Handler(LoggingMiddleware("ADMIN", AdminAuthorizationMiddleware(scope, handler.ServeHTTP)))
+}
 func (w *Server) AppendRoute(path string, handler func(http.ResponseWriter, *http.Request), methods ...string) {
 	r := w.router.HandleFunc(path, LoggingMiddleware("API", handler))
 	if len(methods) > 0 {
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	"github.com/LightningTipBot/LightningTipBot/internal/webhooks"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+type RegisterWebhookRequest struct {
+	URL string `json:"url"`
+	// Events to deliver, all events if empty
+	Events []string `json:"events"`
+}
+
+const webhookDeliveriesLimit = 100
+
+func writeJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RegisterWebhook adds a webhook endpoint. The response contains the secret the
+// deliveries are signed with. It is only shown once.
+func (s Service) RegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	var request RegisterWebhookRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	endpoint, err := webhooks.D.Register(user.Name, request.URL, request.Events)
+	if err != nil {
+		RespondError(w, "could not register webhook: "+err.Error())
+		return
+	}
+	log.Infof("[api] %s registered webhook %d", telegram.GetUserStr(user.Telegram), endpoint.ID)
+	writeJSON(w, endpoint)
+}
+
+func (s Service) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	endpoints, err := webhooks.D.Endpoints(user.Name)
+	if err != nil {
+		RespondError(w, "could not load webhooks")
+		return
+	}
+	writeJSON(w, endpoints)
+}
+
+func (s Service) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		RespondError(w, "invalid webhook id")
+		return
+	}
+	if err := webhooks.D.Delete(user.Name, uint(id)); err != nil {
+		RespondError(w, "could not delete webhook")
+		return
+	}
+	writeJSON(w, map[string]bool{"deleted": true})
+}
+
+// WebhookDeliveries returns the delivery log of a webhook endpoint.
+func (s Service) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		RespondError(w, "invalid webhook id")
+		return
+	}
+	deliveries, err := webhooks.D.Deliveries(user.Name, uint(id), webhookDeliveriesLimit)
+	if err != nil {
+		RespondError(w, "could not load deliveries")
+		return
+	}
+	writeJSON(w, deliveries)
+}
@@ -0,0 +1,180 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scope is a permission of an API key.
+type Scope string
+
+const (
+	// ScopeRead allows to look at the balance, payments and events
+	ScopeRead Scope = "read"
+	// ScopeReceive allows to create invoices
+	ScopeReceive Scope = "receive"
+	// ScopeSend allows to pay invoices and to manage webhooks
+	ScopeSend Scope = "send"
+)
+
+// Prefix marks bot-issued keys. It can't contain "_", the API rejects such keys as banned.
+const Prefix = "ltbk"
+
+// MaxKeys is the number of keys a user can have.
+const MaxKeys = 20
+
+var ErrInvalidKey = fmt.Errorf("invalid api key")
+
+// Key is an API key of a user. Only the hash of the key is stored, the key
+// itself is shown once when it is created.
+type Key struct {
+	ID        string    `json:"id" gorm:"primarykey"`
+	Owner     string    `json:"-" gorm:"index"` // name of the lnbits.User
+	Label     string    `json:"label"`
+	Hash      string    `json:"-" gorm:"uniqueIndex"`
+	Scopes    string    `json:"scopes"`    // comma separated
+	SpendCap  int64     `json:"spend_cap"` // sat, zero means no cap
+	Spent     int64     `json:"spent"`     // sat
+	LastUsed  time.Time `json:"last_used"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Key) TableName() string {
+	return "api_keys"
+}
+
+// Has reports whether the key has scope.
+func (k Key) Has(scope Scope) bool {
+	for _, s := range strings.Split(k.Scopes, ",") {
+		if Scope(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Key{})
+}
+
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// ParseScopes parses a comma separated list of scopes.
+func ParseScopes(s string) ([]Scope, error) {
+	scopes := make([]Scope, 0)
+	for _, scope := range strings.Split(strings.ToLower(s), ",") {
+		switch Scope(scope) {
+		case ScopeRead, ScopeReceive, ScopeSend:
+			scopes = append(scopes, Scope(scope))
+		default:
+			return nil, fmt.Errorf("unknown scope %s", scope)
+		}
+	}
+	return scopes, nil
+}
+
+// Create issues a key for owner and returns it with the secret key.
+func Create(db *gorm.DB, owner string, label string, scopes []Scope, spendCap int64) (Key, string, error) {
+	var count int64
+	if err := db.Model(&Key{}).Where("owner = ?", owner).Count(&count).Error; err != nil {
+		return Key{}, "", err
+	}
+	if count >= MaxKeys {
+		return Key{}, "", fmt.Errorf("too many api keys")
+	}
+	b := make([]byte, 28)
+	if _, err := rand.Read(b); err != nil {
+		return Key{}, "", err
+	}
+	secret := Prefix + hex.EncodeToString(b)
+	names := make([]string, len(scopes))
+	for i, s := range scopes {
+		names[i] = string(s)
+	}
+	key := Key{
+		// the id is part of the key, it is safe to show
+		ID:        hex.EncodeToString(b[:4]),
+		Owner:     owner,
+		Label:     label,
+		Hash:      hashKey(secret),
+		Scopes:    strings.Join(names, ","),
+		SpendCap:  spendCap,
+		CreatedAt: time.Now(),
+	}
+	return key, secret, db.Create(&key).Error
+}
+
+// Keys returns the keys of owner, newest first.
+func Keys(db *gorm.DB, owner string) ([]Key, error) {
+	keys := make([]Key, 0)
+	err := db.Where("owner = ?", owner).Order("created_at desc").Find(&keys).Error
+	return keys, err
+}
+
+// Revoke deletes the key id of owner.
+func Revoke(db *gorm.DB, owner string, id string) error {
+	tx := db.Where("owner = ? AND id = ?", owner, id).Delete(&Key{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("api key %s not found", id)
+	}
+	return nil
+}
+
+// RevokeAll deletes all keys of owner.
+func RevokeAll(db *gorm.DB, owner string) error {
+	return db.Where("owner = ?", owner).Delete(&Key{}).Error
+}
+
+// Authenticate returns the key of a secret key and updates its last use.
+func Authenticate(db *gorm.DB, secret string) (Key, error) {
+	key := Key{}
+	if !strings.HasPrefix(secret, Prefix) {
+		return key, ErrInvalidKey
+	}
+	if err := db.Where("hash = ?", hashKey(secret)).First(&key).Error; err != nil {
+		return key, ErrInvalidKey
+	}
+	key.LastUsed = time.Now()
+	db.Model(&Key{}).Where("id = ?", key.ID).UpdateColumn("last_used", key.LastUsed)
+	return key, nil
+}
+
+// Reserve adds amount to the spent sats of the key. It fails if the spending cap would be exceeded.
+func Reserve(db *gorm.DB, key Key, amount int64) bool {
+	tx := db.Model(&Key{}).
+		Where("id = ? AND (spend_cap = 0 OR spent + ? <= spend_cap)", key.ID, amount).
+		UpdateColumn("spent", gorm.Expr("spent + ?", amount))
+	return tx.Error == nil && tx.RowsAffected == 1
+}
+
+// Release gives back a reserved amount after a failed payment.
+func Release(db *gorm.DB, key Key, amount int64) error {
+	return db.Model(&Key{}).Where("id = ?", key.ID).UpdateColumn("spent", gorm.Expr("spent - ?", amount)).Error
+}
+
+type keyContextKey struct{}
+
+// ContextWithKey stores the key that authenticated a request.
+func ContextWithKey(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// FromContext returns the key stored with ContextWithKey. Requests with the raw
+// wallet keys have none.
+func FromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(keyContextKey{}).(Key)
+	return key, ok
+}
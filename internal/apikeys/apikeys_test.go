@@ -0,0 +1,55 @@
+package apikeys
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestKeys(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseScopes("read,admin"); err == nil {
+		t.Fatal("ParseScopes() accepted an unknown scope")
+	}
+	scopes, _ := ParseScopes("read,send")
+	key, secret, err := Create(db, "alice", "shop", scopes, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticated, err := Authenticate(db, secret)
+	if err != nil || authenticated.ID != key.ID || authenticated.LastUsed.IsZero() {
+		t.Fatalf("Authenticate() = %+v, %v", authenticated, err)
+	}
+	if !authenticated.Has(ScopeSend) || authenticated.Has(ScopeReceive) {
+		t.Errorf("Has() doesn't match scopes %s", authenticated.Scopes)
+	}
+	if _, err := Authenticate(db, secret+"0"); err != ErrInvalidKey {
+		t.Errorf("Authenticate() with wrong key = %v, want ErrInvalidKey", err)
+	}
+
+	if !Reserve(db, key, 60) || Reserve(db, key, 60) {
+		t.Errorf("Reserve() doesn't respect the spending cap of 100 sat")
+	}
+	Release(db, key, 60)
+	if !Reserve(db, key, 100) {
+		t.Errorf("Reserve() after Release() failed")
+	}
+
+	if err := Revoke(db, "bob", key.ID); err == nil {
+		t.Errorf("Revoke() removed the key of another user")
+	}
+	if err := Revoke(db, "alice", key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(db, secret); err != ErrInvalidKey {
+		t.Errorf("Authenticate() after Revoke() = %v, want ErrInvalidKey", err)
+	}
+}
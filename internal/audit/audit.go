@@ -0,0 +1,179 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	ResultOk     = "ok"
+	ResultFailed = "failed"
+	ResultDenied = "denied"
+)
+
+// Entry is one line of the audit log. Every entry contains the hash of the
+// previous entry, so that changing or removing an entry breaks the chain.
+type Entry struct {
+	ID       uint      `json:"id" gorm:"primarykey"`
+	Time     time.Time `json:"time" gorm:"index"`
+	Actor    string    `json:"actor" gorm:"index"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target" gorm:"index"`
+	Amount   int64     `json:"amount"`
+	Result   string    `json:"result"`
+	Detail   string    `json:"detail"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+// TableName keeps the table name stable if Entry is renamed.
+func (Entry) TableName() string {
+	return "audit_entries"
+}
+
+func (e Entry) computeHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		e.PrevHash,
+		strconv.FormatInt(e.Time.UnixNano(), 10),
+		e.Actor,
+		e.Action,
+		e.Target,
+		strconv.FormatInt(e.Amount, 10),
+		e.Result,
+		e.Detail,
+	} {
+		// length prefix, so that fields can't be shifted into each other
+		fmt.Fprintf(h, "%d:%s;", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Logger appends entries to the audit log. It never updates or deletes entries.
+type Logger struct {
+	db       *gorm.DB
+	mu       sync.Mutex
+	lastHash string
+}
+
+// L is the audit log of the bot. Record is a no-op until it is set by New.
+var L *Logger
+
+// New migrates the audit table in db and sets the logger as L.
+func New(db *gorm.DB) (*Logger, error) {
+	err := db.AutoMigrate(&Entry{})
+	if err != nil {
+		return nil, err
+	}
+	l := &Logger{db: db}
+	last := Entry{}
+	tx := db.Order("id desc").Limit(1).Find(&last)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	l.lastHash = last.Hash
+	L = l
+	return l, nil
+}
+
+// Record appends an entry to the audit log. Time, PrevHash and Hash are set by the logger.
+func (l *Logger) Record(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.ID = 0
+	// sqlite keeps microseconds, the hash has to survive a round trip
+	e.Time = time.Now().UTC().Truncate(time.Microsecond)
+	e.PrevHash = l.lastHash
+	e.Hash = e.computeHash()
+	tx := l.db.Create(&e)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	l.lastHash = e.Hash
+	return nil
+}
+
+// Record appends an entry to L. Errors are logged, an audit entry must never
+// stop the action it describes.
+func Record(e Entry) {
+	if L == nil {
+		return
+	}
+	if err := L.Record(e); err != nil {
+		log.Errorf("[audit] could not record %s by %s: %v", e.Action, e.Actor, err)
+	}
+}
+
+// Filter selects entries in Query. Empty fields match everything.
+type Filter struct {
+	// User matches the actor or the target of an entry
+	User  string
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+// Query returns the entries that match the filter, oldest first.
+func (l *Logger) Query(f Filter) ([]Entry, error) {
+	tx := l.db.Model(&Entry{})
+	if f.User != "" {
+		tx = tx.Where("actor = ? OR target = ?", f.User, f.User)
+	}
+	if !f.From.IsZero() {
+		tx = tx.Where("time >= ?", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		tx = tx.Where("time < ?", f.To.UTC())
+	}
+	if f.Limit > 0 {
+		tx = tx.Limit(f.Limit)
+	}
+	entries := make([]Entry, 0)
+	err := tx.Order("id asc").Find(&entries).Error
+	return entries, err
+}
+
+// Verify walks the whole chain. It returns the ID of the first entry that
+// does not match its hash or its predecessor, or 0 if the chain is intact.
+func (l *Logger) Verify() (uint, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var broken uint
+	prevHash := ""
+	entries := make([]Entry, 0)
+	err := l.db.Order("id asc").FindInBatches(&entries, 1000, func(tx *gorm.DB, batch int) error {
+		for _, e := range entries {
+			if e.PrevHash != prevHash || e.computeHash() != e.Hash {
+				broken = e.ID
+				return fmt.Errorf("audit entry %d is broken", e.ID)
+			}
+			prevHash = e.Hash
+		}
+		return nil
+	}).Error
+	if broken != 0 {
+		return broken, nil
+	}
+	return 0, err
+}
+
+type actorKey struct{}
+
+// ContextWithActor stores the actor of a request, e.g. the name of an admin token.
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor stored with ContextWithActor or an empty string.
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
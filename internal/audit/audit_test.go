@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestLogger(t *testing.T) *Logger {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLogger_Chain(t *testing.T) {
+	l := newTestLogger(t)
+	for _, e := range []Entry{
+		{Actor: "admin", Action: "ban", Target: "1", Result: ResultOk},
+		{Actor: "1", Action: "pay", Target: "hash", Amount: 100, Result: ResultOk},
+		{Actor: "2", Action: "pay", Target: "hash", Amount: 200, Result: ResultFailed},
+	} {
+		if err := l.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if broken, err := l.Verify(); broken != 0 || err != nil {
+		t.Fatalf("Verify() = %d, %v, want intact chain", broken, err)
+	}
+
+	entries, err := l.Query(Filter{User: "1"})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Query() = %d entries, %v, want 2", len(entries), err)
+	}
+	entries, err = l.Query(Filter{From: time.Now().Add(time.Hour)})
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("Query() in the future = %d entries, %v, want 0", len(entries), err)
+	}
+
+	// tamper with the amount of the second entry
+	l.db.Model(&Entry{}).Where("id = ?", 2).Update("amount", 1)
+	if broken, _ := l.Verify(); broken != 2 {
+		t.Errorf("Verify() = %d, want 2", broken)
+	}
+}
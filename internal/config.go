@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/configor"
 	log "github.com/sirupsen/logrus"
@@ -16,10 +17,59 @@ var Configuration = struct {
 	Lnbits   LnbitsConfiguration   `yaml:"lnbits"`
 	Generate GenerateConfiguration `yaml:"generate"`
 	Nostr    NostrConfiguration    `yaml:"nostr"`
+	Price    PriceConfiguration    `yaml:"price"`
+	Escrow   EscrowConfiguration   `yaml:"escrow"`
+	LndHub   LndHubConfiguration   `yaml:"lndhub"`
+	API      APIConfiguration      `yaml:"api"`
 }{}
 
+// APIConfiguration configures the /api endpoints.
+type APIConfiguration struct {
+	// LegacyWalletKeys accepts the raw admin and invoice keys of a wallet until
+	// LegacyWalletKeysUntil (YYYY-MM-DD), so integrations can move to /api keys.
+	LegacyWalletKeys      bool   `yaml:"legacy_wallet_keys"`
+	LegacyWalletKeysUntil string `yaml:"legacy_wallet_keys_until"`
+}
+
+// LegacyWalletKeysAccepted reports whether raw wallet keys are accepted at t.
+func (c APIConfiguration) LegacyWalletKeysAccepted(t time.Time) bool {
+	if !c.LegacyWalletKeys {
+		return false
+	}
+	until, err := time.Parse("2006-01-02", c.LegacyWalletKeysUntil)
+	return err == nil && t.Before(until.AddDate(0, 0, 1))
+}
+
+// LndHubConfiguration configures the LndHub API.
+type LndHubConfiguration struct {
+	// LegacyAdminLogin accepts the login "admin" with the admin key of a wallet,
+	// as it was linked before the bot issued its own credentials.
+	LegacyAdminLogin bool `yaml:"legacy_admin_login"`
+}
+
+// EscrowConfiguration sets how escrowed trades resolve when nobody acts.
+type EscrowConfiguration struct {
+	Timeout        int64  `yaml:"timeout"`         // hours
+	DefaultOutcome string `yaml:"default_outcome"` // "release" to the seller or "refund" to the buyer
+}
+
+type PriceConfiguration struct {
+	Sources        []string                `yaml:"sources"`
+	Static         map[string]float64      `yaml:"static"`
+	Currencies     []CurrencyConfiguration `yaml:"currencies"`
+	UpdateInterval int64                   `yaml:"update_interval"` // seconds
+	MaxAge         int64                   `yaml:"max_age"`         // seconds
+	MaxDeviation   float64                 `yaml:"max_deviation"`
+}
+
+type CurrencyConfiguration struct {
+	Code   string `yaml:"code"`
+	Symbol string `yaml:"symbol"`
+}
+
 type NostrConfiguration struct {
 	PrivateKey string `yaml:"private_key"`
+	NWCRelay   string `yaml:"nwc_relay"` // relay of the wallet connect service, empty disables /nwc
 }
 
 type GenerateConfiguration struct {
@@ -36,14 +86,23 @@ type SocksConfiguration struct {
 }
 
 type BotConfiguration struct {
-	SocksProxy     *SocksConfiguration `yaml:"socks_proxy,omitempty"`
-	TorProxy       *SocksConfiguration `yaml:"tor_proxy,omitempty"`
-	LNURLServer    string              `yaml:"lnurl_server"`
-	LNURLServerUrl *url.URL            `yaml:"-"`
-	LNURLHostName  string              `yaml:"lnurl_public_host_name"`
-	LNURLHostUrl   *url.URL            `yaml:"-"`
-	LNURLSendImage bool                `yaml:"lnurl_image"`
-	AdminAPIHost   string              `yaml:"admin_api_host"`
+	SocksProxy     *SocksConfiguration       `yaml:"socks_proxy,omitempty"`
+	TorProxy       *SocksConfiguration       `yaml:"tor_proxy,omitempty"`
+	LNURLServer    string                    `yaml:"lnurl_server"`
+	LNURLServerUrl *url.URL                  `yaml:"-"`
+	LNURLHostName  string                    `yaml:"lnurl_public_host_name"`
+	LNURLHostUrl   *url.URL                  `yaml:"-"`
+	LNURLSendImage bool                      `yaml:"lnurl_image"`
+	AdminAPIHost   string                    `yaml:"admin_api_host"`
+	AdminAPITokens []AdminTokenConfiguration `yaml:"admin_api_tokens"`
+}
+
+// AdminTokenConfiguration is a bearer token for the admin api. Scopes are
+// read, moderation and funds.
+type AdminTokenConfiguration struct {
+	Name   string   `yaml:"name"`
+	Token  string   `yaml:"token"`
+	Scopes []string `yaml:"scopes"`
 }
 
 type TelegramConfiguration struct {
@@ -56,9 +115,12 @@ type DatabaseConfiguration struct {
 	BuntDbPath       string `yaml:"buntdb_path"`
 	TransactionsPath string `yaml:"transactions_path"`
 	GroupsDbPath     string `yaml:"groupsdb_path"`
+	AuditPath        string `yaml:"audit_path"`
+	WebhooksPath     string `yaml:"webhooks_path"`
 }
 
 type LnbitsConfiguration struct {
+	Backend          string   `yaml:"backend"`
 	AdminId          string   `yaml:"admin_id"`
 	AdminKey         string   `yaml:"admin_key"`
 	Url              string   `yaml:"url"`
@@ -89,9 +151,25 @@ func init() {
 	}
 	Configuration.Bot.LNURLHostUrl = hostname
 	checkLnbitsConfiguration()
+	checkDatabaseConfiguration()
+	checkAdminConfiguration()
+	checkPriceConfiguration()
+	checkEscrowConfiguration()
+	checkAPIConfiguration()
 }
 
 func checkLnbitsConfiguration() {
+	if Configuration.Lnbits.Backend == "" {
+		Configuration.Lnbits.Backend = "lnbits"
+	}
+	switch Configuration.Lnbits.Backend {
+	case "lnbits":
+	case "fake":
+		log.Warnf("lnbits backend is set to fake. Do not use this in production.")
+		return
+	default:
+		panic(fmt.Errorf("unknown lnbits backend %q, use lnbits or fake", Configuration.Lnbits.Backend))
+	}
 	if Configuration.Lnbits.Url == "" {
 		panic(fmt.Errorf("please configure a lnbits url"))
 	}
@@ -103,3 +181,67 @@ func checkLnbitsConfiguration() {
 		}
 	}
 }
+
+func checkDatabaseConfiguration() {
+	if Configuration.Database.AuditPath == "" {
+		Configuration.Database.AuditPath = "data/audit.db"
+	}
+	if Configuration.Database.WebhooksPath == "" {
+		Configuration.Database.WebhooksPath = "data/webhooks.db"
+	}
+}
+
+func checkAdminConfiguration() {
+	for _, token := range Configuration.Bot.AdminAPITokens {
+		if token.Name == "" || len(token.Token) < 32 {
+			panic(fmt.Errorf("admin api tokens need a name and a token of at least 32 characters"))
+		}
+		for _, scope := range token.Scopes {
+			if scope != "read" && scope != "moderation" && scope != "funds" {
+				panic(fmt.Errorf("admin api token %s has unknown scope %s", token.Name, scope))
+			}
+		}
+	}
+}
+
+func checkPriceConfiguration() {
+	if len(Configuration.Price.Sources) == 0 {
+		Configuration.Price.Sources = []string{"coinbase", "bitfinex"}
+	}
+	if Configuration.Price.UpdateInterval <= 0 {
+		Configuration.Price.UpdateInterval = 30
+	}
+	if Configuration.Price.MaxAge <= 0 {
+		Configuration.Price.MaxAge = 300
+	}
+	if Configuration.Price.MaxDeviation <= 0 {
+		Configuration.Price.MaxDeviation = 0.05
+	}
+	for _, currency := range Configuration.Price.Currencies {
+		if currency.Code == "" || currency.Symbol == "" {
+			panic(fmt.Errorf("price currencies need a code and a symbol"))
+		}
+	}
+}
+
+func checkEscrowConfiguration() {
+	if Configuration.Escrow.Timeout <= 0 {
+		Configuration.Escrow.Timeout = 14 * 24
+	}
+	switch Configuration.Escrow.DefaultOutcome {
+	case "":
+		Configuration.Escrow.DefaultOutcome = "release"
+	case "release", "refund":
+	default:
+		panic(fmt.Errorf("escrow default_outcome must be release or refund"))
+	}
+}
+
+func checkAPIConfiguration() {
+	if !Configuration.API.LegacyWalletKeys {
+		return
+	}
+	if _, err := time.Parse("2006-01-02", Configuration.API.LegacyWalletKeysUntil); err != nil {
+		panic(fmt.Errorf("api legacy_wallet_keys needs the end of the deprecation window in legacy_wallet_keys_until (YYYY-MM-DD)"))
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"gorm.io/gorm"
 )
 
+// FindUser looks up a user by username, anon id or uuid. With user+wallet, the
+// named wallet of the user is selected instead of the active one.
 func FindUser(database *gorm.DB, username string) (*lnbits.User, *gorm.DB) {
+	username, walletName, _ := strings.Cut(username, "+")
 	// now check for the user
 	user := &lnbits.User{}
 	// check if "username" is actually the user ID
@@ -26,9 +29,35 @@ func FindUser(database *gorm.DB, username string) (*lnbits.User, *gorm.DB) {
 		// assume it's a string @username
 		tx = database.Where("telegram_username = ? COLLATE NOCASE", username).First(user)
 	}
+	if tx.Error == nil && walletName != "" {
+		wallet := lnbits.UserWallet{}
+		tx = database.Where("owner = ? AND name = ?", user.Name, strings.ToLower(walletName)).First(&wallet)
+		if tx.Error == nil {
+			user.UseWallet(wallet)
+		}
+	}
 	return user, tx
 }
 
+// FindUserByWallet returns the user that owns a wallet with its settings. For
+// wallets other than the active one, user.Wallet is set to that wallet.
+func FindUserByWallet(database *gorm.DB, walletID string) (*lnbits.User, error) {
+	user := &lnbits.User{}
+	err := database.Preload("Settings").Where("wallet_id = ?", walletID).First(user).Error
+	if err == nil {
+		return user, nil
+	}
+	wallet := lnbits.UserWallet{}
+	if database.Where("id = ?", walletID).First(&wallet).Error != nil {
+		return user, err
+	}
+	if err := database.Preload("Settings").Where("name = ?", wallet.Owner).First(user).Error; err != nil {
+		return user, err
+	}
+	user.UseWallet(wallet)
+	return user, nil
+}
+
 func FindUserSettings(user *lnbits.User, settingsTx *gorm.DB) (*lnbits.User, error) {
 	// tx := bot.DB.Users.Preload("Settings").First(user)
 	tx := settingsTx.First(user)
@@ -0,0 +1,118 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Event is a payment of a user's wallet.
+type Event struct {
+	User        string    `json:"-"`
+	WalletID    string    `json:"wallet_id"`
+	Incoming    bool      `json:"incoming"`
+	Amount      int64     `json:"amount"` // sat
+	Memo        string    `json:"memo"`
+	PaymentHash string    `json:"payment_hash"`
+	Time        time.Time `json:"time"`
+}
+
+// Name is the SSE event name.
+func (e Event) Name() string {
+	if e.Incoming {
+		return "payment-received"
+	}
+	return "payment-sent"
+}
+
+// Filter selects the events of a subscription. Empty fields match everything.
+type Filter struct {
+	IncomingOnly bool
+	OutgoingOnly bool
+	MinAmount    int64
+	MemoPrefix   string
+}
+
+func (f Filter) match(e Event) bool {
+	return !(f.IncomingOnly && !e.Incoming) &&
+		!(f.OutgoingOnly && e.Incoming) &&
+		e.Amount >= f.MinAmount &&
+		strings.HasPrefix(e.Memo, f.MemoPrefix)
+}
+
+// Subscription receives the events of one user on C until it is closed.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	user   string
+	filter Filter
+	bus    *Bus
+	once   sync.Once
+}
+
+// Close removes the subscription from the bus and closes C.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subscriptions, s)
+		s.bus.mu.Unlock()
+		close(s.c)
+	})
+}
+
+// Bus fans out payment events to any number of subscribers. Publishing never
+// blocks: a subscriber that doesn't keep up loses events.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscriptions: make(map[*Subscription]struct{})}
+}
+
+// B is the event bus of the bot.
+var B = NewBus()
+
+// Subscribe returns a subscription for the events of user that match filter.
+func (b *Bus) Subscribe(user string, filter Filter) *Subscription {
+	c := make(chan Event, 16)
+	s := &Subscription{C: c, c: c, user: user, filter: filter, bus: b}
+	b.mu.Lock()
+	b.subscriptions[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Publish sends e to all matching subscriptions of e.User.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subscriptions {
+		if s.user != e.User || !s.filter.match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			log.Warnf("[events] subscriber of %s is too slow, dropped %s", e.User, e.PaymentHash)
+		}
+	}
+}
+
+// Subscribers returns the number of open subscriptions.
+func (b *Bus) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscriptions)
+}
+
+// Publish sends e on B.
+func Publish(e Event) {
+	B.Publish(e)
+}
@@ -0,0 +1,45 @@
+package events
+
+import "testing"
+
+func TestBus_Filter(t *testing.T) {
+	b := NewBus()
+	all := b.Subscribe("1", Filter{})
+	incoming := b.Subscribe("1", Filter{IncomingOnly: true, MinAmount: 100, MemoPrefix: "order"})
+	other := b.Subscribe("2", Filter{})
+	defer all.Close()
+	defer incoming.Close()
+	defer other.Close()
+
+	b.Publish(Event{User: "1", Incoming: true, Amount: 100, Memo: "order 1"})
+	b.Publish(Event{User: "1", Incoming: true, Amount: 99, Memo: "order 2"})
+	b.Publish(Event{User: "1", Incoming: false, Amount: 500, Memo: "order 3"})
+	b.Publish(Event{User: "1", Incoming: true, Amount: 500, Memo: "tip"})
+
+	if got := len(all.C); got != 4 {
+		t.Errorf("unfiltered subscription got %d events, want 4", got)
+	}
+	if got := len(incoming.C); got != 1 {
+		t.Errorf("filtered subscription got %d events, want 1", got)
+	}
+	if e := <-incoming.C; e.Memo != "order 1" {
+		t.Errorf("filtered subscription got %q", e.Memo)
+	}
+	if got := len(other.C); got != 0 {
+		t.Errorf("other user got %d events, want 0", got)
+	}
+}
+
+func TestBus_SlowSubscriber(t *testing.T) {
+	b := NewBus()
+	s := b.Subscribe("1", Filter{})
+	for i := 0; i < 100; i++ {
+		// must not block
+		b.Publish(Event{User: "1"})
+	}
+	s.Close()
+	s.Close()
+	if b.Subscribers() != 0 {
+		t.Errorf("closed subscription is still on the bus")
+	}
+}
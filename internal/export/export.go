@@ -0,0 +1,220 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatOFX  = "ofx"
+)
+
+// Sources of the fiat value of a row
+const (
+	// FiatLocked is the fiat amount the payment was made in
+	FiatLocked = "locked"
+	// FiatHistorical is converted at the BTC price on the day of the payment
+	FiatHistorical = "historical"
+	// FiatMissing means that no price was available, the row has no fiat value
+	FiatMissing = "missing"
+)
+
+// Row is one payment of a wallet. Amounts are in sat.
+type Row struct {
+	Time         time.Time `json:"time"`
+	Direction    string    `json:"direction"` // "in" or "out"
+	Amount       int64     `json:"amount"`    // negative for outgoing payments
+	Fee          int64     `json:"fee"`
+	Type         string    `json:"type"`
+	Counterparty string    `json:"counterparty,omitempty"`
+	Chat         string    `json:"chat,omitempty"`
+	Memo         string    `json:"memo,omitempty"`
+	FiatAmount   float64   `json:"fiat_amount,omitempty"`
+	FiatCurrency string    `json:"fiat_currency,omitempty"`
+	FiatSource   string    `json:"fiat_source"` // FiatLocked, FiatHistorical or FiatMissing
+	PaymentHash  string    `json:"payment_hash"`
+	Pending      bool      `json:"pending"`
+}
+
+// Statement is the export of a wallet between From and To. Zero times mean no limit.
+type Statement struct {
+	Account string    `json:"account"`
+	From    time.Time `json:"from,omitempty"`
+	To      time.Time `json:"to,omitempty"`
+	Balance int64     `json:"balance"` // sat at the time of the export
+	Rows    []Row     `json:"transactions"`
+}
+
+// ParseFormat returns the format of s. The default is csv.
+func ParseFormat(s string) (string, error) {
+	switch f := strings.ToLower(s); f {
+	case "":
+		return FormatCSV, nil
+	case FormatCSV, FormatJSON, FormatOFX:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %s", s)
+}
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	switch format {
+	case FormatJSON:
+		return "application/json"
+	case FormatOFX:
+		return "application/x-ofx"
+	}
+	return "text/csv"
+}
+
+// Write encodes s in format.
+func Write(w io.Writer, format string, s Statement) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, s)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case FormatOFX:
+		return writeOFX(w, s)
+	}
+	return fmt.Errorf("unknown format %s", format)
+}
+
+func writeCSV(w io.Writer, s Statement) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "direction", "amount_sat", "fee_sat", "type", "counterparty", "chat", "memo", "fiat_amount", "fiat_currency", "fiat_source", "payment_hash", "pending"})
+	for _, r := range s.Rows {
+		fiat := ""
+		if r.FiatSource != FiatMissing && r.FiatCurrency != "" {
+			fiat = strconv.FormatFloat(r.FiatAmount, 'f', 2, 64)
+		}
+		cw.Write([]string{
+			r.Time.UTC().Format(time.RFC3339),
+			r.Direction,
+			strconv.FormatInt(r.Amount, 10),
+			strconv.FormatInt(r.Fee, 10),
+			r.Type,
+			r.Counterparty,
+			r.Chat,
+			r.Memo,
+			fiat,
+			r.FiatCurrency,
+			r.FiatSource,
+			r.PaymentHash,
+			strconv.FormatBool(r.Pending),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// OFX 2.2 statement. Amounts are in BTC with the currency XBT.
+type ofxTransaction struct {
+	Type   string `xml:"TRNTYPE"`
+	Posted string `xml:"DTPOSTED"`
+	Amount string `xml:"TRNAMT"`
+	FitID  string `xml:"FITID"`
+	Name   string `xml:"NAME,omitempty"`
+	Memo   string `xml:"MEMO,omitempty"`
+}
+
+type ofxStatement struct {
+	XMLName      xml.Name         `xml:"OFX"`
+	Status       string           `xml:"SIGNONMSGSRSV1>SONRS>STATUS>CODE"`
+	Severity     string           `xml:"SIGNONMSGSRSV1>SONRS>STATUS>SEVERITY"`
+	ServerDate   string           `xml:"SIGNONMSGSRSV1>SONRS>DTSERVER"`
+	Language     string           `xml:"SIGNONMSGSRSV1>SONRS>LANGUAGE"`
+	TrnUID       string           `xml:"BANKMSGSRSV1>STMTTRNRS>TRNUID"`
+	TrnStatus    string           `xml:"BANKMSGSRSV1>STMTTRNRS>STATUS>CODE"`
+	TrnSeverity  string           `xml:"BANKMSGSRSV1>STMTTRNRS>STATUS>SEVERITY"`
+	Currency     string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>CURDEF"`
+	BankID       string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKACCTFROM>BANKID"`
+	AccountID    string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKACCTFROM>ACCTID"`
+	AccountType  string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKACCTFROM>ACCTTYPE"`
+	Start        string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKTRANLIST>DTSTART"`
+	End          string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKTRANLIST>DTEND"`
+	Transactions []ofxTransaction `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKTRANLIST>STMTTRN"`
+	Balance      string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>LEDGERBAL>BALAMT"`
+	BalanceDate  string           `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>LEDGERBAL>DTASOF"`
+}
+
+const ofxTimeLayout = "20060102150405"
+
+func btc(sat int64) string {
+	sign := ""
+	if sat < 0 {
+		sign, sat = "-", -sat
+	}
+	return fmt.Sprintf("%s%d.%08d", sign, sat/100_000_000, sat%100_000_000)
+}
+
+func writeOFX(w io.Writer, s Statement) error {
+	now := time.Now().UTC()
+	start, end := s.From, s.To
+	if end.IsZero() {
+		end = now
+	}
+	if start.IsZero() && len(s.Rows) > 0 {
+		start = s.Rows[len(s.Rows)-1].Time
+		for _, r := range s.Rows {
+			if r.Time.Before(start) {
+				start = r.Time
+			}
+		}
+	}
+	statement := ofxStatement{
+		Status:      "0",
+		Severity:    "INFO",
+		ServerDate:  now.Format(ofxTimeLayout),
+		Language:    "ENG",
+		TrnUID:      "0",
+		TrnStatus:   "0",
+		TrnSeverity: "INFO",
+		Currency:    "XBT",
+		BankID:      "LightningTipBot",
+		AccountID:   s.Account,
+		AccountType: "CHECKING",
+		Start:       start.UTC().Format(ofxTimeLayout),
+		End:         end.UTC().Format(ofxTimeLayout),
+		Balance:     btc(s.Balance),
+		BalanceDate: now.Format(ofxTimeLayout),
+	}
+	for _, r := range s.Rows {
+		t := ofxTransaction{
+			Type:   "CREDIT",
+			Posted: r.Time.UTC().Format(ofxTimeLayout),
+			Amount: btc(r.Amount - r.Fee),
+			FitID:  r.PaymentHash,
+			Name:   truncate(r.Counterparty, 32),
+			Memo:   truncate(strings.TrimSpace(r.Type+" "+r.Memo), 255),
+		}
+		if r.Amount < 0 {
+			t.Type = "DEBIT"
+		}
+		statement.Transactions = append(statement.Transactions, t)
+	}
+	if _, err := io.WriteString(w, xml.Header+`<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(statement)
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var statement = Statement{
+	Account: "wallet",
+	Balance: 1500,
+	Rows: []Row{
+		{Time: time.Unix(1700000100, 0), Direction: "out", Amount: -500, Fee: 2, Type: "send", Counterparty: "bob", Memo: "pizza, thanks", FiatCurrency: "USD", FiatSource: FiatMissing, PaymentHash: "b"},
+		{Time: time.Unix(1700000000, 0), Direction: "in", Amount: 2002, Type: "tip", Counterparty: "alice", Chat: "group", FiatAmount: 0.74, FiatCurrency: "USD", FiatSource: FiatLocked, PaymentHash: "a"},
+	},
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatCSV, statement); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	if got := records[1][7]; got != "pizza, thanks" {
+		t.Errorf("memo = %q", got)
+	}
+	if got := records[2][8]; got != "0.74" {
+		t.Errorf("fiat = %q", got)
+	}
+	// rows without a price say so instead of showing zero
+	if got := records[1][8] + "|" + records[1][10]; got != "|missing" {
+		t.Errorf("missing fiat = %q, want empty amount and source missing", got)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatJSON, statement); err != nil {
+		t.Fatal(err)
+	}
+	var got Statement
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Rows) != 2 || got.Rows[0].Amount != -500 {
+		t.Errorf("unexpected rows %+v", got.Rows)
+	}
+}
+
+func TestWriteOFX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatOFX, statement); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"<CURDEF>XBT</CURDEF>",
+		"<TRNTYPE>DEBIT</TRNTYPE>",
+		"<TRNAMT>-0.00000502</TRNAMT>",
+		"<TRNAMT>0.00002002</TRNAMT>",
+		"<DTSTART>20231114221320</DTSTART>",
+		"<BALAMT>0.00001500</BALAMT>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in\n%s", want, out)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, _ := ParseFormat(""); f != FormatCSV {
+		t.Errorf("default format = %s", f)
+	}
+	if f, _ := ParseFormat("OFX"); f != FormatOFX {
+		t.Errorf("format = %s", f)
+	}
+	if _, err := ParseFormat("xls"); err == nil {
+		t.Error("expected error")
+	}
+}
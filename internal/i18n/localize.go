@@ -1,6 +1,9 @@
 package i18n
 
 import (
+	"os"
+	"path/filepath"
+
 	"github.com/BurntSushi/toml"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	log "github.com/sirupsen/logrus"
@@ -13,22 +16,36 @@ func init() {
 	Bundle = RegisterLanguages()
 }
 
+// translationsDir returns the translations directory. It is looked up in the
+// parent directories as well, so that package tests find it.
+func translationsDir() string {
+	dir := "translations"
+	for i := 0; i < 4; i++ {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		dir = filepath.Join("..", dir)
+	}
+	return "translations"
+}
+
 func RegisterLanguages() *i18n.Bundle {
 	bundle := i18n.NewBundle(language.English)
 	bundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
-	bundle.MustLoadMessageFile("translations/en.toml")
-	bundle.LoadMessageFile("translations/de.toml")
-	bundle.LoadMessageFile("translations/fi.toml")
-	bundle.LoadMessageFile("translations/it.toml")
-	bundle.LoadMessageFile("translations/es.toml")
-	bundle.LoadMessageFile("translations/nl.toml")
-	bundle.LoadMessageFile("translations/pl.toml")
-	bundle.LoadMessageFile("translations/fr.toml")
-	bundle.LoadMessageFile("translations/pt-br.toml")
-	bundle.LoadMessageFile("translations/tr.toml")
-	bundle.LoadMessageFile("translations/cs.toml")
-	bundle.LoadMessageFile("translations/id.toml")
-	bundle.LoadMessageFile("translations/ru.toml")
+	dir := translationsDir()
+	bundle.MustLoadMessageFile(filepath.Join(dir, "en.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "de.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "fi.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "it.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "es.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "nl.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "pl.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "fr.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "pt-br.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "tr.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "cs.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "id.toml"))
+	bundle.LoadMessageFile(filepath.Join(dir, "ru.toml"))
 	return bundle
 }
 func Translate(languageCode string, MessgeID string) string {
@@ -0,0 +1,170 @@
+package lnbits
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+)
+
+// WalletBackend is the custodial engine that holds the user wallets.
+// Client talks to the LNbits usermanager API, FakeBackend keeps an
+// in-process ledger and is meant for development and tests.
+type WalletBackend interface {
+	// GetUser returns user information
+	GetUser(userId string) (User, error)
+	// CreateUserWithInitialWallet creates new user with initial wallet
+	CreateUserWithInitialWallet(userName, walletName, adminId string, email string) (User, error)
+	// CreateWallet creates a new wallet for an existing user
+	CreateWallet(userId, walletName, adminId string) (Wallet, error)
+	// DeleteWallet deletes a wallet, its keys stop working
+	DeleteWallet(w Wallet) error
+	// Wallets returns all wallets belonging to an user
+	Wallets(u User) ([]Wallet, error)
+	// Info returns wallet information. The balance is in msat.
+	Info(w Wallet) (Wallet, error)
+	// Payments returns the latest wallet payments
+	Payments(w Wallet) (Payments, error)
+	// Payment returns the state of a payment
+	Payment(w Wallet, paymentHash string) (LNbitsPayment, error)
+	// Invoice creates an invoice for a wallet
+	Invoice(w Wallet, params InvoiceParams) (Invoice, error)
+	// Pay pays an invoice with funds from a wallet
+	Pay(w Wallet, params PaymentParams) (Invoice, error)
+}
+
+// Transferer is implemented by backends that can move funds between two of
+// their own wallets in a single atomic booking, without an invoice.
+type Transferer interface {
+	Transfer(from Wallet, to Wallet, params TransferParams) (Invoice, error)
+}
+
+const (
+	BackendLNbits = "lnbits"
+	BackendFake   = "fake"
+)
+
+var (
+	_ WalletBackend = (*Client)(nil)
+	_ WalletBackend = (*FakeBackend)(nil)
+	_ Transferer    = (*Client)(nil)
+	_ Transferer    = (*FakeBackend)(nil)
+)
+
+// ErrPaymentPending is returned when it is unknown whether a payment went
+// through, for example after a timeout. The payment must not be retried
+// before its state was checked with WalletBackend.Payment.
+var ErrPaymentPending = errors.New("payment state unknown")
+
+// ErrPaymentDenied is returned when a payment was refused before it reached
+// the backend, for example by the spending limits of a user.
+var ErrPaymentDenied = errors.New("payment denied")
+
+// PaymentFailed reports whether err means that a payment definitely did not
+// go through: it was denied or the backend rejected it with a client error.
+// For all other errors the state of the payment has to be checked.
+func PaymentFailed(err error) bool {
+	var rejected Error
+	return errors.Is(err, ErrPaymentDenied) ||
+		errors.As(err, &rejected) && rejected.Status < http.StatusInternalServerError
+}
+
+// PaymentNotFound reports whether err means that the backend has no payment
+// with the requested hash in the wallet, as opposed to an error while looking
+// it up.
+func PaymentNotFound(err error) bool {
+	var missing Error
+	return errors.As(err, &missing) && missing.Status == http.StatusNotFound
+}
+
+// TransferWithInvoice moves funds with an invoice of wallet to that is paid by
+// wallet from. If params carries the invoice of an earlier attempt, that
+// invoice is paid again instead of creating a new one. An invoice that turns
+// out to be paid counts as success, so a retry never moves funds twice.
+// Errors after which the payment may still have gone through wrap
+// ErrPaymentPending.
+func TransferWithInvoice(c WalletBackend, from Wallet, to Wallet, params TransferParams, internal bool) (Invoice, error) {
+	invoice := params.Invoice
+	if len(invoice.PaymentRequest) == 0 {
+		var err error
+		invoice, err = c.Invoice(to, InvoiceParams{Out: false, Amount: params.NumSatoshis, Memo: params.Memo, Internal: internal})
+		if err != nil {
+			return invoice, err
+		}
+		if params.OnInvoice != nil {
+			params.OnInvoice(invoice)
+		}
+	}
+	_, err := c.Pay(from, PaymentParams{Out: true, Bolt11: invoice.PaymentRequest})
+	if err == nil {
+		return invoice, nil
+	}
+	payment, checkErr := c.Payment(to, invoice.PaymentHash)
+	if checkErr == nil && payment.Paid {
+		return invoice, nil
+	}
+	if checkErr == nil && PaymentFailed(err) {
+		// the payment was refused and the invoice is unpaid
+		return invoice, err
+	}
+	return invoice, fmt.Errorf("%w: %v", ErrPaymentPending, err)
+}
+
+// InvoiceAmount returns the amount of a payment request in sat. It also
+// understands the invoices of FakeBackend.
+func InvoiceAmount(bolt11 string) (int64, error) {
+	if strings.HasPrefix(bolt11, "lnfake") {
+		// lnfake<amount>1<64 hex characters of the payment hash>
+		data := strings.TrimPrefix(bolt11, "lnfake")
+		if len(data) < 66 {
+			return 0, fmt.Errorf("invalid invoice")
+		}
+		amount, err := strconv.ParseInt(data[:len(data)-65], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid invoice")
+		}
+		return amount, nil
+	}
+	invoice, err := decodepay.Decodepay(bolt11)
+	if err != nil {
+		return 0, err
+	}
+	return invoice.MSatoshi / 1000, nil
+}
+
+// InvoicePaymentHash returns the payment hash of a payment request. It also
+// understands the invoices of FakeBackend.
+func InvoicePaymentHash(bolt11 string) (string, error) {
+	if strings.HasPrefix(bolt11, "lnfake") {
+		if _, err := InvoiceAmount(bolt11); err != nil {
+			return "", err
+		}
+		return bolt11[len(bolt11)-64:], nil
+	}
+	invoice, err := decodepay.Decodepay(bolt11)
+	if err != nil {
+		return "", err
+	}
+	return invoice.PaymentHash, nil
+}
+
+// InvoiceReference returns the recipient, amount and purpose of a payment
+// request. Unlike the payment request, it is the same for every invoice a
+// service issues for the same payment, for example over LNURL-pay.
+func InvoiceReference(bolt11 string) (string, error) {
+	if strings.HasPrefix(bolt11, "lnfake") {
+		amount, err := InvoiceAmount(bolt11)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("lnfake:%d", amount), nil
+	}
+	invoice, err := decodepay.Decodepay(bolt11)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%s:%s", invoice.Payee, invoice.MSatoshi, invoice.DescriptionHash, invoice.Description), nil
+}
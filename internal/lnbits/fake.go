@@ -0,0 +1,355 @@
+package lnbits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FakeBackend is an in-process ledger that implements WalletBackend.
+// Invoices can only be paid by wallets of the same FakeBackend, there is
+// no connection to the Lightning Network. Use it for development and tests.
+type FakeBackend struct {
+	mu       sync.Mutex
+	users    map[string]*User
+	wallets  map[string]*Wallet
+	keys     map[string]string // inkey and adminkey to wallet id
+	invoices map[string]*fakeInvoice
+	bolt11s  map[string]string // payment request to payment hash
+	payments map[string][]Payment
+	booked   map[string]Invoice // idempotency key to transfer
+	client   *http.Client
+}
+
+type fakeInvoice struct {
+	Payment
+	paid bool
+}
+
+// NewFakeBackend returns an empty in-memory ledger.
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		users:    make(map[string]*User),
+		wallets:  make(map[string]*Wallet),
+		keys:     make(map[string]string),
+		invoices: make(map[string]*fakeInvoice),
+		bolt11s:  make(map[string]string),
+		payments: make(map[string][]Payment),
+		booked:   make(map[string]Invoice),
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func fakeRandomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// GetUser returns user information
+func (f *FakeBackend) GetUser(userId string) (User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[userId]
+	if !ok {
+		return User{}, Error{Detail: "User does not exist."}
+	}
+	return *u, nil
+}
+
+// CreateUserWithInitialWallet creates new user with initial wallet
+func (f *FakeBackend) CreateUserWithInitialWallet(userName, walletName, adminId string, email string) (User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := &User{ID: fakeRandomHex(16), Name: userName}
+	f.users[u.ID] = u
+	f.createWallet(u.ID, walletName)
+	return *u, nil
+}
+
+// CreateWallet creates a new wallet.
+func (f *FakeBackend) CreateWallet(userId, walletName, adminId string) (Wallet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.users[userId]; !ok {
+		return Wallet{}, Error{Detail: "User does not exist."}
+	}
+	return *f.createWallet(userId, walletName), nil
+}
+
+func (f *FakeBackend) createWallet(userId, walletName string) *Wallet {
+	w := &Wallet{
+		ID:       fakeRandomHex(16),
+		Adminkey: fakeRandomHex(16),
+		Inkey:    fakeRandomHex(16),
+		Name:     walletName,
+		User:     userId,
+	}
+	f.wallets[w.ID] = w
+	f.keys[w.Adminkey] = w.ID
+	f.keys[w.Inkey] = w.ID
+	return w
+}
+
+// DeleteWallet deletes a wallet and its keys
+func (f *FakeBackend) DeleteWallet(w Wallet) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wallet, ok := f.wallets[w.ID]
+	if !ok {
+		return Error{Detail: "Wallet does not exist."}
+	}
+	delete(f.keys, wallet.Adminkey)
+	delete(f.keys, wallet.Inkey)
+	delete(f.wallets, w.ID)
+	// open invoices of the wallet can't be paid anymore
+	for bolt11, hash := range f.bolt11s {
+		if inv := f.invoices[hash]; inv.WalletID == w.ID {
+			delete(f.bolt11s, bolt11)
+			delete(f.invoices, hash)
+		}
+	}
+	return nil
+}
+
+// Wallets returns all wallets belonging to an user
+func (f *FakeBackend) Wallets(u User) ([]Wallet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wallets := make([]Wallet, 0)
+	for _, w := range f.wallets {
+		if w.User == u.ID {
+			wallets = append(wallets, *w)
+		}
+	}
+	return wallets, nil
+}
+
+// wallet returns the wallet authenticated by key. Must be called with f.mu held.
+func (f *FakeBackend) wallet(key string) (*Wallet, error) {
+	id, ok := f.keys[key]
+	if !ok {
+		return nil, Error{Detail: "Invalid key"}
+	}
+	return f.wallets[id], nil
+}
+
+// Info returns wallet information
+func (f *FakeBackend) Info(w Wallet) (Wallet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wallet, err := f.wallet(w.Inkey)
+	if err != nil {
+		return Wallet{}, err
+	}
+	return *wallet, nil
+}
+
+// Payments returns wallet payments, newest first
+func (f *FakeBackend) Payments(w Wallet) (Payments, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wallet, err := f.wallet(w.Inkey)
+	if err != nil {
+		return nil, err
+	}
+	payments := make(Payments, len(f.payments[wallet.ID]))
+	copy(payments, f.payments[wallet.ID])
+	sort.SliceStable(payments, func(i, j int) bool { return payments[i].Time > payments[j].Time })
+	if len(payments) > 60 {
+		payments = payments[:60]
+	}
+	return payments, nil
+}
+
+// Payment state of a payment. Like LNbits, a wallet only sees its own side
+// of a payment: the payee the invoice and the payer the outgoing payment.
+func (f *FakeBackend) Payment(w Wallet, paymentHash string) (LNbitsPayment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inv, ok := f.invoices[paymentHash]
+	if !ok {
+		return LNbitsPayment{}, Error{Detail: "Payment does not exist.", Status: http.StatusNotFound}
+	}
+	if wallet, err := f.wallet(w.Inkey); err == nil && wallet.ID != inv.WalletID {
+		for _, payment := range f.payments[wallet.ID] {
+			if payment.PaymentHash == paymentHash {
+				return LNbitsPayment{Paid: true, Preimage: inv.Preimage, Details: payment}, nil
+			}
+		}
+		return LNbitsPayment{}, Error{Detail: "Payment does not exist.", Status: http.StatusNotFound}
+	}
+	payment := LNbitsPayment{Paid: inv.paid, Details: inv.Payment}
+	if inv.paid {
+		payment.Preimage = inv.Preimage
+	}
+	return payment, nil
+}
+
+// Invoice creates an invoice associated with wallet w.
+func (f *FakeBackend) Invoice(w Wallet, params InvoiceParams) (Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wallet, err := f.wallet(w.Inkey)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if params.Amount <= 0 {
+		return Invoice{}, Error{Detail: "Amount must be positive."}
+	}
+	preimage := fakeRandomHex(32)
+	preimageBytes, _ := hex.DecodeString(preimage)
+	hash := sha256.Sum256(preimageBytes)
+	paymentHash := hex.EncodeToString(hash[:])
+	bolt11 := fmt.Sprintf("lnfake%d1%s", params.Amount, paymentHash)
+	f.invoices[paymentHash] = &fakeInvoice{Payment: Payment{
+		CheckingID:  paymentHash,
+		Pending:     true,
+		Amount:      params.Amount * 1000,
+		Memo:        params.Memo,
+		Time:        int(time.Now().Unix()),
+		Bolt11:      bolt11,
+		Preimage:    preimage,
+		PaymentHash: paymentHash,
+		WalletID:    wallet.ID,
+		Webhook:     params.Webhook,
+	}}
+	f.bolt11s[bolt11] = paymentHash
+	return Invoice{PaymentHash: paymentHash, PaymentRequest: bolt11}, nil
+}
+
+// Pay pays a given invoice with funds from wallet w.
+func (f *FakeBackend) Pay(w Wallet, params PaymentParams) (Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payer, err := f.wallet(w.Adminkey)
+	if err != nil || payer.Adminkey != w.Adminkey {
+		return Invoice{}, Error{Detail: "Invalid key"}
+	}
+	hash, ok := f.bolt11s[params.Bolt11]
+	if !ok {
+		return Invoice{}, Error{Detail: "Invoice can not be routed."}
+	}
+	inv := f.invoices[hash]
+	if inv.paid {
+		return Invoice{}, Error{Detail: "Invoice already paid."}
+	}
+	if payer.Balance < inv.Amount {
+		return Invoice{}, Error{Detail: "Insufficient balance."}
+	}
+	payee := f.wallets[inv.WalletID]
+	payer.Balance -= inv.Amount
+	payee.Balance += inv.Amount
+	inv.paid = true
+	inv.Pending = false
+	inv.Time = int(time.Now().Unix())
+
+	outgoing := inv.Payment
+	outgoing.Amount = -inv.Amount
+	outgoing.WalletID = payer.ID
+	outgoing.Webhook = nil
+	f.payments[payer.ID] = append(f.payments[payer.ID], outgoing)
+	f.payments[payee.ID] = append(f.payments[payee.ID], inv.Payment)
+
+	if url, ok := inv.Webhook.(string); ok && url != "" {
+		go f.fireWebhook(url, inv.Payment)
+	}
+	return Invoice{PaymentHash: hash, PaymentRequest: params.Bolt11}, nil
+}
+
+// Transfer books an internal transfer from wallet w to wallet to. Debit and
+// credit are written under the same lock. A repeated idempotency key returns
+// the original booking without moving funds again.
+func (f *FakeBackend) Transfer(w Wallet, to Wallet, params TransferParams) (Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if booking, ok := f.booked[params.IdempotencyKey]; ok && params.IdempotencyKey != "" {
+		return booking, nil
+	}
+	payer, err := f.wallet(w.Adminkey)
+	if err != nil || payer.Adminkey != w.Adminkey {
+		return Invoice{}, Error{Detail: "Invalid key"}
+	}
+	payee, ok := f.wallets[to.ID]
+	if !ok {
+		return Invoice{}, Error{Detail: "Wallet does not exist."}
+	}
+	amount := params.NumSatoshis * 1000
+	if amount <= 0 {
+		return Invoice{}, Error{Detail: "Amount must be positive."}
+	}
+	if payer.Balance < amount {
+		return Invoice{}, Error{Detail: "Insufficient balance."}
+	}
+	payer.Balance -= amount
+	payee.Balance += amount
+
+	hash := fakeRandomHex(32)
+	incoming := Payment{
+		CheckingID:  hash,
+		Amount:      amount,
+		Memo:        params.Memo,
+		Time:        int(time.Now().Unix()),
+		PaymentHash: hash,
+		WalletID:    payee.ID,
+	}
+	outgoing := incoming
+	outgoing.Amount = -amount
+	outgoing.WalletID = payer.ID
+	f.payments[payer.ID] = append(f.payments[payer.ID], outgoing)
+	f.payments[payee.ID] = append(f.payments[payee.ID], incoming)
+	f.invoices[hash] = &fakeInvoice{Payment: incoming, paid: true}
+
+	booking := Invoice{PaymentHash: hash}
+	if params.IdempotencyKey != "" {
+		f.booked[params.IdempotencyKey] = booking
+	}
+	return booking, nil
+}
+
+// Deposit credits amount sat to wallet w out of thin air.
+func (f *FakeBackend) Deposit(w Wallet, amount int64, memo string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wallet, err := f.wallet(w.Inkey)
+	if err != nil {
+		return err
+	}
+	wallet.Balance += amount * 1000
+	hash := fakeRandomHex(32)
+	f.payments[wallet.ID] = append(f.payments[wallet.ID], Payment{
+		CheckingID:  hash,
+		Amount:      amount * 1000,
+		Memo:        memo,
+		Time:        int(time.Now().Unix()),
+		PaymentHash: hash,
+		WalletID:    wallet.ID,
+	})
+	return nil
+}
+
+// fireWebhook posts the settled payment to the invoice webhook like LNbits does.
+func (f *FakeBackend) fireWebhook(url string, payment Payment) {
+	body, err := json.Marshal(payment)
+	if err != nil {
+		log.Errorf("[FakeBackend] could not encode webhook: %v", err)
+		return
+	}
+	resp, err := f.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("[FakeBackend] webhook %s failed: %v", url, err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,113 @@
+package lnbits
+
+import (
+	"testing"
+)
+
+func newFakeWallet(t *testing.T, f *FakeBackend, name string) Wallet {
+	u, err := f.CreateUserWithInitialWallet(name, name, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallets, err := f.Wallets(u)
+	if err != nil || len(wallets) != 1 {
+		t.Fatalf("Wallets() = %v, %v", wallets, err)
+	}
+	return wallets[0]
+}
+
+func TestFakeBackend_Pay(t *testing.T) {
+	f := NewFakeBackend()
+	alice := newFakeWallet(t, f, "alice")
+	bob := newFakeWallet(t, f, "bob")
+	if err := f.Deposit(alice, 100, "faucet"); err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := bob.Invoice(InvoiceParams{Amount: 60, Memo: "tip"}, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = alice.Pay(PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}, f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = alice.Pay(PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}, f); err == nil {
+		t.Errorf("paying an invoice twice should fail")
+	}
+
+	tests := []struct {
+		name   string
+		wallet Wallet
+		want   int64
+	}{
+		{name: "payer", wallet: alice, want: 40_000},
+		{name: "payee", wallet: bob, want: 60_000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := f.Info(tt.wallet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Balance != tt.want {
+				t.Errorf("Info().Balance = %d, want %d", info.Balance, tt.want)
+			}
+		})
+	}
+
+	payment, err := f.Payment(bob, invoice.PaymentHash)
+	if err != nil || !payment.Paid {
+		t.Errorf("Payment() = %v, %v, want paid", payment, err)
+	}
+
+	invoice, err = bob.Invoice(InvoiceParams{Amount: 41}, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = alice.Pay(PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}, f); err == nil {
+		t.Errorf("paying with insufficient balance should fail")
+	}
+}
+
+func TestFakeBackend_TransferIdempotency(t *testing.T) {
+	f := NewFakeBackend()
+	alice := newFakeWallet(t, f, "alice")
+	bob := newFakeWallet(t, f, "bob")
+	if err := f.Deposit(alice, 100, "faucet"); err != nil {
+		t.Fatal(err)
+	}
+	params := TransferParams{NumSatoshis: 30, Memo: "tip", IdempotencyKey: "tip:1"}
+	first, err := f.Transfer(alice, bob, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := f.Transfer(alice, bob, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.PaymentHash != second.PaymentHash {
+		t.Errorf("retried transfer booked twice: %s != %s", first.PaymentHash, second.PaymentHash)
+	}
+	info, _ := f.Info(bob)
+	if info.Balance != 30_000 {
+		t.Errorf("Info().Balance = %d, want %d", info.Balance, 30_000)
+	}
+	if _, err = f.Transfer(alice, bob, TransferParams{NumSatoshis: 71, IdempotencyKey: "tip:2"}); err == nil {
+		t.Errorf("transfer with insufficient balance should fail")
+	}
+}
+
+func TestInvoiceAmount(t *testing.T) {
+	f := NewFakeBackend()
+	alice := newFakeWallet(t, f, "alice")
+	for _, amount := range []int64{1, 100, 2100} {
+		invoice, err := alice.Invoice(InvoiceParams{Amount: amount}, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := InvoiceAmount(invoice.PaymentRequest)
+		if err != nil || got != amount {
+			t.Errorf("InvoiceAmount() = %d, %v, want %d", got, err, amount)
+		}
+	}
+}
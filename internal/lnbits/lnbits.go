@@ -84,8 +84,28 @@ func (c *Client) CreateWallet(userId, walletName, adminId string) (wal Wallet, e
 	return
 }
 
+// DeleteWallet deletes a wallet of the user manager. Its keys stop working.
+func (c *Client) DeleteWallet(w Wallet) error {
+	resp, err := req.Delete(c.url+"/usermanager/api/v1/wallets/"+w.ID, c.header)
+	if err != nil {
+		return err
+	}
+
+	if resp.Response().StatusCode >= 300 {
+		var reqErr Error
+		resp.ToJSON(&reqErr)
+		return reqErr
+	}
+	return nil
+}
+
 // Invoice creates an invoice associated with this wallet.
-func (w Wallet) Invoice(params InvoiceParams, c *Client) (lntx Invoice, err error) {
+func (w Wallet) Invoice(params InvoiceParams, c WalletBackend) (lntx Invoice, err error) {
+	return c.Invoice(w, params)
+}
+
+// Invoice creates an invoice for wallet w.
+func (c Client) Invoice(w Wallet, params InvoiceParams) (lntx Invoice, err error) {
 	// custom header with invoice key
 	invoiceHeader := req.Header{
 		"Content-Type": "application/json",
@@ -172,6 +192,7 @@ func (c Client) Payment(w Wallet, payment_hash string) (payment LNbitsPayment, e
 	if resp.Response().StatusCode >= 300 {
 		var reqErr Error
 		resp.ToJSON(&reqErr)
+		reqErr.Status = resp.Response().StatusCode
 		err = reqErr
 		return
 	}
@@ -199,7 +220,12 @@ func (c Client) Wallets(w User) (wtx []Wallet, err error) {
 }
 
 // Pay pays a given invoice with funds from the wallet.
-func (w Wallet) Pay(params PaymentParams, c *Client) (wtx Invoice, err error) {
+func (w Wallet) Pay(params PaymentParams, c WalletBackend) (wtx Invoice, err error) {
+	return c.Pay(w, params)
+}
+
+// Pay pays a given invoice with funds from wallet w.
+func (c Client) Pay(w Wallet, params PaymentParams) (wtx Invoice, err error) {
 	// custom header with admin key
 	adminHeader := req.Header{
 		"Content-Type": "application/json",
@@ -216,6 +242,7 @@ func (w Wallet) Pay(params PaymentParams, c *Client) (wtx Invoice, err error) {
 	if resp.Response().StatusCode >= 300 {
 		var reqErr Error
 		resp.ToJSON(&reqErr)
+		reqErr.Status = resp.Response().StatusCode
 		err = reqErr
 		return
 	}
@@ -223,3 +250,10 @@ func (w Wallet) Pay(params PaymentParams, c *Client) (wtx Invoice, err error) {
 	err = resp.ToJSON(&wtx)
 	return
 }
+
+// Transfer moves funds between two wallets of this LNbits instance. The
+// destination wallet creates an internal invoice, which LNbits settles in a
+// single database transaction without routing it over Lightning.
+func (c *Client) Transfer(from Wallet, to Wallet, params TransferParams) (Invoice, error) {
+	return TransferWithInvoice(c, from, to, params, true)
+}
@@ -43,6 +43,17 @@ type Settings struct {
 	Display DisplaySettings `gorm:"embedded;embeddedPrefix:display_"`
 	Node    NodeSettings    `gorm:"embedded;embeddedPrefix:node_"`
 	Nostr   NostrSettings   `gorm:"embedded;embeddedPrefix:nostr_"`
+	Limits  LimitSettings   `gorm:"embedded;embeddedPrefix:limits_"`
+}
+
+// LimitSettings restrict how much a user can spend. All amounts are in sat,
+// zero means no limit.
+type LimitSettings struct {
+	PerTransaction int64 `json:"pertransaction"`
+	PerHour        int64 `json:"perhour"`
+	PerDay         int64 `json:"perday"`
+	ConfirmAbove   int64 `json:"confirmabove"` // payments above need to be approved
+	Cooldown       int64 `json:"cooldown"`     // minutes between approval and payment
 }
 
 type DisplaySettings struct {
@@ -90,11 +101,15 @@ type InvoiceParams struct {
 	Webhook             string `json:"webhook,omitempty"`              // the webhook to fire back to when payment is received.
 	DescriptionHash     string `json:"description_hash,omitempty"`     // the invoice description hash.
 	UnhashedDescription string `json:"unhashed_description,omitempty"` // the unhashed invoice description.
+	Internal            bool   `json:"internal,omitempty"`             // the invoice can only be paid by wallets of the same LNbits instance.
 }
 
 type PaymentParams struct {
 	Out    bool   `json:"out"`
 	Bolt11 string `json:"bolt11"`
+	// Reference identifies the payment across attempts that each pay a new
+	// invoice. It defaults to InvoiceReference of the invoice.
+	Reference string `json:"-"`
 }
 type PayParams struct {
 	// the BOLT11 payment request you want to pay.
@@ -105,13 +120,21 @@ type PayParams struct {
 }
 
 type TransferParams struct {
-	Memo         string `json:"memo"`           // the transfer description.
-	NumSatoshis  int64  `json:"num_satoshis"`   // the transfer amount.
-	DestWalletId string `json:"dest_wallet_id"` // the key or id of the destination
+	Memo           string `json:"memo"`            // the transfer description.
+	NumSatoshis    int64  `json:"num_satoshis"`    // the transfer amount.
+	DestWalletId   string `json:"dest_wallet_id"`  // the key or id of the destination
+	IdempotencyKey string `json:"idempotency_key"` // transfers with the same key are booked only once.
+	// Invoice of an earlier attempt of the same transfer. It is paid again
+	// instead of creating a new invoice.
+	Invoice Invoice `json:"-"`
+	// OnInvoice is called with a new invoice before it is paid.
+	OnInvoice func(Invoice) `json:"-"`
 }
 
 type Error struct {
 	Detail string `json:"detail"`
+	// Status is the HTTP status code of the response, if known.
+	Status int `json:"-"`
 }
 
 func (err Error) Error() string {
@@ -125,6 +148,33 @@ type Wallet struct {
 	Balance  int64  `json:"balance"`
 	Name     string `json:"name"`
 	User     string `json:"user"`
+	// Detached is set for wallets that are not the active wallet of their user,
+	// see User.UseWallet. They are never saved with the user.
+	Detached bool `json:"detached,omitempty" gorm:"-"`
+}
+
+// UserWallet is one of the wallets of a user. The wallet in User.Wallet is the
+// active one, it receives tips and payments to the Lightning address.
+type UserWallet struct {
+	ID        string    `json:"id" gorm:"primarykey"`
+	Owner     string    `json:"-" gorm:"uniqueIndex:idx_user_wallet_name"` // name of the User
+	Name      string    `json:"name" gorm:"uniqueIndex:idx_user_wallet_name"`
+	Adminkey  string    `json:"-"`
+	Inkey     string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Wallet returns the lnbits wallet of w.
+func (w UserWallet) Wallet() *Wallet {
+	return &Wallet{ID: w.ID, Adminkey: w.Adminkey, Inkey: w.Inkey, Name: w.Name}
+}
+
+// UseWallet points u to one of its wallets for the current request. The active
+// wallet of u in the database stays the same.
+func (u *User) UseWallet(w UserWallet) {
+	active := u.Wallet != nil && u.Wallet.ID == w.ID && !u.Wallet.Detached
+	u.Wallet = w.Wallet()
+	u.Wallet.Detached = !active
 }
 
 type Payment struct {
@@ -6,8 +6,11 @@ import (
 	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal"
+	db "github.com/LightningTipBot/LightningTipBot/internal/database"
+	"github.com/LightningTipBot/LightningTipBot/internal/events"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	"github.com/LightningTipBot/LightningTipBot/internal/webhooks"
 
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -25,7 +28,7 @@ import (
 type Server struct {
 	httpServer *http.Server
 	bot        *tb.Bot
-	c          *lnbits.Client
+	c          lnbits.WalletBackend
 	database   *gorm.DB
 	buntdb     *storage.DB
 }
@@ -66,12 +69,7 @@ func NewServer(bot *telegram.TipBot) *Server {
 }
 
 func (w *Server) GetUserByWalletId(walletId string) (*lnbits.User, error) {
-	user := &lnbits.User{}
-	tx := w.database.Where("wallet_id = ?", walletId).First(user)
-	if tx.Error != nil {
-		return user, tx.Error
-	}
-	return user, nil
+	return db.FindUserByWallet(w.database, walletId)
 }
 
 func (w *Server) newRouter() *mux.Router {
@@ -101,6 +99,20 @@ func (w *Server) receive(writer http.ResponseWriter, request *http.Request) {
 
 	writer.WriteHeader(200)
 
+	events.Publish(events.Event{
+		User:        user.Name,
+		WalletID:    webhookEvent.WalletID,
+		Incoming:    true,
+		Amount:      webhookEvent.Amount / 1000,
+		Memo:        webhookEvent.Memo,
+		PaymentHash: webhookEvent.PaymentHash,
+	})
+	webhooks.Publish(user.Name, webhooks.EventInvoicePaid, map[string]interface{}{
+		"payment_hash": webhookEvent.PaymentHash,
+		"amount":       webhookEvent.Amount / 1000,
+		"memo":         webhookEvent.Memo,
+	})
+
 	// trigger invoice events
 	txInvoiceEvent := &telegram.InvoiceEvent{Invoice: &telegram.Invoice{PaymentHash: webhookEvent.PaymentHash}}
 	err = w.buntdb.Get(txInvoiceEvent)
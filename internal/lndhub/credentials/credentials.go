@@ -0,0 +1,178 @@
+package credentials
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AccessTokenTTL  = 2 * time.Hour
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var ErrBadAuth = fmt.Errorf("bad auth")
+
+// Credential is the LndHub login of a user. The password is shown in /link, like
+// the wallet keys it is stored in clear text and can be rotated.
+type Credential struct {
+	Name      string    `gorm:"primarykey"` // name of the lnbits.User
+	Login     string    `gorm:"uniqueIndex"`
+	Password  string    `json:"-"`
+	CreatedAt time.Time `json:"created"`
+}
+
+func (Credential) TableName() string {
+	return "lndhub_credentials"
+}
+
+// Token is an access or refresh token. Only the hash of the token is stored.
+type Token struct {
+	Hash      string    `gorm:"primarykey"`
+	Name      string    `gorm:"index"`
+	Refresh   bool      `json:"refresh"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time `json:"created"`
+}
+
+func (Token) TableName() string {
+	return "lndhub_tokens"
+}
+
+// WalletName is the credential name for one of the wallets of user that is not
+// necessarily the active one.
+func WalletName(user string, walletID string) string {
+	return user + "/" + walletID
+}
+
+// SplitName returns the user and the wallet id of a credential name. The wallet
+// id is empty for credentials of the active wallet.
+func SplitName(name string) (user string, walletID string) {
+	user, walletID, _ = strings.Cut(name, "/")
+	return
+}
+
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Credential{}, &Token{})
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// Ensure returns the credential of user and creates one if there is none.
+func Ensure(db *gorm.DB, user string) (Credential, error) {
+	credential := Credential{}
+	tx := db.Where("name = ?", user).Limit(1).Find(&credential)
+	if tx.Error != nil {
+		return credential, tx.Error
+	}
+	if tx.RowsAffected > 0 {
+		return credential, nil
+	}
+	return Rotate(db, user)
+}
+
+// Rotate replaces the credential of user and revokes all of its tokens.
+func Rotate(db *gorm.DB, user string) (Credential, error) {
+	login, err := randomHex(10)
+	if err != nil {
+		return Credential{}, err
+	}
+	password, err := randomHex(20)
+	if err != nil {
+		return Credential{}, err
+	}
+	credential := Credential{Name: user, Login: login, Password: password, CreatedAt: time.Now()}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("name = ?", user).Delete(&Token{}).Error; err != nil {
+			return err
+		}
+		return tx.Save(&credential).Error
+	})
+	return credential, err
+}
+
+// Login returns the user of a login and password.
+func Login(db *gorm.DB, login string, password string) (string, error) {
+	credential := Credential{}
+	if err := db.Where("login = ?", login).First(&credential).Error; err != nil {
+		return "", ErrBadAuth
+	}
+	if subtle.ConstantTimeCompare([]byte(credential.Password), []byte(password)) != 1 {
+		return "", ErrBadAuth
+	}
+	return credential.Name, nil
+}
+
+// Issue creates a new pair of access and refresh tokens for user.
+func Issue(db *gorm.DB, user string) (access string, refresh string, err error) {
+	if access, err = randomHex(20); err != nil {
+		return
+	}
+	if refresh, err = randomHex(20); err != nil {
+		return
+	}
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", now).Delete(&Token{}).Error; err != nil {
+			return err
+		}
+		return tx.Create([]Token{
+			{Hash: hashToken(access), Name: user, ExpiresAt: now.Add(AccessTokenTTL), CreatedAt: now},
+			{Hash: hashToken(refresh), Name: user, Refresh: true, ExpiresAt: now.Add(RefreshTokenTTL), CreatedAt: now},
+		}).Error
+	})
+	return
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens. A refresh token can only be used once.
+func Refresh(db *gorm.DB, refreshToken string) (user string, access string, refresh string, err error) {
+	token := Token{}
+	err = db.Where("hash = ? AND refresh = ? AND expires_at > ?", hashToken(refreshToken), true, time.Now()).First(&token).Error
+	if err != nil {
+		return "", "", "", ErrBadAuth
+	}
+	// deleting the token decides a race between two refreshes with the same token
+	tx := db.Where("hash = ?", token.Hash).Delete(&Token{})
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		return "", "", "", ErrBadAuth
+	}
+	access, refresh, err = Issue(db, token.Name)
+	return token.Name, access, refresh, err
+}
+
+// Authenticate returns the user of an access token.
+func Authenticate(db *gorm.DB, accessToken string) (string, error) {
+	token := Token{}
+	err := db.Where("hash = ? AND refresh = ? AND expires_at > ?", hashToken(accessToken), false, time.Now()).First(&token).Error
+	if err != nil {
+		return "", ErrBadAuth
+	}
+	return token.Name, nil
+}
+
+// Revoke deletes the credential and all tokens of user. The next Ensure creates a new credential.
+func Revoke(db *gorm.DB, user string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("name = ?", user).Delete(&Token{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name = ?", user).Delete(&Credential{}).Error
+	})
+}
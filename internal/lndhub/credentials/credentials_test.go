@@ -0,0 +1,78 @@
+package credentials
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCredentials(t *testing.T) {
+	db := newTestDB(t)
+	credential, err := Ensure(db, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := Ensure(db, "alice"); again.Login != credential.Login {
+		t.Fatalf("Ensure() created new credentials")
+	}
+	if _, err := Login(db, credential.Login, "wrong"); err != ErrBadAuth {
+		t.Fatalf("Login() with wrong password = %v, want ErrBadAuth", err)
+	}
+	user, err := Login(db, credential.Login, credential.Password)
+	if err != nil || user != "alice" {
+		t.Fatalf("Login() = %s, %v, want alice", user, err)
+	}
+
+	access, refresh, err := Issue(db, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := Authenticate(db, access); err != nil || user != "alice" {
+		t.Fatalf("Authenticate() = %s, %v, want alice", user, err)
+	}
+	if _, err := Authenticate(db, refresh); err != ErrBadAuth {
+		t.Fatalf("Authenticate() with refresh token = %v, want ErrBadAuth", err)
+	}
+
+	// a refresh token can only be used once
+	_, access, _, err = Refresh(db, refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := Refresh(db, refresh); err != ErrBadAuth {
+		t.Fatalf("second Refresh() = %v, want ErrBadAuth", err)
+	}
+
+	// rotating logs out all tokens
+	rotated, err := Rotate(db, "alice")
+	if err != nil || rotated.Login == credential.Login {
+		t.Fatalf("Rotate() = %v, %v", rotated, err)
+	}
+	if _, err := Authenticate(db, access); err != ErrBadAuth {
+		t.Fatalf("Authenticate() after Rotate() = %v, want ErrBadAuth", err)
+	}
+	if _, err := Login(db, credential.Login, credential.Password); err != ErrBadAuth {
+		t.Fatalf("Login() with old credentials = %v, want ErrBadAuth", err)
+	}
+}
+
+func TestWalletName(t *testing.T) {
+	if user, wallet := SplitName(WalletName("alice", "w1")); user != "alice" || wallet != "w1" {
+		t.Fatalf("SplitName(WalletName()) = %s, %s", user, wallet)
+	}
+	if user, wallet := SplitName("alice"); user != "alice" || wallet != "" {
+		t.Fatalf("SplitName() = %s, %s", user, wallet)
+	}
+}
@@ -1,21 +1,482 @@
 package lndhub
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal"
-	"github.com/LightningTipBot/LightningTipBot/internal/api"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/lndhub/credentials"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
+// LndHub error codes as used by BlueWallet and Zeus
+const (
+	ErrorBadAuth        = 1
+	ErrorNoBalance      = 2
+	ErrorInvalidInvoice = 4
+	ErrorServer         = 7
+	ErrorBadArguments   = 8
+	ErrorPaymentFailed  = 10
+)
+
+// invoiceExpiry is the expiry of the invoices created by addinvoice.
+const invoiceExpiry = 24 * time.Hour
+
+// LndHub serves the LndHub API on the wallets of the bot.
 type LndHub struct {
+	bot      *telegram.TipBot
 	database *gorm.DB
 }
 
 func New(bot *telegram.TipBot) LndHub {
-	return LndHub{database: bot.DB.Users}
+	return LndHub{bot: bot, database: bot.DB.Users}
+}
+
+type Error struct {
+	Error   bool   `json:"error"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func respondError(w http.ResponseWriter, code int, message string) {
+	status := http.StatusBadRequest
+	if code == ErrorBadAuth {
+		status = http.StatusUnauthorized
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Error{Error: true, Code: code, Message: message})
+}
+
+func respond(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Buffer is how LndHub encodes binary values, like a serialized node.js Buffer.
+type Buffer struct {
+	Type string `json:"type"`
+	Data []int  `json:"data"`
+}
+
+func hexBuffer(s string) Buffer {
+	b, _ := hex.DecodeString(s)
+	buffer := Buffer{Type: "Buffer", Data: make([]int, len(b))}
+	for i, c := range b {
+		buffer.Data[i] = int(c)
+	}
+	return buffer
+}
+
+// loadUser returns the user of a credential name if they are not banned. For
+// credentials of another wallet of the user, user.Wallet is set to that wallet.
+func (h LndHub) loadUser(name string) (*lnbits.User, error) {
+	owner, walletID := credentials.SplitName(name)
+	user := &lnbits.User{}
+	err := h.database.Where("name = ?", owner).First(user).Error
+	if err != nil {
+		return nil, err
+	}
+	if user.Banned || user.Wallet == nil || strings.HasPrefix(user.Wallet.Adminkey, "banned_") {
+		return nil, credentials.ErrBadAuth
+	}
+	if walletID != "" {
+		wallet := lnbits.UserWallet{}
+		if err := h.database.Where("owner = ? AND id = ?", owner, walletID).First(&wallet).Error; err != nil {
+			return nil, credentials.ErrBadAuth
+		}
+		user.UseWallet(wallet)
+	}
+	return user, nil
+}
+
+// Authorized passes requests with a valid access token and puts the user into the context.
+func (h LndHub) Authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if len(token) < 7 || !strings.EqualFold(token[:7], "Bearer ") {
+			respondError(w, ErrorBadAuth, "bad auth")
+			return
+		}
+		name, err := credentials.Authenticate(h.database, token[7:])
+		if err != nil {
+			respondError(w, ErrorBadAuth, "bad auth")
+			return
+		}
+		user, err := h.loadUser(name)
+		if err != nil {
+			log.Warnf("[lndhub] could not load user %s: %v", name, err)
+			respondError(w, ErrorBadAuth, "bad auth")
+			return
+		}
+		log.Debugf("[lndhub] User: %s Endpoint: %s %s", telegram.GetUserStr(user.Telegram), r.Method, r.URL.Path)
+		r = r.WithContext(context.WithValue(r.Context(), "user", user))
+		next.ServeHTTP(w, r)
+	}
+}
+
+type AuthRequest struct {
+	Login        string `json:"login"`
+	Password     string `json:"password"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type AuthResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Auth issues tokens for a login and password or for a refresh token. If
+// legacy_admin_login is configured, the login "admin" with the admin key of the
+// wallet is accepted for wallets linked before the bot had its own credentials.
+func (h LndHub) Auth(w http.ResponseWriter, r *http.Request) {
+	var request AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondError(w, ErrorBadArguments, "bad arguments")
+		return
+	}
+	var name, access, refresh string
+	var err error
+	switch {
+	case request.RefreshToken != "":
+		name, access, refresh, err = credentials.Refresh(h.database, request.RefreshToken)
+	case request.Login == "admin" && request.Password != "" && internal.Configuration.LndHub.LegacyAdminLogin:
+		log.Warnf("[lndhub] legacy admin login from %s", r.RemoteAddr)
+		user := &lnbits.User{}
+		err = h.database.Where("wallet_adminkey = ?", request.Password).First(user).Error
+		if err == nil {
+			name = user.Name
+		} else {
+			wallet := lnbits.UserWallet{}
+			if h.database.Where("adminkey = ?", request.Password).First(&wallet).Error == nil {
+				name, err = credentials.WalletName(wallet.Owner, wallet.ID), nil
+			}
+		}
+	case request.Login != "" && request.Password != "":
+		name, err = credentials.Login(h.database, request.Login, request.Password)
+	default:
+		respondError(w, ErrorBadArguments, "bad arguments")
+		return
+	}
+	if err == nil {
+		_, err = h.loadUser(name)
+	}
+	if err != nil {
+		log.Warnf("[lndhub] failed login from %s", r.RemoteAddr)
+		respondError(w, ErrorBadAuth, "bad auth")
+		return
+	}
+	if access == "" {
+		access, refresh, err = credentials.Issue(h.database, name)
+		if err != nil {
+			log.Errorf("[lndhub] could not issue tokens: %v", err)
+			respondError(w, ErrorServer, "internal error")
+			return
+		}
+	}
+	respond(w, AuthResponse{AccessToken: access, RefreshToken: refresh})
+}
+
+func (h LndHub) GetInfo(w http.ResponseWriter, r *http.Request) {
+	respond(w, map[string]interface{}{
+		"alias":           "LightningTipBot",
+		"identity_pubkey": "",
+		"uris":            []string{},
+		"synced_to_chain": true,
+		"chains":          []map[string]string{{"chain": "bitcoin", "network": "mainnet"}},
+	})
+}
+
+func (h LndHub) Balance(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	balance, err := h.bot.GetUserBalance(user)
+	if err != nil {
+		respondError(w, ErrorServer, "balance check failed")
+		return
+	}
+	respond(w, map[string]interface{}{"BTC": map[string]int64{"AvailableBalance": balance}})
+}
+
+type AddInvoiceRequest struct {
+	Amount          json.Number `json:"amt"`
+	Memo            string      `json:"memo"`
+	DescriptionHash string      `json:"description_hash"`
+}
+
+type AddInvoiceResponse struct {
+	PayReq         string `json:"pay_req"`
+	PaymentRequest string `json:"payment_request"`
+	AddIndex       string `json:"add_index"`
+	RHash          Buffer `json:"r_hash"`
+	Hash           string `json:"hash"`
+}
+
+func (h LndHub) AddInvoice(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	var request AddInvoiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondError(w, ErrorBadArguments, "bad arguments")
+		return
+	}
+	// clients send the amount as a number or as a string
+	amount, err := strconv.ParseInt(request.Amount.String(), 10, 64)
+	if err != nil || amount <= 0 {
+		respondError(w, ErrorBadArguments, "invalid amount")
+		return
+	}
+	invoice, err := user.Wallet.Invoice(
+		lnbits.InvoiceParams{
+			Amount:          amount,
+			Out:             false,
+			Memo:            request.Memo,
+			DescriptionHash: request.DescriptionHash,
+			Webhook:         internal.Configuration.Lnbits.WebhookServer},
+		h.bot.Client)
+	if err != nil {
+		log.Errorf("[lndhub] could not create invoice for %s: %v", user.Name, err)
+		respondError(w, ErrorServer, "could not create invoice")
+		return
+	}
+	respond(w, AddInvoiceResponse{
+		PayReq:         invoice.PaymentRequest,
+		PaymentRequest: invoice.PaymentRequest,
+		AddIndex:       "500",
+		RHash:          hexBuffer(invoice.PaymentHash),
+		Hash:           invoice.PaymentHash,
+	})
+}
+
+type PayInvoiceRequest struct {
+	Invoice string `json:"invoice"`
+}
+
+type PaidInvoice struct {
+	PaymentError    string          `json:"payment_error"`
+	PaymentPreimage string          `json:"payment_preimage"`
+	PaymentRoute    struct{}        `json:"payment_route"`
+	PaymentHash     string          `json:"payment_hash"`
+	Decoded         *DecodedInvoice `json:"decoded,omitempty"`
+	FeeMsat         int64           `json:"fee_msat"`
+	Type            string          `json:"type"`
+	Fee             int64           `json:"fee"`
+	Value           int64           `json:"value"`
+	Timestamp       int64           `json:"timestamp"`
+	Memo            string          `json:"memo"`
+}
+
+func (h LndHub) PayInvoice(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	var request PayInvoiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Invoice == "" {
+		respondError(w, ErrorBadArguments, "bad arguments")
+		return
+	}
+	decoded, err := decodeInvoice(request.Invoice)
+	if err != nil {
+		respondError(w, ErrorInvalidInvoice, "not a valid invoice")
+		return
+	}
+	if decoded.NumSatoshis <= 0 {
+		respondError(w, ErrorInvalidInvoice, "zero amount invoices are not supported")
+		return
+	}
+	balance, err := h.bot.GetUserBalance(user)
+	if err != nil {
+		respondError(w, ErrorServer, "balance check failed")
+		return
+	}
+	if balance < decoded.NumSatoshis {
+		respondError(w, ErrorNoBalance, "not enough balance")
+		return
+	}
+	// bot.Client enforces the spending limits of the user
+	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: request.Invoice}, h.bot.Client)
+	if err != nil {
+		log.Warnf("[lndhub] payment of %s failed: %v", user.Name, err)
+		respondError(w, ErrorPaymentFailed, "payment failed: "+err.Error())
+		return
+	}
+	preimage := ""
+	if payment, err := h.bot.Client.Payment(*user.Wallet, invoice.PaymentHash); err == nil {
+		preimage = payment.Preimage
+	}
+	respond(w, PaidInvoice{
+		PaymentPreimage: preimage,
+		PaymentHash:     invoice.PaymentHash,
+		Decoded:         &decoded,
+		Type:            "paid_invoice",
+		Value:           decoded.NumSatoshis,
+		Timestamp:       time.Now().Unix(),
+		Memo:            decoded.Description,
+	})
+}
+
+// payments returns the settled and pending payments of the user, newest first.
+func (h LndHub) payments(user *lnbits.User) (lnbits.Payments, error) {
+	return h.bot.UserPayments(user)
+}
+
+// page applies the limit and offset query parameters.
+func page(r *http.Request, n int) (int, int) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if offset < 0 || offset > n {
+		offset = n
+	}
+	if err != nil || limit <= 0 || offset+limit > n {
+		limit = n - offset
+	}
+	return offset, offset + limit
+}
+
+func (h LndHub) GetTxs(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	payments, err := h.payments(user)
+	if err != nil {
+		respondError(w, ErrorServer, "could not load transactions")
+		return
+	}
+	txs := make([]PaidInvoice, 0)
+	for _, p := range payments {
+		if p.Amount >= 0 || p.Pending {
+			continue
+		}
+		txs = append(txs, PaidInvoice{
+			PaymentPreimage: p.Preimage,
+			PaymentHash:     p.PaymentHash,
+			FeeMsat:         -p.Fee,
+			Type:            "paid_invoice",
+			Fee:             -p.Fee / 1000,
+			Value:           -p.Amount / 1000,
+			Timestamp:       int64(p.Time),
+			Memo:            p.Memo,
+		})
+	}
+	from, to := page(r, len(txs))
+	respond(w, txs[from:to])
 }
-func (w LndHub) Handle(writer http.ResponseWriter, request *http.Request) {
-	api.Proxy(writer, request, internal.Configuration.Lnbits.Url)
+
+type UserInvoice struct {
+	RHash          Buffer `json:"r_hash"`
+	PaymentRequest string `json:"payment_request"`
+	AddIndex       string `json:"add_index"`
+	Description    string `json:"description"`
+	PaymentHash    string `json:"payment_hash"`
+	IsPaid         bool   `json:"ispaid"`
+	Amount         int64  `json:"amt"`
+	ExpireTime     int64  `json:"expire_time"`
+	Timestamp      int64  `json:"timestamp"`
+	Type           string `json:"type"`
+}
+
+func (h LndHub) GetUserInvoices(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	payments, err := h.payments(user)
+	if err != nil {
+		respondError(w, ErrorServer, "could not load invoices")
+		return
+	}
+	invoices := make([]UserInvoice, 0)
+	for _, p := range payments {
+		if p.Amount <= 0 {
+			continue
+		}
+		invoices = append(invoices, UserInvoice{
+			RHash:          hexBuffer(p.PaymentHash),
+			PaymentRequest: p.Bolt11,
+			AddIndex:       "500",
+			Description:    p.Memo,
+			PaymentHash:    p.PaymentHash,
+			IsPaid:         !p.Pending,
+			Amount:         p.Amount / 1000,
+			ExpireTime:     int64(invoiceExpiry.Seconds()),
+			Timestamp:      int64(p.Time),
+			Type:           "user_invoice",
+		})
+	}
+	from, to := page(r, len(invoices))
+	respond(w, invoices[from:to])
+}
+
+type DecodedInvoice struct {
+	Destination     string `json:"destination"`
+	PaymentHash     string `json:"payment_hash"`
+	NumSatoshis     int64  `json:"num_satoshis"`
+	NumMsat         int64  `json:"num_msat"`
+	Timestamp       int64  `json:"timestamp"`
+	Expiry          int64  `json:"expiry"`
+	Description     string `json:"description"`
+	DescriptionHash string `json:"description_hash"`
+}
+
+// decodeInvoice decodes a payment request. Invoices of the fake backend only carry
+// the amount and the payment hash.
+func decodeInvoice(bolt11 string) (DecodedInvoice, error) {
+	bolt11 = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(bolt11)), "lightning:")
+	if strings.HasPrefix(bolt11, "lnfake") {
+		amount, err := lnbits.InvoiceAmount(bolt11)
+		if err != nil {
+			return DecodedInvoice{}, err
+		}
+		return DecodedInvoice{
+			PaymentHash: bolt11[len(bolt11)-64:],
+			NumSatoshis: amount,
+			NumMsat:     amount * 1000,
+			Timestamp:   time.Now().Unix(),
+			Expiry:      int64(invoiceExpiry.Seconds()),
+		}, nil
+	}
+	invoice, err := decodepay.Decodepay(bolt11)
+	if err != nil {
+		return DecodedInvoice{}, err
+	}
+	return DecodedInvoice{
+		Destination:     invoice.Payee,
+		PaymentHash:     invoice.PaymentHash,
+		NumSatoshis:     invoice.MSatoshi / 1000,
+		NumMsat:         invoice.MSatoshi,
+		Timestamp:       int64(invoice.CreatedAt),
+		Expiry:          int64(invoice.Expiry),
+		Description:     invoice.Description,
+		DescriptionHash: invoice.DescriptionHash,
+	}, nil
+}
+
+func (h LndHub) DecodeInvoice(w http.ResponseWriter, r *http.Request) {
+	decoded, err := decodeInvoice(r.URL.Query().Get("invoice"))
+	if err != nil {
+		respondError(w, ErrorInvalidInvoice, "not a valid invoice")
+		return
+	}
+	respond(w, decoded)
+}
+
+func (h LndHub) CheckPayment(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	payment, err := h.bot.Client.Payment(*user.Wallet, mux.Vars(r)["payment_hash"])
+	if err != nil {
+		respondError(w, ErrorServer, "could not check payment")
+		return
+	}
+	respond(w, map[string]bool{"paid": payment.Paid})
+}
+
+// GetBtc and GetPending answer with empty lists, there are no on-chain funds.
+func (h LndHub) GetBtc(w http.ResponseWriter, r *http.Request) {
+	respond(w, []interface{}{})
+}
+
+func (h LndHub) GetPending(w http.ResponseWriter, r *http.Request) {
+	respond(w, []interface{}{})
 }
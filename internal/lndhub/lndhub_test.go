@@ -0,0 +1,193 @@
+package lndhub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/lndhub/credentials"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram"
+	"github.com/eko/gocache/store"
+	gocache "github.com/patrickmn/go-cache"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testHub struct {
+	LndHub
+	fake *lnbits.FakeBackend
+}
+
+func newTestHub(t *testing.T) testHub {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&lnbits.User{}, &telegram.RetiredWallet{}, &lnbits.UserWallet{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := credentials.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	fake := lnbits.NewFakeBackend()
+	bot := &telegram.TipBot{
+		DB:     &telegram.Databases{Users: db},
+		Client: fake,
+		Cache:  telegram.Cache{GoCacheStore: store.NewGoCache(gocache.New(time.Minute, time.Minute), nil)},
+	}
+	return testHub{LndHub: New(bot), fake: fake}
+}
+
+// newUser creates a user with balance sat and returns it with its LndHub credential.
+func (h testHub) newUser(t *testing.T, name string, balance int64) (*lnbits.User, credentials.Credential) {
+	u, err := h.fake.CreateUserWithInitialWallet(name, name, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallets, _ := h.fake.Wallets(u)
+	user := &lnbits.User{ID: u.ID, Name: name, Telegram: &tb.User{ID: int64(len(name)), Username: name}, Wallet: &wallets[0],
+		AnonID: name, AnonIDSha256: name, UUID: name}
+	if err := h.database.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if balance > 0 {
+		if err := h.fake.Deposit(*user.Wallet, balance, "faucet"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	credential, err := credentials.Ensure(h.database, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, credential
+}
+
+// call runs handler with a JSON body and decodes the response into response.
+func call(t *testing.T, handler http.HandlerFunc, token string, body interface{}, response interface{}) int {
+	var b bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&b).Encode(body)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/lndhub/ext/", &b)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if response != nil {
+		if err := json.NewDecoder(w.Body).Decode(response); err != nil {
+			t.Fatalf("could not decode response %q: %v", w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+func (h testHub) login(t *testing.T, credential credentials.Credential) string {
+	var auth AuthResponse
+	if code := call(t, h.Auth, "", AuthRequest{Login: credential.Login, Password: credential.Password}, &auth); code != http.StatusOK {
+		t.Fatalf("auth returned %d", code)
+	}
+	return auth.AccessToken
+}
+
+func TestLndHub_Auth(t *testing.T) {
+	h := newTestHub(t)
+	user, credential := h.newUser(t, "alice", 0)
+
+	var auth AuthResponse
+	if code := call(t, h.Auth, "", AuthRequest{Login: credential.Login, Password: credential.Password}, &auth); code != http.StatusOK || auth.AccessToken == "" {
+		t.Fatalf("login = %d %+v", code, auth)
+	}
+	var refreshed AuthResponse
+	if code := call(t, h.Auth, "", AuthRequest{RefreshToken: auth.RefreshToken}, &refreshed); code != http.StatusOK || refreshed.AccessToken == "" {
+		t.Fatalf("refresh = %d %+v", code, refreshed)
+	}
+	if code := call(t, h.Auth, "", AuthRequest{Login: credential.Login, Password: "wrong"}, nil); code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong password = %d", code)
+	}
+
+	legacy := AuthRequest{Login: "admin", Password: user.Wallet.Adminkey}
+	if code := call(t, h.Auth, "", legacy, nil); code != http.StatusUnauthorized {
+		t.Errorf("legacy admin login without legacy_admin_login = %d", code)
+	}
+	internal.Configuration.LndHub.LegacyAdminLogin = true
+	defer func() { internal.Configuration.LndHub.LegacyAdminLogin = false }()
+	if code := call(t, h.Auth, "", legacy, nil); code != http.StatusOK {
+		t.Errorf("legacy admin login with legacy_admin_login = %d", code)
+	}
+}
+
+func TestLndHub_Authorized(t *testing.T) {
+	h := newTestHub(t)
+	_, credential := h.newUser(t, "alice", 0)
+	token := h.login(t, credential)
+	for _, tt := range []struct {
+		token string
+		want  int
+	}{
+		{token: "", want: http.StatusUnauthorized},
+		{token: "invalid", want: http.StatusUnauthorized},
+		{token: token, want: http.StatusOK},
+	} {
+		if code := call(t, h.Authorized(h.Balance), tt.token, nil, nil); code != tt.want {
+			t.Errorf("balance with token %q = %d, want %d", tt.token, code, tt.want)
+		}
+	}
+}
+
+func TestLndHub_Payments(t *testing.T) {
+	h := newTestHub(t)
+	_, aliceCredential := h.newUser(t, "alice", 100)
+	_, bobCredential := h.newUser(t, "bob", 0)
+	alice, bob := h.login(t, aliceCredential), h.login(t, bobCredential)
+
+	// clients send the amount as a string or as a number
+	var invoice AddInvoiceResponse
+	if code := call(t, h.Authorized(h.AddInvoice), bob, map[string]interface{}{"amt": "30", "memo": "coffee"}, &invoice); code != http.StatusOK || invoice.PaymentRequest == "" {
+		t.Fatalf("addinvoice = %d %+v", code, invoice)
+	}
+	if code := call(t, h.Authorized(h.AddInvoice), bob, map[string]interface{}{"amt": 0}, nil); code != http.StatusBadRequest {
+		t.Errorf("addinvoice without amount = %d", code)
+	}
+
+	var paid PaidInvoice
+	if code := call(t, h.Authorized(h.PayInvoice), alice, PayInvoiceRequest{Invoice: invoice.PaymentRequest}, &paid); code != http.StatusOK || paid.PaymentHash != invoice.Hash {
+		t.Fatalf("payinvoice = %d %+v", code, paid)
+	}
+	var failed Error
+	if code := call(t, h.Authorized(h.AddInvoice), bob, map[string]interface{}{"amt": 1000}, &invoice); code != http.StatusOK {
+		t.Fatalf("addinvoice = %d", code)
+	}
+	if code := call(t, h.Authorized(h.PayInvoice), alice, PayInvoiceRequest{Invoice: invoice.PaymentRequest}, &failed); code != http.StatusBadRequest || failed.Code != ErrorNoBalance {
+		t.Errorf("payinvoice above balance = %d %+v", code, failed)
+	}
+
+	var balance map[string]map[string]int64
+	call(t, h.Authorized(h.Balance), alice, nil, &balance)
+	if got := balance["BTC"]["AvailableBalance"]; got != 70 {
+		t.Errorf("balance of alice = %d, want 70", got)
+	}
+	call(t, h.Authorized(h.Balance), bob, nil, &balance)
+	if got := balance["BTC"]["AvailableBalance"]; got != 30 {
+		t.Errorf("balance of bob = %d, want 30", got)
+	}
+
+	var txs []PaidInvoice
+	call(t, h.Authorized(h.GetTxs), alice, nil, &txs)
+	if len(txs) != 1 || txs[0].Value != 30 || !strings.Contains(txs[0].Memo, "coffee") {
+		t.Errorf("gettxs of alice = %+v", txs)
+	}
+	call(t, h.Authorized(h.GetTxs), bob, nil, &txs)
+	if len(txs) != 0 {
+		t.Errorf("gettxs of bob lists incoming payments: %+v", txs)
+	}
+}
@@ -50,7 +50,7 @@ type Invoice struct {
 }
 type Lnurl struct {
 	telegram         *tb.Bot
-	c                *lnbits.Client
+	c                lnbits.WalletBackend
 	database         *gorm.DB
 	callbackHostname *url.URL
 	buntdb           *storage.DB
@@ -286,13 +286,15 @@ func (w Lnurl) serveLNURLpSecond(username string, amount_msat int64, comment str
 				Reason: fmt.Sprintf("Invalid user.")},
 		}, fmt.Errorf("[serveLNURLpSecond] user %s not found", username)
 	}
-	// get user settings
+	// get user settings, this reloads the active wallet of the user
+	wallet := user.Wallet
 	user2, err := db.FindUserSettings(user, w.bot.DB.Users.Preload("Settings"))
 	if err != nil {
 		fmt.Errorf("[serveLNURLpSecond] Couldn't fetch user settings from database: %v", err)
 	} else {
 		user = user2
 	}
+	user.Wallet = wallet
 	// user is ok now create invoice
 	// set wallet lnbits client
 
@@ -452,7 +454,11 @@ func (w Lnurl) metaData(username string) lnurl.Metadata {
 	if strings.HasPrefix(username, "1x") {
 		user, _ := db.FindUser(w.database, username)
 		if user.Telegram.Username != "" {
+			_, wallet, found := strings.Cut(username, "+")
 			username = user.Telegram.Username
+			if found {
+				username += "+" + wallet
+			}
 		}
 	}
 
@@ -0,0 +1,115 @@
+package nwc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"gorm.io/gorm"
+)
+
+// Event kinds of NIP-47
+const (
+	KindInfo     = 13194
+	KindRequest  = 23194
+	KindResponse = 23195
+)
+
+// Connection is a wallet connection of a user. Only the public key of the
+// connection secret is stored, the secret is shown once when it is created.
+type Connection struct {
+	ID        string    `json:"id" gorm:"primarykey"`
+	Owner     string    `json:"-" gorm:"index"` // name of the lnbits.User
+	PubKey    string    `json:"pubkey" gorm:"uniqueIndex"`
+	Budget    int64     `json:"budget"` // sat, zero means no budget
+	Spent     int64     `json:"spent"`  // sat
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Connection) TableName() string {
+	return "nwc_connections"
+}
+
+// Expired reports whether the connection can no longer be used.
+func (c Connection) Expired() bool {
+	return !c.ExpiresAt.IsZero() && time.Now().After(c.ExpiresAt)
+}
+
+// HandledEvent is the id of a request that was answered.
+type HandledEvent struct {
+	ID        string    `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (HandledEvent) TableName() string {
+	return "nwc_handled_events"
+}
+
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Connection{}, &HandledEvent{})
+}
+
+// Create adds a connection for owner and returns it with its secret.
+func Create(db *gorm.DB, owner string, budget int64, expiry time.Duration) (Connection, string, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return Connection{}, "", err
+	}
+	secret := nostr.GeneratePrivateKey()
+	pubkey, err := nostr.GetPublicKey(secret)
+	if err != nil {
+		return Connection{}, "", err
+	}
+	connection := Connection{
+		ID:        hex.EncodeToString(id),
+		Owner:     owner,
+		PubKey:    pubkey,
+		Budget:    budget,
+		CreatedAt: time.Now(),
+	}
+	if expiry > 0 {
+		connection.ExpiresAt = connection.CreatedAt.Add(expiry)
+	}
+	return connection, secret, db.Create(&connection).Error
+}
+
+// Connections returns the connections of owner, newest first.
+func Connections(db *gorm.DB, owner string) ([]Connection, error) {
+	connections := make([]Connection, 0)
+	err := db.Where("owner = ?", owner).Order("created_at desc").Find(&connections).Error
+	return connections, err
+}
+
+// Revoke deletes the connection id of owner.
+func Revoke(db *gorm.DB, owner string, id string) error {
+	tx := db.Where("owner = ? AND id = ?", owner, id).Delete(&Connection{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("connection %s not found", id)
+	}
+	return nil
+}
+
+// URI returns the nostr+walletconnect URI of a connection secret.
+func URI(walletPubKey string, relay string, secret string) string {
+	return fmt.Sprintf("nostr+walletconnect://%s?relay=%s&secret=%s", walletPubKey, url.QueryEscape(relay), secret)
+}
+
+// reserve adds amount to the spent budget of c. It fails if the budget would be exceeded.
+func reserve(db *gorm.DB, c Connection, amount int64) bool {
+	tx := db.Model(&Connection{}).
+		Where("id = ? AND (budget = 0 OR spent + ? <= budget)", c.ID, amount).
+		Update("spent", gorm.Expr("spent + ?", amount))
+	return tx.Error == nil && tx.RowsAffected == 1
+}
+
+// release gives back a reserved amount after a failed payment.
+func release(db *gorm.DB, c Connection, amount int64) error {
+	return db.Model(&Connection{}).Where("id = ?", c.ID).Update("spent", gorm.Expr("spent - ?", amount)).Error
+}
@@ -0,0 +1,71 @@
+package nwc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relay connects to a nostr relay with go-nostr.
+type relay struct {
+	url   string
+	mu    sync.Mutex
+	relay *nostr.Relay
+}
+
+func NewRelay(url string) Relay {
+	return &relay{url: url}
+}
+
+func (r *relay) Subscribe(ctx context.Context, filter nostr.Filter) (<-chan nostr.Event, error) {
+	conn, err := nostr.RelayConnect(ctx, r.url)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if r.relay != nil {
+		r.relay.Close()
+	}
+	r.relay = conn
+	r.mu.Unlock()
+
+	sub := conn.Subscribe(ctx, nostr.Filters{filter})
+	events := make(chan nostr.Event)
+	go func() {
+		defer close(events)
+		defer conn.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-conn.ConnectionError:
+				return
+			case event, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				select {
+				case events <- *event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+func (r *relay) Publish(ctx context.Context, event nostr.Event) error {
+	r.mu.Lock()
+	conn := r.relay
+	r.mu.Unlock()
+	if conn == nil {
+		return fmt.Errorf("not connected to %s", r.url)
+	}
+	if status := conn.Publish(ctx, event); status == nostr.PublishStatusFailed {
+		return fmt.Errorf("%s rejected event %s", r.url, event.ID)
+	}
+	return nil
+}
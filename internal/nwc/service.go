@@ -0,0 +1,364 @@
+package nwc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Error codes of NIP-47
+const (
+	ErrorRateLimited         = "RATE_LIMITED"
+	ErrorNotImplemented      = "NOT_IMPLEMENTED"
+	ErrorInsufficientBalance = "INSUFFICIENT_BALANCE"
+	ErrorQuotaExceeded       = "QUOTA_EXCEEDED"
+	ErrorRestricted          = "RESTRICTED"
+	ErrorUnauthorized        = "UNAUTHORIZED"
+	ErrorInternal            = "INTERNAL"
+	ErrorOther               = "OTHER"
+)
+
+// ErrPaymentFailed is wrapped by the errors of Wallet.Pay if the payment
+// definitely did not go through. Only then the budget is given back.
+var ErrPaymentFailed = errors.New("payment failed")
+
+// handledEventTTL is how long request ids are remembered to drop replays.
+const handledEventTTL = 24 * time.Hour
+
+// Methods are the supported requests, announced in the info event.
+var Methods = []string{"pay_invoice", "make_invoice", "get_balance", "lookup_invoice"}
+
+// Invoice is an invoice of a wallet. Amount is in sat.
+type Invoice struct {
+	PaymentRequest  string
+	PaymentHash     string
+	Preimage        string
+	Description     string
+	DescriptionHash string
+	Amount          int64
+	Paid            bool
+	CreatedAt       time.Time
+}
+
+// Wallet gives the service access to the wallets of the bot. Owner is the name of the user.
+type Wallet interface {
+	Balance(owner string) (int64, error)
+	// Decode returns the amount in sat and the payment hash of an invoice.
+	Decode(bolt11 string) (int64, string, error)
+	// Pay pays an invoice and returns the preimage. Errors wrap
+	// ErrPaymentFailed if no funds have moved.
+	Pay(owner string, bolt11 string) (string, error)
+	MakeInvoice(owner string, amount int64, description string, descriptionHash string) (Invoice, error)
+	LookupInvoice(owner string, paymentHash string) (Invoice, error)
+}
+
+// Relay is the connection of the service to a nostr relay.
+type Relay interface {
+	// Subscribe returns the events that match filter. The channel is closed when the connection is lost.
+	Subscribe(ctx context.Context, filter nostr.Filter) (<-chan nostr.Event, error)
+	Publish(ctx context.Context, event nostr.Event) error
+}
+
+type Request struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Response struct {
+	ResultType string      `json:"result_type"`
+	Error      *Error      `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+}
+
+// Transaction is the result of make_invoice and lookup_invoice. Amounts are in msat.
+type Transaction struct {
+	Type            string `json:"type"`
+	Invoice         string `json:"invoice"`
+	Description     string `json:"description"`
+	DescriptionHash string `json:"description_hash"`
+	Preimage        string `json:"preimage,omitempty"`
+	PaymentHash     string `json:"payment_hash"`
+	Amount          int64  `json:"amount"`
+	FeesPaid        int64  `json:"fees_paid"`
+	CreatedAt       int64  `json:"created_at"`
+}
+
+// Service answers NIP-47 requests to the connections in its database.
+type Service struct {
+	db         *gorm.DB
+	privateKey string
+	PubKey     string
+	relay      Relay
+	wallet     Wallet
+	retry      time.Duration
+
+	mu sync.Mutex
+}
+
+func New(db *gorm.DB, privateKey string, relay Relay, wallet Wallet) (*Service, error) {
+	pubkey, err := nostr.GetPublicKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{
+		db:         db,
+		privateKey: privateKey,
+		PubKey:     pubkey,
+		relay:      relay,
+		wallet:     wallet,
+		retry:      10 * time.Second,
+	}, nil
+}
+
+// Start subscribes to requests until ctx is done. It reconnects when the relay connection is lost.
+func (s *Service) Start(ctx context.Context) {
+	go func() {
+		for {
+			s.listen(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.retry):
+			}
+		}
+	}()
+}
+
+func (s *Service) listen(ctx context.Context) {
+	since := time.Now().Add(-time.Minute)
+	events, err := s.relay.Subscribe(ctx, nostr.Filter{
+		Kinds: []int{KindRequest},
+		Tags:  nostr.TagMap{"p": []string{s.PubKey}},
+		Since: &since,
+	})
+	if err != nil {
+		log.Errorf("[nwc] could not subscribe: %v", err)
+		return
+	}
+	info := nostr.Event{PubKey: s.PubKey, CreatedAt: time.Now(), Kind: KindInfo, Tags: nostr.Tags{}, Content: strings.Join(Methods, " ")}
+	info.Sign(s.privateKey)
+	if err := s.relay.Publish(ctx, info); err != nil {
+		log.Warnf("[nwc] could not publish info event: %v", err)
+	}
+	log.Infof("[nwc] listening for requests to %s", s.PubKey)
+	for event := range events {
+		go s.Handle(ctx, event)
+	}
+	log.Warnf("[nwc] lost relay connection")
+}
+
+// firstSeen reports whether the request is new. Relays may deliver an event
+// twice, also after a restart, so the ids are kept in the database.
+func (s *Service) firstSeen(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db.Where("created_at < ?", time.Now().Add(-handledEventTTL)).Delete(&HandledEvent{})
+	tx := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&HandledEvent{ID: id, CreatedAt: time.Now()})
+	if tx.Error != nil {
+		// rather drop a request than risk to answer it twice
+		log.Errorf("[nwc] could not record request %s: %v", id, tx.Error)
+		return false
+	}
+	return tx.RowsAffected == 1
+}
+
+// Handle answers a request event.
+func (s *Service) Handle(ctx context.Context, event nostr.Event) {
+	if event.Kind != KindRequest {
+		return
+	}
+	// only a valid request may use up its id, a forged copy would block it
+	if ok, err := event.CheckSignature(); !ok || err != nil || event.ID != event.GetID() {
+		log.Warnf("[nwc] request %s with invalid signature", event.ID)
+		return
+	}
+	if !s.firstSeen(event.ID) {
+		return
+	}
+	sharedSecret, err := nip04.ComputeSharedSecret(event.PubKey, s.privateKey)
+	if err != nil {
+		log.Warnf("[nwc] request %s: %v", event.ID, err)
+		return
+	}
+	request := Request{}
+	var response Response
+	content, err := nip04.Decrypt(event.Content, sharedSecret)
+	if err == nil {
+		err = json.Unmarshal([]byte(content), &request)
+	}
+	if err != nil {
+		response = errorResponse(request.Method, ErrorOther, "invalid request")
+	} else {
+		response = s.dispatch(event.PubKey, request)
+	}
+	payload, err := json.Marshal(response)
+	if err != nil {
+		log.Errorf("[nwc] request %s: %v", event.ID, err)
+		return
+	}
+	encrypted, err := nip04.Encrypt(string(payload), sharedSecret)
+	if err != nil {
+		log.Errorf("[nwc] request %s: %v", event.ID, err)
+		return
+	}
+	reply := nostr.Event{
+		PubKey:    s.PubKey,
+		CreatedAt: time.Now(),
+		Kind:      KindResponse,
+		Tags:      nostr.Tags{{"p", event.PubKey}, {"e", event.ID}},
+		Content:   encrypted,
+	}
+	reply.Sign(s.privateKey)
+	if err := s.relay.Publish(ctx, reply); err != nil {
+		log.Errorf("[nwc] could not answer request %s: %v", event.ID, err)
+	}
+}
+
+func errorResponse(method string, code string, message string) Response {
+	return Response{ResultType: method, Error: &Error{Code: code, Message: message}}
+}
+
+func (s *Service) dispatch(pubkey string, request Request) Response {
+	connection := Connection{}
+	if err := s.db.Where("pub_key = ?", pubkey).First(&connection).Error; err != nil {
+		return errorResponse(request.Method, ErrorUnauthorized, "unknown connection")
+	}
+	if connection.Expired() {
+		return errorResponse(request.Method, ErrorUnauthorized, "connection expired")
+	}
+	var result interface{}
+	var failure *Error
+	switch request.Method {
+	case "get_balance":
+		result, failure = s.getBalance(connection)
+	case "pay_invoice":
+		result, failure = s.payInvoice(connection, request.Params)
+	case "make_invoice":
+		result, failure = s.makeInvoice(connection, request.Params)
+	case "lookup_invoice":
+		result, failure = s.lookupInvoice(connection, request.Params)
+	default:
+		return errorResponse(request.Method, ErrorNotImplemented, fmt.Sprintf("unknown method %s", request.Method))
+	}
+	if failure != nil {
+		return Response{ResultType: request.Method, Error: failure}
+	}
+	return Response{ResultType: request.Method, Result: result}
+}
+
+func (s *Service) getBalance(c Connection) (interface{}, *Error) {
+	balance, err := s.wallet.Balance(c.Owner)
+	if err != nil {
+		return nil, &Error{Code: ErrorInternal, Message: "balance check failed"}
+	}
+	return map[string]int64{"balance": balance * 1000}, nil
+}
+
+func (s *Service) payInvoice(c Connection, params json.RawMessage) (interface{}, *Error) {
+	var p struct {
+		Invoice string `json:"invoice"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || p.Invoice == "" {
+		return nil, &Error{Code: ErrorOther, Message: "missing invoice"}
+	}
+	amount, _, err := s.wallet.Decode(p.Invoice)
+	if err != nil || amount <= 0 {
+		return nil, &Error{Code: ErrorOther, Message: "invalid invoice"}
+	}
+	balance, err := s.wallet.Balance(c.Owner)
+	if err != nil {
+		return nil, &Error{Code: ErrorInternal, Message: "balance check failed"}
+	}
+	if balance < amount {
+		return nil, &Error{Code: ErrorInsufficientBalance, Message: "not enough balance"}
+	}
+	if !reserve(s.db, c, amount) {
+		return nil, &Error{Code: ErrorQuotaExceeded, Message: "budget of the connection exceeded"}
+	}
+	preimage, err := s.wallet.Pay(c.Owner, p.Invoice)
+	if err != nil {
+		if !errors.Is(err, ErrPaymentFailed) {
+			// the payment may have gone through, the budget stays reserved
+			log.Warnf("[nwc] payment of %s with connection %s in unknown state: %v", c.Owner, c.ID, err)
+			return nil, &Error{Code: ErrorInternal, Message: "payment state unknown"}
+		}
+		if err := release(s.db, c, amount); err != nil {
+			log.Errorf("[nwc] could not release budget of %s: %v", c.ID, err)
+		}
+		return nil, &Error{Code: ErrorOther, Message: err.Error()}
+	}
+	log.Infof("[nwc] %s paid %d sat with connection %s", c.Owner, amount, c.ID)
+	return map[string]string{"preimage": preimage}, nil
+}
+
+func (s *Service) makeInvoice(c Connection, params json.RawMessage) (interface{}, *Error) {
+	var p struct {
+		Amount          int64  `json:"amount"` // msat
+		Description     string `json:"description"`
+		DescriptionHash string `json:"description_hash"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || p.Amount < 1000 {
+		return nil, &Error{Code: ErrorOther, Message: "invalid amount"}
+	}
+	invoice, err := s.wallet.MakeInvoice(c.Owner, p.Amount/1000, p.Description, p.DescriptionHash)
+	if err != nil {
+		return nil, &Error{Code: ErrorInternal, Message: "could not create invoice"}
+	}
+	return transaction(invoice), nil
+}
+
+func (s *Service) lookupInvoice(c Connection, params json.RawMessage) (interface{}, *Error) {
+	var p struct {
+		PaymentHash string `json:"payment_hash"`
+		Invoice     string `json:"invoice"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &Error{Code: ErrorOther, Message: "invalid params"}
+	}
+	if p.PaymentHash == "" && p.Invoice != "" {
+		_, hash, err := s.wallet.Decode(p.Invoice)
+		if err != nil {
+			return nil, &Error{Code: ErrorOther, Message: "invalid invoice"}
+		}
+		p.PaymentHash = hash
+	}
+	if p.PaymentHash == "" {
+		return nil, &Error{Code: ErrorOther, Message: "missing payment_hash"}
+	}
+	invoice, err := s.wallet.LookupInvoice(c.Owner, p.PaymentHash)
+	if err != nil {
+		return nil, &Error{Code: ErrorOther, Message: "invoice not found"}
+	}
+	return transaction(invoice), nil
+}
+
+func transaction(invoice Invoice) Transaction {
+	t := Transaction{
+		Type:            "incoming",
+		Invoice:         invoice.PaymentRequest,
+		Description:     invoice.Description,
+		DescriptionHash: invoice.DescriptionHash,
+		PaymentHash:     invoice.PaymentHash,
+		Amount:          invoice.Amount * 1000,
+		CreatedAt:       invoice.CreatedAt.Unix(),
+	}
+	if invoice.Paid {
+		t.Preimage = invoice.Preimage
+	}
+	return t
+}
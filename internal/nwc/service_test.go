@@ -0,0 +1,242 @@
+package nwc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// localRelay stands in for a nostr relay: requests are pushed to the
+// subscription and published events are collected.
+type localRelay struct {
+	requests  chan nostr.Event
+	published chan nostr.Event
+}
+
+func (r *localRelay) Subscribe(ctx context.Context, filter nostr.Filter) (<-chan nostr.Event, error) {
+	return r.requests, nil
+}
+
+func (r *localRelay) Publish(ctx context.Context, event nostr.Event) error {
+	r.published <- event
+	return nil
+}
+
+type testWallet struct {
+	balance int64
+	paid    []string
+	// err is returned by Pay without paying
+	err error
+}
+
+func (w *testWallet) Balance(owner string) (int64, error) {
+	return w.balance, nil
+}
+
+func (w *testWallet) Decode(bolt11 string) (int64, string, error) {
+	var amount int64
+	if _, err := fmt.Sscanf(bolt11, "lntest%d", &amount); err != nil {
+		return 0, "", err
+	}
+	return amount, "hash" + bolt11, nil
+}
+
+func (w *testWallet) Pay(owner string, bolt11 string) (string, error) {
+	if w.err != nil {
+		return "", w.err
+	}
+	amount, _, _ := w.Decode(bolt11)
+	w.balance -= amount
+	w.paid = append(w.paid, bolt11)
+	return "preimage", nil
+}
+
+func (w *testWallet) MakeInvoice(owner string, amount int64, description string, descriptionHash string) (Invoice, error) {
+	return Invoice{PaymentRequest: fmt.Sprintf("lntest%d", amount), PaymentHash: "hash", Amount: amount, Description: description, CreatedAt: time.Now()}, nil
+}
+
+func (w *testWallet) LookupInvoice(owner string, paymentHash string) (Invoice, error) {
+	return Invoice{PaymentHash: paymentHash, Amount: 1, Paid: true, Preimage: "preimage"}, nil
+}
+
+func TestService(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	relay := &localRelay{requests: make(chan nostr.Event), published: make(chan nostr.Event, 1)}
+	wallet := &testWallet{balance: 1000}
+	s, err := New(db, nostr.GeneratePrivateKey(), relay, wallet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+	if info := <-relay.published; info.Kind != KindInfo {
+		t.Fatalf("first event has kind %d, want info event", info.Kind)
+	}
+
+	connection, secret, err := Create(db, "alice", 150, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedSecret, err := nip04.ComputeSharedSecret(s.PubKey, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRequest := func(method string, params interface{}) nostr.Event {
+		payload, _ := json.Marshal(map[string]interface{}{"method": method, "params": params})
+		content, _ := nip04.Encrypt(string(payload), sharedSecret)
+		request := nostr.Event{PubKey: connection.PubKey, CreatedAt: time.Now(), Kind: KindRequest, Tags: nostr.Tags{{"p", s.PubKey}}, Content: content}
+		request.Sign(secret)
+		return request
+	}
+	send := func(method string, request nostr.Event) Response {
+		relay.requests <- request
+		reply := <-relay.published
+		if ok, _ := reply.CheckSignature(); !ok || reply.PubKey != s.PubKey {
+			t.Fatalf("%s: reply is not signed by the service", method)
+		}
+		if e := reply.Tags.GetFirst([]string{"e", request.ID}); e == nil {
+			t.Fatalf("%s: reply does not reference the request", method)
+		}
+		decrypted, err := nip04.Decrypt(reply.Content, sharedSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := Response{}
+		json.Unmarshal([]byte(decrypted), &response)
+		return response
+	}
+	call := func(method string, params interface{}) Response {
+		return send(method, newRequest(method, params))
+	}
+
+	// copies of a request with its id but a bad signature or other content
+	// don't keep the request from being answered
+	request := newRequest("get_balance", struct{}{})
+	badSignature := request
+	badSignature.Sig = badSignature.Sig[:len(badSignature.Sig)-2] + "00"
+	otherContent := request
+	otherContent.Sign(nostr.GeneratePrivateKey())
+	otherContent.ID = request.ID
+	relay.requests <- badSignature
+	relay.requests <- otherContent
+	response := send("get_balance", request)
+	if response.Error != nil || response.Result.(map[string]interface{})["balance"] != float64(1000000) {
+		t.Errorf("get_balance = %+v, want 1000000 msat", response)
+	}
+	response = call("pay_invoice", map[string]string{"invoice": "lntest100"})
+	if response.Error != nil || response.Result.(map[string]interface{})["preimage"] != "preimage" {
+		t.Errorf("pay_invoice = %+v, want preimage", response)
+	}
+	// the budget of 150 sat is exhausted
+	response = call("pay_invoice", map[string]string{"invoice": "lntest100"})
+	if response.Error == nil || response.Error.Code != ErrorQuotaExceeded {
+		t.Errorf("pay_invoice over budget = %+v, want %s", response, ErrorQuotaExceeded)
+	}
+	if len(wallet.paid) != 1 {
+		t.Errorf("paid %d invoices, want 1", len(wallet.paid))
+	}
+	response = call("make_invoice", map[string]interface{}{"amount": 21000, "description": "test"})
+	if response.Error != nil || response.Result.(map[string]interface{})["invoice"] != "lntest21" {
+		t.Errorf("make_invoice = %+v, want lntest21", response)
+	}
+	response = call("lookup_invoice", map[string]string{"invoice": "lntest21"})
+	if response.Error != nil || response.Result.(map[string]interface{})["payment_hash"] != "hashlntest21" {
+		t.Errorf("lookup_invoice = %+v, want hashlntest21", response)
+	}
+	response = call("pay_keysend", struct{}{})
+	if response.Error == nil || response.Error.Code != ErrorNotImplemented {
+		t.Errorf("pay_keysend = %+v, want %s", response, ErrorNotImplemented)
+	}
+
+	// revoked connections are unknown
+	if err := Revoke(db, "alice", connection.ID); err != nil {
+		t.Fatal(err)
+	}
+	response = call("get_balance", struct{}{})
+	if response.Error == nil || response.Error.Code != ErrorUnauthorized {
+		t.Errorf("get_balance after revoke = %+v, want %s", response, ErrorUnauthorized)
+	}
+}
+
+func newTestService(t *testing.T, db *gorm.DB, wallet Wallet) *Service {
+	if db == nil {
+		var err error
+		db, err = gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "nwc.db")), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Migrate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(db, nostr.GeneratePrivateKey(), &localRelay{}, wallet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestService_payInvoiceBudget(t *testing.T) {
+	wallet := &testWallet{balance: 1000}
+	s := newTestService(t, nil, wallet)
+	connection, _, err := Create(s.db, "alice", 150, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spent := func() int64 {
+		c := Connection{}
+		s.db.First(&c, "id = ?", connection.ID)
+		return c.Spent
+	}
+	params := json.RawMessage(`{"invoice":"lntest100"}`)
+
+	wallet.err = fmt.Errorf("%w: insufficient balance", ErrPaymentFailed)
+	if _, failure := s.payInvoice(connection, params); failure == nil || failure.Code != ErrorOther {
+		t.Fatalf("failed payment = %+v, want %s", failure, ErrorOther)
+	}
+	if got := spent(); got != 0 {
+		t.Errorf("spent %d sat after a failed payment, want 0", got)
+	}
+
+	// after a timeout the payment may have gone through
+	wallet.err = fmt.Errorf("timeout")
+	if _, failure := s.payInvoice(connection, params); failure == nil || failure.Code != ErrorInternal {
+		t.Fatalf("payment in unknown state = %+v, want %s", failure, ErrorInternal)
+	}
+	if got := spent(); got != 100 {
+		t.Errorf("spent %d sat after a payment in unknown state, want 100", got)
+	}
+}
+
+func TestService_firstSeen(t *testing.T) {
+	s := newTestService(t, nil, &testWallet{})
+	if !s.firstSeen("event") {
+		t.Fatal("new event was seen before")
+	}
+	if s.firstSeen("event") {
+		t.Fatal("event was handled twice")
+	}
+	// a restarted service remembers the event
+	restarted := newTestService(t, s.db, &testWallet{})
+	if restarted.firstSeen("event") {
+		t.Fatal("event was handled again after a restart")
+	}
+	if !restarted.firstSeen("other") {
+		t.Fatal("new event was seen before")
+	}
+}
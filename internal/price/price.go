@@ -2,52 +2,117 @@ package price
 
 import (
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-	"strconv"
-	"strings"
+	"math"
+	"sort"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/tidwall/gjson"
 )
 
+// Currency is a fiat currency that amounts can be entered in, like 5€ or 5EUR.
+type Currency struct {
+	Code   string
+	Symbol string
+}
+
+// Quote is the aggregated BTC price of a currency.
+type Quote struct {
+	Price     float64
+	UpdatedAt time.Time
+}
+
+var DefaultCurrencies = []Currency{
+	{Code: "EUR", Symbol: "€"},
+	{Code: "GBP", Symbol: "£"},
+	{Code: "JPY", Symbol: "¥"},
+	{Code: "BRL", Symbol: "R$"},
+	{Code: "MXN", Symbol: "MX$"},
+	{Code: "USD", Symbol: "$"},
+	{Code: "RUB", Symbol: "₽"},
+	{Code: "TRY", Symbol: "₺"},
+	{Code: "INR", Symbol: "₹"},
+}
+
 type PriceWatcher struct {
-	client         *http.Client
 	UpdateInterval time.Duration
-	Currencies     map[string]string
-	Exchanges      map[string]func(string) (float64, error)
+	// MaxAge is the age after which a quote is too old to be used for conversions
+	MaxAge time.Duration
+	// MaxDeviation is the relative distance from the median above which a source is ignored
+	MaxDeviation float64
+	// Currencies are ordered by symbol length, so that R$ is matched before $
+	Currencies []Currency
+	Sources    []PriceSource
+
+	mu     sync.RWMutex
+	quotes map[string]Quote
+	// daily are the historical prices by currency and day
+	daily map[string]float64
+}
+
+type Option func(p *PriceWatcher)
+
+func WithSources(sources ...PriceSource) Option {
+	return func(p *PriceWatcher) {
+		p.Sources = sources
+	}
+}
+
+func WithCurrencies(currencies []Currency) Option {
+	return func(p *PriceWatcher) {
+		p.Currencies = currencies
+	}
+}
+
+func WithUpdateInterval(d time.Duration) Option {
+	return func(p *PriceWatcher) {
+		p.UpdateInterval = d
+	}
+}
+
+func WithMaxAge(d time.Duration) Option {
+	return func(p *PriceWatcher) {
+		p.MaxAge = d
+	}
+}
+
+func WithMaxDeviation(deviation float64) Option {
+	return func(p *PriceWatcher) {
+		p.MaxDeviation = deviation
+	}
 }
 
 var (
-	Price map[string]float64
-	P     *PriceWatcher
+	P *PriceWatcher
+	// ErrNoPrice is returned for currencies without any quote yet
+	ErrNoPrice = fmt.Errorf("no price available")
+	// ErrStalePrice is returned when the last quote is older than MaxAge
+	ErrStalePrice = fmt.Errorf("price data is outdated")
 )
 
-func NewPriceWatcher() *PriceWatcher {
+// NewPriceWatcher creates the watcher and sets it as P. Without options it
+// polls Coinbase and Bitfinex for DefaultCurrencies.
+func NewPriceWatcher(opts ...Option) *PriceWatcher {
 	pricewatcher := &PriceWatcher{
-		client: &http.Client{
-			Timeout: time.Second * time.Duration(5),
-		},
-		// attention: $ must come after other $-denominated currencies like R$
-		Currencies: map[string]string{
-			"EUR": "€",
-			"GBP": "£",
-			"JPY": "¥",
-			"BRL": "R$",
-			"MXN": "MX$",
-			"USD": "$",
-			"RUB": "₽",
-			"TRY": "₺",
-			"INR": "₹",
-		},
-		Exchanges:      make(map[string]func(string) (float64, error), 0),
 		UpdateInterval: time.Second * time.Duration(30),
+		MaxAge:         time.Minute * time.Duration(5),
+		MaxDeviation:   0.05,
+		Currencies:     DefaultCurrencies,
+		quotes:         make(map[string]Quote),
+		daily:          make(map[string]float64),
 	}
-	pricewatcher.Exchanges["coinbase"] = pricewatcher.GetCoinbasePrice
-	pricewatcher.Exchanges["bitfinex"] = pricewatcher.GetBitfinexPrice
-	Price = make(map[string]float64, 0)
+	for _, opt := range opts {
+		opt(pricewatcher)
+	}
+	if len(pricewatcher.Sources) == 0 {
+		pricewatcher.Sources = []PriceSource{NewCoinbaseSource(), NewBitfinexSource()}
+	}
+	currencies := make([]Currency, len(pricewatcher.Currencies))
+	copy(currencies, pricewatcher.Currencies)
+	sort.SliceStable(currencies, func(i, j int) bool {
+		return len(currencies[i].Symbol) > len(currencies[j].Symbol)
+	})
+	pricewatcher.Currencies = currencies
 	log.Infof("[PriceWatcher] Watcher started")
 	P = pricewatcher
 	return pricewatcher
@@ -59,74 +124,124 @@ func (p *PriceWatcher) Start() {
 
 func (p *PriceWatcher) Watch() error {
 	for {
-		for currency, _ := range p.Currencies {
-			avg_price := 0.0
-			n_responses := 0
-			for _, getPrice := range p.Exchanges {
-				fprice, err := getPrice(currency)
-				if err != nil {
-					// log.Debug(err)
-					// if one exchanges is down, use the next
-					continue
-				}
-				n_responses++
-				avg_price += fprice
-				// log.Debugf("[PriceWatcher] %s %s price: %f", exchange, currency, fprice)
-				time.Sleep(time.Second * time.Duration(2))
+		for _, currency := range p.Currencies {
+			if err := p.Update(currency.Code); err != nil {
+				log.Warnf("[PriceWatcher] %s: %v", currency.Code, err)
 			}
-			Price[currency] = avg_price / float64(n_responses)
-			// log.Debugf("[PriceWatcher] Average %s price: %f", currency, Price[currency])
+			// don't hammer the exchanges
+			time.Sleep(time.Second * time.Duration(2))
 		}
 		time.Sleep(p.UpdateInterval)
 	}
 }
 
-func (p *PriceWatcher) GetCoinbasePrice(currency string) (float64, error) {
-	coinbaseEndpoint, err := url.Parse(fmt.Sprintf("https://api.coinbase.com/v2/prices/spot?currency=%s", currency))
-	response, err := p.client.Get(coinbaseEndpoint.String())
-	if err != nil {
-		return 0, err
+// Update asks all sources for the price of currency. The quote is only
+// replaced if the sources agree, otherwise the old quote ages until it is stale.
+func (p *PriceWatcher) Update(currency string) error {
+	prices := make([]float64, 0, len(p.Sources))
+	for _, source := range p.Sources {
+		fprice, err := source.Price(currency)
+		if err != nil || !(fprice > 0) || math.IsInf(fprice, 0) {
+			// if one exchange is down, use the next
+			log.Debugf("[PriceWatcher] %s %s: %v", source.Name(), currency, err)
+			continue
+		}
+		prices = append(prices, fprice)
 	}
-	bodyBytes, err := ioutil.ReadAll(response.Body)
+	fprice, err := aggregate(prices, p.MaxDeviation)
 	if err != nil {
-		log.Debug(err)
-		return 0, err
+		return err
 	}
-	price := gjson.Get(string(bodyBytes), "data.amount")
-	if len(price.String()) > 0 {
-		fprice, err := strconv.ParseFloat(strings.TrimSpace(price.String()), 64)
-		if err != nil {
-			log.Debug(err)
-			return 0, err
+	p.mu.Lock()
+	p.quotes[currency] = Quote{Price: fprice, UpdatedAt: time.Now()}
+	p.mu.Unlock()
+	return nil
+}
+
+// aggregate returns the median of all prices that are within maxDeviation of the median of all prices.
+func aggregate(prices []float64, maxDeviation float64) (float64, error) {
+	if len(prices) == 0 {
+		return 0, fmt.Errorf("no source returned a price")
+	}
+	m := median(prices)
+	accepted := make([]float64, 0, len(prices))
+	for _, fprice := range prices {
+		if math.Abs(fprice-m)/m <= maxDeviation {
+			accepted = append(accepted, fprice)
 		}
-		return fprice, nil
-	} else {
-		return 0, fmt.Errorf("no price")
 	}
+	if len(accepted) == 0 {
+		return 0, fmt.Errorf("sources disagree: %v", prices)
+	}
+	return median(accepted), nil
 }
 
-func (p *PriceWatcher) GetBitfinexPrice(currency string) (float64, error) {
-	var bitfinexCurrencyToPair = map[string]string{"USD": "btcusd", "EUR": "btceur", "GBP": "btcusd", "JPY": "btcjpy"}
-	pair := bitfinexCurrencyToPair[currency]
-	bitfinexEndpoint, err := url.Parse(fmt.Sprintf("https://api.bitfinex.com/v1/pubticker/%s", pair))
-	response, err := p.client.Get(bitfinexEndpoint.String())
-	if err != nil {
-		return 0, err
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Quote returns the last quote of currency, even if it is stale.
+func (p *PriceWatcher) Quote(currency string) (Quote, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	quote, ok := p.quotes[currency]
+	return quote, ok
+}
+
+// Get returns the BTC price of currency. It refuses quotes that are older than MaxAge.
+func (p *PriceWatcher) Get(currency string) (float64, error) {
+	quote, ok := p.Quote(currency)
+	if !ok {
+		return 0, ErrNoPrice
+	}
+	if p.MaxAge > 0 && time.Since(quote.UpdatedAt) > p.MaxAge {
+		return 0, ErrStalePrice
+	}
+	return quote.Price, nil
+}
+
+// PriceAt returns the BTC price of currency on the day of t from the sources that
+// have a history. Past days are cached, their prices don't change anymore.
+func (p *PriceWatcher) PriceAt(currency string, t time.Time) (float64, error) {
+	day := t.UTC().Format("2006-01-02")
+	key := currency + ":" + day
+	p.mu.RLock()
+	fprice, ok := p.daily[key]
+	p.mu.RUnlock()
+	if ok {
+		return fprice, nil
 	}
-	bodyBytes, err := ioutil.ReadAll(response.Body)
+	prices := make([]float64, 0, len(p.Sources))
+	for _, source := range p.Sources {
+		historical, ok := source.(HistoricalSource)
+		if !ok {
+			continue
+		}
+		fprice, err := historical.PriceAt(currency, t)
+		if err != nil || !(fprice > 0) || math.IsInf(fprice, 0) {
+			log.Debugf("[PriceWatcher] %s %s on %s: %v", source.Name(), currency, day, err)
+			continue
+		}
+		prices = append(prices, fprice)
+	}
+	if len(prices) == 0 {
+		return 0, ErrNoPrice
+	}
+	fprice, err := aggregate(prices, p.MaxDeviation)
 	if err != nil {
-		log.Debug(err)
 		return 0, err
 	}
-	price := gjson.Get(string(bodyBytes), "last_price")
-	if len(price.String()) > 0 {
-		fprice, err := strconv.ParseFloat(strings.TrimSpace(price.String()), 64)
-		if err != nil {
-			log.Debug(err)
-			return 0, err
-		}
-		return fprice, nil
-	} else {
-		return 0, fmt.Errorf("no price")
+	if day != time.Now().UTC().Format("2006-01-02") {
+		p.mu.Lock()
+		p.daily[key] = fprice
+		p.mu.Unlock()
 	}
+	return fprice, nil
 }
@@ -0,0 +1,91 @@
+package price
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name    string
+		prices  []float64
+		want    float64
+		wantErr bool
+	}{
+		{name: "single", prices: []float64{100}, want: 100},
+		{name: "median", prices: []float64{100, 102, 101}, want: 101},
+		{name: "outlier", prices: []float64{100, 102, 101, 500}, want: 101},
+		{name: "even", prices: []float64{100, 102}, want: 101},
+		{name: "disagree", prices: []float64{100, 200}, wantErr: true},
+		{name: "empty", prices: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := aggregate(tt.prices, 0.05)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("aggregate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("aggregate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriceWatcher_Get(t *testing.T) {
+	source := NewStaticSource(map[string]float64{"USD": 30_000})
+	p := NewPriceWatcher(WithSources(source), WithMaxAge(time.Minute))
+
+	if _, err := p.Get("USD"); !errors.Is(err, ErrNoPrice) {
+		t.Errorf("Get() before Update() error = %v, want %v", err, ErrNoPrice)
+	}
+	if err := p.Update("USD"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.Get("USD"); err != nil || got != 30_000 {
+		t.Errorf("Get() = %v, %v, want 30000", got, err)
+	}
+	if err := p.Update("EUR"); err == nil {
+		t.Errorf("Update() without any price should fail")
+	}
+
+	// a stale quote must not be used
+	p.mu.Lock()
+	p.quotes["USD"] = Quote{Price: 30_000, UpdatedAt: time.Now().Add(-2 * time.Minute)}
+	p.mu.Unlock()
+	if _, err := p.Get("USD"); !errors.Is(err, ErrStalePrice) {
+		t.Errorf("Get() error = %v, want %v", err, ErrStalePrice)
+	}
+}
+
+func TestPriceWatcher_PriceAt(t *testing.T) {
+	source := NewStaticSource(map[string]float64{"USD": 30_000})
+	p := NewPriceWatcher(WithSources(source))
+	day := time.Date(2023, 11, 14, 12, 0, 0, 0, time.UTC)
+
+	if got, err := p.PriceAt("USD", day); err != nil || got != 30_000 {
+		t.Fatalf("PriceAt() = %v, %v, want 30000", got, err)
+	}
+	// past days are cached
+	source.Set("USD", 40_000)
+	if got, _ := p.PriceAt("USD", day.Add(time.Hour)); got != 30_000 {
+		t.Errorf("PriceAt() later that day = %v, want 30000", got)
+	}
+	if _, err := p.PriceAt("EUR", day); !errors.Is(err, ErrNoPrice) {
+		t.Errorf("PriceAt() without price error = %v, want %v", err, ErrNoPrice)
+	}
+	// sources without a history are not asked
+	p = NewPriceWatcher(WithSources(NewBitfinexSource()))
+	if _, err := p.PriceAt("USD", day); !errors.Is(err, ErrNoPrice) {
+		t.Errorf("PriceAt() without historical source error = %v, want %v", err, ErrNoPrice)
+	}
+}
+
+func TestNewPriceWatcher_CurrencyOrder(t *testing.T) {
+	p := NewPriceWatcher(WithSources(NewStaticSource(nil)),
+		WithCurrencies([]Currency{{Code: "USD", Symbol: "$"}, {Code: "BRL", Symbol: "R$"}}))
+	if p.Currencies[0].Code != "BRL" {
+		t.Errorf("R$ must be matched before $, got %v", p.Currencies)
+	}
+}
@@ -0,0 +1,148 @@
+package price
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// PriceSource returns the current BTC price in a fiat currency.
+type PriceSource interface {
+	Name() string
+	Price(currency string) (float64, error)
+}
+
+// HistoricalSource returns the BTC price in a fiat currency on a past day.
+type HistoricalSource interface {
+	PriceSource
+	PriceAt(currency string, t time.Time) (float64, error)
+}
+
+var (
+	_ HistoricalSource = (*CoinbaseSource)(nil)
+	_ HistoricalSource = (*StaticSource)(nil)
+	_ PriceSource      = (*CoinbaseSource)(nil)
+	_ PriceSource      = (*BitfinexSource)(nil)
+	_ PriceSource      = (*StaticSource)(nil)
+)
+
+func newHttpClient() *http.Client {
+	return &http.Client{
+		Timeout: time.Second * time.Duration(5),
+	}
+}
+
+// getJsonField fetches endpoint and parses the float at path of the JSON response.
+func getJsonField(client *http.Client, endpoint string, path string) (float64, error) {
+	response, err := client.Get(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status %d", response.StatusCode)
+	}
+	bodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, err
+	}
+	price := gjson.Get(string(bodyBytes), path)
+	if len(price.String()) == 0 {
+		return 0, fmt.Errorf("no price")
+	}
+	return strconv.ParseFloat(strings.TrimSpace(price.String()), 64)
+}
+
+type CoinbaseSource struct {
+	client *http.Client
+}
+
+func NewCoinbaseSource() *CoinbaseSource {
+	return &CoinbaseSource{client: newHttpClient()}
+}
+
+func (s *CoinbaseSource) Name() string {
+	return "coinbase"
+}
+
+func (s *CoinbaseSource) Price(currency string) (float64, error) {
+	endpoint := fmt.Sprintf("https://api.coinbase.com/v2/prices/spot?currency=%s", url.QueryEscape(currency))
+	return getJsonField(s.client, endpoint, "data.amount")
+}
+
+// PriceAt returns the spot price of the day of t.
+func (s *CoinbaseSource) PriceAt(currency string, t time.Time) (float64, error) {
+	endpoint := fmt.Sprintf("https://api.coinbase.com/v2/prices/BTC-%s/spot?date=%s", url.PathEscape(currency), t.UTC().Format("2006-01-02"))
+	return getJsonField(s.client, endpoint, "data.amount")
+}
+
+type BitfinexSource struct {
+	client *http.Client
+}
+
+func NewBitfinexSource() *BitfinexSource {
+	return &BitfinexSource{client: newHttpClient()}
+}
+
+func (s *BitfinexSource) Name() string {
+	return "bitfinex"
+}
+
+var bitfinexCurrencyToPair = map[string]string{"USD": "btcusd", "EUR": "btceur", "GBP": "btcgbp", "JPY": "btcjpy"}
+
+func (s *BitfinexSource) Price(currency string) (float64, error) {
+	pair, ok := bitfinexCurrencyToPair[currency]
+	if !ok {
+		return 0, fmt.Errorf("bitfinex does not support %s", currency)
+	}
+	endpoint := fmt.Sprintf("https://api.bitfinex.com/v1/pubticker/%s", pair)
+	return getJsonField(s.client, endpoint, "last_price")
+}
+
+// StaticSource returns fixed prices. It is meant for tests and for development
+// setups without internet access.
+type StaticSource struct {
+	mu     sync.RWMutex
+	prices map[string]float64
+}
+
+func NewStaticSource(prices map[string]float64) *StaticSource {
+	s := &StaticSource{prices: make(map[string]float64)}
+	for currency, fprice := range prices {
+		s.prices[currency] = fprice
+	}
+	return s
+}
+
+func (s *StaticSource) Name() string {
+	return "static"
+}
+
+// Set changes the price of currency.
+func (s *StaticSource) Set(currency string, fprice float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prices[currency] = fprice
+}
+
+// PriceAt returns the fixed price, there is no history.
+func (s *StaticSource) PriceAt(currency string, t time.Time) (float64, error) {
+	return s.Price(currency)
+}
+
+func (s *StaticSource) Price(currency string) (float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fprice, ok := s.prices[currency]
+	if !ok {
+		return 0, fmt.Errorf("no static price for %s", currency)
+	}
+	return fprice, nil
+}
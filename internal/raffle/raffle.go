@@ -0,0 +1,111 @@
+// Package raffle draws the winners of a raffle with a commit and reveal scheme.
+//
+// A random seed is created when the raffle starts and only its commitment,
+// the hex sha256 of the seed, is published. When the raffle ends, the seed is
+// revealed and the winners are drawn from the participants sorted by their
+// ids: winner k is the participant at index sha256("<seed hex>:<k>") modulo
+// the number of remaining participants, where the hash is read as a big endian
+// uint64 of its first 8 bytes. Anyone can check the seed against the
+// commitment and repeat the draw.
+package raffle
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	seedLength  = 32
+	MinDuration = time.Minute
+	MaxDuration = 7 * 24 * time.Hour
+)
+
+// NewSeed returns a random seed.
+func NewSeed() ([]byte, error) {
+	seed := make([]byte, seedLength)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return seed, nil
+}
+
+// Commitment returns the hex sha256 of seed that is published before the draw.
+func Commitment(seed []byte) string {
+	h := sha256.Sum256(seed)
+	return hex.EncodeToString(h[:])
+}
+
+// Verify returns true if seed belongs to commitment.
+func Verify(commitment string, seed []byte) bool {
+	return strings.EqualFold(Commitment(seed), commitment)
+}
+
+// Draw returns up to winners participants. The result only depends on the seed
+// and the set of participants, not on their order.
+func Draw(seed []byte, participants []int64, winners int) []int64 {
+	pool := Sorted(participants)
+	seedHex := hex.EncodeToString(seed)
+	drawn := make([]int64, 0, winners)
+	for k := 0; k < winners && len(pool) > 0; k++ {
+		h := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", seedHex, k)))
+		i := binary.BigEndian.Uint64(h[:8]) % uint64(len(pool))
+		drawn = append(drawn, pool[i])
+		pool = append(pool[:i], pool[i+1:]...)
+	}
+	return drawn
+}
+
+// ParticipantsDigest returns the hex sha256 of the sorted participant ids
+// joined by commas, so that the list the draw was made from can be checked.
+func ParticipantsDigest(participants []int64) string {
+	ids := make([]string, 0, len(participants))
+	for _, id := range Sorted(participants) {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	h := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	return hex.EncodeToString(h[:])
+}
+
+var durationUnits = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// ParseDuration parses durations like 30m, 12h, 2d or 1w between MinDuration
+// and MaxDuration.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.ToLower(s)
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	unit, ok := durationUnits[s[len(s)-1:]]
+	if !ok {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	d := time.Duration(n) * unit
+	if d < MinDuration || d > MaxDuration {
+		return 0, fmt.Errorf("duration %q must be between %s and %s", s, MinDuration, MaxDuration)
+	}
+	return d, nil
+}
+
+// Sorted returns the ids in ascending order, the order winners are drawn from.
+func Sorted(ids []int64) []int64 {
+	sorted := make([]int64, len(ids))
+	copy(sorted, ids)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
@@ -0,0 +1,91 @@
+package raffle
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCommitment(t *testing.T) {
+	seed, err := NewSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitment := Commitment(seed)
+	if !Verify(commitment, seed) {
+		t.Fatal("seed does not verify against its commitment")
+	}
+	other := bytes.Repeat([]byte{1}, seedLength)
+	if Verify(commitment, other) {
+		t.Fatal("other seed verifies against commitment")
+	}
+}
+
+func TestDraw(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, seedLength)
+	participants := []int64{5, 3, 9, 1, 42, 17}
+	winners := Draw(seed, participants, 3)
+	if len(winners) != 3 {
+		t.Fatalf("got %d winners, want 3", len(winners))
+	}
+	seen := make(map[int64]bool)
+	for _, w := range winners {
+		if seen[w] {
+			t.Fatalf("%d drawn twice", w)
+		}
+		seen[w] = true
+	}
+	// the order of the participants does not change the result
+	shuffled := []int64{42, 17, 9, 5, 3, 1}
+	again := Draw(seed, shuffled, 3)
+	for i := range winners {
+		if winners[i] != again[i] {
+			t.Fatalf("draw depends on order: %v != %v", winners, again)
+		}
+	}
+	if got := Draw(seed, participants, 10); len(got) != len(participants) {
+		t.Fatalf("got %d winners, want all %d participants", len(got), len(participants))
+	}
+	if got := Draw(seed, nil, 2); len(got) != 0 {
+		t.Fatalf("got winners without participants: %v", got)
+	}
+	if participants[0] != 5 {
+		t.Fatal("draw modified the participants")
+	}
+}
+
+func TestParticipantsDigest(t *testing.T) {
+	if ParticipantsDigest([]int64{2, 1}) != ParticipantsDigest([]int64{1, 2}) {
+		t.Fatal("digest depends on order")
+	}
+	if ParticipantsDigest([]int64{1, 2}) == ParticipantsDigest([]int64{1, 3}) {
+		t.Fatal("digest of different participants is equal")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "30m", want: 30 * time.Minute},
+		{in: "12H", want: 12 * time.Hour},
+		{in: "2d", want: 48 * time.Hour},
+		{in: "1w", want: 7 * 24 * time.Hour},
+		{in: "0m", wantErr: true},
+		{in: "2w", wantErr: true},
+		{in: "10", wantErr: true},
+		{in: "h", wantErr: true},
+		{in: "-1h", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParseDuration(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ParseDuration(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
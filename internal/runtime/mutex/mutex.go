@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"sync"
 
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
 	"github.com/gorilla/mux"
 
 	cmap "github.com/orcaman/concurrent-map"
@@ -31,6 +32,7 @@ func UnlockHTTP(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	if m, ok := mutexMap.Get(vars["id"]); ok {
 		m.(*sync.Mutex).Unlock()
+		audit.Record(audit.Entry{Actor: audit.Actor(r.Context()), Action: "mutex_unlock", Target: vars["id"], Result: audit.ResultOk})
 		w.Write([]byte(fmt.Sprintf("Unlocked mutex %s.\nCurrent number of locks: %d\nLocks: %+v",
 			vars["id"], len(mutexMap.Keys()), mutexMap.Keys())))
 		return
@@ -0,0 +1,244 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinInterval is the shortest interval between two runs of a schedule.
+var MinInterval = time.Hour
+
+// Spec computes the run times of a recurring schedule.
+type Spec interface {
+	// Next returns the first run time strictly after t.
+	Next(t time.Time) time.Time
+	// String returns the canonical form of the spec that Parse accepts.
+	String() string
+}
+
+var namedSpecs = map[string]string{
+	"hourly":  "every 1h",
+	"daily":   "every 1d",
+	"weekly":  "every 1w",
+	"monthly": "every 1mo",
+}
+
+// Parse parses a spec like "daily", "every 3d", "12h" or a five field
+// cron expression like "0 9 * * 1".
+func Parse(s string) (Spec, error) {
+	fields := strings.Fields(s)
+	spec, n, err := ParseFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(fields) {
+		return nil, fmt.Errorf("unexpected %q in schedule", strings.Join(fields[n:], " "))
+	}
+	return spec, nil
+}
+
+// ParseFields parses a spec from the beginning of fields and returns
+// the number of fields it consumed. This lets commands put more
+// arguments after the spec.
+func ParseFields(fields []string) (Spec, int, error) {
+	if len(fields) == 0 {
+		return nil, 0, fmt.Errorf("no schedule given")
+	}
+	first := strings.TrimPrefix(strings.ToLower(fields[0]), "@")
+	if named, ok := namedSpecs[first]; ok {
+		spec, err := Parse(named)
+		return spec, 1, err
+	}
+	if first == "every" {
+		if len(fields) < 2 {
+			return nil, 0, fmt.Errorf("no interval given")
+		}
+		spec, err := parseInterval(fields[1])
+		return spec, 2, err
+	}
+	if spec, err := parseInterval(first); err == nil {
+		return spec, 1, nil
+	}
+	if len(fields) < 5 {
+		return nil, 0, fmt.Errorf("invalid schedule %q", fields[0])
+	}
+	spec, err := parseCron(fields[:5])
+	return spec, 5, err
+}
+
+// interval runs every months plus every duration.
+type interval struct {
+	months int
+	every  time.Duration
+	text   string
+}
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.AddDate(0, i.months, 0).Add(i.every)
+}
+
+func (i interval) String() string {
+	return "every " + i.text
+}
+
+var intervalUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	// "mo" has to be checked before "m"
+	{"mo", 0},
+	{"w", 7 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+}
+
+func parseInterval(s string) (Spec, error) {
+	s = strings.ToLower(s)
+	for _, u := range intervalUnits {
+		if !strings.HasSuffix(s, u.suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(s, u.suffix))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid interval %q", s)
+		}
+		if u.unit == 0 {
+			return interval{months: n, text: s}, nil
+		}
+		if time.Duration(n)*u.unit < MinInterval {
+			return nil, fmt.Errorf("interval %q is shorter than %s", s, MinInterval)
+		}
+		return interval{every: time.Duration(n) * u.unit, text: s}, nil
+	}
+	return nil, fmt.Errorf("invalid interval %q", s)
+}
+
+// cron is a classic five field cron expression evaluated in UTC.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	text                          string
+}
+
+var cronRanges = [5]struct{ min, max int }{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 6},  // day of week
+}
+
+func parseCron(fields []string) (Spec, error) {
+	// only allow a single minute so that a schedule runs at most once an hour
+	if _, err := strconv.Atoi(fields[0]); err != nil {
+		return nil, fmt.Errorf("the minute of a cron schedule must be a number")
+	}
+	var bits [5]uint64
+	for i, f := range fields {
+		b, err := parseCronField(f, cronRanges[i].min, cronRanges[i].max)
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = b
+	}
+	// 7 is sunday as well
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return cron{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+		text:    strings.Join(fields, " "),
+	}, nil
+}
+
+// parseCronField parses lists of values, ranges and steps like "1-5", "*/15" or "1,3".
+func parseCronField(field string, min, max int) (uint64, error) {
+	if min == 0 && max == 6 {
+		// day of week accepts 7 for sunday
+		max = 7
+	}
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return 0, fmt.Errorf("invalid step in %q", field)
+			}
+			step = s
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", field)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid range in %q", field)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", field, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	// like in cron, a restricted day of month and day of week match either one
+	if !c.domStar && !c.dowStar {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (c cron) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	// give up after five years, e.g. for "0 0 30 2 *"
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c cron) String() string {
+	return c.text
+}
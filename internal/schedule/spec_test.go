@@ -0,0 +1,59 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC) // a wednesday
+	tests := []struct {
+		spec    string
+		want    time.Time
+		wantErr bool
+	}{
+		{spec: "daily", want: start.AddDate(0, 0, 1)},
+		{spec: "every 3d", want: start.AddDate(0, 0, 3)},
+		{spec: "12h", want: start.Add(12 * time.Hour)},
+		{spec: "every 2w", want: start.AddDate(0, 0, 14)},
+		{spec: "monthly", want: start.AddDate(0, 1, 0)},
+		{spec: "0 9 * * 1", want: time.Date(2024, time.February, 5, 9, 0, 0, 0, time.UTC)},
+		{spec: "30 10 * * *", want: time.Date(2024, time.February, 1, 10, 30, 0, 0, time.UTC)},
+		{spec: "0 0 1,15 * *", want: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 12 * * 7", want: time.Date(2024, time.February, 4, 12, 0, 0, 0, time.UTC)},
+		{spec: "0 */6 * * *", want: time.Date(2024, time.January, 31, 12, 0, 0, 0, time.UTC)},
+		{spec: "10m", wantErr: true},
+		{spec: "* * * * *", wantErr: true},
+		{spec: "0 24 * * *", wantErr: true},
+		{spec: "sometimes", wantErr: true},
+		{spec: "daily extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			spec, err := Parse(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := spec.Next(start); !got.Equal(tt.want) {
+				t.Errorf("Next() = %s, want %s", got, tt.want)
+			}
+			if _, err := Parse(spec.String()); err != nil {
+				t.Errorf("Parse(String()) = %v", err)
+			}
+		})
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	fields := []string{"0", "9", "*", "*", "1-5", "until:2025-01-01", "rent"}
+	spec, n, err := ParseFields(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 || spec.String() != "0 9 * * 1-5" {
+		t.Errorf("ParseFields() = %q, %d, want %q, 5", spec, n, "0 9 * * 1-5")
+	}
+}
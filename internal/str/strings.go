@@ -21,6 +21,16 @@ func MarkdownV2Escape(s string) string {
 	return s
 }
 
+// Truncate cuts s to at most n characters and marks the cut with "...". Unlike
+// slicing the string, it never splits a multi-byte character.
+func Truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
+
 func MarkdownEscape(s string) string {
 	for _, esc := range markdownEscapes {
 		if strings.Contains(s, esc) {
@@ -0,0 +1,57 @@
+package telegram
+
+import (
+	"fmt"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	log "github.com/sirupsen/logrus"
+)
+
+// withoutSpendingLimits returns a copy of the bot that pays with the wallet
+// backend directly. It is meant for admin bookings that must not be stopped by
+// the limits of a user.
+func (bot *TipBot) withoutSpendingLimits() *TipBot {
+	unguarded := *bot
+	switch guard := bot.Client.(type) {
+	case *spendingGuard:
+		unguarded.Client = guard.WalletBackend
+	case *transferSpendingGuard:
+		unguarded.Client = guard.WalletBackend
+	}
+	return &unguarded
+}
+
+// AdjustBalance credits (amount > 0) or debits (amount < 0) the wallet of user
+// from the bot wallet. The reason is stored as memo of the transaction. An
+// adjustment with a key that was used before for the user is not booked again.
+func (bot *TipBot) AdjustBalance(user *lnbits.User, amount int64, reason string, key string) (*Transaction, error) {
+	if amount == 0 {
+		return nil, fmt.Errorf("amount must not be 0")
+	}
+	if user.Wallet == nil {
+		return nil, fmt.Errorf("user has no wallet")
+	}
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return nil, err
+	}
+	from, to := me, user
+	if amount < 0 {
+		from, to, amount = user, me, -amount
+	}
+	key = fmt.Sprintf("admin:%s:%s", user.Name, key)
+	if previous, err := bot.getTransactionByIdempotencyKey(key); err == nil && (previous.Amount != amount || previous.FromId != from.Telegram.ID) {
+		return nil, fmt.Errorf("idempotency key was used for another adjustment")
+	}
+	t := NewTransaction(bot.withoutSpendingLimits(), from, to, amount, TransactionType("admin"), TransactionIdempotencyKey(key))
+	t.Memo = fmt.Sprintf("Adjustment: %s", reason)
+	success, err := t.Send()
+	if !success {
+		if err == nil {
+			err = fmt.Errorf("transaction failed")
+		}
+		return t, err
+	}
+	log.Infof("[AdjustBalance] %s -> %s %d sat: %s", t.FromUser, t.ToUser, amount, reason)
+	return t, nil
+}
@@ -0,0 +1,37 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+)
+
+func TestTipBot_AdjustBalance(t *testing.T) {
+	fake := lnbits.NewFakeBackend()
+	bot := newTestBot(t, fake)
+	me := newTestUser(t, bot, fake, 1, 100)
+	alice := newTestUser(t, bot, fake, 2, 0)
+
+	if _, err := bot.AdjustBalance(alice, 40, "refund", "ticket-1"); err != nil {
+		t.Fatal(err)
+	}
+	// a retried call doesn't credit the user again
+	if _, err := bot.AdjustBalance(alice, 40, "refund", "ticket-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := balanceOf(t, bot, alice); got != 40 {
+		t.Errorf("balance of alice = %d, want 40", got)
+	}
+	if _, err := bot.AdjustBalance(alice, -40, "refund", "ticket-1"); err == nil {
+		t.Error("AdjustBalance() reusing the key for a debit succeeded")
+	}
+	if _, err := bot.AdjustBalance(alice, -10, "correction", "ticket-2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := balanceOf(t, bot, alice); got != 30 {
+		t.Errorf("balance of alice = %d, want 30", got)
+	}
+	if got := balanceOf(t, bot, me); got != 70 {
+		t.Errorf("balance of the bot = %d, want 70", got)
+	}
+}
@@ -45,9 +45,25 @@ func decodeAmountFromCommand(input string) (amount int64, err error) {
 	return amount, err
 }
 
+// decodeFiatAmountFromCommand is like decodeAmountFromCommand but also returns
+// the fiat amount if the amount was given in a fiat currency.
+func decodeFiatAmountFromCommand(input string) (amount int64, fiat *FiatAmount, err error) {
+	if len(strings.Split(input, " ")) < 2 {
+		return 0, nil, fmt.Errorf("message doesn't contain any amount")
+	}
+	return GetFiatAmount(strings.Split(input, " ")[1])
+}
+
 // GetAmount parses an amount from a string like 1.2k or 3.50€
 // and returns the value in satoshis
 func GetAmount(input string) (amount int64, err error) {
+	amount, _, err = GetFiatAmount(input)
+	return amount, err
+}
+
+// GetFiatAmount parses an amount like GetAmount. If the amount was given in a
+// fiat currency, it also returns the fiat amount and the rate it was converted at.
+func GetFiatAmount(input string) (amount int64, fiat *FiatAmount, err error) {
 	// replace occurances of comma with dot
 	input = strings.Replace(input, ",", ".", -1)
 
@@ -60,14 +76,15 @@ func GetAmount(input string) (amount int64, err error) {
 	if strings.HasSuffix(strings.ToLower(input), "k") {
 		fmount, err := strconv.ParseFloat(strings.TrimSpace(input[:len(input)-1]), 64)
 		if err != nil {
-			return 0, err
+			return 0, nil, err
 		}
 		amount = int64(fmount * 1000)
-		return amount, err
+		return amount, nil, err
 	}
 
 	// convert fiat currencies to satoshis
-	for currency, symbol := range price.P.Currencies {
+	for _, c := range price.P.Currencies {
+		currency, symbol := c.Code, c.Symbol
 		if strings.HasPrefix(input, symbol) || strings.HasSuffix(input, symbol) || // for 1$ and $1
 			strings.HasPrefix(strings.ToLower(input), strings.ToLower(currency)) || // for USD1
 			strings.HasSuffix(strings.ToLower(input), strings.ToLower(currency)) { // for 1USD
@@ -77,32 +94,29 @@ func GetAmount(input string) (amount int64, err error) {
 			fmount, err := strconv.ParseFloat(numeric_string, 64)
 			if err != nil {
 				log.Errorln(err)
-				return 0, err
-			}
-			if !(price.Price[currency] > 0) {
-				return 0, fmt.Errorf("price is zero")
+				return 0, nil, err
 			}
-			amount = int64(fmount / price.Price[currency] * float64(100_000_000))
-			return amount, nil
+			return lockFiatAmount(currency, fmount)
 		}
 	}
 
 	// use plain integer as satoshis
 	amount, err = strconv.ParseInt(input, 10, 64)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 	if amount <= 0 {
-		return 0, fmt.Errorf("amount must be greater than 0")
+		return 0, nil, fmt.Errorf("amount must be greater than 0")
 	}
-	return amount, err
+	return amount, nil, err
 }
 
 func SatoshisToFiat(amount int64, currency string) (fiat float64, err error) {
-	if !(price.Price[currency] > 0) {
-		return 0, fmt.Errorf("price is zero")
+	fprice, err := price.P.Get(currency)
+	if err != nil {
+		return 0, fmt.Errorf("could not convert %s: %w", currency, err)
 	}
-	fiat = float64(amount) / 100_000_000 * price.Price[currency]
+	fiat = float64(amount) / 100_000_000 * fprice
 	return fiat, nil
 }
 
@@ -228,7 +242,8 @@ func (bot *TipBot) enterAmountHandler(ctx intercept.Context) (intercept.Context,
 		SetUserState(user, bot, lnbits.UserHasEnteredAmount, string(StateDataJson))
 		return bot.lnurlWithdrawHandlerWithdraw(ctx)
 	case "CreateInvoiceState":
-		ctx.Message().Text = fmt.Sprintf("/invoice %d", amount)
+		// pass the amount as entered, so that a fiat amount stays a fiat invoice
+		ctx.Message().Text = fmt.Sprintf("/invoice %s", strings.TrimSpace(ctx.Message().Text))
 		SetUserState(user, bot, lnbits.UserHasEnteredAmount, "")
 		return bot.invoiceHandler(ctx)
 	case "CreateDonationState":
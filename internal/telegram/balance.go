@@ -33,6 +33,12 @@ func (bot *TipBot) balanceHandler(ctx intercept.Context) (intercept.Context, err
 		return bot.startHandler(ctx)
 	}
 
+	// /balance <wallet> shows one of the other wallets of the user
+	user, err := bot.selectWallet(ctx, user)
+	if err != nil {
+		return ctx, err
+	}
+
 	usrStr := GetUserStr(ctx.Sender())
 	balance, err := bot.GetUserBalance(user)
 	if err != nil {
@@ -42,6 +48,10 @@ func (bot *TipBot) balanceHandler(ctx intercept.Context) (intercept.Context, err
 	}
 
 	log.Infof("[/balance] %s's balance: %d sat\n", usrStr, balance)
+	if user.Wallet.Detached {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(walletsBalanceMessage, user.Wallet.Name, balance))
+		return ctx, nil
+	}
 	bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "balanceMessage"), balance))
 	return ctx, nil
 }
@@ -1,6 +1,7 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -17,6 +18,7 @@ import (
 	"github.com/LightningTipBot/LightningTipBot/internal"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
 	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/webhooks"
 	gocache "github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
@@ -27,7 +29,7 @@ type TipBot struct {
 	Bunt     *storage.DB
 	ShopBunt *storage.DB
 	Telegram *tb.Bot
-	Client   *lnbits.Client
+	Client   lnbits.WalletBackend
 	limiter  map[string]limiter.Limiter
 	Cache
 }
@@ -47,14 +49,29 @@ func NewBot() TipBot {
 	// create sqlite databases
 	dbs := AutoMigration()
 	limiter.Start()
-	return TipBot{
+	bot := TipBot{
 		DB:       dbs,
-		Client:   lnbits.NewClient(internal.Configuration.Lnbits.AdminKey, internal.Configuration.Lnbits.Url),
 		Bunt:     createBunt(internal.Configuration.Database.BuntDbPath),
 		ShopBunt: createBunt(internal.Configuration.Database.ShopBuntDbPath),
 		Telegram: newTelegramBot(),
 		Cache:    Cache{GoCacheStore: gocacheStore},
 	}
+	// all outgoing payments go through the spending limits of the users
+	bot.Client = newSpendingGuard(newWalletBackend(), bot)
+	return bot
+}
+
+// newWalletBackend returns the wallet backend selected in the configuration.
+func newWalletBackend() lnbits.WalletBackend {
+	switch internal.Configuration.Lnbits.Backend {
+	case lnbits.BackendFake:
+		log.Warnf("[WalletBackend] Using in-memory fake ledger. Funds are not real and will be lost on restart.")
+		return lnbits.NewFakeBackend()
+	case lnbits.BackendLNbits:
+		return lnbits.NewClient(internal.Configuration.Lnbits.AdminKey, internal.Configuration.Lnbits.Url)
+	default:
+		panic(fmt.Errorf("unknown wallet backend %q", internal.Configuration.Lnbits.Backend))
+	}
 }
 
 // newTelegramBot will create a new Telegram bot.
@@ -116,6 +133,12 @@ func (bot *TipBot) Start() {
 		log.Errorf("Could not initialize bot wallet: %s", err.Error())
 	}
 
+	// resolve transfers that were interrupted by a restart
+	bot.reconcilePendingTransactions()
+	bot.reconcileTreasuryPayouts()
+	bot.startTransactionReconciler()
+	bot.startRetiredWalletSweeper()
+
 	// register telegram handlers
 	bot.registerTelegramHandlers()
 
@@ -136,6 +159,16 @@ func (bot *TipBot) Start() {
 	go bot.Telegram.Start()
 
 	go bot.restartPersistedTickets()
+	go bot.restartPersistedSchedules()
+	go bot.restartPersistedBounties()
+	go bot.restartPersistedEscrows()
+	go bot.restartPersistedPolls()
+	go bot.restartPersistedRaffles()
+
+	// deliver queued webhook events, including those from before a restart
+	webhooks.D.Start(context.Background())
+	// answer nostr wallet connect requests
+	bot.startNwcService(context.Background())
 	// gracefully shutdown
 	exit := make(chan os.Signal, 1) // we need to reserve to buffer size 1, so the notifier are not blocked
 	// we need to catch SIGTERM and SIGSTOP
@@ -0,0 +1,598 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	bountyMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnPayBounty     = bountyMenu.Data("💰 Pay out", "pay_bounty")
+	btnCancelBounty  = bountyMenu.Data("🚫 Cancel", "cancel_bounty")
+	btnApproveClaim  = bountyMenu.Data("✅ Approve", "approve_claim")
+	btnRejectClaim   = bountyMenu.Data("❌ Reject", "reject_claim")
+	errNoBountyClaim = fmt.Errorf("no approved claims")
+)
+
+const (
+	bountyTTL           = 7 * 24 * time.Hour
+	bountyRetryInterval = 10 * time.Minute
+	maxBountyClaims     = 20
+)
+
+const (
+	BountyOpen     = "open"
+	BountyPaid     = "paid"
+	BountyRefunded = "refunded"
+
+	BountyClaimOpen     = "open"
+	BountyClaimApproved = "approved"
+	BountyClaimRejected = "rejected"
+)
+
+var (
+	bountyHelpMessage      = "🎯 *Bounties*\n`/bounty <amount> <description>` Put sats on a task. The sats are held by the bot until you pay out.\nReply to a bounty to claim it. The creator or a group admin approves claims, *Pay out* splits the bounty between all approved claims. Open bounties are paid out to approved claims or refunded after 7 days."
+	bountyMessage          = "🎯 *Bounty: %d sat* by %s\n\n%s\n\n_Reply to this message to claim it._\nClaims: %d, approved: %d. Expires %s."
+	bountyPaidMessage      = "🎯 *Bounty: %d sat* by %s\n\n%s\n\n✅ Paid out to %s."
+	bountyRefundedMessage  = "🎯 *Bounty: %d sat* by %s\n\n%s\n\n↩️ Closed without payout. The sats were refunded."
+	bountyClaimMessage     = "🙋 %s claims the bounty of %s:\n%s"
+	bountyClaimApproved    = "✅ Claim of %s approved by %s."
+	bountyClaimRejected    = "❌ Claim of %s rejected by %s."
+	bountyPrivateMessage   = "🎯 Bounties can only be created in groups."
+	bountyBalanceMessage   = "🚫 Your balance is too low for this bounty."
+	bountyFailedMessage    = "🚫 Couldn't create the bounty. Please try again later."
+	bountyClosedMessage    = "🎯 This bounty is closed."
+	bountyOwnClaimMessage  = "🎯 You can't claim your own bounty."
+	bountyClaimedMessage   = "🎯 You already claimed this bounty."
+	bountyFullMessage      = "🎯 This bounty has too many claims."
+	bountyNotAllowed       = "🚫 Only the creator of the bounty or an admin can do this."
+	bountyNoApprovedClaims = "🎯 Approve a claim first."
+	bountyPayoutFailed     = "🚫 Some payouts failed. Press Pay out to try again."
+	bountyPayoutStarted    = "🎯 The payout has started, claims can't be changed anymore."
+	bountyPaidReceived     = "🎯 You received %d sat for the bounty of %s."
+	bountyRefundReceived   = "↩️ Your bounty of %d sat was refunded."
+)
+
+// BountyClaim is a reply to a bounty by a user who wants to be paid.
+type BountyClaim struct {
+	Claimant  *tb.User `json:"claimant"`
+	Note      string   `json:"note"`
+	MessageID int      `json:"message_id"` // message with the approve and reject buttons
+	Status    string   `json:"status"`
+	Amount    int64    `json:"amount"` // share once the bounty is paid out
+	Paid      bool     `json:"paid"`
+}
+
+// Bounty holds sats of its creator in the bot wallet until they are paid to
+// approved claims or refunded.
+type Bounty struct {
+	*storage.Base
+	From         *lnbits.User   `json:"from"`
+	Amount       int64          `json:"amount"`
+	Description  string         `json:"description"`
+	ChatID       int64          `json:"chat_id"`
+	ChatTitle    string         `json:"chat_title"`
+	MessageID    int            `json:"message_id"`
+	Claims       []*BountyClaim `json:"claims"`
+	Status       string         `json:"status"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+	RetryAt      time.Time      `json:"retry_at"`
+	LanguageCode string         `json:"languagecode"`
+}
+
+// bountyMessageRef points from the message of a bounty to the bounty, so that
+// replies to the message can be matched.
+type bountyMessageRef struct {
+	*storage.Base
+	BountyID string `json:"bounty_id"`
+}
+
+func bountyMessageKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("bounty-message:%d:%d", chatID, messageID)
+}
+
+func (b *Bounty) message() tb.Editable {
+	return &tb.StoredMessage{MessageID: strconv.Itoa(b.MessageID), ChatID: b.ChatID}
+}
+
+func (b *Bounty) approvedClaims() []*BountyClaim {
+	var approved []*BountyClaim
+	for _, c := range b.Claims {
+		if c.Status == BountyClaimApproved {
+			approved = append(approved, c)
+		}
+	}
+	return approved
+}
+
+// payoutStarted returns true once the shares of the approved claims are fixed.
+// From then on the claims can't be approved or rejected anymore.
+func (b *Bounty) payoutStarted() bool {
+	for _, c := range b.Claims {
+		if c.Paid || c.Amount > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// text returns the message of the bounty in its current state.
+func (b *Bounty) text() string {
+	from := GetUserStrMd(b.From.Telegram)
+	description := str.MarkdownEscape(b.Description)
+	switch b.Status {
+	case BountyPaid:
+		var recipients []string
+		for _, c := range b.approvedClaims() {
+			recipients = append(recipients, fmt.Sprintf("%s (%d sat)", GetUserStrMd(c.Claimant), c.Amount))
+		}
+		return fmt.Sprintf(bountyPaidMessage, b.Amount, from, description, strings.Join(recipients, ", "))
+	case BountyRefunded:
+		return fmt.Sprintf(bountyRefundedMessage, b.Amount, from, description)
+	}
+	return fmt.Sprintf(bountyMessage, b.Amount, from, description, len(b.Claims), len(b.approvedClaims()), b.ExpiresAt.UTC().Format("2 Jan 15:04 UTC"))
+}
+
+func (b *Bounty) keyboard() *tb.ReplyMarkup {
+	if b.Status != BountyOpen {
+		return &tb.ReplyMarkup{}
+	}
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	menu.Inline(menu.Row(
+		menu.Data(btnPayBounty.Text, btnPayBounty.Unique, b.ID),
+		menu.Data(btnCancelBounty.Text, btnCancelBounty.Unique, b.ID)))
+	return menu
+}
+
+func claimKeyboard(bountyID string, claim int) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	data := fmt.Sprintf("%s|%d", bountyID, claim)
+	menu.Inline(menu.Row(
+		menu.Data(btnApproveClaim.Text, btnApproveClaim.Unique, data),
+		menu.Data(btnRejectClaim.Text, btnRejectClaim.Unique, data)))
+	return menu
+}
+
+func (bot *TipBot) loadBounty(id string) (*Bounty, error) {
+	b := &Bounty{Base: storage.New(storage.ID(id))}
+	sn, err := b.Get(b, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*Bounty), nil
+}
+
+// mayManageBounty returns true if user created the bounty or is an admin of its group.
+func (bot *TipBot) mayManageBounty(b *Bounty, user *tb.User) bool {
+	return user.ID == b.From.Telegram.ID || bot.isAdmin(&tb.Chat{ID: b.ChatID}, user)
+}
+
+// bountyHandler invoked on "/bounty <amount> <description>"
+func (bot *TipBot) bountyHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, bountyPrivateMessage)
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	args := strings.Fields(m.Text)
+	if len(args) < 3 {
+		bot.trySendMessage(m.Sender, bountyHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(args[1])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, bountyHelpMessage)
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	from := LoadUser(ctx)
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	b := &Bounty{
+		Base:         storage.New(storage.ID(fmt.Sprintf("bounty:%s", RandStringRunes(10)))),
+		From:         from,
+		Amount:       amount,
+		Description:  GetMemoFromCommand(m.Text, 2),
+		ChatID:       m.Chat.ID,
+		ChatTitle:    m.Chat.Title,
+		Status:       BountyOpen,
+		ExpiresAt:    time.Now().Add(bountyTTL),
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	b.Description = str.Truncate(b.Description, 500)
+
+	// move the sats of the creator to the bot until the bounty is closed
+	t := NewTransaction(bot, from, me, amount, TransactionType("bounty"), TransactionChat(m.Chat),
+		TransactionIdempotencyKey(b.ID+":escrow"))
+	t.Memo = fmt.Sprintf("🎯 Bounty in %s.", m.Chat.Title)
+	if success, err := t.Send(); !success {
+		log.Warnf("[/bounty] Escrow of %s failed: %v", GetUserStr(from.Telegram), err)
+		balance, _ := bot.GetUserBalance(from)
+		if balance < amount {
+			bot.trySendMessage(m.Sender, bountyBalanceMessage)
+		} else {
+			bot.trySendMessage(m.Sender, bountyFailedMessage)
+		}
+		return ctx, err
+	}
+
+	msg := bot.trySendMessage(m.Chat, b.text(), b.keyboard())
+	if msg == nil {
+		b.Status = BountyRefunded
+		bot.refundBounty(b)
+		return ctx, fmt.Errorf("could not send bounty %s", b.ID)
+	}
+	b.MessageID = msg.ID
+	ref := &bountyMessageRef{Base: storage.New(storage.ID(bountyMessageKey(b.ChatID, b.MessageID))), BountyID: b.ID}
+	if err := ref.Set(ref, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	if err := b.Set(b, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	bot.startBountyTimer(b)
+	log.Infof("[🎯 bounty] %s created bounty %s of %d sat in %d.", GetUserStr(from.Telegram), b.ID, amount, b.ChatID)
+	return ctx, nil
+}
+
+// claimBountyHandler is invoked on replies to bounties. ok is false if the
+// message is not a reply to a bounty.
+func (bot *TipBot) claimBountyHandler(ctx intercept.Context) (c intercept.Context, ok bool, err error) {
+	m := ctx.Message()
+	if !m.IsReply() {
+		return ctx, false, nil
+	}
+	ref := &bountyMessageRef{Base: storage.New(storage.ID(bountyMessageKey(m.Chat.ID, m.ReplyTo.ID)))}
+	rn, err := ref.Get(ref, bot.Bunt)
+	if err != nil {
+		return ctx, false, nil
+	}
+	id := rn.(*bountyMessageRef).BountyID
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	b, err := bot.loadBounty(id)
+	if err != nil {
+		return ctx, true, err
+	}
+	if b.Status != BountyOpen {
+		bot.trySendMessage(m.Sender, bountyClosedMessage)
+		return ctx, true, errors.Create(errors.NotActiveError)
+	}
+	if m.Sender.ID == b.From.Telegram.ID {
+		bot.trySendMessage(m.Sender, bountyOwnClaimMessage)
+		return ctx, true, errors.Create(errors.SelfPaymentError)
+	}
+	for _, claim := range b.Claims {
+		if claim.Claimant.ID == m.Sender.ID {
+			bot.trySendMessage(m.Sender, bountyClaimedMessage)
+			return ctx, true, fmt.Errorf("already claimed")
+		}
+	}
+	if len(b.Claims) >= maxBountyClaims {
+		bot.trySendMessage(m.Sender, bountyFullMessage)
+		return ctx, true, fmt.Errorf("too many claims")
+	}
+	note := m.Text
+	if strings.HasPrefix(note, "/") {
+		note = GetMemoFromCommand(note, 1)
+	}
+	note = str.Truncate(note, 300)
+	claim := &BountyClaim{Claimant: m.Sender, Note: note, Status: BountyClaimOpen}
+	text := fmt.Sprintf(bountyClaimMessage, GetUserStrMd(m.Sender), GetUserStrMd(b.From.Telegram), str.MarkdownEscape(note))
+	msg := bot.tryReplyMessage(m, text, claimKeyboard(b.ID, len(b.Claims)))
+	if msg == nil {
+		return ctx, true, fmt.Errorf("could not send claim")
+	}
+	claim.MessageID = msg.ID
+	b.Claims = append(b.Claims, claim)
+	if err := b.Set(b, bot.Bunt); err != nil {
+		return ctx, true, err
+	}
+	bot.tryEditMessage(b.message(), b.text(), b.keyboard())
+	log.Infof("[🎯 bounty] %s claimed bounty %s.", GetUserStr(m.Sender), b.ID)
+	return ctx, true, nil
+}
+
+// claimHandler invoked on "/claim [<note>]" as a reply to a bounty
+func (bot *TipBot) claimHandler(ctx intercept.Context) (intercept.Context, error) {
+	ctx, ok, err := bot.claimBountyHandler(ctx)
+	if !ok {
+		bot.trySendMessage(ctx.Message().Sender, bountyHelpMessage)
+	}
+	return ctx, err
+}
+
+func (bot *TipBot) approveClaimHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.decideClaim(ctx, BountyClaimApproved)
+}
+
+func (bot *TipBot) rejectClaimHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.decideClaim(ctx, BountyClaimRejected)
+}
+
+func (bot *TipBot) decideClaim(ctx intercept.Context, status string) (intercept.Context, error) {
+	c := ctx.Callback()
+	id, index, _ := strings.Cut(ctx.Data(), "|")
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return ctx, err
+	}
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	b, err := bot.loadBounty(id)
+	if err != nil {
+		return ctx, err
+	}
+	if i < 0 || i >= len(b.Claims) {
+		return ctx, fmt.Errorf("invalid claim %d of %s", i, id)
+	}
+	if b.Status != BountyOpen {
+		ctx.Context = context.WithValue(ctx, "callback_response", bountyClosedMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if !bot.mayManageBounty(b, c.Sender) {
+		ctx.Context = context.WithValue(ctx, "callback_response", bountyNotAllowed)
+		return ctx, fmt.Errorf("%s may not manage %s", GetUserStr(c.Sender), id)
+	}
+	if b.payoutStarted() {
+		ctx.Context = context.WithValue(ctx, "callback_response", bountyPayoutStarted)
+		return ctx, fmt.Errorf("payout of %s has started", id)
+	}
+	claim := b.Claims[i]
+	claim.Status = status
+	if err := b.Set(b, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	text := bountyClaimApproved
+	if status == BountyClaimRejected {
+		text = bountyClaimRejected
+	}
+	bot.tryEditMessage(c.Message, fmt.Sprintf(text, GetUserStrMd(claim.Claimant), GetUserStrMd(c.Sender)), &tb.ReplyMarkup{})
+	bot.tryEditMessage(b.message(), b.text(), b.keyboard())
+	log.Infof("[🎯 bounty] Claim %d of bounty %s %s by %s.", i, id, status, GetUserStr(c.Sender))
+	return ctx, nil
+}
+
+func (bot *TipBot) payBountyHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	id := ctx.Data()
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	b, err := bot.loadBounty(id)
+	if err != nil {
+		return ctx, err
+	}
+	if b.Status != BountyOpen {
+		ctx.Context = context.WithValue(ctx, "callback_response", bountyClosedMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if !bot.mayManageBounty(b, c.Sender) {
+		ctx.Context = context.WithValue(ctx, "callback_response", bountyNotAllowed)
+		return ctx, fmt.Errorf("%s may not manage %s", GetUserStr(c.Sender), id)
+	}
+	if err := bot.releaseBounty(b); err != nil {
+		if err == errNoBountyClaim {
+			ctx.Context = context.WithValue(ctx, "callback_response", bountyNoApprovedClaims)
+		} else {
+			ctx.Context = context.WithValue(ctx, "callback_response", bountyPayoutFailed)
+		}
+		return ctx, err
+	}
+	return ctx, nil
+}
+
+func (bot *TipBot) cancelBountyHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	id := ctx.Data()
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	b, err := bot.loadBounty(id)
+	if err != nil {
+		return ctx, err
+	}
+	if b.Status != BountyOpen {
+		ctx.Context = context.WithValue(ctx, "callback_response", bountyClosedMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if !bot.mayManageBounty(b, c.Sender) {
+		ctx.Context = context.WithValue(ctx, "callback_response", bountyNotAllowed)
+		return ctx, fmt.Errorf("%s may not manage %s", GetUserStr(c.Sender), id)
+	}
+	// a partly paid bounty can only be finished
+	for _, claim := range b.Claims {
+		if claim.Paid {
+			ctx.Context = context.WithValue(ctx, "callback_response", bountyPayoutFailed)
+			return ctx, fmt.Errorf("bounty %s is partly paid", id)
+		}
+	}
+	return ctx, bot.refundBounty(b)
+}
+
+// releaseBounty splits the bounty between the approved claims. Claims that
+// were paid in an earlier attempt are skipped. The caller must hold the lock
+// of the bounty.
+func (bot *TipBot) releaseBounty(b *Bounty) error {
+	approved := b.approvedClaims()
+	if len(approved) == 0 {
+		return errNoBountyClaim
+	}
+	// the shares are fixed with the first payout
+	if approved[0].Amount == 0 {
+		weights := make([]int64, len(approved))
+		for i := range weights {
+			weights[i] = 1
+		}
+		shares, err := splitAmount(b.Amount, weights)
+		if err != nil {
+			return err
+		}
+		for i, claim := range approved {
+			claim.Amount = shares[i]
+		}
+	}
+	var total int64
+	for _, claim := range approved {
+		if claim.Amount < 1 {
+			return fmt.Errorf("claim of %s in %s has no share", GetUserStr(claim.Claimant), b.ID)
+		}
+		total += claim.Amount
+	}
+	if total != b.Amount {
+		return fmt.Errorf("shares of %s add up to %d sat instead of %d sat", b.ID, total, b.Amount)
+	}
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return err
+	}
+	unguarded := bot.withoutSpendingLimits()
+	var failed error
+	for _, claim := range approved {
+		if claim.Paid {
+			continue
+		}
+		to, err := bot.bountyClaimant(claim)
+		if err != nil {
+			failed = err
+			continue
+		}
+		t := NewTransaction(unguarded, me, to, claim.Amount, TransactionType("bounty payout"),
+			TransactionIdempotencyKey(fmt.Sprintf("%s:%d", b.ID, claim.Claimant.ID)))
+		t.ChatID, t.ChatName = b.ChatID, b.ChatTitle
+		t.Memo = fmt.Sprintf("🎯 Bounty of %s.", GetUserStr(b.From.Telegram))
+		if success, err := t.Send(); !success {
+			log.Errorf("[bounty] Payout of %s to %s failed: %v", b.ID, GetUserStr(claim.Claimant), err)
+			failed = fmt.Errorf("payout failed: %v", err)
+			continue
+		}
+		claim.Paid = true
+		bot.trySendMessage(claim.Claimant, fmt.Sprintf(bountyPaidReceived, claim.Amount, GetUserStrMd(b.From.Telegram)))
+	}
+	if failed == nil {
+		b.Status = BountyPaid
+		b.Active = false
+		stopBountyTimer(b.ID)
+		log.Infof("[🎯 bounty] Bounty %s paid out to %d claims.", b.ID, len(approved))
+	}
+	if err := b.Set(b, bot.Bunt); err != nil {
+		return err
+	}
+	bot.tryEditMessage(b.message(), b.text(), b.keyboard())
+	return failed
+}
+
+// refundBounty books the bounty back to its creator. The caller must hold the
+// lock of the bounty.
+func (bot *TipBot) refundBounty(b *Bounty) error {
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return err
+	}
+	t := NewTransaction(bot.withoutSpendingLimits(), me, b.From, b.Amount, TransactionType("bounty refund"),
+		TransactionIdempotencyKey(b.ID+":refund"))
+	t.ChatID, t.ChatName = b.ChatID, b.ChatTitle
+	t.Memo = "↩️ Bounty refund."
+	if success, err := t.Send(); !success {
+		log.Errorf("[bounty] Refund of %s failed: %v", b.ID, err)
+		return fmt.Errorf("refund failed: %v", err)
+	}
+	b.Status = BountyRefunded
+	b.Active = false
+	stopBountyTimer(b.ID)
+	bot.trySendMessage(b.From.Telegram, fmt.Sprintf(bountyRefundReceived, b.Amount))
+	log.Infof("[🎯 bounty] Bounty %s refunded.", b.ID)
+	if b.MessageID != 0 {
+		bot.tryEditMessage(b.message(), b.text(), b.keyboard())
+	}
+	return b.Set(b, bot.Bunt)
+}
+
+// bountyClaimant returns the user of a claim and creates a wallet if necessary.
+func (bot *TipBot) bountyClaimant(claim *BountyClaim) (*lnbits.User, error) {
+	if to, exists := bot.UserExists(claim.Claimant); exists {
+		return to, nil
+	}
+	return bot.CreateWalletForTelegramUser(claim.Claimant)
+}
+
+// expireBounty pays an expired bounty to its approved claims or refunds it.
+// If that fails, the bounty stays open and expireBounty runs again later.
+func (bot *TipBot) expireBounty(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	runtime.RemoveTicker(id)
+	b, err := bot.loadBounty(id)
+	if err != nil {
+		log.Errorf("[expireBounty] %v", err)
+		return
+	}
+	if b.Status != BountyOpen {
+		return
+	}
+	err = bot.releaseBounty(b)
+	if err == errNoBountyClaim {
+		err = bot.refundBounty(b)
+	}
+	if err != nil {
+		log.Errorf("[expireBounty] Could not close bounty %s: %v", id, err)
+		b.RetryAt = time.Now().Add(bountyRetryInterval)
+		if err := b.Set(b, bot.Bunt); err != nil {
+			log.Errorf("[expireBounty] Could not save bounty %s: %v", id, err)
+		}
+		bot.startBountyTimer(b)
+	}
+}
+
+// startBountyTimer runs expireBounty at the expiry of the bounty or at the
+// next retry of a failed payout.
+func (bot *TipBot) startBountyTimer(b *Bounty) {
+	id := b.ID
+	at := b.ExpiresAt
+	if b.RetryAt.After(at) {
+		at = b.RetryAt
+	}
+	t := runtime.NewResettableFunction(id,
+		runtime.WithTimer(time.NewTimer(time.Until(at))))
+	t.Do(func() {
+		bot.expireBounty(id)
+	})
+}
+
+func stopBountyTimer(id string) {
+	if t, ok := runtime.Get(id); ok {
+		t.StopChan <- struct{}{}
+		runtime.RemoveTicker(id)
+	}
+}
+
+func (bot *TipBot) restartPersistedBounties() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		err := tx.Ascend("bounty", func(key, value string) bool {
+			b := &Bounty{}
+			err := json.Unmarshal([]byte(value), b)
+			if err != nil {
+				return true
+			}
+			if b.Status == BountyOpen {
+				bot.startBountyTimer(b)
+			}
+			return true // continue iteration
+		})
+		return err
+	})
+}
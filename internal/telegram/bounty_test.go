@@ -0,0 +1,92 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+)
+
+func TestTipBot_expireBounty(t *testing.T) {
+	fake := lnbits.NewFakeBackend()
+	bot := newTestBot(t, fake)
+	// the bot wallet holds less than the bounty, so the second payout fails
+	me := newTestUser(t, bot, fake, 1, 60)
+	alice := newTestUser(t, bot, fake, 2, 0)
+	bob := newTestUser(t, bot, fake, 3, 0)
+	carol := newTestUser(t, bot, fake, 4, 0)
+	b := &Bounty{
+		Base:   storage.New(storage.ID("bounty:test")),
+		From:   alice,
+		Amount: 100,
+		Claims: []*BountyClaim{
+			{Claimant: bob.Telegram, Status: BountyClaimApproved},
+			{Claimant: carol.Telegram, Status: BountyClaimApproved},
+		},
+		Status:    BountyOpen,
+		ExpiresAt: time.Now(),
+	}
+	if err := b.Set(b, bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	defer stopBountyTimer(b.ID)
+
+	bot.expireBounty(b.ID)
+	b, err := bot.loadBounty(b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Status != BountyOpen || !b.Claims[0].Paid || b.Claims[1].Paid {
+		t.Fatalf("bounty after a failed payout = %+v, want open and paid to the first claim", b)
+	}
+	if !b.payoutStarted() {
+		t.Error("claims of a partly paid bounty can still be changed")
+	}
+	if time.Until(b.RetryAt) < bountyRetryInterval-time.Minute {
+		t.Errorf("retry at %v, want in %v", b.RetryAt, bountyRetryInterval)
+	}
+	if _, ok := runtime.Get(b.ID); !ok {
+		t.Error("no retry of the failed payout was scheduled")
+	}
+
+	if err := fake.Deposit(*me.Wallet, 40, "top up"); err != nil {
+		t.Fatal(err)
+	}
+	bot.expireBounty(b.ID)
+	if b, _ = bot.loadBounty(b.ID); b.Status != BountyPaid {
+		t.Errorf("status after the retry = %s, want %s", b.Status, BountyPaid)
+	}
+	if got := balanceOf(t, bot, bob); got != 50 {
+		t.Errorf("balance of bob = %d, want 50", got)
+	}
+	if got := balanceOf(t, bot, carol); got != 50 {
+		t.Errorf("balance of carol = %d, want 50", got)
+	}
+}
+
+func TestTipBot_releaseBountyShares(t *testing.T) {
+	fake := lnbits.NewFakeBackend()
+	bot := newTestBot(t, fake)
+	newTestUser(t, bot, fake, 1, 100)
+	bob := newTestUser(t, bot, fake, 2, 0)
+	carol := newTestUser(t, bot, fake, 3, 0)
+	// carol was approved after bob's share was fixed
+	b := &Bounty{
+		Base:   storage.New(storage.ID("bounty:shares")),
+		From:   bob,
+		Amount: 100,
+		Claims: []*BountyClaim{
+			{Claimant: bob.Telegram, Status: BountyClaimApproved, Amount: 100},
+			{Claimant: carol.Telegram, Status: BountyClaimApproved},
+		},
+		Status: BountyOpen,
+	}
+	if err := bot.releaseBounty(b); err == nil {
+		t.Fatal("releaseBounty() with shares that don't add up succeeded")
+	}
+	if got := balanceOf(t, bot, bob); got != 0 {
+		t.Errorf("balance of bob = %d, want 0", got)
+	}
+}
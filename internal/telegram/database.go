@@ -11,8 +11,13 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/LightningTipBot/LightningTipBot/internal/apikeys"
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
 	"github.com/LightningTipBot/LightningTipBot/internal/database"
+	"github.com/LightningTipBot/LightningTipBot/internal/lndhub/credentials"
+	"github.com/LightningTipBot/LightningTipBot/internal/nwc"
 	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/webhooks"
 
 	"github.com/eko/gocache/store"
 
@@ -37,6 +42,11 @@ type Databases struct {
 
 const (
 	JoinTicketIndex             = "join-ticket:*"
+	ScheduleIndex               = "schedule:*"
+	BountyIndex                 = "bounty:*"
+	EscrowIndex                 = "escrow:*"
+	PollIndex                   = "poll:*"
+	RaffleIndex                 = "raffle:*"
 	MessageOrderedByReplyToFrom = "message.reply_to_message.from.id"
 	TipTooltipKeyPattern        = "tip-tool-tip:*"
 )
@@ -58,6 +68,31 @@ func createBunt(file string) *storage.DB {
 	if err != nil {
 		panic(err)
 	}
+	err = bunt.CreateIndex("schedule", ScheduleIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 3 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("bounty", BountyIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 4 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("escrow", EscrowIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 5 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("poll", PollIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 6 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("raffle", RaffleIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 7 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
 	log.Infof("[blunt] total time: %s", time.Since(t1))
 	return bunt
 }
@@ -117,12 +152,28 @@ func AutoMigration() *Databases {
 	if err != nil {
 		panic(err)
 	}
+	err = orm.AutoMigrate(&RetiredWallet{}, &lnbits.UserWallet{})
+	if err != nil {
+		panic(err)
+	}
+	err = credentials.Migrate(orm)
+	if err != nil {
+		panic(err)
+	}
+	err = nwc.Migrate(orm)
+	if err != nil {
+		panic(err)
+	}
+	err = apikeys.Migrate(orm)
+	if err != nil {
+		panic(err)
+	}
 
 	txLogger, err := gorm.Open(sqlite.Open(internal.Configuration.Database.TransactionsPath), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true, FullSaveAssociations: true})
 	if err != nil {
 		panic("Initialize orm failed.")
 	}
-	err = txLogger.AutoMigrate(&Transaction{})
+	err = txLogger.AutoMigrate(&Transaction{}, &Spend{})
 	if err != nil {
 		panic(err)
 	}
@@ -131,7 +182,25 @@ func AutoMigration() *Databases {
 	if err != nil {
 		panic("Initialize orm failed.")
 	}
-	err = groupsDb.AutoMigrate(&Group{})
+	err = groupsDb.AutoMigrate(&Group{}, &Treasury{}, &TreasurySigner{}, &TreasuryPayout{}, &TreasuryVote{}, &EscrowArbiter{})
+	if err != nil {
+		panic(err)
+	}
+
+	auditDb, err := gorm.Open(sqlite.Open(internal.Configuration.Database.AuditPath), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true})
+	if err != nil {
+		panic("Initialize orm failed.")
+	}
+	_, err = audit.New(auditDb)
+	if err != nil {
+		panic(err)
+	}
+
+	webhooksDb, err := gorm.Open(sqlite.Open(internal.Configuration.Database.WebhooksPath), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true})
+	if err != nil {
+		panic("Initialize orm failed.")
+	}
+	_, err = webhooks.New(webhooksDb)
 	if err != nil {
 		panic(err)
 	}
@@ -283,7 +352,12 @@ func UpdateUserRecord(user *lnbits.User, bot TipBot) error {
 		log.Errorf("[UpdateUserRecord] UUID empty! Setting to: %s", user.UUID)
 	}
 
-	tx := bot.DB.Users.Save(user)
+	db := bot.DB.Users
+	if user.Wallet != nil && user.Wallet.Detached {
+		// the user works with another of their wallets, keep the active one
+		db = db.Omit("wallet_id", "wallet_adminkey", "wallet_inkey", "wallet_balance", "wallet_name", "wallet_user")
+	}
+	tx := db.Save(user)
 	if tx.Error != nil {
 		errmsg := fmt.Sprintf("[UpdateUserRecord] Error: Couldn't update %s's info in Database.", GetUserStr(user.Telegram))
 		log.Errorln(errmsg)
@@ -291,7 +365,11 @@ func UpdateUserRecord(user *lnbits.User, bot TipBot) error {
 	}
 	log.Tracef("[UpdateUserRecord] Records of user %s updated.", GetUserStr(user.Telegram))
 	if bot.Cache.GoCacheStore != nil {
-		updateCachedUser(user, bot)
+		if user.Wallet != nil && user.Wallet.Detached {
+			bot.Cache.Delete(user.Name)
+		} else {
+			updateCachedUser(user, bot)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,487 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	escrowMenu          = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnReleaseEscrow    = escrowMenu.Data("✅ Release", "release_escrow")
+	btnRefundEscrow     = escrowMenu.Data("↩️ Refund", "refund_escrow")
+	btnDisputeEscrow    = escrowMenu.Data("⚠️ Dispute", "dispute_escrow")
+	maxEscrowArbiters   = 10
+	escrowTermsMaxLen   = 500
+	escrowOutcomeRefund = "refund"
+	// a failed resolution at the timeout is tried again after this long
+	escrowRetryInterval = 10 * time.Minute
+)
+
+const (
+	EscrowOpen     = "open"
+	EscrowDisputed = "disputed"
+	EscrowReleased = "released"
+	EscrowRefunded = "refunded"
+)
+
+var (
+	escrowHelpMessage      = "🤝 *Escrow*\n`/escrow @seller <amount> <terms>` Lock sats for a trade. The seller is paid when you press *Release*. The seller can *Refund* you.\nIf something goes wrong, either side can raise a *Dispute*. The arbiters of the group then release or refund.\n`/escrow arbiters @user1 @user2` Set the arbiters (group owner). Without arbiters, group admins decide.\nTrades that nobody resolves are settled with a %s after %d hours."
+	escrowMessage          = "🤝 *Escrow: %d sat*\nBuyer: %s\nSeller: %s\n\n%s\n\n%s"
+	escrowOpenStatus       = "⏳ Waiting for the buyer to release. Resolves with a %s on %s."
+	escrowDisputedStatus   = "⚠️ Disputed by %s. An arbiter decides. Resolves with a %s on %s."
+	escrowReleasedStatus   = "✅ Released to the seller by %s."
+	escrowRefundedStatus   = "↩️ Refunded to the buyer by %s."
+	escrowSellerMessage    = "🤝 %s locked %d sat in escrow for a trade with you in %s:\n%s"
+	escrowResolvedMessage  = "🤝 The escrow of %d sat between %s and %s was %s."
+	escrowPrivateMessage   = "🤝 Escrows can only be created in groups."
+	escrowSellerUnknown    = "🚫 %s hasn't created a wallet yet."
+	escrowYourselfMessage  = "🚫 You can't trade with yourself."
+	escrowBalanceMessage   = "🚫 Your balance is too low for this escrow."
+	escrowFailedMessage    = "🚫 Couldn't create the escrow. Please try again later."
+	escrowClosedMessage    = "🤝 This escrow is closed."
+	escrowNotAllowed       = "🚫 You can't do this."
+	escrowResolveFailed    = "🚫 Couldn't move the sats. Please try again later."
+	escrowArbitersMessage  = "⚖️ *Arbiters:* %s"
+	escrowNoArbiters       = "⚖️ No arbiters set. Group admins decide disputes."
+	escrowArbitersInvalid  = "🚫 Usage: `/escrow arbiters @user1 @user2 ...`"
+	escrowArbitersOwner    = "🚫 Only the group owner can set the arbiters."
+	escrowArbitersSet      = "⚖️ Arbiters set: %s"
+	escrowTimeoutActorName = "timeout"
+)
+
+// EscrowTrade holds the sats of a buyer in the bot wallet until the trade is
+// released to the seller or refunded.
+type EscrowTrade struct {
+	*storage.Base
+	Buyer      *lnbits.User `json:"buyer"`
+	Seller     *lnbits.User `json:"seller"`
+	Amount     int64        `json:"amount"`
+	Terms      string       `json:"terms"`
+	ChatID     int64        `json:"chat_id"`
+	ChatTitle  string       `json:"chat_title"`
+	MessageID  int          `json:"message_id"`
+	Status     string       `json:"status"`
+	DisputedBy *tb.User     `json:"disputed_by"`
+	ResolvedBy string       `json:"resolved_by"`
+	ExpiresAt  time.Time    `json:"expires_at"`
+	RetryAt    time.Time    `json:"retry_at"`
+}
+
+// EscrowArbiter decides disputed trades in a group.
+type EscrowArbiter struct {
+	ChatID     int64  `gorm:"primaryKey;autoIncrement:false"`
+	TelegramID int64  `gorm:"primaryKey;autoIncrement:false"`
+	Username   string `json:"username"`
+}
+
+func escrowOutcomeStr(outcome string) string {
+	if outcome == escrowOutcomeRefund {
+		return "refund to the buyer"
+	}
+	return "release to the seller"
+}
+
+func (e *EscrowTrade) message() tb.Editable {
+	return &tb.StoredMessage{MessageID: strconv.Itoa(e.MessageID), ChatID: e.ChatID}
+}
+
+// text returns the message of the trade in its current state.
+func (e *EscrowTrade) text() string {
+	expires := e.ExpiresAt.UTC().Format("2 Jan 15:04 UTC")
+	outcome := escrowOutcomeStr(internal.Configuration.Escrow.DefaultOutcome)
+	var status string
+	switch e.Status {
+	case EscrowOpen:
+		status = fmt.Sprintf(escrowOpenStatus, outcome, expires)
+	case EscrowDisputed:
+		status = fmt.Sprintf(escrowDisputedStatus, GetUserStrMd(e.DisputedBy), outcome, expires)
+	case EscrowReleased:
+		status = fmt.Sprintf(escrowReleasedStatus, str.MarkdownEscape(e.ResolvedBy))
+	case EscrowRefunded:
+		status = fmt.Sprintf(escrowRefundedStatus, str.MarkdownEscape(e.ResolvedBy))
+	}
+	return fmt.Sprintf(escrowMessage, e.Amount, GetUserStrMd(e.Buyer.Telegram), GetUserStrMd(e.Seller.Telegram), str.MarkdownEscape(e.Terms), status)
+}
+
+func (e *EscrowTrade) keyboard() *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	release := menu.Data(btnReleaseEscrow.Text, btnReleaseEscrow.Unique, e.ID)
+	refund := menu.Data(btnRefundEscrow.Text, btnRefundEscrow.Unique, e.ID)
+	switch e.Status {
+	case EscrowOpen:
+		menu.Inline(menu.Row(release, refund), menu.Row(menu.Data(btnDisputeEscrow.Text, btnDisputeEscrow.Unique, e.ID)))
+	case EscrowDisputed:
+		menu.Inline(menu.Row(release, refund))
+	}
+	return menu
+}
+
+func (bot *TipBot) loadEscrow(id string) (*EscrowTrade, error) {
+	e := &EscrowTrade{Base: storage.New(storage.ID(id))}
+	sn, err := e.Get(e, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*EscrowTrade), nil
+}
+
+func (bot *TipBot) escrowArbiters(chatID int64) ([]EscrowArbiter, error) {
+	arbiters := make([]EscrowArbiter, 0)
+	err := bot.DB.Groups.Where("chat_id = ?", chatID).Find(&arbiters).Error
+	return arbiters, err
+}
+
+// isEscrowArbiter returns true if user may decide disputes of the trade. The
+// buyer and the seller never decide their own dispute.
+func (bot *TipBot) isEscrowArbiter(e *EscrowTrade, user *tb.User) bool {
+	if user.ID == e.Buyer.Telegram.ID || user.ID == e.Seller.Telegram.ID {
+		return false
+	}
+	arbiters, err := bot.escrowArbiters(e.ChatID)
+	if err != nil {
+		return false
+	}
+	if len(arbiters) == 0 {
+		return bot.isAdmin(&tb.Chat{ID: e.ChatID}, user)
+	}
+	for _, a := range arbiters {
+		if a.TelegramID == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// escrowHandler invoked on "/escrow @seller <amount> <terms>" and "/escrow arbiters"
+func (bot *TipBot) escrowHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, escrowPrivateMessage)
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	args := strings.Fields(m.Text)
+	if len(args) > 1 && strings.ToLower(args[1]) == "arbiters" {
+		return bot.escrowArbitersHandler(ctx, args[2:])
+	}
+	if len(args) < 4 || !strings.HasPrefix(args[1], "@") {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(escrowHelpMessage,
+			escrowOutcomeStr(internal.Configuration.Escrow.DefaultOutcome), internal.Configuration.Escrow.Timeout))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(args[2])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(escrowHelpMessage,
+			escrowOutcomeStr(internal.Configuration.Escrow.DefaultOutcome), internal.Configuration.Escrow.Timeout))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	buyer := LoadUser(ctx)
+	seller, err := GetUserByTelegramUsername(strings.TrimPrefix(args[1], "@"), *bot)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(escrowSellerUnknown, str.MarkdownEscape(args[1])))
+		return ctx, err
+	}
+	if seller.ID == buyer.ID {
+		bot.trySendMessage(m.Sender, escrowYourselfMessage)
+		return ctx, errors.Create(errors.SelfPaymentError)
+	}
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	e := &EscrowTrade{
+		Base:      storage.New(storage.ID(fmt.Sprintf("escrow:%s", RandStringRunes(10)))),
+		Buyer:     buyer,
+		Seller:    seller,
+		Amount:    amount,
+		Terms:     GetMemoFromCommand(m.Text, 3),
+		ChatID:    m.Chat.ID,
+		ChatTitle: m.Chat.Title,
+		Status:    EscrowOpen,
+		ExpiresAt: time.Now().Add(time.Duration(internal.Configuration.Escrow.Timeout) * time.Hour),
+	}
+	e.Terms = str.Truncate(e.Terms, escrowTermsMaxLen)
+
+	// lock the sats of the buyer in the bot wallet
+	t := NewTransaction(bot, buyer, me, amount, TransactionType("escrow"), TransactionChat(m.Chat), TransactionBatch(e.ID),
+		TransactionIdempotencyKey(e.ID+":lock"))
+	t.Memo = fmt.Sprintf("🤝 Escrow for %s.", GetUserStr(seller.Telegram))
+	if success, err := t.Send(); !success {
+		log.Warnf("[/escrow] Lock of %s failed: %v", GetUserStr(buyer.Telegram), err)
+		balance, _ := bot.GetUserBalance(buyer)
+		if balance < amount {
+			bot.trySendMessage(m.Sender, escrowBalanceMessage)
+		} else {
+			bot.trySendMessage(m.Sender, escrowFailedMessage)
+		}
+		return ctx, err
+	}
+	audit.Record(audit.Entry{Actor: buyer.Name, Action: "escrow.open", Target: e.ID, Amount: amount, Result: audit.ResultOk,
+		Detail: fmt.Sprintf("seller %s in %d", seller.Name, e.ChatID)})
+
+	msg := bot.trySendMessage(m.Chat, e.text(), e.keyboard())
+	if msg == nil {
+		bot.resolveEscrow(e, EscrowRefunded, escrowTimeoutActorName)
+		return ctx, fmt.Errorf("could not send escrow %s", e.ID)
+	}
+	e.MessageID = msg.ID
+	if err := e.Set(e, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	bot.startEscrowTimer(e)
+	bot.trySendMessage(seller.Telegram, fmt.Sprintf(escrowSellerMessage, GetUserStrMd(buyer.Telegram), amount, str.MarkdownEscape(e.ChatTitle), str.MarkdownEscape(e.Terms)))
+	log.Infof("[🤝 escrow] %s locked %d sat for %s (%s).", GetUserStr(buyer.Telegram), amount, GetUserStr(seller.Telegram), e.ID)
+	return ctx, nil
+}
+
+// escrowArbitersHandler shows the arbiters of a group or replaces them.
+func (bot *TipBot) escrowArbitersHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	if len(args) == 0 {
+		arbiters, err := bot.escrowArbiters(m.Chat.ID)
+		if err != nil {
+			return ctx, err
+		}
+		if len(arbiters) == 0 {
+			bot.trySendMessage(m.Chat, escrowNoArbiters)
+			return ctx, nil
+		}
+		names := make([]string, len(arbiters))
+		for i, a := range arbiters {
+			names[i] = "@" + a.Username
+		}
+		bot.trySendMessage(m.Chat, fmt.Sprintf(escrowArbitersMessage, str.MarkdownEscape(strings.Join(names, ", "))))
+		return ctx, nil
+	}
+	user := LoadUser(ctx)
+	if !bot.isOwner(m.Chat, user.Telegram) {
+		bot.trySendMessage(m.Chat, escrowArbitersOwner)
+		return ctx, fmt.Errorf("not owner")
+	}
+	if len(args) > maxEscrowArbiters {
+		bot.trySendMessage(m.Chat, escrowArbitersInvalid)
+		return ctx, fmt.Errorf("too many arbiters")
+	}
+	arbiters := make([]EscrowArbiter, 0, len(args))
+	seen := make(map[int64]bool)
+	for _, arg := range args {
+		arbiter, err := GetUserByTelegramUsername(strings.TrimPrefix(arg, "@"), *bot)
+		if err != nil || !strings.HasPrefix(arg, "@") {
+			bot.trySendMessage(m.Chat, escrowArbitersInvalid)
+			return ctx, fmt.Errorf("unknown arbiter %s", arg)
+		}
+		if seen[arbiter.Telegram.ID] {
+			continue
+		}
+		seen[arbiter.Telegram.ID] = true
+		arbiters = append(arbiters, EscrowArbiter{ChatID: m.Chat.ID, TelegramID: arbiter.Telegram.ID, Username: arbiter.Telegram.Username})
+	}
+	if err := bot.DB.Groups.Where("chat_id = ?", m.Chat.ID).Delete(&EscrowArbiter{}).Error; err != nil {
+		return ctx, err
+	}
+	if err := bot.DB.Groups.Create(&arbiters).Error; err != nil {
+		return ctx, err
+	}
+	names := make([]string, len(arbiters))
+	for i, a := range arbiters {
+		names[i] = "@" + a.Username
+	}
+	audit.Record(audit.Entry{Actor: user.Name, Action: "escrow.arbiters", Target: strconv.FormatInt(m.Chat.ID, 10), Result: audit.ResultOk,
+		Detail: strings.Join(names, ",")})
+	bot.trySendMessage(m.Chat, fmt.Sprintf(escrowArbitersSet, str.MarkdownEscape(strings.Join(names, ", "))))
+	return ctx, nil
+}
+
+func (bot *TipBot) releaseEscrowHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.decideEscrow(ctx, EscrowReleased)
+}
+
+func (bot *TipBot) refundEscrowHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.decideEscrow(ctx, EscrowRefunded)
+}
+
+// decideEscrow resolves a trade. While the trade is open, the buyer releases
+// and the seller refunds. A disputed trade is decided by the arbiters.
+func (bot *TipBot) decideEscrow(ctx intercept.Context, outcome string) (intercept.Context, error) {
+	c := ctx.Callback()
+	id := ctx.Data()
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	e, err := bot.loadEscrow(id)
+	if err != nil {
+		return ctx, err
+	}
+	allowed := false
+	switch e.Status {
+	case EscrowOpen:
+		if outcome == EscrowReleased {
+			allowed = c.Sender.ID == e.Buyer.Telegram.ID
+		} else {
+			allowed = c.Sender.ID == e.Seller.Telegram.ID
+		}
+	case EscrowDisputed:
+		allowed = bot.isEscrowArbiter(e, c.Sender)
+	default:
+		ctx.Context = context.WithValue(ctx, "callback_response", escrowClosedMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if !allowed {
+		ctx.Context = context.WithValue(ctx, "callback_response", escrowNotAllowed)
+		audit.Record(audit.Entry{Actor: strconv.FormatInt(c.Sender.ID, 10), Action: "escrow." + outcome, Target: e.ID, Amount: e.Amount, Result: audit.ResultDenied})
+		return ctx, fmt.Errorf("%s may not resolve %s", GetUserStr(c.Sender), id)
+	}
+	if err := bot.resolveEscrow(e, outcome, GetUserStr(c.Sender)); err != nil {
+		ctx.Context = context.WithValue(ctx, "callback_response", escrowResolveFailed)
+		return ctx, err
+	}
+	return ctx, nil
+}
+
+func (bot *TipBot) disputeEscrowHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	id := ctx.Data()
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	e, err := bot.loadEscrow(id)
+	if err != nil {
+		return ctx, err
+	}
+	if e.Status != EscrowOpen {
+		ctx.Context = context.WithValue(ctx, "callback_response", escrowClosedMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if c.Sender.ID != e.Buyer.Telegram.ID && c.Sender.ID != e.Seller.Telegram.ID {
+		ctx.Context = context.WithValue(ctx, "callback_response", escrowNotAllowed)
+		return ctx, fmt.Errorf("%s is not a party of %s", GetUserStr(c.Sender), id)
+	}
+	e.Status = EscrowDisputed
+	e.DisputedBy = c.Sender
+	if err := e.Set(e, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	audit.Record(audit.Entry{Actor: strconv.FormatInt(c.Sender.ID, 10), Action: "escrow.dispute", Target: e.ID, Amount: e.Amount, Result: audit.ResultOk})
+	bot.tryEditMessage(e.message(), e.text(), e.keyboard())
+	log.Infof("[🤝 escrow] %s disputed %s.", GetUserStr(c.Sender), e.ID)
+	return ctx, nil
+}
+
+// resolveEscrow pays the sats of the trade to the seller or back to the
+// buyer. The caller must hold the lock of the trade.
+func (bot *TipBot) resolveEscrow(e *EscrowTrade, outcome string, actor string) error {
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return err
+	}
+	to, transactionType, action := e.Seller, "escrow release", "release"
+	if outcome == EscrowRefunded {
+		to, transactionType, action = e.Buyer, "escrow refund", "refund"
+	}
+	t := NewTransaction(bot.withoutSpendingLimits(), me, to, e.Amount, TransactionType(transactionType), TransactionBatch(e.ID),
+		TransactionIdempotencyKey(e.ID+":"+action))
+	t.ChatID, t.ChatName = e.ChatID, e.ChatTitle
+	t.Memo = fmt.Sprintf("🤝 Escrow %s by %s.", action, actor)
+	entry := audit.Entry{Actor: actor, Action: "escrow." + action, Target: e.ID, Amount: e.Amount}
+	if success, err := t.Send(); !success {
+		log.Errorf("[escrow] Could not %s %s: %v", action, e.ID, err)
+		entry.Result, entry.Detail = audit.ResultFailed, fmt.Sprint(err)
+		audit.Record(entry)
+		return fmt.Errorf("%s failed: %v", action, err)
+	}
+	entry.Result = audit.ResultOk
+	audit.Record(entry)
+	e.Status = outcome
+	e.ResolvedBy = actor
+	e.Active = false
+	stopEscrowTimer(e.ID)
+	if e.MessageID != 0 {
+		bot.tryEditMessage(e.message(), e.text(), e.keyboard())
+	}
+	resolved := fmt.Sprintf(escrowResolvedMessage, e.Amount, GetUserStrMd(e.Buyer.Telegram), GetUserStrMd(e.Seller.Telegram), outcome)
+	bot.trySendMessage(e.Buyer.Telegram, resolved)
+	bot.trySendMessage(e.Seller.Telegram, resolved)
+	log.Infof("[🤝 escrow] %s %s by %s.", e.ID, outcome, actor)
+	return e.Set(e, bot.Bunt)
+}
+
+// expireEscrow resolves a trade that nobody resolved with the configured default.
+// If that fails, the trade stays open and expireEscrow runs again later.
+func (bot *TipBot) expireEscrow(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	runtime.RemoveTicker(id)
+	e, err := bot.loadEscrow(id)
+	if err != nil {
+		log.Errorf("[expireEscrow] %v", err)
+		return
+	}
+	if e.Status != EscrowOpen && e.Status != EscrowDisputed {
+		return
+	}
+	outcome := EscrowReleased
+	if internal.Configuration.Escrow.DefaultOutcome == escrowOutcomeRefund {
+		outcome = EscrowRefunded
+	}
+	if err := bot.resolveEscrow(e, outcome, escrowTimeoutActorName); err != nil {
+		log.Errorf("[expireEscrow] Could not resolve %s: %v", id, err)
+		e.RetryAt = time.Now().Add(escrowRetryInterval)
+		if err := e.Set(e, bot.Bunt); err != nil {
+			log.Errorf("[expireEscrow] Could not save %s: %v", id, err)
+		}
+		bot.startEscrowTimer(e)
+	}
+}
+
+// startEscrowTimer runs expireEscrow at the timeout of the trade or at the
+// next retry of a failed resolution.
+func (bot *TipBot) startEscrowTimer(e *EscrowTrade) {
+	id := e.ID
+	at := e.ExpiresAt
+	if e.RetryAt.After(at) {
+		at = e.RetryAt
+	}
+	t := runtime.NewResettableFunction(id,
+		runtime.WithTimer(time.NewTimer(time.Until(at))))
+	t.Do(func() {
+		bot.expireEscrow(id)
+	})
+}
+
+func stopEscrowTimer(id string) {
+	if t, ok := runtime.Get(id); ok {
+		t.StopChan <- struct{}{}
+		runtime.RemoveTicker(id)
+	}
+}
+
+func (bot *TipBot) restartPersistedEscrows() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		err := tx.Ascend("escrow", func(key, value string) bool {
+			e := &EscrowTrade{}
+			err := json.Unmarshal([]byte(value), e)
+			if err != nil {
+				return true
+			}
+			if e.Status == EscrowOpen || e.Status == EscrowDisputed {
+				bot.startEscrowTimer(e)
+			}
+			return true // continue iteration
+		})
+		return err
+	})
+}
@@ -0,0 +1,57 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+)
+
+func TestTipBot_expireEscrow(t *testing.T) {
+	fake := lnbits.NewFakeBackend()
+	bot := newTestBot(t, fake)
+	// the bot wallet can't pay out the trade yet
+	me := newTestUser(t, bot, fake, 1, 0)
+	alice := newTestUser(t, bot, fake, 2, 0)
+	bob := newTestUser(t, bot, fake, 3, 0)
+	e := &EscrowTrade{
+		Base:      storage.New(storage.ID("escrow:test")),
+		Buyer:     alice,
+		Seller:    bob,
+		Amount:    100,
+		Status:    EscrowOpen,
+		ExpiresAt: time.Now(),
+	}
+	if err := e.Set(e, bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	defer stopEscrowTimer(e.ID)
+
+	bot.expireEscrow(e.ID)
+	e, err := bot.loadEscrow(e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != EscrowOpen {
+		t.Fatalf("status after a failed resolution = %s, want %s", e.Status, EscrowOpen)
+	}
+	if time.Until(e.RetryAt) < escrowRetryInterval-time.Minute {
+		t.Errorf("retry at %v, want in %v", e.RetryAt, escrowRetryInterval)
+	}
+	if _, ok := runtime.Get(e.ID); !ok {
+		t.Error("no retry of the failed resolution was scheduled")
+	}
+
+	if err := fake.Deposit(*me.Wallet, 100, "top up"); err != nil {
+		t.Fatal(err)
+	}
+	bot.expireEscrow(e.ID)
+	if e, _ = bot.loadEscrow(e.ID); e.Status != EscrowReleased {
+		t.Errorf("status after the retry = %s, want %s", e.Status, EscrowReleased)
+	}
+	if got := balanceOf(t, bot, bob); got != 100 {
+		t.Errorf("balance of bob = %d, want 100", got)
+	}
+}
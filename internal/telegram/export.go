@@ -0,0 +1,232 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/export"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/price"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const exportDateLayout = "2006-01-02"
+
+var (
+	exportHelpMessage  = "📄 *Export transactions:* `/export [csv|json|ofx] [<from>] [<to>]`\nDates are `YYYY-MM-DD`, both are included. Fiat values are in your `/set unit` currency (USD for BTC) at the price of the day, `missing` marks payments without a price. Example: `/export ofx 2023-01-01 2023-12-31`"
+	exportEmptyMessage = "📄 No transactions in this time range."
+	exportErrorMessage = "🚫 Couldn't export your transactions. Please try again later."
+)
+
+// ParseExportRange parses the optional from and to dates of an export. to
+// includes the whole day.
+func ParseExportRange(from, to string) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+	if len(from) > 0 {
+		if start, err = time.Parse(exportDateLayout, from); err != nil {
+			return start, end, fmt.Errorf("invalid date %s", from)
+		}
+	}
+	if len(to) > 0 {
+		if end, err = time.Parse(exportDateLayout, to); err != nil {
+			return start, end, fmt.Errorf("invalid date %s", to)
+		}
+		end = end.Add(24*time.Hour - time.Second)
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return start, end, fmt.Errorf("%s is before %s", to, from)
+	}
+	return start, end, nil
+}
+
+// ExportStatement merges the LNbits payments of the wallet of user between
+// from and to with the transactions of the bot. Zero times mean no limit.
+func (bot *TipBot) ExportStatement(user *lnbits.User, from, to time.Time) (export.Statement, error) {
+	statement := export.Statement{Account: user.Wallet.ID, From: from, To: to}
+	payments, err := bot.UserPayments(user)
+	if err != nil {
+		return statement, err
+	}
+	var selected lnbits.Payments
+	for _, p := range payments {
+		t := time.Unix(int64(p.Time), 0)
+		if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && t.After(to)) {
+			continue
+		}
+		// unpaid invoices
+		if p.Pending && p.Amount > 0 {
+			continue
+		}
+		selected = append(selected, p)
+	}
+	transactions := bot.transactionsByPaymentHash(selected)
+	currency := exportCurrency(user)
+	for _, p := range selected {
+		row := export.Row{
+			Time:        time.Unix(int64(p.Time), 0).UTC(),
+			Direction:   "in",
+			Amount:      p.Amount / 1000,
+			Type:        "receive",
+			Memo:        p.Memo,
+			PaymentHash: p.PaymentHash,
+			Pending:     p.Pending,
+		}
+		if fee := p.Fee; fee != 0 {
+			if fee < 0 {
+				fee = -fee
+			}
+			row.Fee = (fee + 999) / 1000
+		}
+		if p.Amount < 0 {
+			row.Direction, row.Type = "out", "pay"
+		}
+		if t, ok := transactions[p.PaymentHash]; ok {
+			row.Type = t.Type
+			row.Chat = t.ChatName
+			row.Counterparty = t.FromUser
+			if p.Amount < 0 {
+				row.Counterparty = t.ToUser
+			}
+			// the sender of a split pays the bot wallet, which pays the recipients
+			switch t.Type {
+			case "split escrow":
+				row.Type, row.Counterparty = "split", bot.splitRecipients(t.BatchID)
+			case "split escrow refund":
+				row.Type, row.Counterparty = "split refund", bot.splitRecipients(t.BatchID)
+			}
+			if len(t.Fiat.Currency) > 0 {
+				row.FiatAmount, row.FiatCurrency, row.FiatSource = t.Fiat.Amount, t.Fiat.Currency, export.FiatLocked
+			}
+		}
+		if quote := bot.getFiatQuote(p.PaymentHash); quote != nil {
+			row.FiatAmount, row.FiatCurrency, row.FiatSource = quote.Amount, quote.Currency, export.FiatLocked
+		}
+		if row.FiatSource == "" {
+			setHistoricalFiat(&row, currency)
+		}
+		statement.Rows = append(statement.Rows, row)
+	}
+	if balance, err := bot.GetUserBalance(user); err == nil {
+		statement.Balance = balance
+	}
+	return statement, nil
+}
+
+// splitRecipients lists the recipients of the legs of a split.
+func (bot *TipBot) splitRecipients(batch string) string {
+	var recipients []string
+	err := bot.DB.Transactions.Model(&Transaction{}).Where("batch_id = ? AND type = ?", batch, "split").
+		Order("id").Pluck("to_user", &recipients).Error
+	if err != nil {
+		log.Errorf("[export] could not load recipients of %s: %v", batch, err)
+	}
+	return strings.Join(recipients, ", ")
+}
+
+// exportCurrency is the fiat currency of the statement of user. Users who see
+// their balance in BTC get USD.
+func exportCurrency(user *lnbits.User) string {
+	if user.Settings != nil {
+		if c := strings.ToUpper(user.Settings.Display.DisplayCurrency); c != "" && c != "BTC" {
+			return c
+		}
+	}
+	return "USD"
+}
+
+// setHistoricalFiat converts the amount of row at the BTC price on its day. If
+// there is no price, the row is marked as missing its fiat value.
+func setHistoricalFiat(row *export.Row, currency string) {
+	row.FiatCurrency, row.FiatSource = currency, export.FiatMissing
+	if price.P == nil {
+		return
+	}
+	rate, err := price.P.PriceAt(currency, row.Time)
+	if err != nil {
+		log.Debugf("[export] no %s price on %s: %v", currency, row.Time.Format(exportDateLayout), err)
+		return
+	}
+	// like locked amounts, the direction of the row gives the sign
+	row.FiatAmount = math.Round(math.Abs(float64(row.Amount))/100_000_000*rate*100) / 100
+	row.FiatSource = export.FiatHistorical
+}
+
+// transactionsByPaymentHash loads the successful transactions of payments.
+func (bot *TipBot) transactionsByPaymentHash(payments lnbits.Payments) map[string]Transaction {
+	transactions := make(map[string]Transaction)
+	hashes := make([]string, 0, len(payments))
+	for _, p := range payments {
+		hashes = append(hashes, p.PaymentHash)
+	}
+	// sqlite limits the number of variables in a query
+	for len(hashes) > 0 {
+		n := len(hashes)
+		if n > 500 {
+			n = 500
+		}
+		var chunk []Transaction
+		err := bot.DB.Transactions.Where("invoice_payment_hash IN ? AND success = ?", hashes[:n], true).Find(&chunk).Error
+		if err != nil {
+			log.Errorf("[export] could not load transactions: %v", err)
+		}
+		for _, t := range chunk {
+			transactions[t.Invoice.PaymentHash] = t
+		}
+		hashes = hashes[n:]
+	}
+	return transactions
+}
+
+func (bot *TipBot) exportHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	format := export.FormatCSV
+	var dates []string
+	for _, arg := range strings.Fields(m.Text)[1:] {
+		if f, err := export.ParseFormat(arg); err == nil {
+			format = f
+			continue
+		}
+		dates = append(dates, arg)
+	}
+	if len(dates) > 2 {
+		bot.trySendMessage(m.Sender, exportHelpMessage)
+		return ctx, fmt.Errorf("too many arguments")
+	}
+	dates = append(dates, "", "")
+	from, to, err := ParseExportRange(dates[0], dates[1])
+	if err != nil {
+		bot.trySendMessage(m.Sender, exportHelpMessage)
+		return ctx, err
+	}
+	statement, err := bot.ExportStatement(user, from, to)
+	if err != nil {
+		log.Errorf("[/export] could not export transactions of %s: %v", GetUserStr(user.Telegram), err)
+		bot.trySendMessage(m.Sender, exportErrorMessage)
+		return ctx, err
+	}
+	if len(statement.Rows) == 0 {
+		bot.trySendMessage(m.Sender, exportEmptyMessage)
+		return ctx, nil
+	}
+	var buf bytes.Buffer
+	if err := export.Write(&buf, format, statement); err != nil {
+		log.Errorf("[/export] could not write %s: %v", format, err)
+		bot.trySendMessage(m.Sender, exportErrorMessage)
+		return ctx, err
+	}
+	document := &tb.Document{
+		File:     tb.FromReader(bytes.NewReader(buf.Bytes())),
+		FileName: fmt.Sprintf("transactions-%s.%s", time.Now().UTC().Format(exportDateLayout), format),
+		MIME:     export.ContentType(format),
+		Caption:  fmt.Sprintf("📄 %d transactions", len(statement.Rows)),
+	}
+	bot.trySendMessage(m.Sender, document)
+	return ctx, nil
+}
@@ -305,7 +305,8 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 
 		// todo: user new get username function to get userStrings
 		transactionMemo := fmt.Sprintf("🚰 Faucet from %s to %s.", fromUserStr, toUserStr)
-		t := NewTransaction(bot, from, to, inlineFaucet.PerUserAmount, TransactionType("faucet"))
+		t := NewTransaction(bot, from, to, inlineFaucet.PerUserAmount, TransactionType("faucet"),
+			TransactionIdempotencyKey(fmt.Sprintf("%s:%d", inlineFaucet.ID, to.Telegram.ID)))
 		t.Memo = transactionMemo
 
 		success, err := t.Send()
@@ -0,0 +1,66 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/price"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	log "github.com/sirupsen/logrus"
+)
+
+// FiatAmount is an amount in a fiat currency together with the exchange rate
+// that was locked when it was converted to satoshis.
+type FiatAmount struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+	Rate     float64 `json:"rate"` // BTC price in Currency
+}
+
+func (f FiatAmount) String() string {
+	return fmt.Sprintf("%.2f %s", f.Amount, f.Currency)
+}
+
+// lockFiatAmount converts amount of currency to satoshis at the current rate.
+func lockFiatAmount(currency string, amount float64) (int64, *FiatAmount, error) {
+	rate, err := price.P.Get(currency)
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not convert %s: %w", currency, err)
+	}
+	sat := int64(amount / rate * float64(100_000_000))
+	return sat, &FiatAmount{Currency: currency, Amount: amount, Rate: rate}, nil
+}
+
+// FiatQuote remembers the fiat amount of a payment, so that it can be shown
+// next to the satoshis later on.
+type FiatQuote struct {
+	PaymentHash string     `json:"payment_hash"`
+	Amount      int64      `json:"amount"`
+	Fiat        FiatAmount `json:"fiat"`
+	CreatedAt   time.Time  `json:"created"`
+}
+
+func (q FiatQuote) Key() string {
+	return fmt.Sprintf("fiat:%s", q.PaymentHash)
+}
+
+func (bot *TipBot) saveFiatQuote(paymentHash string, amount int64, fiat *FiatAmount) {
+	if fiat == nil || len(paymentHash) == 0 {
+		return
+	}
+	runtime.IgnoreError(bot.Bunt.Set(FiatQuote{PaymentHash: paymentHash, Amount: amount, Fiat: *fiat, CreatedAt: time.Now()}))
+}
+
+// getFiatQuote returns the fiat amount of a payment or nil if it was paid in satoshis.
+func (bot *TipBot) getFiatQuote(paymentHash string) *FiatAmount {
+	if len(paymentHash) == 0 {
+		return nil
+	}
+	quote := &FiatQuote{PaymentHash: paymentHash}
+	err := bot.Bunt.Get(quote)
+	if err != nil {
+		log.Tracef("[getFiatQuote] %s: %v", paymentHash, err)
+		return nil
+	}
+	return &quote.Fiat
+}
@@ -580,7 +580,8 @@ func (bot TipBot) addGroupHandler(ctx intercept.Context) (intercept.Context, err
 // createGroupTicketInvoice produces an invoice for the group ticket with a
 // callback that then calls groupGetInviteLinkHandler upton payment
 func (bot *TipBot) createGroupTicketInvoice(ctx context.Context, payer *lnbits.User, group *Group, memo string, callback int, callbackData string) (*InvoiceEvent, error) {
-	invoice, err := group.Ticket.Creator.Wallet.Invoice(
+	creator := bot.ticketReceiver(group)
+	invoice, err := creator.Wallet.Invoice(
 		lnbits.InvoiceParams{
 			Out:     false,
 			Amount:  group.Ticket.Price,
@@ -601,7 +602,7 @@ func (bot *TipBot) createGroupTicketInvoice(ctx context.Context, payer *lnbits.U
 			PaymentRequest: invoice.PaymentRequest,
 			Amount:         group.Ticket.Price,
 			Memo:           memo},
-		User:         group.Ticket.Creator,
+		User:         creator,
 		Callback:     callback,
 		CallbackData: callbackData,
 		LanguageCode: ctx.Value("publicLanguageCode").(string),
@@ -612,3 +613,20 @@ func (bot *TipBot) createGroupTicketInvoice(ctx context.Context, payer *lnbits.U
 	runtime.IgnoreError(invoiceEvent.Set(invoiceEvent, bot.Bunt))
 	return invoiceEvent, nil
 }
+
+// ticketReceiver returns the creator of the ticket of group. If the group has a
+// treasury, the creator is pointed to the treasury wallet and the proceeds go there.
+func (bot *TipBot) ticketReceiver(group *Group) *lnbits.User {
+	treasury, err := bot.loadTreasury(group.ID)
+	if err != nil {
+		return group.Ticket.Creator
+	}
+	wallet, err := bot.treasuryWallet(treasury)
+	if err != nil {
+		log.Errorf("[ticketReceiver] could not load treasury of %d: %v", group.ID, err)
+		return group.Ticket.Creator
+	}
+	creator := *group.Ticket.Creator
+	creator.Wallet = wallet.Wallet
+	return &creator
+}
@@ -115,6 +115,21 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 		// 		},
 		// 	},
 		// },
+		{
+			Endpoints: []interface{}{"/split"},
+			Handler:   bot.splitHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/tip", "/t", "/honk", "/zap"},
 			Handler:   bot.tipHandler,
@@ -196,6 +211,22 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/nwc"},
+			Handler:   bot.nwcHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/node"},
 			Handler:   bot.nodeHandler,
@@ -356,6 +387,17 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.requireUserInterceptor,
 				}},
 		},
+		{
+			Endpoints: []interface{}{"/export"},
+			Handler:   bot.exportHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+				}},
+		},
 		{
 			Endpoints: []interface{}{&btnLeftTransactionsButton},
 			Handler:   bot.transactionsScrollLeftHandler,
@@ -388,6 +430,96 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/bounty"},
+			Handler:   bot.bountyHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/raffle"},
+			Handler:   bot.raffleHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/poll"},
+			Handler:   bot.pollHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/vote"},
+			Handler:   bot.voteHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/escrow"},
+			Handler:   bot.escrowHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/claim"},
+			Handler:   bot.claimHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/faucet", "/zapfhahn", "/kraan", "/grifo"},
 			Handler:   bot.faucetHandler,
@@ -418,6 +550,22 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/schedule", "/schedules"},
+			Handler:   bot.scheduleHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/help", &btnHelpMainMenu},
 			Handler:   bot.helpHandler,
@@ -497,6 +645,38 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/wallets"},
+			Handler:   bot.walletsHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/wallet"},
+			Handler:   bot.walletHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/api"},
 			Handler:   bot.apiHandler,
@@ -561,6 +741,21 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/treasury"},
+			Handler:   bot.treasuryHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/join"},
 			Handler:   bot.groupRequestJoinHandler,
@@ -623,11 +818,11 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 		},
 		{
 			Endpoints: []interface{}{tb.OnText},
-			Handler:   bot.anyTextHandler,
+			Handler:   bot.textHandler,
 			Interceptor: &Interceptor{
 
 				Before: []intercept.Func{
-					bot.requirePrivateChatInterceptor, // Respond to any text only in private chat
+					bot.requirePrivateChatOrReplyInterceptor, // Respond to any text only in private chat and to replies to the bot
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.loadUserInterceptor, // need to use loadUserInterceptor instead of requireUserInterceptor, because user might not be registered yet
@@ -818,6 +1013,230 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{&btnPauseSchedule},
+			Handler:   bot.pauseScheduleHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnResumeSchedule},
+			Handler:   bot.resumeScheduleHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelSchedule},
+			Handler:   bot.cancelScheduleHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnJoinRaffle},
+			Handler:   bot.joinRaffleHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelRaffle},
+			Handler:   bot.cancelRaffleHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnVotePoll},
+			Handler:   bot.votePollHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnClosePoll},
+			Handler:   bot.closePollHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnReleaseEscrow},
+			Handler:   bot.releaseEscrowHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnRefundEscrow},
+			Handler:   bot.refundEscrowHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnDisputeEscrow},
+			Handler:   bot.disputeEscrowHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnPayBounty},
+			Handler:   bot.payBountyHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelBounty},
+			Handler:   bot.cancelBountyHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnApproveClaim},
+			Handler:   bot.approveClaimHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnRejectClaim},
+			Handler:   bot.rejectClaimHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnApprovePayout},
+			Handler:   bot.approvePayoutHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnRejectPayout},
+			Handler:   bot.rejectPayoutHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnApprovePayment},
+			Handler:   bot.approvePaymentHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{&btnDenyPayment},
+			Handler:   bot.denyPaymentHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.answerCallbackInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{&btnAcceptInlineTipjar},
 			Handler:   bot.acceptInlineTipjarHandler,
@@ -252,7 +252,7 @@ func (bot *TipBot) sendInlineReceiveHandler(ctx intercept.Context) (intercept.Co
 
 	// todo: user new get username function to get userStrings
 	transactionMemo := fmt.Sprintf("💸 Receive from %s to %s.", fromUserStr, toUserStr)
-	t := NewTransaction(bot, from, to, inlineReceive.Amount, TransactionType("inline receive"))
+	t := NewTransaction(bot, from, to, inlineReceive.Amount, TransactionType("inline receive"), TransactionIdempotencyKey(inlineReceive.ID))
 	t.Memo = transactionMemo
 	success, err := t.Send()
 	if !success {
@@ -225,7 +225,7 @@ func (bot *TipBot) acceptInlineSendHandler(ctx intercept.Context) (intercept.Con
 
 	// todo: user new get username function to get userStrings
 	transactionMemo := fmt.Sprintf("💸 Send from %s to %s.", fromUserStr, toUserStr)
-	t := NewTransaction(bot, fromUser, to, amount, TransactionType("inline send"))
+	t := NewTransaction(bot, fromUser, to, amount, TransactionType("inline send"), TransactionIdempotencyKey(inlineSend.ID))
 	t.Memo = transactionMemo
 	success, err := t.Send()
 	if !success {
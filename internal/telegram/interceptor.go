@@ -197,6 +197,21 @@ func (bot TipBot) requirePrivateChatInterceptor(ctx intercept.Context) (intercep
 	return ctx, errors.Create(errors.InvalidTypeError)
 }
 
+// requirePrivateChatOrReplyInterceptor lets private messages and replies to
+// messages of the bot through.
+func (bot TipBot) requirePrivateChatOrReplyInterceptor(ctx intercept.Context) (intercept.Context, error) {
+	if m := ctx.Message(); m != nil {
+		if m.Chat.Type == tb.ChatPrivate {
+			return ctx, nil
+		}
+		if m.IsReply() && m.ReplyTo.Sender != nil && m.ReplyTo.Sender.ID == bot.Telegram.Me.ID {
+			return ctx, nil
+		}
+		return ctx, fmt.Errorf("[requirePrivateChatOrReplyInterceptor] no private chat")
+	}
+	return ctx, errors.Create(errors.InvalidTypeError)
+}
+
 const photoTag = "<Photo>"
 
 func (bot TipBot) logMessageInterceptor(ctx intercept.Context) (intercept.Context, error) {
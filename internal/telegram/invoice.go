@@ -73,10 +73,11 @@ func AssertEventType(event Event, eventType EventType) error {
 }
 
 type Invoice struct {
-	PaymentHash    string `json:"payment_hash"`
-	PaymentRequest string `json:"payment_request"`
-	Amount         int64  `json:"amount"`
-	Memo           string `json:"memo"`
+	PaymentHash    string      `json:"payment_hash"`
+	PaymentRequest string      `json:"payment_request"`
+	Amount         int64       `json:"amount"`
+	Memo           string      `json:"memo"`
+	Fiat           *FiatAmount `json:"fiat,omitempty"` // set if the invoice was quoted in fiat
 }
 type InvoiceEvent struct {
 	*Invoice
@@ -92,6 +93,15 @@ type InvoiceEvent struct {
 	UserCurrency   string       `json:"usercurrency,omitempty"`    // the currency a user selected
 }
 
+type InvoiceEventOption func(invoiceEvent *InvoiceEvent)
+
+// InvoiceEventFiat quotes the invoice in fiat at the rate that was locked in fiat.
+func InvoiceEventFiat(fiat *FiatAmount) InvoiceEventOption {
+	return func(invoiceEvent *InvoiceEvent) {
+		invoiceEvent.Fiat = fiat
+	}
+}
+
 func (invoiceEvent InvoiceEvent) Type() EventType {
 	return EventTypeInvoice
 }
@@ -129,7 +139,7 @@ func (bot *TipBot) invoiceHandler(ctx intercept.Context) (intercept.Context, err
 		return ctx, errors.Create(errors.NoPrivateChatError)
 	}
 	// if no amount is in the command, ask for it
-	amount, err := decodeAmountFromCommand(m.Text)
+	amount, fiat, err := decodeFiatAmountFromCommand(m.Text)
 	if (err != nil || amount < 1) && m.Chat.Type == tb.ChatPrivate {
 		// // no amount was entered, set user state and ask fo""r amount
 		_, err = bot.askForAmount(ctx, "", "CreateInvoiceState", 0, 0, m.Text)
@@ -156,7 +166,7 @@ func (bot *TipBot) invoiceHandler(ctx intercept.Context) (intercept.Context, err
 		currency = "BTC"
 	}
 
-	invoice, err := bot.createInvoiceWithEvent(ctx, user, amount, memo, currency, InvoiceCallbackGeneric, "")
+	invoice, err := bot.createInvoiceWithEvent(ctx, user, amount, memo, currency, InvoiceCallbackGeneric, "", InvoiceEventFiat(fiat))
 	if err != nil {
 		errmsg := fmt.Sprintf("[/invoice] Could not create an invoice: %s", err.Error())
 		bot.tryEditMessage(creatingMsg, Translate(ctx, "errorTryLaterMessage"))
@@ -177,12 +187,16 @@ func (bot *TipBot) invoiceHandler(ctx intercept.Context) (intercept.Context, err
 	//bot.tryDeleteMessage(creatingMsg)
 
 	// send the invoice data to user
-	bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: fmt.Sprintf("`%s`", invoice.PaymentRequest)})
+	caption := fmt.Sprintf("`%s`", invoice.PaymentRequest)
+	if fiat != nil {
+		caption += fmt.Sprintf(Translate(ctx, "invoiceFiatRateMessage"), fiat.String(), amount, fiat.Rate, fiat.Currency)
+	}
+	bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: caption})
 	log.Printf("[/invoice] Incvoice created. User: %s, amount: %d sat.", userStr, amount)
 	return ctx, nil
 }
 
-func (bot *TipBot) createInvoiceWithEvent(ctx context.Context, user *lnbits.User, amount int64, memo string, currency string, callback int, callbackData string) (InvoiceEvent, error) {
+func (bot *TipBot) createInvoiceWithEvent(ctx context.Context, user *lnbits.User, amount int64, memo string, currency string, callback int, callbackData string, opts ...InvoiceEventOption) (InvoiceEvent, error) {
 	invoice, err := user.Wallet.Invoice(
 		lnbits.InvoiceParams{
 			Out:     false,
@@ -206,8 +220,12 @@ func (bot *TipBot) createInvoiceWithEvent(ctx context.Context, user *lnbits.User
 		LanguageCode: ctx.Value("publicLanguageCode").(string),
 		UserCurrency: currency,
 	}
+	for _, opt := range opts {
+		opt(&invoiceEvent)
+	}
 	// save invoice struct for later use
 	runtime.IgnoreError(bot.Bunt.Set(invoiceEvent))
+	bot.saveFiatQuote(invoiceEvent.PaymentHash, amount, invoiceEvent.Fiat)
 	return invoiceEvent, nil
 }
 
@@ -220,7 +238,10 @@ func (bot *TipBot) notifyInvoiceReceivedEvent(event Event) {
 		log.Errorln(errmsg)
 	}
 
-	if invoiceEvent.UserCurrency == "" || strings.ToLower(invoiceEvent.UserCurrency) == "btc" {
+	if invoiceEvent.Fiat != nil {
+		// the invoice was quoted in fiat, show the amount at the locked rate
+		bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedCurrencyMessage"), invoiceEvent.Amount, invoiceEvent.Fiat.Amount, invoiceEvent.Fiat.Currency))
+	} else if invoiceEvent.UserCurrency == "" || strings.ToLower(invoiceEvent.UserCurrency) == "btc" {
 		bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedMessage"), invoiceEvent.Amount))
 	} else {
 		fiatAmount, err := SatoshisToFiat(invoiceEvent.Amount, strings.ToUpper(invoiceEvent.UserCurrency))
@@ -0,0 +1,459 @@
+package telegram
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
+	"github.com/LightningTipBot/LightningTipBot/internal/database"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/events"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/LightningTipBot/LightningTipBot/internal/webhooks"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	paymentApprovalMenu = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnApprovePayment   = paymentApprovalMenu.Data("✅ Approve", "approve_payment")
+	btnDenyPayment      = paymentApprovalMenu.Data("🚫 Deny", "deny_payment")
+)
+
+// paymentApprovalTTL is how long an approval request can be used.
+const paymentApprovalTTL = time.Hour
+
+// Spend is an outgoing payment that counts towards the spending limits of the
+// owner of a wallet. The limits apply to all wallets of the owner together.
+type Spend struct {
+	ID          uint      `gorm:"primarykey"`
+	WalletID    string    `gorm:"index"`
+	Owner       string    `gorm:"index"` // name of the lnbits.User
+	Amount      int64     `json:"amount"`
+	PaymentHash string    `json:"payment_hash"`
+	CreatedAt   time.Time `gorm:"index"`
+}
+
+// PaymentApproval is created for payments above LimitSettings.ConfirmAbove.
+// The payment goes through once the owner approved it and the cooldown passed.
+type PaymentApproval struct {
+	*storage.Base
+	WalletID     string    `json:"wallet_id"`
+	TelegramID   int64     `json:"telegram_id"`
+	Amount       int64     `json:"amount"`
+	Approved     bool      `json:"approved"`
+	ApprovedAt   time.Time `json:"approved_at"`
+	LanguageCode string    `json:"languagecode"`
+}
+
+// minLimitChangeDelay is the shortest time until a loosened limit takes effect.
+const minLimitChangeDelay = 24 * time.Hour
+
+// limitNames are the limits that can be set with /set limit.
+var limitNames = []string{"tx", "hour", "day", "confirm", "cooldown"}
+
+// LimitChange is a loosened spending limit that takes effect at ApplyAt.
+// Stricter limits take effect immediately.
+type LimitChange struct {
+	*storage.Base
+	TelegramID int64     `json:"telegram_id"`
+	Limit      string    `json:"limit"`
+	Value      int64     `json:"value"`
+	ApplyAt    time.Time `json:"apply_at"`
+}
+
+func limitChangeID(user *lnbits.User, name string) string {
+	return fmt.Sprintf("limit-change:%d:%s", user.Telegram.ID, name)
+}
+
+// limitField returns the setting of a limit by its name in /set limit.
+func limitField(limits *lnbits.LimitSettings, name string) *int64 {
+	switch name {
+	case "tx":
+		return &limits.PerTransaction
+	case "hour":
+		return &limits.PerHour
+	case "day":
+		return &limits.PerDay
+	case "confirm":
+		return &limits.ConfirmAbove
+	case "cooldown":
+		return &limits.Cooldown
+	}
+	return nil
+}
+
+// loosensLimit reports whether changing a limit from old to value allows more
+// spending. Zero turns a limit off. A shorter cooldown is looser.
+func loosensLimit(name string, old int64, value int64) bool {
+	if name == "cooldown" {
+		return value < old
+	}
+	return old > 0 && (value == 0 || value > old)
+}
+
+// limitChangeDelay is how long user has to wait until a loosened limit takes effect.
+func limitChangeDelay(limits lnbits.LimitSettings) time.Duration {
+	if cooldown := time.Duration(limits.Cooldown) * time.Minute; cooldown > minLimitChangeDelay {
+		return cooldown
+	}
+	return minLimitChangeDelay
+}
+
+// scheduleLimitChange stores a loosened limit of user that takes effect after limitChangeDelay.
+func (bot *TipBot) scheduleLimitChange(user *lnbits.User, name string, value int64) (*LimitChange, error) {
+	change := &LimitChange{
+		Base:       storage.New(storage.ID(limitChangeID(user, name))),
+		TelegramID: user.Telegram.ID,
+		Limit:      name,
+		Value:      value,
+		ApplyAt:    time.Now().Add(limitChangeDelay(user.Settings.Limits)),
+	}
+	return change, change.Set(change, bot.Bunt)
+}
+
+// cancelLimitChange removes a pending change of a limit of user.
+func (bot *TipBot) cancelLimitChange(user *lnbits.User, name string) {
+	change := &LimitChange{Base: storage.New(storage.ID(limitChangeID(user, name)))}
+	if sn, err := change.Get(change, bot.Bunt); err == nil && sn.(*LimitChange).Active {
+		change = sn.(*LimitChange)
+		change.Inactivate(change, bot.Bunt)
+	}
+}
+
+// pendingLimitChanges returns the loosened limits of user that are not in effect yet.
+func (bot *TipBot) pendingLimitChanges(user *lnbits.User) []*LimitChange {
+	changes := make([]*LimitChange, 0)
+	for _, name := range limitNames {
+		change := &LimitChange{Base: storage.New(storage.ID(limitChangeID(user, name)))}
+		if sn, err := change.Get(change, bot.Bunt); err == nil && sn.(*LimitChange).Active {
+			changes = append(changes, sn.(*LimitChange))
+		}
+	}
+	return changes
+}
+
+// applyLimitChanges sets the loosened limits of user that are due. It returns
+// true if a limit was changed, the caller has to save the user.
+func (bot *TipBot) applyLimitChanges(user *lnbits.User) bool {
+	changed := false
+	for _, change := range bot.pendingLimitChanges(user) {
+		if time.Now().Before(change.ApplyAt) {
+			continue
+		}
+		*limitField(&user.Settings.Limits, change.Limit) = change.Value
+		if err := change.Inactivate(change, bot.Bunt); err != nil {
+			log.Errorf("[applyLimitChanges] %v", err)
+		}
+		log.Infof("[limits] %s changed limit %s to %d", GetUserStr(user.Telegram), change.Limit, change.Value)
+		changed = true
+	}
+	return changed
+}
+
+// paymentApprovalID identifies the approval of a payment. It has to fit into
+// the callback data of a button.
+func paymentApprovalID(walletID string, reference string) string {
+	h := sha256.Sum256([]byte(walletID + reference))
+	return fmt.Sprintf("approval:%s", hex.EncodeToString(h[:])[:24])
+}
+
+// spendingGuard enforces the spending limits of the wallet owners. It wraps the
+// wallet backend, so that every outgoing payment of the bot goes through it.
+type spendingGuard struct {
+	lnbits.WalletBackend
+	// bot uses the unguarded backend
+	bot TipBot
+}
+
+// transferSpendingGuard is used for backends that implement lnbits.Transferer.
+type transferSpendingGuard struct {
+	*spendingGuard
+	transferer lnbits.Transferer
+}
+
+func newSpendingGuard(backend lnbits.WalletBackend, bot TipBot) lnbits.WalletBackend {
+	bot.Client = backend
+	guard := &spendingGuard{WalletBackend: backend, bot: bot}
+	if transferer, ok := backend.(lnbits.Transferer); ok {
+		return &transferSpendingGuard{spendingGuard: guard, transferer: transferer}
+	}
+	return guard
+}
+
+// Pay checks the limits of the owner of w before the invoice is paid.
+// Approvals are bound to params.Reference or the recipient, amount and purpose
+// of the invoice, since many flows create a new invoice for every attempt.
+func (g *spendingGuard) Pay(w lnbits.Wallet, params lnbits.PaymentParams) (lnbits.Invoice, error) {
+	amount, err := lnbits.InvoiceAmount(params.Bolt11)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	reference := params.Reference
+	if len(reference) == 0 {
+		if reference, err = lnbits.InvoiceReference(params.Bolt11); err != nil {
+			return lnbits.Invoice{}, err
+		}
+	}
+	return g.spend(w, amount, "pay", "", "pay:"+reference, func() (lnbits.Invoice, error) {
+		return g.WalletBackend.Pay(w, params)
+	})
+}
+
+// Transfer checks the limits of the owner of from before the transfer is booked.
+func (g *transferSpendingGuard) Transfer(from lnbits.Wallet, to lnbits.Wallet, params lnbits.TransferParams) (lnbits.Invoice, error) {
+	reference := fmt.Sprintf("transfer:%s:%d", to.ID, params.NumSatoshis)
+	target := fmt.Sprintf("wallet:%s", to.ID)
+	if owner, err := g.walletOwner(to); err == nil {
+		target = owner.Name
+	}
+	return g.spend(from, params.NumSatoshis, "transfer", target, reference, func() (lnbits.Invoice, error) {
+		return g.transferer.Transfer(from, to, params)
+	})
+}
+
+// walletOwner returns the user of a wallet with its settings.
+func (g *spendingGuard) walletOwner(w lnbits.Wallet) (*lnbits.User, error) {
+	return database.FindUserByWallet(g.bot.DB.Users, w.ID)
+}
+
+// spendLockID is the lock that serializes the limit check and the payment. The
+// limits add up the payments of all wallets of the owner, so the lock is per owner.
+func spendLockID(owner string) string {
+	return fmt.Sprintf("spend:user:%s", owner)
+}
+
+// spend runs pay if amount is within the limits of the owner of w and records it
+// in the spends table and the audit log. reference identifies the payment for the
+// approval of large payments. Payments that may still settle count as spent.
+func (g *spendingGuard) spend(w lnbits.Wallet, amount int64, action string, target string, reference string, pay func() (lnbits.Invoice, error)) (lnbits.Invoice, error) {
+	entry := audit.Entry{Actor: fmt.Sprintf("wallet:%s", w.ID), Action: action, Target: target, Amount: amount}
+	user, err := g.walletOwner(w)
+	if err != nil {
+		// wallets that don't belong to a user have no limits
+		log.Debugf("[spendingGuard] no user with wallet %s: %v", w.ID, err)
+		lockID := fmt.Sprintf("spend:%s", w.ID)
+		mutex.Lock(lockID)
+		defer mutex.Unlock(lockID)
+		invoice, err := pay()
+		recordPayment(entry, invoice, err)
+		return invoice, err
+	}
+	lockID := spendLockID(user.Name)
+	mutex.Lock(lockID)
+	defer mutex.Unlock(lockID)
+
+	entry.Actor = user.Name
+	if user.Settings != nil && g.bot.applyLimitChanges(user) {
+		if err := UpdateUserRecord(user, g.bot); err != nil {
+			log.Errorf("[spendingGuard] could not save limits of %s: %v", GetUserStr(user.Telegram), err)
+		}
+	}
+	if user.Settings != nil {
+		if err := g.checkLimits(user, w, amount, reference); err != nil {
+			log.Warnf("[spendingGuard] %s: %v", GetUserStr(user.Telegram), err)
+			entry.Result, entry.Detail = audit.ResultDenied, err.Error()
+			audit.Record(entry)
+			return lnbits.Invoice{}, fmt.Errorf("%w: %v", lnbits.ErrPaymentDenied, err)
+		}
+	}
+	invoice, err := pay()
+	recordPayment(entry, invoice, err)
+	pending := stderrors.Is(err, lnbits.ErrPaymentPending)
+	if err != nil && !pending {
+		return invoice, err
+	}
+	if limits := user.Settings; limits != nil && limits.Limits.ConfirmAbove > 0 && amount > limits.Limits.ConfirmAbove {
+		g.useApproval(w, reference)
+	}
+	if !pending {
+		events.Publish(events.Event{User: user.Name, WalletID: w.ID, Amount: amount, PaymentHash: invoice.PaymentHash})
+		webhooks.Publish(user.Name, webhooks.EventPaymentSent, map[string]interface{}{
+			"payment_hash": invoice.PaymentHash,
+			"amount":       amount,
+			"type":         action,
+		})
+	}
+	spend := &Spend{WalletID: w.ID, Owner: user.Name, Amount: amount, PaymentHash: invoice.PaymentHash, CreatedAt: time.Now()}
+	if tx := g.bot.DB.Transactions.Create(spend); tx.Error != nil {
+		log.Errorf("[spendingGuard] could not record spend of %s: %v", GetUserStr(user.Telegram), tx.Error)
+	}
+	return invoice, err
+}
+
+// recordPayment adds the result of an outgoing payment to the audit log.
+func recordPayment(entry audit.Entry, invoice lnbits.Invoice, err error) {
+	entry.Result = audit.ResultOk
+	if err != nil {
+		entry.Result, entry.Detail = audit.ResultFailed, err.Error()
+	} else if entry.Target == "" {
+		entry.Target = invoice.PaymentHash
+	}
+	audit.Record(entry)
+}
+
+func (g *spendingGuard) checkLimits(user *lnbits.User, w lnbits.Wallet, amount int64, reference string) error {
+	limits := user.Settings.Limits
+	lang := user.Telegram.LanguageCode
+	if limits.PerTransaction > 0 && amount > limits.PerTransaction {
+		g.bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(lang, "limitPerTransactionMessage"), amount, limits.PerTransaction))
+		return fmt.Errorf("amount %d above limit of %d sat per transaction", amount, limits.PerTransaction)
+	}
+	if limits.PerHour > 0 {
+		spent := g.spentSince(user, w, time.Now().Add(-time.Hour))
+		if spent+amount > limits.PerHour {
+			g.bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(lang, "limitPerHourMessage"), limits.PerHour, spent))
+			return fmt.Errorf("amount %d above limit of %d sat per hour, spent %d sat", amount, limits.PerHour, spent)
+		}
+	}
+	if limits.PerDay > 0 {
+		spent := g.spentSince(user, w, time.Now().Add(-24*time.Hour))
+		if spent+amount > limits.PerDay {
+			g.bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(lang, "limitPerDayMessage"), limits.PerDay, spent))
+			return fmt.Errorf("amount %d above limit of %d sat per day, spent %d sat", amount, limits.PerDay, spent)
+		}
+	}
+	if limits.ConfirmAbove > 0 && amount > limits.ConfirmAbove {
+		return g.checkApproval(user, w, amount, reference)
+	}
+	return nil
+}
+
+// spentSince returns the sum of all payments from the wallets of user since a
+// point in time. Spends recorded before they had an owner count for their wallet w.
+func (g *spendingGuard) spentSince(user *lnbits.User, w lnbits.Wallet, since time.Time) int64 {
+	var spent int64
+	tx := g.bot.DB.Transactions.Model(&Spend{}).
+		Where("(owner = ? OR (COALESCE(owner, '') = '' AND wallet_id = ?)) AND created_at > ?", user.Name, w.ID, since).
+		Select("COALESCE(SUM(amount), 0)").Scan(&spent)
+	if tx.Error != nil {
+		log.Errorf("[spendingGuard] could not sum spends of %s: %v", GetUserStr(user.Telegram), tx.Error)
+	}
+	return spent
+}
+
+// checkApproval returns nil if the payment was approved and the cooldown has passed.
+// Otherwise it asks the user for approval.
+func (g *spendingGuard) checkApproval(user *lnbits.User, w lnbits.Wallet, amount int64, reference string) error {
+	lang := user.Telegram.LanguageCode
+	approval := &PaymentApproval{Base: storage.New(storage.ID(paymentApprovalID(w.ID, reference)))}
+	if sn, err := approval.Get(approval, g.bot.Bunt); err == nil {
+		approval = sn.(*PaymentApproval)
+	}
+	if approval.Active && approval.Approved && time.Since(approval.ApprovedAt) < paymentApprovalTTL {
+		cooldown := approval.ApprovedAt.Add(time.Duration(user.Settings.Limits.Cooldown) * time.Minute)
+		if time.Now().Before(cooldown) {
+			g.bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(lang, "paymentApprovalCooldownMessage"), cooldown.UTC().Format(scheduleTimeLayout)))
+			return fmt.Errorf("payment of %d sat in cooldown until %s", amount, cooldown)
+		}
+		// the approval is used up by spend once the payment went out
+		return nil
+	}
+	approval = &PaymentApproval{
+		Base:         storage.New(storage.ID(approval.ID)),
+		WalletID:     w.ID,
+		TelegramID:   user.Telegram.ID,
+		Amount:       amount,
+		LanguageCode: lang,
+	}
+	if err := approval.Set(approval, g.bot.Bunt); err != nil {
+		return err
+	}
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	menu.Inline(menu.Row(
+		menu.Data(i18n.Translate(lang, "approveButtonMessage"), "approve_payment", approval.ID),
+		menu.Data(i18n.Translate(lang, "denyButtonMessage"), "deny_payment", approval.ID)))
+	g.bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(lang, "paymentApprovalMessage"), amount), menu)
+	return fmt.Errorf("payment of %d sat needs approval", amount)
+}
+
+// useApproval uses up the approval of a payment. An approval is good for one
+// payment, but only a payment that went out, or may have, counts.
+func (g *spendingGuard) useApproval(w lnbits.Wallet, reference string) {
+	approval := &PaymentApproval{Base: storage.New(storage.ID(paymentApprovalID(w.ID, reference)))}
+	sn, err := approval.Get(approval, g.bot.Bunt)
+	if err != nil {
+		return
+	}
+	approval = sn.(*PaymentApproval)
+	if !approval.Active || !approval.Approved {
+		return
+	}
+	if err := approval.Inactivate(approval, g.bot.Bunt); err != nil {
+		log.Errorf("[spendingGuard] could not use up approval %s: %v", approval.ID, err)
+	}
+}
+
+// loadPaymentApprovalFromCallback loads the approval of a button. Only the wallet owner may press it.
+func (bot *TipBot) loadPaymentApprovalFromCallback(ctx intercept.Context) (*PaymentApproval, error) {
+	tx := &PaymentApproval{Base: storage.New(storage.ID(ctx.Data()))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	approval := sn.(*PaymentApproval)
+	if approval.TelegramID != ctx.Callback().Sender.ID {
+		return nil, errors.Create(errors.UnknownError)
+	}
+	if !approval.Active || approval.Approved || time.Since(approval.CreatedAt) > paymentApprovalTTL {
+		bot.tryEditMessage(ctx.Callback().Message, Translate(ctx, "paymentApprovalNotActiveMessage"), &tb.ReplyMarkup{})
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return approval, nil
+}
+
+// approvePaymentHandler approves a payment above the confirmation threshold.
+// The user has to retry the payment afterwards.
+func (bot *TipBot) approvePaymentHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	approval, err := bot.loadPaymentApprovalFromCallback(ctx)
+	if err != nil {
+		log.Errorf("[approvePaymentHandler] %v", err)
+		return ctx, err
+	}
+	approval.Approved = true
+	approval.ApprovedAt = time.Now()
+	err = approval.Set(approval, bot.Bunt)
+	if err != nil {
+		return ctx, err
+	}
+	user, err := GetLnbitsUserWithSettings(ctx.Callback().Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	message := fmt.Sprintf(Translate(ctx, "paymentApprovedMessage"), approval.Amount)
+	if cooldown := user.Settings.Limits.Cooldown; cooldown > 0 {
+		message += fmt.Sprintf(Translate(ctx, "paymentApprovedCooldownMessage"),
+			approval.ApprovedAt.Add(time.Duration(cooldown)*time.Minute).UTC().Format(scheduleTimeLayout))
+	}
+	log.Infof("[limits] %s approved payment of %d sat", GetUserStr(ctx.Callback().Sender), approval.Amount)
+	bot.tryEditMessage(ctx.Callback().Message, message, &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// denyPaymentHandler rejects a payment above the confirmation threshold.
+func (bot *TipBot) denyPaymentHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	approval, err := bot.loadPaymentApprovalFromCallback(ctx)
+	if err != nil {
+		log.Errorf("[denyPaymentHandler] %v", err)
+		return ctx, err
+	}
+	approval.Canceled = true
+	err = approval.Inactivate(approval, bot.Bunt)
+	if err != nil {
+		return ctx, err
+	}
+	log.Infof("[limits] %s denied payment of %d sat", GetUserStr(ctx.Callback().Sender), approval.Amount)
+	bot.tryEditMessage(ctx.Callback().Message, fmt.Sprintf(Translate(ctx, "paymentDeniedMessage"), approval.Amount), &tb.ReplyMarkup{})
+	return ctx, nil
+}
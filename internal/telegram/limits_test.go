@@ -0,0 +1,221 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+)
+
+func newTestGuard(t *testing.T, limits lnbits.LimitSettings) (*spendingGuard, *lnbits.User) {
+	fake := lnbits.NewFakeBackend()
+	bot := newTestBot(t, fake)
+	user := newTestUser(t, bot, fake, 1, 1000)
+	user.Settings = &lnbits.Settings{ID: user.ID, Limits: limits}
+	return &spendingGuard{WalletBackend: fake, bot: *bot}, user
+}
+
+func addSpend(t *testing.T, g *spendingGuard, w *lnbits.Wallet, amount int64, ago time.Duration) {
+	if err := g.bot.DB.Transactions.Create(&Spend{WalletID: w.ID, Amount: amount, CreatedAt: time.Now().Add(-ago)}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpendingGuard_checkLimits(t *testing.T) {
+	g, user := newTestGuard(t, lnbits.LimitSettings{PerTransaction: 100, PerHour: 150, PerDay: 300})
+	// only spends within the rolling windows count
+	addSpend(t, g, user.Wallet, 100, 30*time.Minute)
+	addSpend(t, g, user.Wallet, 120, 2*time.Hour)
+	addSpend(t, g, user.Wallet, 500, 25*time.Hour)
+
+	tests := []struct {
+		name    string
+		amount  int64
+		wantErr bool
+	}{
+		{name: "within limits", amount: 50},
+		{name: "per transaction", amount: 101, wantErr: true},
+		{name: "per hour", amount: 51, wantErr: true},
+		{name: "per day", amount: 81, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := g.checkLimits(user, *user.Wallet, tt.amount, "ref")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkLimits(%d) error = %v, wantErr %v", tt.amount, err, tt.wantErr)
+			}
+		})
+	}
+	// the per day limit is reached before the per hour limit
+	addSpend(t, g, user.Wallet, 40, 3*time.Hour)
+	if err := g.checkLimits(user, *user.Wallet, 45, "ref"); err == nil {
+		t.Error("checkLimits above the per day limit succeeded")
+	}
+}
+
+func TestSpendingGuard_spentSince(t *testing.T) {
+	g, user := newTestGuard(t, lnbits.LimitSettings{PerDay: 300})
+	other := lnbits.Wallet{ID: "other"}
+	// spends from all wallets of the user count
+	for _, spend := range []Spend{
+		{WalletID: user.Wallet.ID, Owner: user.Name, Amount: 100},
+		{WalletID: other.ID, Owner: user.Name, Amount: 150},
+		{WalletID: other.ID, Owner: "someone else", Amount: 1000},
+	} {
+		spend.CreatedAt = time.Now()
+		if err := g.bot.DB.Transactions.Create(&spend).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := g.spentSince(user, other, time.Now().Add(-time.Hour)); got != 250 {
+		t.Errorf("spentSince() = %d, want 250", got)
+	}
+	if err := g.checkLimits(user, other, 51, "ref"); err == nil {
+		t.Error("checkLimits above the per day limit of all wallets succeeded")
+	}
+}
+
+func TestSpendingGuard_spendPending(t *testing.T) {
+	g, user := newTestGuard(t, lnbits.LimitSettings{PerDay: 100})
+	if err := g.bot.DB.Users.Create(user.Settings).Error; err != nil {
+		t.Fatal(err)
+	}
+	pending := func() (lnbits.Invoice, error) {
+		return lnbits.Invoice{}, fmt.Errorf("%w: timeout", lnbits.ErrPaymentPending)
+	}
+	if _, err := g.spend(*user.Wallet, 60, "pay", "", "ref", pending); !errors.Is(err, lnbits.ErrPaymentPending) {
+		t.Fatalf("spend() error = %v, want pending", err)
+	}
+	// the pending payment counts towards the limits
+	if got := g.spentSince(user, *user.Wallet, time.Now().Add(-time.Hour)); got != 60 {
+		t.Errorf("spentSince() = %d, want 60", got)
+	}
+	if _, err := g.spend(*user.Wallet, 60, "pay", "", "ref", pending); !errors.Is(err, lnbits.ErrPaymentDenied) {
+		t.Errorf("spend() above the limit error = %v, want denied", err)
+	}
+}
+
+func approvePayment(t *testing.T, g *spendingGuard, w *lnbits.Wallet, reference string, at time.Time) {
+	approval := &PaymentApproval{Base: storage.New(storage.ID(paymentApprovalID(w.ID, reference)))}
+	sn, err := approval.Get(approval, g.bot.Bunt)
+	if err != nil {
+		t.Fatalf("no approval was requested: %v", err)
+	}
+	approval = sn.(*PaymentApproval)
+	approval.Approved, approval.ApprovedAt = true, at
+	if err := approval.Set(approval, g.bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpendingGuard_checkApproval(t *testing.T) {
+	g, user := newTestGuard(t, lnbits.LimitSettings{ConfirmAbove: 100, Cooldown: 10})
+	if err := g.checkLimits(user, *user.Wallet, 100, "small"); err != nil {
+		t.Fatalf("payment at the threshold needs approval: %v", err)
+	}
+	if err := g.checkLimits(user, *user.Wallet, 200, "large"); err == nil {
+		t.Fatal("payment above the threshold went through without approval")
+	}
+
+	approvePayment(t, g, user.Wallet, "large", time.Now())
+	if err := g.checkLimits(user, *user.Wallet, 200, "large"); err == nil {
+		t.Fatal("approved payment went through during the cooldown")
+	}
+	if err := g.checkLimits(user, *user.Wallet, 200, "other"); err == nil {
+		t.Fatal("approval was used for another payment")
+	}
+
+	approvePayment(t, g, user.Wallet, "large", time.Now().Add(-11*time.Minute))
+	if err := g.checkLimits(user, *user.Wallet, 200, "large"); err != nil {
+		t.Fatalf("approved payment after the cooldown: %v", err)
+	}
+	// an approval is good for one payment
+	g.useApproval(*user.Wallet, "large")
+	if err := g.checkLimits(user, *user.Wallet, 200, "large"); err == nil {
+		t.Fatal("approval was used twice")
+	}
+
+	approvePayment(t, g, user.Wallet, "large", time.Now().Add(-2*paymentApprovalTTL))
+	if err := g.checkLimits(user, *user.Wallet, 200, "large"); err == nil {
+		t.Fatal("expired approval was used")
+	}
+}
+
+func TestSpendingGuard_spendApproval(t *testing.T) {
+	g, user := newTestGuard(t, lnbits.LimitSettings{ConfirmAbove: 100})
+	if err := g.bot.DB.Users.Create(user.Settings).Error; err != nil {
+		t.Fatal(err)
+	}
+	reference := "large"
+	if err := g.checkLimits(user, *user.Wallet, 200, reference); err == nil {
+		t.Fatal("payment above the threshold went through without approval")
+	}
+	approvePayment(t, g, user.Wallet, reference, time.Now())
+
+	// a failed payment keeps the approval
+	failed := func() (lnbits.Invoice, error) { return lnbits.Invoice{}, fmt.Errorf("no route") }
+	if _, err := g.spend(*user.Wallet, 200, "pay", "", reference, failed); err == nil {
+		t.Fatal("spend() of a failed payment succeeded")
+	}
+	paid := func() (lnbits.Invoice, error) { return lnbits.Invoice{PaymentHash: "hash"}, nil }
+	if _, err := g.spend(*user.Wallet, 200, "pay", "", reference, paid); err != nil {
+		t.Fatalf("approved payment after a failed attempt: %v", err)
+	}
+	if _, err := g.spend(*user.Wallet, 200, "pay", "", reference, paid); err == nil {
+		t.Error("approval was used twice")
+	}
+}
+
+func Test_loosensLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit string
+		old   int64
+		value int64
+		want  bool
+	}{
+		{name: "set", limit: "day", old: 0, value: 100, want: false},
+		{name: "lower", limit: "day", old: 100, value: 50, want: false},
+		{name: "raise", limit: "day", old: 100, value: 200, want: true},
+		{name: "off", limit: "tx", old: 100, value: 0, want: true},
+		{name: "longer cooldown", limit: "cooldown", old: 10, value: 20, want: false},
+		{name: "shorter cooldown", limit: "cooldown", old: 10, value: 5, want: true},
+		{name: "cooldown off", limit: "cooldown", old: 10, value: 0, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loosensLimit(tt.limit, tt.old, tt.value); got != tt.want {
+				t.Errorf("loosensLimit(%s, %d, %d) = %v, want %v", tt.limit, tt.old, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTipBot_applyLimitChanges(t *testing.T) {
+	g, user := newTestGuard(t, lnbits.LimitSettings{PerDay: 100})
+	bot := &g.bot
+	change, err := bot.scheduleLimitChange(user, "day", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(change.ApplyAt) < minLimitChangeDelay-time.Minute {
+		t.Fatalf("limit change applies at %s, too early", change.ApplyAt)
+	}
+	if bot.applyLimitChanges(user) || user.Settings.Limits.PerDay != 100 {
+		t.Fatal("loosened limit took effect immediately")
+	}
+
+	change.ApplyAt = time.Now().Add(-time.Second)
+	if err := change.Set(change, bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	if !bot.applyLimitChanges(user) || user.Settings.Limits.PerDay != 0 {
+		t.Fatal("due limit change was not applied")
+	}
+	if len(bot.pendingLimitChanges(user)) != 0 {
+		t.Fatal("applied limit change is still pending")
+	}
+}
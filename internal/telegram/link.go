@@ -3,8 +3,13 @@ package telegram
 import (
 	"bytes"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/LightningTipBot/LightningTipBot/internal/apikeys"
+	"github.com/LightningTipBot/LightningTipBot/internal/lndhub/credentials"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
 
 	"github.com/LightningTipBot/LightningTipBot/internal"
@@ -16,15 +21,38 @@ import (
 
 func (bot *TipBot) lndhubHandler(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
-	if internal.Configuration.Lnbits.LnbitsPublicUrl == "" {
+	if internal.Configuration.Bot.LNURLHostName == "" {
 		bot.trySendMessage(m.Sender, Translate(ctx, "couldNotLinkMessage"))
 		return ctx, fmt.Errorf("invalid configuration")
 	}
 	// first check whether the user is initialized
 	fromUser := LoadUser(ctx)
+	var credential credentials.Credential
+	var err error
+	if args := strings.Fields(m.Text); len(args) > 1 && strings.ToLower(args[1]) == "rotate" {
+		// new credentials log out every linked wallet
+		credential, err = credentials.Rotate(bot.DB.Users, fromUser.Name)
+		if err == nil {
+			log.Infof("[/link] Rotated LndHub credentials of %s", GetUserStr(fromUser.Telegram))
+			bot.trySendMessage(m.Sender, Translate(ctx, "linkRotatedMessage"))
+		}
+	} else {
+		credential, err = credentials.Ensure(bot.DB.Users, fromUser.Name)
+	}
+	if err != nil {
+		log.Errorf("[/link] Could not load LndHub credentials of %s: %v", GetUserStr(fromUser.Telegram), err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "couldNotLinkMessage"))
+		return ctx, err
+	}
+	return bot.sendLndHubLink(ctx, credential)
+}
+
+// sendLndHubLink sends the LndHub URL of a credential and hides it after a minute.
+func (bot *TipBot) sendLndHubLink(ctx intercept.Context, credential credentials.Credential) (intercept.Context, error) {
+	m := ctx.Message()
 	linkmsg := bot.trySendMessageEditable(m.Sender, Translate(ctx, "walletConnectMessage"))
 
-	lndhubUrl := fmt.Sprintf("lndhub://admin:%s@%slndhub/ext/", fromUser.Wallet.Adminkey, internal.Configuration.Lnbits.LnbitsPublicUrl)
+	lndhubUrl := fmt.Sprintf("lndhub://%s:%s@%s/lndhub/ext/", credential.Login, credential.Password, strings.TrimSuffix(internal.Configuration.Bot.LNURLHostName, "/"))
 
 	// create qr code
 	qr, err := qrcode.Encode(lndhubUrl, qrcode.Medium, 256)
@@ -45,14 +73,96 @@ func (bot *TipBot) lndhubHandler(ctx intercept.Context) (intercept.Context, erro
 	return ctx, nil
 }
 
+// apiHandler manages the api keys of a user: /api keys [add <label> <scopes> [<cap>] | revoke <id>]
 func (bot *TipBot) apiHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	args := strings.Fields(m.Text)
+	if len(args) > 2 && strings.ToLower(args[1]) == "keys" {
+		switch strings.ToLower(args[2]) {
+		case "add":
+			return bot.addApiKeyHandler(ctx, args[3:])
+		case "revoke":
+			return bot.revokeApiKeyHandler(ctx, args[3:])
+		}
+	}
+	return bot.listApiKeysHandler(ctx)
+}
+
+func (bot *TipBot) listApiKeysHandler(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
 	fromUser := LoadUser(ctx)
-	apimesg := bot.trySendMessageEditable(m.Sender, fmt.Sprintf(Translate(ctx, "apiConnectMessage"), fromUser.Wallet.Adminkey, fromUser.Wallet.Inkey))
-	// auto delete
+	keys, err := apikeys.Keys(bot.DB.Users, fromUser.Name)
+	if err != nil {
+		return ctx, err
+	}
+	list := Translate(ctx, "apiNoKeysMessage")
+	if len(keys) > 0 {
+		lines := make([]string, 0, len(keys))
+		for _, k := range keys {
+			spendCap := "-"
+			if k.SpendCap > 0 {
+				spendCap = fmt.Sprintf("%d/%d sat", k.Spent, k.SpendCap)
+			}
+			lastUsed := "-"
+			if !k.LastUsed.IsZero() {
+				lastUsed = k.LastUsed.Format("2006-01-02 15:04")
+			}
+			lines = append(lines, fmt.Sprintf(Translate(ctx, "apiKeyLineMessage"), k.ID, str.MarkdownEscape(k.Label), k.Scopes, spendCap, lastUsed))
+		}
+		list = strings.Join(lines, "\n")
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "apiConnectMessage"), list))
+	return ctx, nil
+}
+
+func (bot *TipBot) addApiKeyHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	fromUser := LoadUser(ctx)
+	if len(args) < 2 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "apiKeyHelpMessage"))
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	scopes, err := apikeys.ParseScopes(args[1])
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "apiKeyHelpMessage"))
+		return ctx, err
+	}
+	var spendCap int64
+	if len(args) > 2 {
+		spendCap, err = strconv.ParseInt(args[2], 10, 64)
+		if err != nil || spendCap < 0 {
+			bot.trySendMessage(m.Sender, Translate(ctx, "apiKeyHelpMessage"))
+			return ctx, fmt.Errorf("invalid spending cap")
+		}
+	}
+	key, secret, err := apikeys.Create(bot.DB.Users, fromUser.Name, args[0], scopes, spendCap)
+	if err != nil {
+		log.Errorf("[/api] could not create api key for %s: %v", GetUserStr(fromUser.Telegram), err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "apiKeyCreateErrorMessage"))
+		return ctx, err
+	}
+	log.Infof("[/api] %s created api key %s with scopes %s", GetUserStr(fromUser.Telegram), key.ID, key.Scopes)
+	apimesg := bot.trySendMessageEditable(m.Sender, fmt.Sprintf(Translate(ctx, "apiKeyCreatedMessage"), key.ID, key.Scopes, secret))
+	// auto hide, only the hash of the key is stored
 	go func() {
 		time.Sleep(time.Second * 60)
 		bot.tryEditMessage(apimesg, Translate(ctx, "apiHiddenMessage"))
 	}()
 	return ctx, nil
 }
+
+func (bot *TipBot) revokeApiKeyHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	fromUser := LoadUser(ctx)
+	if len(args) < 1 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "apiKeyHelpMessage"))
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	if err := apikeys.Revoke(bot.DB.Users, fromUser.Name, args[0]); err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "apiKeyNotFoundMessage"))
+		return ctx, err
+	}
+	log.Infof("[/api] %s revoked api key %s", GetUserStr(fromUser.Telegram), args[0])
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "apiKeyRevokedMessage"), args[0]))
+	return ctx, nil
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
 
 	lnurl "github.com/fiatjaf/go-lnurl"
+	decodepay "github.com/fiatjaf/ln-decodepay"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -252,3 +253,65 @@ func (bot *TipBot) sendToLightningAddress(ctx intercept.Context, address string,
 	}
 	return bot.lnurlHandler(ctx)
 }
+
+// payLightningAddress pays amount sat from the wallet of user to a Lightning address
+// without any user interaction. It is used for payments that run in the background.
+func (bot *TipBot) payLightningAddress(user *lnbits.User, address string, amount int64, comment string) (lnbits.Invoice, error) {
+	_, params, err := bot.HandleLNURL(address)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	payParams, ok := params.(lnurl.LNURLPayParams)
+	if !ok {
+		return lnbits.Invoice{}, fmt.Errorf("%s is not a lightning address", address)
+	}
+	if payParams.MaxSendable != 0 && payParams.MinSendable != 0 &&
+		(amount*1000 > payParams.MaxSendable || amount*1000 < payParams.MinSendable) {
+		return lnbits.Invoice{}, fmt.Errorf("amount not in range %d-%d sat", payParams.MinSendable/1000, payParams.MaxSendable/1000)
+	}
+	callbackUrl, err := url.Parse(payParams.Callback)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	client, err := network.GetClientForScheme(callbackUrl)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	qs := callbackUrl.Query()
+	qs.Set("amount", strconv.FormatInt(amount*1000, 10)) // msat
+	if len(comment) > int(payParams.CommentAllowed) {
+		comment = comment[:payParams.CommentAllowed]
+	}
+	if len(comment) > 0 {
+		qs.Set("comment", comment)
+	}
+	callbackUrl.RawQuery = qs.Encode()
+
+	res, err := client.Get(callbackUrl.String())
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	var values lnurl.LNURLPayValues
+	json.Unmarshal(body, &values)
+	if values.Status == "ERROR" || len(values.PR) < 1 {
+		reason := "Could not receive invoice."
+		if len(values.Reason) > 0 {
+			reason = values.Reason
+		}
+		return lnbits.Invoice{}, fmt.Errorf("error in LNURLPayValues: %s", reason)
+	}
+	// never pay more than we asked for
+	bolt11, err := decodepay.Decodepay(values.PR)
+	if err != nil {
+		return lnbits.Invoice{}, err
+	}
+	if bolt11.MSatoshi != amount*1000 {
+		return lnbits.Invoice{}, fmt.Errorf("invoice amount %d msat does not match %d sat", bolt11.MSatoshi, amount)
+	}
+	return user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: values.PR}, bot.Client)
+}
@@ -0,0 +1,260 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/nwc"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/nbd-wtf/go-nostr"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	nwcHelpMessage        = "⚙️ *Nostr Wallet Connect commands:*\n`/nwc add <budget> [<days>]` ✅ Connect a nostr client that can spend up to `<budget>` sat. The connection expires after `<days>` (default 30, 0 for never).\n`/nwc revoke <id>` 🗑 Remove a connection.\n`/nwc help` 📖 Show help."
+	nwcListMessage        = "💜 *Your wallet connections:*\n%s"
+	nwcNoConnections      = "💜 You have no wallet connections."
+	nwcConnectionMessage  = "`%s` spent %d of %s sat, %s"
+	nwcCreatedMessage     = "🔗 *New wallet connection* `%s`\n\n⚠️ Never share this URI with anyone or they will be able to spend up to %s sat. Paste it into your nostr client:\n\n`%s`"
+	nwcHiddenMessage      = "🔍 Connection URI hidden. Create a new connection if you need it again."
+	nwcRevokedMessage     = "🗑 Connection `%s` removed."
+	nwcDisabledMessage    = "🚫 Nostr Wallet Connect is not available."
+	nwcInvalidAmount      = "🚫 Invalid budget or number of days."
+	nwcConnectionNotFound = "🚫 Connection not found."
+)
+
+// nwcWallet gives the wallet connect service access to the wallets of the users.
+// Payments go through bot.Client and respect the spending limits.
+type nwcWallet struct {
+	bot *TipBot
+}
+
+var _ nwc.Wallet = nwcWallet{}
+
+func (w nwcWallet) user(owner string) (*lnbits.User, error) {
+	user := &lnbits.User{}
+	err := w.bot.DB.Users.Where("name = ?", owner).First(user).Error
+	if err != nil {
+		return nil, err
+	}
+	if user.Banned || user.Wallet == nil {
+		return nil, fmt.Errorf("user %s can't use their wallet", owner)
+	}
+	return user, nil
+}
+
+func (w nwcWallet) Balance(owner string) (int64, error) {
+	user, err := w.user(owner)
+	if err != nil {
+		return 0, err
+	}
+	return w.bot.GetUserBalance(user)
+}
+
+func (w nwcWallet) Decode(bolt11 string) (int64, string, error) {
+	amount, err := lnbits.InvoiceAmount(bolt11)
+	if err != nil {
+		return 0, "", err
+	}
+	hash, err := lnbits.InvoicePaymentHash(bolt11)
+	return amount, hash, err
+}
+
+func (w nwcWallet) Pay(owner string, bolt11 string) (string, error) {
+	user, err := w.user(owner)
+	if err != nil {
+		return "", err
+	}
+	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: bolt11}, w.bot.Client)
+	if err != nil {
+		hash, _ := lnbits.InvoicePaymentHash(bolt11)
+		if payment, checkErr := w.bot.Client.Payment(*user.Wallet, hash); checkErr == nil && payment.Paid {
+			return payment.Preimage, nil
+		}
+		if lnbits.PaymentFailed(err) {
+			return "", fmt.Errorf("%w: %v", nwc.ErrPaymentFailed, err)
+		}
+		return "", err
+	}
+	payment, err := w.bot.Client.Payment(*user.Wallet, invoice.PaymentHash)
+	if err != nil {
+		// the payment went through, the preimage is optional for most clients
+		log.Warnf("[nwc] could not load preimage of %s: %v", invoice.PaymentHash, err)
+		return "", nil
+	}
+	return payment.Preimage, nil
+}
+
+func (w nwcWallet) MakeInvoice(owner string, amount int64, description string, descriptionHash string) (nwc.Invoice, error) {
+	user, err := w.user(owner)
+	if err != nil {
+		return nwc.Invoice{}, err
+	}
+	invoice, err := user.Wallet.Invoice(
+		lnbits.InvoiceParams{
+			Amount:          amount,
+			Out:             false,
+			Memo:            description,
+			DescriptionHash: descriptionHash,
+			Webhook:         internal.Configuration.Lnbits.WebhookServer},
+		w.bot.Client)
+	if err != nil {
+		return nwc.Invoice{}, err
+	}
+	return nwc.Invoice{
+		PaymentRequest:  invoice.PaymentRequest,
+		PaymentHash:     invoice.PaymentHash,
+		Description:     description,
+		DescriptionHash: descriptionHash,
+		Amount:          amount,
+		CreatedAt:       time.Now(),
+	}, nil
+}
+
+func (w nwcWallet) LookupInvoice(owner string, paymentHash string) (nwc.Invoice, error) {
+	user, err := w.user(owner)
+	if err != nil {
+		return nwc.Invoice{}, err
+	}
+	payment, err := w.bot.Client.Payment(*user.Wallet, paymentHash)
+	if err != nil {
+		return nwc.Invoice{}, err
+	}
+	if payment.Details.WalletID != "" && payment.Details.WalletID != user.Wallet.ID {
+		return nwc.Invoice{}, fmt.Errorf("invoice %s belongs to another wallet", paymentHash)
+	}
+	return nwc.Invoice{
+		PaymentRequest: payment.Details.Bolt11,
+		PaymentHash:    paymentHash,
+		Preimage:       payment.Preimage,
+		Description:    payment.Details.Memo,
+		Amount:         payment.Details.Amount / 1000,
+		Paid:           payment.Paid,
+		CreatedAt:      time.Unix(int64(payment.Details.Time), 0),
+	}, nil
+}
+
+// startNwcService answers wallet connect requests if a relay is configured.
+func (bot *TipBot) startNwcService(ctx context.Context) {
+	cfg := internal.Configuration.Nostr
+	if cfg.NWCRelay == "" || cfg.PrivateKey == "" {
+		return
+	}
+	service, err := nwc.New(bot.DB.Users, cfg.PrivateKey, nwc.NewRelay(cfg.NWCRelay), nwcWallet{bot: bot})
+	if err != nil {
+		log.Errorf("[nwc] could not start wallet connect service: %v", err)
+		return
+	}
+	service.Start(ctx)
+}
+
+func (bot *TipBot) nwcHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	cfg := internal.Configuration.Nostr
+	if cfg.NWCRelay == "" || cfg.PrivateKey == "" {
+		bot.trySendMessage(m.Sender, nwcDisabledMessage)
+		return ctx, fmt.Errorf("nwc is disabled")
+	}
+	splits := strings.Fields(m.Text)
+	if len(splits) > 1 {
+		switch strings.ToLower(splits[1]) {
+		case "add":
+			return bot.addNwcHandler(ctx, splits[2:])
+		case "revoke":
+			return bot.revokeNwcHandler(ctx, splits[2:])
+		case "help":
+			bot.trySendMessage(m.Sender, nwcHelpMessage)
+			return ctx, nil
+		}
+	}
+	return bot.listNwcHandler(ctx)
+}
+
+func budgetStr(budget int64) string {
+	if budget == 0 {
+		return "unlimited"
+	}
+	return strconv.FormatInt(budget, 10)
+}
+
+func (bot *TipBot) listNwcHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	connections, err := nwc.Connections(bot.DB.Users, user.Name)
+	if err != nil {
+		return ctx, err
+	}
+	if len(connections) == 0 {
+		bot.trySendMessage(m.Sender, nwcNoConnections+"\n\n"+nwcHelpMessage)
+		return ctx, nil
+	}
+	lines := make([]string, 0, len(connections))
+	for _, c := range connections {
+		expiry := "never expires"
+		if c.Expired() {
+			expiry = "expired"
+		} else if !c.ExpiresAt.IsZero() {
+			expiry = "expires " + c.ExpiresAt.Format("2006-01-02")
+		}
+		lines = append(lines, fmt.Sprintf(nwcConnectionMessage, c.ID, c.Spent, budgetStr(c.Budget), expiry))
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(nwcListMessage, strings.Join(lines, "\n"))+"\n\n"+nwcHelpMessage)
+	return ctx, nil
+}
+
+func (bot *TipBot) addNwcHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if len(args) < 1 {
+		bot.trySendMessage(m.Sender, nwcHelpMessage)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	budget, err := strconv.ParseInt(args[0], 10, 64)
+	days := int64(30)
+	if err == nil && len(args) > 1 {
+		days, err = strconv.ParseInt(args[1], 10, 64)
+	}
+	if err != nil || budget < 0 || days < 0 {
+		bot.trySendMessage(m.Sender, nwcInvalidAmount)
+		return ctx, fmt.Errorf("invalid arguments")
+	}
+	connection, secret, err := nwc.Create(bot.DB.Users, user.Name, budget, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		log.Errorf("[/nwc] could not create connection for %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	pubkey, err := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+	if err != nil {
+		return ctx, err
+	}
+	log.Infof("[/nwc] %s created connection %s with budget %d", GetUserStr(user.Telegram), connection.ID, budget)
+	uri := nwc.URI(pubkey, internal.Configuration.Nostr.NWCRelay, secret)
+	msg := bot.trySendMessageEditable(m.Sender, fmt.Sprintf(nwcCreatedMessage, connection.ID, budgetStr(budget), uri))
+	// auto hide, the secret is not stored
+	go func() {
+		time.Sleep(time.Second * 60)
+		bot.tryEditMessage(msg, nwcHiddenMessage, tb.Silent)
+	}()
+	return ctx, nil
+}
+
+func (bot *TipBot) revokeNwcHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if len(args) < 1 {
+		bot.trySendMessage(m.Sender, nwcHelpMessage)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	if err := nwc.Revoke(bot.DB.Users, user.Name, args[0]); err != nil {
+		bot.trySendMessage(m.Sender, nwcConnectionNotFound)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(nwcRevokedMessage, args[0]))
+	return ctx, nil
+}
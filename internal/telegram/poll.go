@@ -0,0 +1,453 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	pollMenu     = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnVotePoll  = pollMenu.Data("", "vote_poll")
+	btnClosePoll = pollMenu.Data("🔒 Close", "close_poll")
+)
+
+const (
+	pollTTL           = 24 * time.Hour
+	maxPollOptions    = 10
+	pollTextMaxLen    = 300
+	pollOptionMaxLen  = 60
+	pollTreasuryParam = "treasury"
+)
+
+var (
+	pollHelpMessage       = "📊 *Polls*\n`/poll <cost> <question> | <option 1> | <option 2> ...` Start a poll. Every vote costs at least <cost> sat and is paid to you.\n`/poll treasury <cost> <question> | ...` Votes are paid to the treasury of the group.\nPress an option to vote with <cost> sat. Reply to the poll with `/vote <option> <amount>` to vote with more sats. Results are weighted by sats. Polls close after 24 hours."
+	pollMessage           = "📊 *%s*\n\n%s\n\n🗳 %d votes, %d sat. A vote costs %d sat and goes to %s.\n_Reply with_ `/vote <option> <amount>` _to vote with more sats._ Closes %s."
+	pollClosedMessage     = "📊 *%s*\n\n%s\n\n🔒 Closed. %d votes, %d sat went to %s."
+	pollOptionLine        = "%d. %s\n%s %d sat (%d votes)"
+	pollTallyMessage      = "📊 *Final tally:* %s\n\n%s"
+	pollTallyLine         = "%s %s: %d sat (%d votes)"
+	pollNoVotesMessage    = "No votes."
+	pollPrivateMessage    = "📊 Polls can only be created in groups."
+	pollNoTreasury        = "🚫 This group has no treasury. Set one up with `/treasury setup`."
+	pollIsClosedMessage   = "📊 This poll is closed."
+	pollVotedMessage      = "🗳 You voted for %s with %d sat."
+	pollNoWalletMessage   = "🚫 You need a wallet to vote. Start a chat with %s."
+	pollBalanceMessage    = "🚫 Your balance is too low to vote."
+	pollOwnVoteMessage    = "🚫 You can't vote on your own poll."
+	pollVoteFailed        = "🚫 Couldn't vote. Please try again later."
+	pollVoteUsage         = "🚫 Reply to a poll with `/vote <option> <amount>`. The amount must be at least %d sat."
+	pollNotAllowedMessage = "🚫 Only the creator of the poll or an admin can close it."
+)
+
+// PollOption is one answer of a poll with the sats it received.
+type PollOption struct {
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+	Sats  int64  `json:"sats"`
+}
+
+// Poll is a group poll where votes are paid in sats, either to the creator
+// of the poll or to the treasury of the group.
+type Poll struct {
+	*storage.Base
+	From      *lnbits.User  `json:"from"`
+	Question  string        `json:"question"`
+	Options   []*PollOption `json:"options"`
+	Cost      int64         `json:"cost"`
+	Treasury  bool          `json:"treasury"` // votes are paid to the group treasury
+	ChatID    int64         `json:"chat_id"`
+	ChatTitle string        `json:"chat_title"`
+	MessageID int           `json:"message_id"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// pollMessageRef points from the message of a poll to the poll, so that
+// /vote replies can be matched.
+type pollMessageRef struct {
+	*storage.Base
+	PollID string `json:"poll_id"`
+}
+
+func pollMessageKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("poll-message:%d:%d", chatID, messageID)
+}
+
+// parsePoll parses "<question> | <option 1> | <option 2> ...".
+func parsePoll(text string) (string, []*PollOption, error) {
+	parts := strings.Split(text, "|")
+	if len(parts) < 3 || len(parts)-1 > maxPollOptions {
+		return "", nil, fmt.Errorf("a poll needs between 2 and %d options", maxPollOptions)
+	}
+	question := strings.TrimSpace(parts[0])
+	if question == "" || len(question) > pollTextMaxLen {
+		return "", nil, fmt.Errorf("invalid question")
+	}
+	options := make([]*PollOption, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		option := strings.TrimSpace(p)
+		if option == "" || len(option) > pollOptionMaxLen {
+			return "", nil, fmt.Errorf("invalid option %q", option)
+		}
+		options = append(options, &PollOption{Text: option})
+	}
+	return question, options, nil
+}
+
+func (p *Poll) message() tb.Editable {
+	return &tb.StoredMessage{MessageID: strconv.Itoa(p.MessageID), ChatID: p.ChatID}
+}
+
+func (p *Poll) totals() (votes int, sats int64) {
+	for _, o := range p.Options {
+		votes += o.Votes
+		sats += o.Sats
+	}
+	return votes, sats
+}
+
+func (p *Poll) recipientStr() string {
+	if p.Treasury {
+		return "the group treasury"
+	}
+	return GetUserStrMd(p.From.Telegram)
+}
+
+// text returns the message of the poll with the current results.
+func (p *Poll) text() string {
+	votes, sats := p.totals()
+	lines := make([]string, len(p.Options))
+	for i, o := range p.Options {
+		total := sats
+		if total == 0 {
+			total = 1
+		}
+		lines[i] = fmt.Sprintf(pollOptionLine, i+1, str.MarkdownEscape(o.Text), MakeProgressbar(o.Sats, total), o.Sats, o.Votes)
+	}
+	if !p.Active {
+		return fmt.Sprintf(pollClosedMessage, str.MarkdownEscape(p.Question), strings.Join(lines, "\n"), votes, sats, p.recipientStr())
+	}
+	return fmt.Sprintf(pollMessage, str.MarkdownEscape(p.Question), strings.Join(lines, "\n"), votes, sats, p.Cost, p.recipientStr(),
+		p.ExpiresAt.UTC().Format("2 Jan 15:04 UTC"))
+}
+
+// tally returns the final results, the options with the most sats first.
+func (p *Poll) tally() string {
+	votes, _ := p.totals()
+	if votes == 0 {
+		return fmt.Sprintf(pollTallyMessage, str.MarkdownEscape(p.Question), pollNoVotesMessage)
+	}
+	options := make([]*PollOption, len(p.Options))
+	copy(options, p.Options)
+	sort.SliceStable(options, func(i, j int) bool { return options[i].Sats > options[j].Sats })
+	lines := make([]string, len(options))
+	for i, o := range options {
+		medal := "▫️"
+		if o.Sats == options[0].Sats && o.Sats > 0 {
+			medal = "🏆"
+		}
+		lines[i] = fmt.Sprintf(pollTallyLine, medal, str.MarkdownEscape(o.Text), o.Sats, o.Votes)
+	}
+	return fmt.Sprintf(pollTallyMessage, str.MarkdownEscape(p.Question), strings.Join(lines, "\n"))
+}
+
+func (p *Poll) keyboard() *tb.ReplyMarkup {
+	if !p.Active {
+		return &tb.ReplyMarkup{}
+	}
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	rows := make([]tb.Row, 0, len(p.Options)+1)
+	for i, o := range p.Options {
+		rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("%d. %s", i+1, o.Text), btnVotePoll.Unique, fmt.Sprintf("%s|%d", p.ID, i))))
+	}
+	rows = append(rows, menu.Row(menu.Data(btnClosePoll.Text, btnClosePoll.Unique, p.ID)))
+	menu.Inline(rows...)
+	return menu
+}
+
+func (bot *TipBot) loadPoll(id string) (*Poll, error) {
+	p := &Poll{Base: storage.New(storage.ID(id))}
+	sn, err := p.Get(p, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*Poll), nil
+}
+
+// pollRecipient returns the user the votes of a poll are paid to.
+func (bot *TipBot) pollRecipient(p *Poll) (*lnbits.User, error) {
+	if !p.Treasury {
+		return p.From, nil
+	}
+	treasury, err := bot.loadTreasury(p.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	return bot.treasuryWallet(treasury)
+}
+
+// pollHandler invoked on "/poll [treasury] <cost> <question> | <option 1> | <option 2> ..."
+func (bot *TipBot) pollHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, pollPrivateMessage)
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	args := strings.Fields(m.Text)
+	treasury := len(args) > 1 && strings.ToLower(args[1]) == pollTreasuryParam
+	costArg := 1
+	if treasury {
+		costArg = 2
+	}
+	if len(args) < costArg+2 {
+		bot.trySendMessage(m.Sender, pollHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	cost, err := GetAmount(args[costArg])
+	if err != nil || cost < 1 {
+		bot.trySendMessage(m.Sender, pollHelpMessage)
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	question, options, err := parsePoll(GetMemoFromCommand(m.Text, costArg+1))
+	if err != nil {
+		bot.trySendMessage(m.Sender, pollHelpMessage)
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	if treasury {
+		if _, err := bot.loadTreasury(m.Chat.ID); err != nil {
+			bot.trySendMessage(m.Chat, pollNoTreasury)
+			return ctx, err
+		}
+	}
+	p := &Poll{
+		Base:      storage.New(storage.ID(fmt.Sprintf("poll:%s", RandStringRunes(10)))),
+		From:      LoadUser(ctx),
+		Question:  question,
+		Options:   options,
+		Cost:      cost,
+		Treasury:  treasury,
+		ChatID:    m.Chat.ID,
+		ChatTitle: m.Chat.Title,
+		ExpiresAt: time.Now().Add(pollTTL),
+	}
+	msg := bot.trySendMessage(m.Chat, p.text(), p.keyboard())
+	if msg == nil {
+		return ctx, fmt.Errorf("could not send poll")
+	}
+	p.MessageID = msg.ID
+	if err := p.Set(p, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	ref := &pollMessageRef{Base: storage.New(storage.ID(pollMessageKey(p.ChatID, p.MessageID))), PollID: p.ID}
+	if err := ref.Set(ref, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	bot.startPollTimer(p)
+	log.Infof("[📊 poll] %s started poll %s with %d options (%d sat per vote).", GetUserStr(m.Sender), p.ID, len(options), cost)
+	return ctx, nil
+}
+
+// votePollHandler is invoked when an option of a poll is pressed. The vote costs
+// the cost of the poll.
+func (bot *TipBot) votePollHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	split := strings.Split(ctx.Data(), "|")
+	if len(split) != 2 {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	option, err := strconv.Atoi(split[1])
+	if err != nil {
+		return ctx, err
+	}
+	id := split[0]
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	p, err := bot.loadPoll(id)
+	if err != nil {
+		return ctx, err
+	}
+	response, err := bot.votePoll(p, LoadUser(ctx), c.Sender, option, p.Cost, fmt.Sprintf("%s:%s", p.ID, c.ID))
+	ctx.Context = context.WithValue(ctx, "callback_response", response)
+	return ctx, err
+}
+
+// voteHandler invoked on "/vote <option> <amount>" as a reply to a poll
+func (bot *TipBot) voteHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if !m.IsReply() {
+		bot.trySendMessage(m.Sender, pollHelpMessage)
+		return ctx, errors.Create(errors.NoReplyMessageError)
+	}
+	ref := &pollMessageRef{Base: storage.New(storage.ID(pollMessageKey(m.Chat.ID, m.ReplyTo.ID)))}
+	rn, err := ref.Get(ref, bot.Bunt)
+	if err != nil {
+		bot.trySendMessage(m.Sender, pollHelpMessage)
+		return ctx, err
+	}
+	id := rn.(*pollMessageRef).PollID
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	p, err := bot.loadPoll(id)
+	if err != nil {
+		return ctx, err
+	}
+	args := strings.Fields(m.Text)
+	if len(args) != 3 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(pollVoteUsage, p.Cost))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	option, err := strconv.Atoi(args[1])
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(pollVoteUsage, p.Cost))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(args[2])
+	if err != nil || amount < p.Cost {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(pollVoteUsage, p.Cost))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	response, err := bot.votePoll(p, LoadUser(ctx), m.Sender, option-1, amount, fmt.Sprintf("%s:%d:%d", p.ID, m.Chat.ID, m.ID))
+	bot.trySendMessage(m.Sender, response)
+	bot.tryDeleteMessage(m)
+	return ctx, err
+}
+
+// votePoll pays amount from voter to the recipient of the poll and counts the
+// vote. The caller must hold the lock of the poll. The returned text is shown
+// to the voter.
+func (bot *TipBot) votePoll(p *Poll, voter *lnbits.User, sender *tb.User, option int, amount int64, key string) (string, error) {
+	if !p.Active {
+		return pollIsClosedMessage, errors.Create(errors.NotActiveError)
+	}
+	if option < 0 || option >= len(p.Options) {
+		return fmt.Sprintf(pollVoteUsage, p.Cost), errors.Create(errors.InvalidSyntaxError)
+	}
+	if voter == nil || voter.Wallet == nil {
+		return fmt.Sprintf(pollNoWalletMessage, GetUserStr(bot.Telegram.Me)), fmt.Errorf("user has no wallet")
+	}
+	if !p.Treasury && sender.ID == p.From.Telegram.ID {
+		return pollOwnVoteMessage, errors.Create(errors.SelfPaymentError)
+	}
+	to, err := bot.pollRecipient(p)
+	if err != nil {
+		return pollVoteFailed, err
+	}
+	t := NewTransaction(bot, voter, to, amount, TransactionType("poll"), TransactionIdempotencyKey(key))
+	t.ChatID, t.ChatName = p.ChatID, p.ChatTitle
+	t.Memo = fmt.Sprintf("📊 Vote on %s.", p.Question)
+	if success, err := t.Send(); !success {
+		log.Warnf("[poll] Vote of %s on %s failed: %v", GetUserStr(sender), p.ID, err)
+		if balance, _ := bot.GetUserBalance(voter); balance < amount {
+			return pollBalanceMessage, err
+		}
+		return pollVoteFailed, err
+	}
+	p.Options[option].Votes++
+	p.Options[option].Sats += amount
+	if err := p.Set(p, bot.Bunt); err != nil {
+		return pollVoteFailed, err
+	}
+	bot.tryEditStack(p.message(), p.ID, p.text(), p.keyboard())
+	log.Infof("[📊 poll] %s voted %d sat for option %d of %s.", GetUserStr(sender), amount, option+1, p.ID)
+	return fmt.Sprintf(pollVotedMessage, p.Options[option].Text, amount), nil
+}
+
+// closePollHandler is invoked when the creator of a poll or an admin closes it.
+func (bot *TipBot) closePollHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	id := ctx.Data()
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	p, err := bot.loadPoll(id)
+	if err != nil {
+		return ctx, err
+	}
+	if !p.Active {
+		ctx.Context = context.WithValue(ctx, "callback_response", pollIsClosedMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if c.Sender.ID != p.From.Telegram.ID && !bot.isAdmin(&tb.Chat{ID: p.ChatID}, c.Sender) {
+		ctx.Context = context.WithValue(ctx, "callback_response", pollNotAllowedMessage)
+		return ctx, fmt.Errorf("%s may not close %s", GetUserStr(c.Sender), id)
+	}
+	stopPollTimer(p.ID)
+	return ctx, bot.closePoll(p)
+}
+
+// closePoll ends the poll and posts the final tally. The caller must hold the
+// lock of the poll.
+func (bot *TipBot) closePoll(p *Poll) error {
+	p.Active = false
+	if err := p.Set(p, bot.Bunt); err != nil {
+		return err
+	}
+	bot.tryEditStack(p.message(), p.ID, p.text(), p.keyboard())
+	bot.trySendMessage(&tb.Chat{ID: p.ChatID}, p.tally())
+	log.Infof("[📊 poll] Poll %s closed.", p.ID)
+	return nil
+}
+
+func (bot *TipBot) expirePoll(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	runtime.RemoveTicker(id)
+	p, err := bot.loadPoll(id)
+	if err != nil {
+		log.Errorf("[expirePoll] %v", err)
+		return
+	}
+	if !p.Active {
+		return
+	}
+	if err := bot.closePoll(p); err != nil {
+		log.Errorf("[expirePoll] Could not close poll %s: %v", id, err)
+	}
+}
+
+func (bot *TipBot) startPollTimer(p *Poll) {
+	id := p.ID
+	t := runtime.NewResettableFunction(id,
+		runtime.WithTimer(time.NewTimer(time.Until(p.ExpiresAt))))
+	t.Do(func() {
+		bot.expirePoll(id)
+	})
+}
+
+func stopPollTimer(id string) {
+	if t, ok := runtime.Get(id); ok {
+		t.StopChan <- struct{}{}
+		runtime.RemoveTicker(id)
+	}
+}
+
+func (bot *TipBot) restartPersistedPolls() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		err := tx.Ascend("poll", func(key, value string) bool {
+			p := &Poll{}
+			err := json.Unmarshal([]byte(value), p)
+			if err != nil {
+				return true
+			}
+			if p.Active {
+				bot.startPollTimer(p)
+			}
+			return true // continue iteration
+		})
+		return err
+	})
+}
@@ -0,0 +1,553 @@
+package telegram
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/raffle"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	raffleMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnJoinRaffle   = raffleMenu.Data("🎟 Join", "join_raffle")
+	btnCancelRaffle = raffleMenu.Data("🚫 Cancel", "cancel_raffle")
+)
+
+const (
+	maxRaffleWinners      = 100
+	maxRaffleParticipants = 5000
+	raffleMembersParam    = "members"
+	// participant ids are listed in the proof up to this many participants
+	raffleProofMaxIDs = 200
+	// failed payouts and refunds are tried again after this long
+	raffleRetryInterval = 10 * time.Minute
+)
+
+const (
+	RaffleOpen      = "open"
+	RaffleDrawn     = "drawn"
+	RafflePaid      = "paid"
+	RaffleCancelled = "cancelled"
+)
+
+var (
+	raffleHelpMessage      = "🎟 *Raffles*\n`/raffle <amount> <winners> <duration> [members]` Raffle sats among everyone who joins within the duration (e.g. 30m, 12h, 2d). The prize is split between the winners.\nWith `members`, only members who were in the group before the raffle started can join.\nThe winners are drawn with a seed that is committed at the start and revealed with the draw, so anyone can check the result."
+	raffleMessage          = "🎟 *Raffle: %d sat* by %s\n%d winners, %d sat each. %s\n\nParticipants: %d\nDraw: %s\nCommitment: `%s`"
+	raffleMembersOnly      = "Only members who joined before the raffle can take part."
+	raffleDrawnMessage     = "🎟 *Raffle: %d sat* by %s\n\n🏆 Winners: %s"
+	raffleCancelledMessage = "🎟 *Raffle: %d sat* by %s\n\n🚫 Cancelled. The sats were refunded."
+	raffleNoWinnersMessage = "🎟 *Raffle: %d sat* by %s\n\nNobody joined. The sats were refunded."
+	raffleProofMessage     = "🎲 *Draw of raffle* `%s`\nSeed: `%s`\nCommitment: `%s`\nParticipants (%d): `%s`\nParticipants digest: `%s`\nWinners: %s\n\nCheck: sha256(seed) is the commitment. Sort the participant ids ascending, winner k (from 0) is at index sha256(\"<seed>:<k>\") mod the number of remaining participants, reading the first 8 bytes of the hash as a big endian number."
+	raffleProofTooMany     = "too many to list, see digest"
+	rafflePrivateMessage   = "🎟 Raffles can only be created in groups."
+	raffleBalanceMessage   = "🚫 Your balance is too low for this raffle."
+	raffleFailedMessage    = "🚫 Couldn't create the raffle. Please try again later."
+	raffleAmountMessage    = "🚫 Every winner needs at least 1 sat and a raffle can have at most %d winners."
+	raffleClosedMessage    = "🎟 This raffle is closed."
+	raffleJoinedMessage    = "🎟 You joined the raffle."
+	raffleAlreadyJoined    = "🎟 You already joined this raffle."
+	raffleOwnMessage       = "🎟 You can't join your own raffle."
+	raffleFullMessage      = "🎟 This raffle is full."
+	raffleNotMember        = "🚫 Only members who joined the group before the raffle can take part."
+	raffleNotAllowed       = "🚫 Only the creator of the raffle or an admin can cancel it."
+	raffleWonMessage       = "🏆 You won %d sat in the raffle of %s."
+	raffleRefundReceived   = "↩️ Your raffle of %d sat was refunded."
+)
+
+// RaffleWinner is a drawn participant and the share of the prize.
+type RaffleWinner struct {
+	User   *tb.User `json:"user"`
+	Amount int64    `json:"amount"`
+	Paid   bool     `json:"paid"`
+}
+
+// Raffle holds the prize of its creator in the bot wallet until the winners
+// are drawn. The seed is kept secret until the draw, only its commitment is
+// published.
+type Raffle struct {
+	*storage.Base
+	From         *lnbits.User    `json:"from"`
+	Amount       int64           `json:"amount"`
+	NWinners     int             `json:"n_winners"`
+	MembersOnly  bool            `json:"members_only"`
+	Newcomers    []int64         `json:"newcomers"` // joined the group after the raffle started
+	Participants []*tb.User      `json:"participants"`
+	Winners      []*RaffleWinner `json:"winners"`
+	Seed         string          `json:"seed"` // hex
+	Commitment   string          `json:"commitment"`
+	ChatID       int64           `json:"chat_id"`
+	ChatTitle    string          `json:"chat_title"`
+	MessageID    int             `json:"message_id"`
+	Status       string          `json:"status"`
+	EndsAt       time.Time       `json:"ends_at"`
+	RetryAt      time.Time       `json:"retry_at"`
+}
+
+func (r *Raffle) message() tb.Editable {
+	return &tb.StoredMessage{MessageID: strconv.Itoa(r.MessageID), ChatID: r.ChatID}
+}
+
+func (r *Raffle) participantIDs() []int64 {
+	ids := make([]int64, len(r.Participants))
+	for i, p := range r.Participants {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+func (r *Raffle) hasParticipant(id int64) bool {
+	for _, p := range r.Participants {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Raffle) isNewcomer(id int64) bool {
+	for _, n := range r.Newcomers {
+		if n == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Raffle) winnersStr() string {
+	names := make([]string, len(r.Winners))
+	for i, w := range r.Winners {
+		names[i] = fmt.Sprintf("%s (%d sat)", GetUserStrMd(w.User), w.Amount)
+	}
+	return strings.Join(names, ", ")
+}
+
+// text returns the message of the raffle in its current state.
+func (r *Raffle) text() string {
+	from := GetUserStrMd(r.From.Telegram)
+	switch r.Status {
+	case RaffleDrawn, RafflePaid:
+		if len(r.Winners) == 0 {
+			return fmt.Sprintf(raffleNoWinnersMessage, r.Amount, from)
+		}
+		return fmt.Sprintf(raffleDrawnMessage, r.Amount, from, r.winnersStr())
+	case RaffleCancelled:
+		return fmt.Sprintf(raffleCancelledMessage, r.Amount, from)
+	}
+	restriction := ""
+	if r.MembersOnly {
+		restriction = raffleMembersOnly
+	}
+	return fmt.Sprintf(raffleMessage, r.Amount, from, r.NWinners, r.Amount/int64(r.NWinners), restriction,
+		len(r.Participants), r.EndsAt.UTC().Format("2 Jan 15:04 UTC"), r.Commitment)
+}
+
+func (r *Raffle) keyboard() *tb.ReplyMarkup {
+	if r.Status != RaffleOpen {
+		return &tb.ReplyMarkup{}
+	}
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	menu.Inline(menu.Row(
+		menu.Data(btnJoinRaffle.Text, btnJoinRaffle.Unique, r.ID),
+		menu.Data(btnCancelRaffle.Text, btnCancelRaffle.Unique, r.ID)))
+	return menu
+}
+
+// proof returns the message that reveals the seed of a drawn raffle.
+func (r *Raffle) proof() string {
+	ids := r.participantIDs()
+	list := raffleProofTooMany
+	if len(ids) <= raffleProofMaxIDs {
+		sorted := make([]string, 0, len(ids))
+		for _, id := range raffle.Sorted(ids) {
+			sorted = append(sorted, strconv.FormatInt(id, 10))
+		}
+		list = strings.Join(sorted, ",")
+	}
+	return fmt.Sprintf(raffleProofMessage, r.ID, r.Seed, r.Commitment, len(ids), list, raffle.ParticipantsDigest(ids), r.winnersStr())
+}
+
+func (bot *TipBot) loadRaffle(id string) (*Raffle, error) {
+	r := &Raffle{Base: storage.New(storage.ID(id))}
+	sn, err := r.Get(r, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*Raffle), nil
+}
+
+// raffleHandler invoked on "/raffle <amount> <winners> <duration> [members]"
+func (bot *TipBot) raffleHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Sender, rafflePrivateMessage)
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	args := strings.Fields(m.Text)
+	if len(args) < 4 || len(args) > 5 || (len(args) == 5 && strings.ToLower(args[4]) != raffleMembersParam) {
+		bot.trySendMessage(m.Sender, raffleHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(args[1])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, raffleHelpMessage)
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	winners, err := strconv.Atoi(args[2])
+	if err != nil || winners < 1 || winners > maxRaffleWinners || amount < int64(winners) {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(raffleAmountMessage, maxRaffleWinners))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	duration, err := raffle.ParseDuration(args[3])
+	if err != nil {
+		bot.trySendMessage(m.Sender, raffleHelpMessage)
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	seed, err := raffle.NewSeed()
+	if err != nil {
+		return ctx, err
+	}
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	from := LoadUser(ctx)
+	r := &Raffle{
+		Base:        storage.New(storage.ID(fmt.Sprintf("raffle:%s", RandStringRunes(10)))),
+		From:        from,
+		Amount:      amount,
+		NWinners:    winners,
+		MembersOnly: len(args) == 5,
+		Seed:        hex.EncodeToString(seed),
+		Commitment:  raffle.Commitment(seed),
+		ChatID:      m.Chat.ID,
+		ChatTitle:   m.Chat.Title,
+		Status:      RaffleOpen,
+		EndsAt:      time.Now().Add(duration),
+	}
+
+	// move the prize to the bot until the draw
+	t := NewTransaction(bot, from, me, amount, TransactionType("raffle"), TransactionChat(m.Chat),
+		TransactionIdempotencyKey(r.ID+":escrow"))
+	t.Memo = fmt.Sprintf("🎟 Raffle in %s.", m.Chat.Title)
+	if success, err := t.Send(); !success {
+		log.Warnf("[/raffle] Escrow of %s failed: %v", GetUserStr(from.Telegram), err)
+		balance, _ := bot.GetUserBalance(from)
+		if balance < amount {
+			bot.trySendMessage(m.Sender, raffleBalanceMessage)
+		} else {
+			bot.trySendMessage(m.Sender, raffleFailedMessage)
+		}
+		return ctx, err
+	}
+	msg := bot.trySendMessage(m.Chat, r.text(), r.keyboard())
+	if msg == nil {
+		bot.refundRaffle(r)
+		return ctx, fmt.Errorf("could not send raffle %s", r.ID)
+	}
+	r.MessageID = msg.ID
+	if err := r.Set(r, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	bot.startRaffleTimer(r)
+	log.Infof("[🎟 raffle] %s started raffle %s of %d sat for %d winners.", GetUserStr(from.Telegram), r.ID, amount, winners)
+	return ctx, nil
+}
+
+// joinRaffleHandler adds the sender of the callback to the participants.
+func (bot *TipBot) joinRaffleHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	id := ctx.Data()
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	r, err := bot.loadRaffle(id)
+	if err != nil {
+		return ctx, err
+	}
+	response := raffleJoinedMessage
+	switch {
+	case r.Status != RaffleOpen:
+		response, err = raffleClosedMessage, errors.Create(errors.NotActiveError)
+	case c.Sender.ID == r.From.Telegram.ID:
+		response, err = raffleOwnMessage, errors.Create(errors.SelfPaymentError)
+	case r.hasParticipant(c.Sender.ID):
+		response, err = raffleAlreadyJoined, fmt.Errorf("already joined")
+	case len(r.Participants) >= maxRaffleParticipants:
+		response, err = raffleFullMessage, fmt.Errorf("too many participants")
+	case r.MembersOnly && !bot.isRaffleMember(r, c.Sender):
+		response, err = raffleNotMember, fmt.Errorf("%s is no member", GetUserStr(c.Sender))
+	}
+	ctx.Context = context.WithValue(ctx, "callback_response", response)
+	if err != nil {
+		return ctx, err
+	}
+	r.Participants = append(r.Participants, c.Sender)
+	if err := r.Set(r, bot.Bunt); err != nil {
+		return ctx, err
+	}
+	bot.tryEditStack(r.message(), r.ID, r.text(), r.keyboard())
+	log.Infof("[🎟 raffle] %s joined raffle %s.", GetUserStr(c.Sender), r.ID)
+	return ctx, nil
+}
+
+// isRaffleMember returns true if user is in the group of the raffle and was
+// not seen joining it after the raffle started.
+func (bot *TipBot) isRaffleMember(r *Raffle, user *tb.User) bool {
+	if r.isNewcomer(user.ID) {
+		return false
+	}
+	member, err := bot.Telegram.ChatMemberOf(&tb.Chat{ID: r.ChatID}, user)
+	if err != nil {
+		log.Warnf("[raffle] Could not get membership of %s: %v", GetUserStr(user), err)
+		return false
+	}
+	// restricted users may have left the group already, they can't take part
+	return member.Role == tb.Creator || member.Role == tb.Administrator || member.Role == tb.Member
+}
+
+// markRaffleNewcomers excludes users that join a group from the members only
+// raffles that are open in the group.
+func (bot *TipBot) markRaffleNewcomers(chatID int64, users []tb.User) {
+	var ids []string
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("raffle", func(key, value string) bool {
+			r := &Raffle{}
+			if err := json.Unmarshal([]byte(value), r); err != nil {
+				return true
+			}
+			if r.ChatID == chatID && r.MembersOnly && r.Status == RaffleOpen {
+				ids = append(ids, r.ID)
+			}
+			return true // continue iteration
+		})
+	})
+	for _, id := range ids {
+		func() {
+			mutex.Lock(id)
+			defer mutex.Unlock(id)
+			r, err := bot.loadRaffle(id)
+			if err != nil || r.Status != RaffleOpen {
+				return
+			}
+			for _, u := range users {
+				if !r.isNewcomer(u.ID) {
+					r.Newcomers = append(r.Newcomers, u.ID)
+				}
+			}
+			if err := r.Set(r, bot.Bunt); err != nil {
+				log.Errorf("[raffle] Could not save newcomers of %s: %v", id, err)
+			}
+		}()
+	}
+}
+
+func (bot *TipBot) cancelRaffleHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	id := ctx.Data()
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	r, err := bot.loadRaffle(id)
+	if err != nil {
+		return ctx, err
+	}
+	if r.Status != RaffleOpen {
+		ctx.Context = context.WithValue(ctx, "callback_response", raffleClosedMessage)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if c.Sender.ID != r.From.Telegram.ID && !bot.isAdmin(&tb.Chat{ID: r.ChatID}, c.Sender) {
+		ctx.Context = context.WithValue(ctx, "callback_response", raffleNotAllowed)
+		return ctx, fmt.Errorf("%s may not cancel %s", GetUserStr(c.Sender), id)
+	}
+	if err := bot.refundRaffle(r); err != nil {
+		return ctx, err
+	}
+	r.Status = RaffleCancelled
+	bot.tryEditMessage(r.message(), r.text(), r.keyboard())
+	return ctx, r.Set(r, bot.Bunt)
+}
+
+// drawRaffle reveals the seed and draws the winners. The winners are saved
+// before any payout, so that a raffle is never drawn twice. The caller must
+// hold the lock of the raffle.
+func (bot *TipBot) drawRaffle(r *Raffle) error {
+	seed, err := hex.DecodeString(r.Seed)
+	if err != nil {
+		return err
+	}
+	drawn := raffle.Draw(seed, r.participantIDs(), r.NWinners)
+	if len(drawn) == 0 {
+		if err := bot.refundRaffle(r); err != nil {
+			return err
+		}
+		r.Status = RaffleDrawn
+		bot.tryEditMessage(r.message(), r.text(), r.keyboard())
+		return r.Set(r, bot.Bunt)
+	}
+	weights := make([]int64, len(drawn))
+	for i := range weights {
+		weights[i] = 1
+	}
+	shares, err := splitAmount(r.Amount, weights)
+	if err != nil {
+		return err
+	}
+	for i, id := range drawn {
+		for _, p := range r.Participants {
+			if p.ID == id {
+				r.Winners = append(r.Winners, &RaffleWinner{User: p, Amount: shares[i]})
+			}
+		}
+	}
+	r.Status = RaffleDrawn
+	if err := r.Set(r, bot.Bunt); err != nil {
+		return err
+	}
+	bot.tryEditMessage(r.message(), r.text(), r.keyboard())
+	bot.trySendMessage(&tb.Chat{ID: r.ChatID}, r.proof())
+	log.Infof("[🎟 raffle] Raffle %s drawn, %d winners of %d participants.", r.ID, len(r.Winners), len(r.Participants))
+	return bot.payRaffle(r)
+}
+
+// payRaffle pays the winners of a drawn raffle. Winners that were paid in an
+// earlier attempt are skipped. The caller must hold the lock of the raffle.
+func (bot *TipBot) payRaffle(r *Raffle) error {
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return err
+	}
+	unguarded := bot.withoutSpendingLimits()
+	var failed error
+	for _, w := range r.Winners {
+		if w.Paid {
+			continue
+		}
+		to, exists := bot.UserExists(w.User)
+		if !exists {
+			if to, err = bot.CreateWalletForTelegramUser(w.User); err != nil {
+				failed = err
+				continue
+			}
+		}
+		t := NewTransaction(unguarded, me, to, w.Amount, TransactionType("raffle payout"), TransactionBatch(r.ID),
+			TransactionIdempotencyKey(fmt.Sprintf("%s:%d", r.ID, w.User.ID)))
+		t.ChatID, t.ChatName = r.ChatID, r.ChatTitle
+		t.Memo = fmt.Sprintf("🎟 Raffle of %s.", GetUserStr(r.From.Telegram))
+		if success, err := t.Send(); !success {
+			log.Errorf("[raffle] Payout of %s to %s failed: %v", r.ID, GetUserStr(w.User), err)
+			failed = fmt.Errorf("payout failed: %v", err)
+			continue
+		}
+		w.Paid = true
+		bot.trySendMessage(w.User, fmt.Sprintf(raffleWonMessage, w.Amount, GetUserStrMd(r.From.Telegram)))
+	}
+	if failed == nil {
+		r.Status = RafflePaid
+		r.Active = false
+	}
+	if err := r.Set(r, bot.Bunt); err != nil {
+		return err
+	}
+	return failed
+}
+
+// refundRaffle books the prize back to the creator. The caller must hold the
+// lock of the raffle.
+func (bot *TipBot) refundRaffle(r *Raffle) error {
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return err
+	}
+	t := NewTransaction(bot.withoutSpendingLimits(), me, r.From, r.Amount, TransactionType("raffle refund"),
+		TransactionIdempotencyKey(r.ID+":refund"))
+	t.ChatID, t.ChatName = r.ChatID, r.ChatTitle
+	t.Memo = "↩️ Raffle refund."
+	if success, err := t.Send(); !success {
+		log.Errorf("[raffle] Refund of %s failed: %v", r.ID, err)
+		return fmt.Errorf("refund failed: %v", err)
+	}
+	r.Active = false
+	stopRaffleTimer(r.ID)
+	bot.trySendMessage(r.From.Telegram, fmt.Sprintf(raffleRefundReceived, r.Amount))
+	log.Infof("[🎟 raffle] Raffle %s refunded.", r.ID)
+	return nil
+}
+
+// endRaffle draws an open raffle at its end and retries the payouts of a
+// drawn one. If a payout or the refund fails, endRaffle runs again later.
+func (bot *TipBot) endRaffle(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	runtime.RemoveTicker(id)
+	r, err := bot.loadRaffle(id)
+	if err != nil {
+		log.Errorf("[endRaffle] %v", err)
+		return
+	}
+	switch r.Status {
+	case RaffleOpen:
+		err = bot.drawRaffle(r)
+	case RaffleDrawn:
+		err = bot.payRaffle(r)
+	}
+	if err != nil {
+		log.Errorf("[endRaffle] Could not end raffle %s: %v", id, err)
+		r.RetryAt = time.Now().Add(raffleRetryInterval)
+		if err := r.Set(r, bot.Bunt); err != nil {
+			log.Errorf("[endRaffle] Could not save raffle %s: %v", id, err)
+		}
+		bot.startRaffleTimer(r)
+	}
+}
+
+// startRaffleTimer runs endRaffle at the end of the raffle or at the next
+// retry of a failed payout.
+func (bot *TipBot) startRaffleTimer(r *Raffle) {
+	id := r.ID
+	at := r.EndsAt
+	if r.RetryAt.After(at) {
+		at = r.RetryAt
+	}
+	t := runtime.NewResettableFunction(id,
+		runtime.WithTimer(time.NewTimer(time.Until(at))))
+	t.Do(func() {
+		bot.endRaffle(id)
+	})
+}
+
+func stopRaffleTimer(id string) {
+	if t, ok := runtime.Get(id); ok {
+		t.StopChan <- struct{}{}
+		runtime.RemoveTicker(id)
+	}
+}
+
+func (bot *TipBot) restartPersistedRaffles() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		err := tx.Ascend("raffle", func(key, value string) bool {
+			r := &Raffle{}
+			err := json.Unmarshal([]byte(value), r)
+			if err != nil {
+				return true
+			}
+			// drawn raffles with missing payouts are retried
+			if r.Status == RaffleOpen || (r.Status == RaffleDrawn && r.Active) {
+				bot.startRaffleTimer(r)
+			}
+			return true // continue iteration
+		})
+		return err
+	})
+}
@@ -0,0 +1,61 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+)
+
+func TestTipBot_endRaffle(t *testing.T) {
+	fake := lnbits.NewFakeBackend()
+	bot := newTestBot(t, fake)
+	// the bot wallet holds less than the prize, so the second payout fails
+	me := newTestUser(t, bot, fake, 1, 60)
+	alice := newTestUser(t, bot, fake, 2, 0)
+	bob := newTestUser(t, bot, fake, 3, 0)
+	carol := newTestUser(t, bot, fake, 4, 0)
+	r := &Raffle{
+		Base:   storage.New(storage.ID("raffle:test")),
+		From:   alice,
+		Amount: 100,
+		Winners: []*RaffleWinner{
+			{User: bob.Telegram, Amount: 50},
+			{User: carol.Telegram, Amount: 50},
+		},
+		Status: RaffleDrawn,
+		EndsAt: time.Now(),
+	}
+	if err := r.Set(r, bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	defer stopRaffleTimer(r.ID)
+
+	bot.endRaffle(r.ID)
+	r, err := bot.loadRaffle(r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != RaffleDrawn || !r.Winners[0].Paid || r.Winners[1].Paid {
+		t.Fatalf("raffle after a failed payout = %+v, want drawn and paid to the first winner", r)
+	}
+	if time.Until(r.RetryAt) < raffleRetryInterval-time.Minute {
+		t.Errorf("retry at %v, want in %v", r.RetryAt, raffleRetryInterval)
+	}
+	if _, ok := runtime.Get(r.ID); !ok {
+		t.Error("no retry of the failed payout was scheduled")
+	}
+
+	if err := fake.Deposit(*me.Wallet, 40, "top up"); err != nil {
+		t.Fatal(err)
+	}
+	bot.endRaffle(r.ID)
+	if r, _ = bot.loadRaffle(r.ID); r.Status != RafflePaid {
+		t.Errorf("status after the retry = %s, want %s", r.Status, RafflePaid)
+	}
+	if got := balanceOf(t, bot, carol); got != 50 {
+		t.Errorf("balance of carol = %d, want 50", got)
+	}
+}
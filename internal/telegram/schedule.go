@@ -0,0 +1,491 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/schedule"
+	"github.com/LightningTipBot/LightningTipBot/internal/storage"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/LightningTipBot/LightningTipBot/pkg/lightning"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	scheduleMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnPauseSchedule  = scheduleMenu.Data("⏸ Pause", "pause_schedule")
+	btnResumeSchedule = scheduleMenu.Data("▶️ Resume", "resume_schedule")
+	btnCancelSchedule = scheduleMenu.Data("🚫 Cancel", "cancel_schedule")
+)
+
+const (
+	scheduleDateLayout  = "2006-01-02"
+	scheduleTimeLayout  = "2006-01-02 15:04 UTC"
+	scheduleMaxPerUser  = 20
+	scheduleUntilPrefix = "until:"
+	scheduleMaxPrefix   = "max:"
+)
+
+// ScheduledPayment is a recurring payment to a Telegram user or a Lightning address.
+type ScheduledPayment struct {
+	*storage.Base
+	From           *lnbits.User `json:"from"`
+	ToTelegramId   int64        `json:"to_telegram_id"`
+	ToTelegramUser string       `json:"to_telegram_user"`
+	ToAddress      string       `json:"to_address"`
+	Amount         int64        `json:"amount"`
+	Memo           string       `json:"memo"`
+	Spec           string       `json:"spec"`
+	NextRun        time.Time    `json:"next_run"`
+	EndDate        time.Time    `json:"end_date"`
+	MaxCount       int          `json:"max_count"`
+	Count          int          `json:"count"`
+	Paused         bool         `json:"paused"`
+	LastAttempt    time.Time    `json:"last_attempt"`
+	LanguageCode   string       `json:"languagecode"`
+}
+
+func helpScheduleUsage(ctx context.Context, errormsg string) string {
+	if len(errormsg) > 0 {
+		return fmt.Sprintf(Translate(ctx, "scheduleHelpText"), fmt.Sprintf(Translate(ctx, "errorReasonMessage"), str.MarkdownEscape(errormsg)))
+	}
+	return fmt.Sprintf(Translate(ctx, "scheduleHelpText"), "")
+}
+
+// recipientStr returns the recipient as a markdown string.
+func (s *ScheduledPayment) recipientStr() string {
+	if len(s.ToAddress) > 0 {
+		return str.MarkdownEscape(s.ToAddress)
+	}
+	return str.MarkdownEscape("@" + s.ToTelegramUser)
+}
+
+// summary describes the scheduled payment in the language of its creator.
+func (s *ScheduledPayment) summary() string {
+	text := fmt.Sprintf(i18n.Translate(s.LanguageCode, "scheduleSummaryMessage"),
+		s.Amount, s.recipientStr(), str.MarkdownEscape(s.Spec), s.NextRun.UTC().Format(scheduleTimeLayout), s.Count)
+	if s.MaxCount > 0 {
+		text += fmt.Sprintf(i18n.Translate(s.LanguageCode, "scheduleSummaryMaxMessage"), s.MaxCount)
+	}
+	if !s.EndDate.IsZero() {
+		text += fmt.Sprintf(i18n.Translate(s.LanguageCode, "scheduleSummaryUntilMessage"), s.EndDate.UTC().Format(scheduleDateLayout))
+	}
+	if len(s.Memo) > 0 {
+		text += fmt.Sprintf(i18n.Translate(s.LanguageCode, "scheduleSummaryMemoMessage"), str.MarkdownEscape(s.Memo))
+	}
+	if s.Paused {
+		text += i18n.Translate(s.LanguageCode, "schedulePausedMessage")
+	}
+	return text
+}
+
+// finished returns true if the schedule must not run at next anymore.
+func (s *ScheduledPayment) finished(next time.Time) bool {
+	return next.IsZero() ||
+		(s.MaxCount > 0 && s.Count >= s.MaxCount) ||
+		(!s.EndDate.IsZero() && next.After(s.EndDate))
+}
+
+func (bot *TipBot) makeScheduleKeyboard(ctx context.Context, s *ScheduledPayment) *tb.ReplyMarkup {
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	toggleButton := menu.Data(Translate(ctx, "pauseButtonMessage"), "pause_schedule", s.ID)
+	if s.Paused {
+		toggleButton = menu.Data(Translate(ctx, "resumeButtonMessage"), "resume_schedule", s.ID)
+	}
+	cancelButton := menu.Data(Translate(ctx, "cancelButtonMessage"), "cancel_schedule", s.ID)
+	menu.Inline(
+		menu.Row(
+			toggleButton,
+			cancelButton),
+	)
+	return menu
+}
+
+// scheduleHandler invoked on "/schedule <amount> <user|address> <spec> [until:<date>] [max:<count>] [<memo>]"
+func (bot *TipBot) scheduleHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	arguments := strings.Fields(ctx.Message().Text)
+	if len(arguments) < 2 || strings.ToLower(arguments[1]) == "list" {
+		return bot.listSchedulesHandler(ctx)
+	}
+	if len(arguments) < 4 {
+		bot.trySendMessage(ctx.Sender(), helpScheduleUsage(ctx, ""))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(arguments[1])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(ctx.Sender(), helpScheduleUsage(ctx, Translate(ctx, "sendValidAmountMessage")))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	spec, n, err := schedule.ParseFields(arguments[3:])
+	if err != nil {
+		bot.trySendMessage(ctx.Sender(), helpScheduleUsage(ctx, err.Error()))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+
+	id := fmt.Sprintf("schedule:%d:%s", user.Telegram.ID, RandStringRunes(8))
+	scheduledPayment := &ScheduledPayment{
+		Base:         storage.New(storage.ID(id)),
+		From:         user,
+		Amount:       amount,
+		Spec:         spec.String(),
+		NextRun:      spec.Next(time.Now()),
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+
+	// options and memo follow the spec
+	options := arguments[3+n:]
+	for len(options) > 0 {
+		option := strings.ToLower(options[0])
+		if strings.HasPrefix(option, scheduleUntilPrefix) {
+			until, err := time.Parse(scheduleDateLayout, strings.TrimPrefix(option, scheduleUntilPrefix))
+			if err != nil {
+				bot.trySendMessage(ctx.Sender(), helpScheduleUsage(ctx, err.Error()))
+				return ctx, errors.New(errors.InvalidSyntaxError, err)
+			}
+			// the schedule runs until the end of that day
+			scheduledPayment.EndDate = until.Add(24*time.Hour - time.Second)
+		} else if strings.HasPrefix(option, scheduleMaxPrefix) {
+			max, err := strconv.Atoi(strings.TrimPrefix(option, scheduleMaxPrefix))
+			if err != nil || max < 1 {
+				bot.trySendMessage(ctx.Sender(), helpScheduleUsage(ctx, fmt.Sprintf("invalid count %q", options[0])))
+				return ctx, errors.Create(errors.InvalidSyntaxError)
+			}
+			scheduledPayment.MaxCount = max
+		} else {
+			break
+		}
+		options = options[1:]
+	}
+	scheduledPayment.Memo = strings.Join(options, " ")
+	if scheduledPayment.finished(scheduledPayment.NextRun) {
+		bot.trySendMessage(ctx.Sender(), helpScheduleUsage(ctx, "schedule ends before the first payment"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+
+	// recipient is either a lightning address or a user of this bot
+	recipient := arguments[2]
+	if lightning.IsLightningAddress(recipient) {
+		scheduledPayment.ToAddress = recipient
+	} else {
+		toUserStrWithoutAt := strings.TrimPrefix(recipient, "@")
+		toUserDb, err := GetUserByTelegramUsername(toUserStrWithoutAt, *bot)
+		if err != nil {
+			bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape("@"+toUserStrWithoutAt)))
+			return ctx, err
+		}
+		if toUserDb.ID == user.ID {
+			bot.trySendMessage(ctx.Sender(), Translate(ctx, "sendYourselfMessage"))
+			return ctx, errors.Create(errors.SelfPaymentError)
+		}
+		scheduledPayment.ToTelegramId = toUserDb.Telegram.ID
+		scheduledPayment.ToTelegramUser = toUserStrWithoutAt
+	}
+
+	if len(bot.getScheduledPayments(user)) >= scheduleMaxPerUser {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "scheduleTooManyMessage"), scheduleMaxPerUser))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+
+	err = scheduledPayment.Set(scheduledPayment, bot.Bunt)
+	if err != nil {
+		return ctx, err
+	}
+	bot.startScheduledPaymentTimer(scheduledPayment)
+	log.Infof("[schedule] %s scheduled %d sat to %s (%s)", GetUserStr(user.Telegram), amount, recipient, scheduledPayment.Spec)
+	bot.trySendMessage(ctx.Sender(), scheduledPayment.summary(), bot.makeScheduleKeyboard(ctx, scheduledPayment))
+	return ctx, nil
+}
+
+// getScheduledPayments returns all active scheduled payments of user.
+func (bot *TipBot) getScheduledPayments(user *lnbits.User) []*ScheduledPayment {
+	scheduledPayments := make([]*ScheduledPayment, 0)
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("schedule", func(key, value string) bool {
+			s := &ScheduledPayment{}
+			if err := json.Unmarshal([]byte(value), s); err != nil {
+				return true
+			}
+			if s.Active && s.From != nil && s.From.Telegram != nil && s.From.Telegram.ID == user.Telegram.ID {
+				scheduledPayments = append(scheduledPayments, s)
+			}
+			return true
+		})
+	})
+	return scheduledPayments
+}
+
+// listSchedulesHandler sends one message with buttons per scheduled payment of the user.
+func (bot *TipBot) listSchedulesHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	scheduledPayments := bot.getScheduledPayments(user)
+	if len(scheduledPayments) == 0 {
+		bot.trySendMessage(ctx.Sender(), helpScheduleUsage(ctx, Translate(ctx, "scheduleListEmptyMessage")))
+		return ctx, nil
+	}
+	for _, s := range scheduledPayments {
+		bot.trySendMessage(ctx.Sender(), s.summary(), bot.makeScheduleKeyboard(ctx, s))
+	}
+	return ctx, nil
+}
+
+// loadScheduledPaymentFromCallback loads the scheduled payment of a button. Only its creator may press it.
+func (bot *TipBot) loadScheduledPaymentFromCallback(ctx intercept.Context) (*ScheduledPayment, error) {
+	tx := &ScheduledPayment{Base: storage.New(storage.ID(ctx.Data()))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	s := sn.(*ScheduledPayment)
+	if s.From.Telegram.ID != ctx.Callback().Sender.ID {
+		return nil, errors.Create(errors.UnknownError)
+	}
+	if !s.Active {
+		bot.tryEditMessage(ctx.Callback().Message, Translate(ctx, "scheduleNotActiveMessage"), &tb.ReplyMarkup{})
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return s, nil
+}
+
+// pauseScheduleHandler stops the timer of a scheduled payment.
+func (bot *TipBot) pauseScheduleHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	s, err := bot.loadScheduledPaymentFromCallback(ctx)
+	if err != nil {
+		log.Errorf("[pauseScheduleHandler] %v", err)
+		return ctx, err
+	}
+	stopScheduledPaymentTimer(s.ID)
+	s.Paused = true
+	err = s.Set(s, bot.Bunt)
+	if err != nil {
+		return ctx, err
+	}
+	bot.tryEditMessage(ctx.Callback().Message, s.summary(), bot.makeScheduleKeyboard(ctx, s))
+	return ctx, nil
+}
+
+// resumeScheduleHandler restarts the timer of a paused scheduled payment. Runs missed
+// during the pause are skipped.
+func (bot *TipBot) resumeScheduleHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	s, err := bot.loadScheduledPaymentFromCallback(ctx)
+	if err != nil {
+		log.Errorf("[resumeScheduleHandler] %v", err)
+		return ctx, err
+	}
+	spec, err := schedule.Parse(s.Spec)
+	if err != nil {
+		return ctx, err
+	}
+	s.Paused = false
+	if s.NextRun.Before(time.Now()) {
+		s.NextRun = nextScheduledRun(spec, s.NextRun)
+	}
+	if s.finished(s.NextRun) {
+		bot.finishScheduledPayment(s)
+		bot.tryEditMessage(ctx.Callback().Message, s.summary(), &tb.ReplyMarkup{})
+		return ctx, nil
+	}
+	err = s.Set(s, bot.Bunt)
+	if err != nil {
+		return ctx, err
+	}
+	bot.startScheduledPaymentTimer(s)
+	bot.tryEditMessage(ctx.Callback().Message, s.summary(), bot.makeScheduleKeyboard(ctx, s))
+	return ctx, nil
+}
+
+// cancelScheduleHandler stops a scheduled payment for good.
+func (bot *TipBot) cancelScheduleHandler(ctx intercept.Context) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, ctx.Data())
+	defer mutex.UnlockWithContext(ctx, ctx.Data())
+	s, err := bot.loadScheduledPaymentFromCallback(ctx)
+	if err != nil {
+		log.Errorf("[cancelScheduleHandler] %v", err)
+		return ctx, err
+	}
+	stopScheduledPaymentTimer(s.ID)
+	s.Canceled = true
+	err = s.Inactivate(s, bot.Bunt)
+	if err != nil {
+		return ctx, err
+	}
+	log.Infof("[schedule] %s canceled %s", GetUserStr(ctx.Callback().Sender), s.ID)
+	bot.tryEditMessage(ctx.Callback().Message, Translate(ctx, "scheduleCanceledMessage"), &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// nextScheduledRun returns the first run of spec after the last run that is not in the past.
+// Runs that were missed, e.g. while the bot was offline, are skipped.
+func nextScheduledRun(spec schedule.Spec, last time.Time) time.Time {
+	now := time.Now()
+	next := spec.Next(last)
+	for !next.IsZero() && next.Before(now) {
+		next = spec.Next(next)
+	}
+	return next
+}
+
+// startScheduledPaymentTimer runs the scheduled payment at its next run. Overdue
+// payments run immediately.
+func (bot *TipBot) startScheduledPaymentTimer(s *ScheduledPayment) {
+	id := s.ID
+	t := runtime.NewResettableFunction(id,
+		runtime.WithTimer(time.NewTimer(time.Until(s.NextRun))))
+	t.Do(func() {
+		bot.runScheduledPayment(id)
+	})
+}
+
+func stopScheduledPaymentTimer(id string) {
+	if t, ok := runtime.Get(id); ok {
+		t.StopChan <- struct{}{}
+		runtime.RemoveTicker(id)
+	}
+}
+
+// runScheduledPayment pays the current run of a scheduled payment and sets the timer for the next one.
+func (bot *TipBot) runScheduledPayment(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	tx := &ScheduledPayment{Base: storage.New(storage.ID(id))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[runScheduledPayment] %v", err)
+		return
+	}
+	s := sn.(*ScheduledPayment)
+	if !s.Active || s.Paused {
+		return
+	}
+	spec, err := schedule.Parse(s.Spec)
+	if err != nil {
+		log.Errorf("[runScheduledPayment] %s: %v", s.ID, err)
+		return
+	}
+
+	err = bot.payScheduledPayment(s)
+	if err != nil {
+		log.Warnf("[runScheduledPayment] %s: %v", s.ID, err)
+		bot.trySendMessage(s.From.Telegram, fmt.Sprintf(i18n.Translate(s.LanguageCode, "scheduleFailedMessage"), s.Amount, s.recipientStr(), str.MarkdownEscape(err.Error())))
+	} else {
+		s.Count++
+		log.Infof("[schedule] %s paid %d sat to %s (%d)", s.ID, s.Amount, s.recipientStr(), s.Count)
+		bot.trySendMessage(s.From.Telegram, fmt.Sprintf(i18n.Translate(s.LanguageCode, "scheduleExecutedMessage"), s.Amount, s.recipientStr()))
+	}
+
+	s.NextRun = nextScheduledRun(spec, s.NextRun)
+	if s.finished(s.NextRun) {
+		bot.finishScheduledPayment(s)
+		return
+	}
+	err = s.Set(s, bot.Bunt)
+	if err != nil {
+		log.Errorf("[runScheduledPayment] %v", err)
+		return
+	}
+	bot.startScheduledPaymentTimer(s)
+}
+
+// payScheduledPayment pays a single run of a scheduled payment.
+func (bot *TipBot) payScheduledPayment(s *ScheduledPayment) error {
+	// reload the user, the wallet might have changed since the payment was scheduled
+	from, err := GetLnbitsUser(s.From.Telegram, *bot)
+	if err != nil {
+		return err
+	}
+	if from.Wallet == nil {
+		return errors.Create(errors.UserNoWalletError)
+	}
+
+	if len(s.ToAddress) > 0 {
+		// external payments can't be deduplicated by the wallet backend. We remember
+		// the run before paying, so a crash never pays the same run twice.
+		if s.LastAttempt.Equal(s.NextRun) {
+			return fmt.Errorf("payment was interrupted, skipping this run")
+		}
+		s.LastAttempt = s.NextRun
+		err = s.Set(s, bot.Bunt)
+		if err != nil {
+			return err
+		}
+		release := lockWallets(from)
+		defer release()
+		balance, err := bot.GetUserBalance(from)
+		if err != nil {
+			return err
+		}
+		if balance < s.Amount {
+			return fmt.Errorf("balance too low")
+		}
+		_, err = bot.payLightningAddress(from, s.ToAddress, s.Amount, s.Memo)
+		return err
+	}
+
+	to, err := GetLnbitsUser(&tb.User{ID: s.ToTelegramId, Username: s.ToTelegramUser}, *bot)
+	if err != nil {
+		return err
+	}
+	t := NewTransaction(bot, from, to, s.Amount,
+		TransactionType("schedule"),
+		TransactionIdempotencyKey(fmt.Sprintf("%s:%d", s.ID, s.NextRun.Unix())))
+	t.Memo = fmt.Sprintf("🗓 Scheduled payment from %s to %s.", GetUserStr(from.Telegram), GetUserStr(to.Telegram))
+	success, err := t.Send()
+	if err != nil {
+		return err
+	}
+	if !success {
+		return fmt.Errorf("transaction failed")
+	}
+	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), GetUserStrMd(from.Telegram), s.Amount))
+	if len(s.Memo) > 0 {
+		bot.trySendMessage(to.Telegram, fmt.Sprintf("✉️ %s", str.MarkdownEscape(s.Memo)))
+	}
+	return nil
+}
+
+// finishScheduledPayment inactivates a scheduled payment that reached its end date or maximum count.
+func (bot *TipBot) finishScheduledPayment(s *ScheduledPayment) {
+	err := s.Inactivate(s, bot.Bunt)
+	if err != nil {
+		log.Errorf("[finishScheduledPayment] %v", err)
+	}
+	log.Infof("[schedule] %s finished after %d payments", s.ID, s.Count)
+	bot.trySendMessage(s.From.Telegram, fmt.Sprintf(i18n.Translate(s.LanguageCode, "scheduleFinishedMessage"), s.Amount, s.recipientStr(), s.Count))
+}
+
+// restartPersistedSchedules kicks off the timers of all active scheduled payments
+func (bot *TipBot) restartPersistedSchedules() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		err := tx.Ascend("schedule", func(key, value string) bool {
+			s := &ScheduledPayment{}
+			err := json.Unmarshal([]byte(value), s)
+			if err != nil {
+				return true
+			}
+			if s.Active && !s.Paused {
+				bot.startScheduledPaymentTimer(s)
+			}
+			return true // continue iteration
+		})
+		return err
+	})
+}
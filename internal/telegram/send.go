@@ -319,7 +319,7 @@ func (bot *TipBot) confirmSendHandler(ctx intercept.Context) (intercept.Context,
 	fromUserStr := GetUserStr(from.Telegram)
 
 	transactionMemo := fmt.Sprintf("💸 Send from %s to %s.", fromUserStr, toUserStr)
-	t := NewTransaction(bot, from, to, amount, TransactionType("send"))
+	t := NewTransaction(bot, from, to, amount, TransactionType("send"), TransactionIdempotencyKey(sendData.ID))
 	t.Memo = transactionMemo
 
 	success, err := t.Send()
@@ -2,6 +2,7 @@ package telegram
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
@@ -9,7 +10,10 @@ import (
 )
 
 var (
-	settingsHelpMessage = "📖 Change user settings\n\n`/set unit <BTC|USD|EUR|GBP>` 💶 Change your default currency."
+	settingsHelpMessage = "📖 Change user settings\n\n`/set unit <BTC|USD|EUR|GBP>` 💶 Change your default currency.\n" +
+		"`/set limit <tx|hour|day|confirm> <amount|off>` 🛡 Limit your payments per transaction, rolling hour or day, or ask for approval above an amount.\n" +
+		"`/set limit cooldown <minutes|off>` ⏳ Wait after approving a payment before it can be made.\n" +
+		"Raising or removing a limit takes effect after 24 hours or your cooldown, whichever is longer."
 )
 
 func (bot *TipBot) settingHandler(ctx intercept.Context) (intercept.Context, error) {
@@ -21,6 +25,8 @@ func (bot *TipBot) settingHandler(ctx intercept.Context) (intercept.Context, err
 		switch strings.ToLower(splits[1]) {
 		case "unit":
 			return bot.addFiatCurrency(ctx)
+		case "limit", "limits":
+			return bot.setLimitHandler(ctx)
 		case "help":
 			return bot.nostrHelpHandler(ctx)
 		}
@@ -66,3 +72,83 @@ func (bot *TipBot) addFiatCurrency(ctx intercept.Context) (intercept.Context, er
 	bot.trySendMessage(ctx.Message().Sender, "✅ Your default currency has been updated.")
 	return ctx, nil
 }
+
+func limitStr(amount int64, unit string) string {
+	if amount == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d %s", amount, unit)
+}
+
+// setLimitHandler invoked on "/set limit <tx|hour|day|confirm|cooldown> <value|off>"
+func (bot *TipBot) setLimitHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	if bot.applyLimitChanges(user) {
+		if err := UpdateUserRecord(user, *bot); err != nil {
+			return ctx, err
+		}
+	}
+	limits := &user.Settings.Limits
+
+	splits := strings.Fields(m.Text)
+	if len(splits) < 4 {
+		// display users current limits
+		message := fmt.Sprintf("🛡 Your spending limits:\n\nPer transaction: `%s`\nPer hour: `%s`\nPer day: `%s`\nApproval above: `%s`\nCooldown after approval: `%s`",
+			limitStr(limits.PerTransaction, "sat"), limitStr(limits.PerHour, "sat"), limitStr(limits.PerDay, "sat"),
+			limitStr(limits.ConfirmAbove, "sat"), limitStr(limits.Cooldown, "min"))
+		for _, change := range bot.pendingLimitChanges(user) {
+			unit := "sat"
+			if change.Limit == "cooldown" {
+				unit = "min"
+			}
+			message += fmt.Sprintf("\n\n⏳ `%s` changes to `%s` at %s.", change.Limit, limitStr(change.Value, unit), change.ApplyAt.UTC().Format(scheduleTimeLayout))
+		}
+		bot.trySendMessage(m.Sender, message)
+		return ctx, nil
+	}
+	var value int64
+	if strings.ToLower(splits[3]) != "off" {
+		if strings.ToLower(splits[2]) == "cooldown" {
+			value, err = strconv.ParseInt(splits[3], 10, 64)
+		} else {
+			value, err = GetAmount(splits[3])
+		}
+		if err != nil || value <= 0 {
+			bot.trySendMessage(m.Sender, "🚫 Invalid value. Please enter a positive number or `off`.")
+			return ctx, fmt.Errorf("invalid limit")
+		}
+	}
+	name := strings.ToLower(splits[2])
+	if name == "transaction" {
+		name = "tx"
+	}
+	field := limitField(limits, name)
+	if field == nil {
+		bot.trySendMessage(m.Sender, "🚫 Invalid limit. Please use one of the following: `tx`, `hour`, `day`, `confirm`, `cooldown`")
+		return ctx, fmt.Errorf("invalid limit")
+	}
+	if loosensLimit(name, *field, value) {
+		// someone with access to the account must not be able to lift the limits right away
+		change, err := bot.scheduleLimitChange(user, name, value)
+		if err != nil {
+			return ctx, err
+		}
+		log.Infof("[setLimitHandler] %s will change limit %s to %d at %s", GetUserStr(user.Telegram), name, value, change.ApplyAt)
+		bot.trySendMessage(m.Sender, fmt.Sprintf("⏳ Your limit will change at %s. Set a stricter value to cancel.", change.ApplyAt.UTC().Format(scheduleTimeLayout)))
+		return ctx, nil
+	}
+	*field = value
+	bot.cancelLimitChange(user, name)
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[setLimitHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	log.Infof("[setLimitHandler] %s set limit %s to %d", GetUserStr(user.Telegram), splits[2], value)
+	bot.trySendMessage(m.Sender, "✅ Your spending limits have been updated.")
+	return ctx, nil
+}
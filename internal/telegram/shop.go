@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	"github.com/LightningTipBot/LightningTipBot/internal/webhooks"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/errors"
 
@@ -40,6 +41,7 @@ type ShopItem struct {
 	Title        string       `json:"title"`       // Title of the item
 	Description  string       `json:"description"` // Description of the item
 	Price        int64        `json:"price"`       // price of the item
+	FiatPrice    *FiatAmount  `json:"fiatPrice"`   // price of the item if it was set in fiat
 	NSold        int          `json:"nSold"`       // number of times item was sold
 	TbPhoto      *tb.Photo    `json:"tbPhoto"`     // Telegram photo object
 	LanguageCode string       `json:"languagecode"`
@@ -163,10 +165,11 @@ func (bot *TipBot) enterShopItemPriceHandler(ctx intercept.Context) (intercept.C
 	}
 
 	var amount int64
+	var fiat *FiatAmount
 	if m.Text == "0" {
 		amount = 0
 	} else {
-		amount, err = GetAmount(m.Text)
+		amount, fiat, err = GetFiatAmount(m.Text)
 		if err != nil {
 			log.Warnf("[enterShopItemPriceHandler] %s", err.Error())
 			bot.trySendMessage(m.Sender, Translate(ctx, "lnurlInvalidAmountMessage"))
@@ -176,6 +179,7 @@ func (bot *TipBot) enterShopItemPriceHandler(ctx intercept.Context) (intercept.C
 	}
 
 	item.Price = amount
+	item.FiatPrice = fiat
 	shop.Items[item.ID] = item
 	runtime.IgnoreError(shop.Set(shop, bot.ShopBunt))
 	bot.tryDeleteMessage(m)
@@ -418,7 +422,7 @@ func (bot *TipBot) getItemTitle(ctx context.Context, item *ShopItem) string {
 		caption += fmt.Sprintf("(%d Files)", len(item.FileIDs))
 	}
 	if item.Price > 0 {
-		caption += fmt.Sprintf("\n\n💸 Price: %d sat", item.Price)
+		caption += fmt.Sprintf("\n\n💸 Price: %s", item.priceStr())
 	}
 	// item.TbPhoto.Caption = caption
 	return caption
@@ -802,10 +806,17 @@ func (bot *TipBot) shopGetItemFilesHandler(ctx intercept.Context) (intercept.Con
 	if item.Price <= 0 {
 		bot.shopSendItemFilesToUser(ctx, user, itemID)
 	} else {
+		// lock the price until the user confirms the purchase
+		quote, err := bot.lockShopItemQuote(user, &item)
+		if err != nil {
+			log.Warnf("[shopGetItemFilesHandler] %s", err.Error())
+			ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "shopPriceUnavailableMessage"))
+			return ctx, err
+		}
 		if item.TbPhoto != nil {
 			item.TbPhoto.Caption = bot.getItemTitle(ctx, &item)
 		}
-		bot.tryEditMessage(shopView.Message, item.TbPhoto, bot.shopItemConfirmBuyMenu(ctx, shop, &item))
+		bot.tryEditMessage(shopView.Message, item.TbPhoto, bot.shopItemConfirmBuyMenu(ctx, shop, &item, quote))
 	}
 
 	// // send the cover image
@@ -849,13 +860,21 @@ func (bot *TipBot) shopConfirmBuyHandler(ctx intercept.Context) (intercept.Conte
 	// fromUserStrMd := GetUserStrMd(from.Telegram)
 	toUserStr := GetUserStr(to.Telegram)
 	toUserStrMd := GetUserStrMd(to.Telegram)
-	amount := item.Price
-	if amount <= 0 {
+	if item.Price <= 0 {
 		log.Errorf("[shopConfirmBuyHandler] item has no price.")
 		return ctx, errors.Create(errors.InvalidAmountError)
 	}
+	// pay the price the user has seen on the confirmation button
+	quote, err := bot.getShopItemQuote(user, &item)
+	if err != nil {
+		log.Warnf("[shopConfirmBuyHandler] %s", err.Error())
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "shopPriceExpiredMessage"))
+		return ctx, err
+	}
+	bot.Cache.Delete(shopItemQuoteKey(user, &item))
+	amount := quote.Amount
 	transactionMemo := fmt.Sprintf("🛍 Shop from %s.", toUserStr)
-	t := NewTransaction(bot, from, to, amount, TransactionType("shop"))
+	t := NewTransaction(bot, from, to, amount, TransactionType("shop"), TransactionFiat(quote.Fiat))
 	t.Memo = transactionMemo
 
 	success, err := t.Send()
@@ -873,9 +892,17 @@ func (bot *TipBot) shopConfirmBuyHandler(ctx intercept.Context) (intercept.Conte
 		shopItemTitle = fmt.Sprintf("%s", item.Title)
 	}
 	ctx.Context = context.WithValue(ctx, "callback_response", "🛍 Purchase successful.")
-	bot.trySendMessage(to.Telegram, fmt.Sprintf("🛍 Someone bought `%s` from your shop `%s` for `%d sat`.", str.MarkdownEscape(shopItemTitle), str.MarkdownEscape(shop.Title), amount))
-	bot.trySendMessage(from.Telegram, fmt.Sprintf("🛍 You bought `%s` from %s's shop `%s` for `%d sat`.", str.MarkdownEscape(shopItemTitle), toUserStrMd, str.MarkdownEscape(shop.Title), amount))
+	bot.trySendMessage(to.Telegram, fmt.Sprintf("🛍 Someone bought `%s` from your shop `%s` for `%s`.", str.MarkdownEscape(shopItemTitle), str.MarkdownEscape(shop.Title), quote.String()))
+	bot.trySendMessage(from.Telegram, fmt.Sprintf("🛍 You bought `%s` from %s's shop `%s` for `%s`.", str.MarkdownEscape(shopItemTitle), toUserStrMd, str.MarkdownEscape(shop.Title), quote.String()))
 	log.Infof("[🛍 shop] %s bought from %s shop: %s item: %s  for %d sat.", toUserStr, GetUserStr(to.Telegram), shop.Title, shopItemTitle, amount)
+	webhooks.Publish(to.Name, webhooks.EventShopSale, map[string]interface{}{
+		"shop_id": shop.ID,
+		"shop":    shop.Title,
+		"item_id": itemID,
+		"item":    shopItemTitle,
+		"amount":  amount,
+		"fiat":    quote.Fiat,
+	})
 	bot.shopSendItemFilesToUser(ctx, user, itemID)
 	return ctx, nil
 }
@@ -84,8 +84,8 @@ func (bot TipBot) shopItemSettingsMenu(ctx intercept.Context, shop *Shop, item *
 }
 
 // shopItemConfirmBuyMenu builds the buttons to confirm a purchase
-func (bot TipBot) shopItemConfirmBuyMenu(ctx intercept.Context, shop *Shop, item *ShopItem) *tb.ReplyMarkup {
-	shopItemBuyButton = shopKeyboard.Data(fmt.Sprintf("💸 Pay %d sat", item.Price), "shop_itembuy", item.ID)
+func (bot TipBot) shopItemConfirmBuyMenu(ctx intercept.Context, shop *Shop, item *ShopItem, quote *ShopItemQuote) *tb.ReplyMarkup {
+	shopItemBuyButton = shopKeyboard.Data(fmt.Sprintf("💸 Pay %s", quote.String()), "shop_itembuy", item.ID)
 	shopItemCancelBuyButton = shopKeyboard.Data("⬅️ Back", "shop_itemcancelbuy", item.ID)
 	buttons := []tb.Row{}
 	buttons = append(buttons, shopKeyboard.Row(shopItemBuyButton))
@@ -111,7 +111,7 @@ func (bot TipBot) shopMenu(ctx intercept.Context, shop *Shop, item *ShopItem) *t
 	shopPrevitemButton = shopKeyboard.Data("<", "shop_previtem", shop.ID)
 	buyButtonText := "📩 Get"
 	if item.Price > 0 {
-		buyButtonText = fmt.Sprintf("Buy (%d sat)", item.Price)
+		buyButtonText = fmt.Sprintf("Buy (%s)", item.priceStr())
 	}
 	shopBuyitemButton = shopKeyboard.Data(buyButtonText, "shop_buyitem", item.ID)
 
@@ -147,6 +147,58 @@ func (bot *TipBot) makseShopSelectionButtons(shops []*Shop, uniqueString string)
 	return buttons
 }
 
+// -------------- ShopItemQuote --------------
+
+// ShopItemQuote is the price of an item at a locked rate. It is shown on the
+// confirmation button and charged when the user confirms.
+type ShopItemQuote struct {
+	Amount int64       `json:"amount"`
+	Fiat   *FiatAmount `json:"fiat"`
+}
+
+func (q ShopItemQuote) String() string {
+	if q.Fiat != nil {
+		return fmt.Sprintf("%d sat (%s)", q.Amount, q.Fiat.String())
+	}
+	return fmt.Sprintf("%d sat", q.Amount)
+}
+
+// priceStr returns the price in the currency the owner has set it in.
+func (item *ShopItem) priceStr() string {
+	if item.FiatPrice != nil {
+		return item.FiatPrice.String()
+	}
+	return fmt.Sprintf("%d sat", item.Price)
+}
+
+func shopItemQuoteKey(user *lnbits.User, item *ShopItem) string {
+	return fmt.Sprintf("shop-quote-%d-%s", user.Telegram.ID, item.ID)
+}
+
+// lockShopItemQuote converts the price of item at the current rate and keeps it for user.
+func (bot *TipBot) lockShopItemQuote(user *lnbits.User, item *ShopItem) (*ShopItemQuote, error) {
+	quote := &ShopItemQuote{Amount: item.Price}
+	if item.FiatPrice != nil {
+		amount, fiat, err := lockFiatAmount(item.FiatPrice.Currency, item.FiatPrice.Amount)
+		if err != nil {
+			return nil, err
+		}
+		quote = &ShopItemQuote{Amount: amount, Fiat: fiat}
+	}
+	err := bot.Cache.Set(shopItemQuoteKey(user, item), *quote, &store.Options{Expiration: 5 * time.Minute})
+	return quote, err
+}
+
+// getShopItemQuote returns the price that was locked for user.
+func (bot *TipBot) getShopItemQuote(user *lnbits.User, item *ShopItem) (*ShopItemQuote, error) {
+	q, err := bot.Cache.Get(shopItemQuoteKey(user, item))
+	if err != nil {
+		return nil, fmt.Errorf("price quote expired")
+	}
+	quote := q.(ShopItemQuote)
+	return &quote, nil
+}
+
 // -------------- ShopView --------------
 
 // getUserShopview returns ShopView object from cache that holds information about the user's current browsing view
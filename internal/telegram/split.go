@@ -0,0 +1,312 @@
+package telegram
+
+import (
+	stderrors "errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/errors"
+	"github.com/LightningTipBot/LightningTipBot/internal/i18n"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxSplitRecipients = 20
+	maxSplitWeight     = 1000
+)
+
+var (
+	splitHelpMessage      = "📖 *Split a tip:* `/split <amount> @user1 @user2 ... [<memo>]`\nThe amount is divided evenly. Add a weight to give someone a bigger share: `/split 1000 @alice:2 @bob`"
+	splitAmountMessage    = "🚫 %d sat can't be split between %d users."
+	splitTooManyMessage   = "🚫 You can split a tip between at most %d users."
+	splitDuplicateMessage = "🚫 %s is listed twice."
+	splitBalanceMessage   = "🚫 Your balance is too low to split %d sat."
+	splitFailedMessage    = "🚫 The split tip failed. No sats were sent."
+	splitSummaryMessage   = "🏅 %s split %d sat between %s."
+	splitReceivedMessage  = "🏅 %s has tipped you %d sat (split tip)."
+)
+
+// splitLeg is the share of one recipient of a split tip.
+type splitLeg struct {
+	Name   string
+	Weight int64
+	User   *lnbits.User
+	Amount int64
+}
+
+// parseSplitRecipients parses @user or @user:weight arguments. It returns the
+// recipients and the number of arguments used, the rest is the memo.
+func parseSplitRecipients(args []string) ([]*splitLeg, int, error) {
+	var legs []*splitLeg
+	seen := make(map[string]bool)
+	n := 0
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			break
+		}
+		name, weightStr, hasWeight := strings.Cut(strings.TrimPrefix(arg, "@"), ":")
+		weight := int64(1)
+		if hasWeight {
+			w, err := strconv.ParseInt(weightStr, 10, 64)
+			if err != nil || w < 1 || w > maxSplitWeight {
+				return nil, 0, fmt.Errorf("invalid weight %s", weightStr)
+			}
+			weight = w
+		}
+		if len(name) == 0 {
+			return nil, 0, fmt.Errorf("invalid recipient %s", arg)
+		}
+		if seen[strings.ToLower(name)] {
+			return nil, 0, fmt.Errorf(splitDuplicateMessage, str.MarkdownEscape("@"+name))
+		}
+		seen[strings.ToLower(name)] = true
+		legs = append(legs, &splitLeg{Name: name, Weight: weight})
+		n++
+	}
+	return legs, n, nil
+}
+
+// splitAmount divides amount by weights. The remainder is handed out one sat
+// at a time in order, so that the shares always add up to amount.
+func splitAmount(amount int64, weights []int64) ([]int64, error) {
+	var total int64
+	for _, w := range weights {
+		total += w
+	}
+	if len(weights) == 0 || amount < int64(len(weights)) {
+		return nil, fmt.Errorf(splitAmountMessage, amount, len(weights))
+	}
+	shares := make([]int64, len(weights))
+	var sum int64
+	for i, w := range weights {
+		shares[i] = amount * w / total
+		sum += shares[i]
+	}
+	for i := 0; sum < amount; i = (i + 1) % len(shares) {
+		shares[i]++
+		sum++
+	}
+	for _, s := range shares {
+		if s < 1 {
+			return nil, fmt.Errorf(splitAmountMessage, amount, len(weights))
+		}
+	}
+	return shares, nil
+}
+
+// splitHandler invoked on "/split 100 @a @b @c [memo]" and "/tip 100 @a @b"
+func (bot *TipBot) splitHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	from := LoadUser(ctx)
+	if from.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	args := strings.Fields(m.Text)
+	if len(args) < 3 {
+		bot.trySendMessage(m.Sender, splitHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	amount, err := GetAmount(args[1])
+	if err != nil || amount < 1 {
+		NewMessage(m, WithDuration(0, bot))
+		bot.trySendMessage(m.Sender, splitHelpMessage)
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	legs, n, err := parseSplitRecipients(args[2:])
+	if err != nil || len(legs) == 0 {
+		NewMessage(m, WithDuration(0, bot))
+		if err != nil {
+			bot.trySendMessage(m.Sender, err.Error())
+		}
+		bot.trySendMessage(m.Sender, splitHelpMessage)
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if len(legs) > maxSplitRecipients {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(splitTooManyMessage, maxSplitRecipients))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	memo := strings.Join(args[2+n:], " ")
+	memo = str.Truncate(memo, 200)
+
+	weights := make([]int64, len(legs))
+	for i, leg := range legs {
+		weights[i] = leg.Weight
+	}
+	shares, err := splitAmount(amount, weights)
+	if err != nil {
+		bot.trySendMessage(m.Sender, err.Error())
+		return ctx, err
+	}
+	for i, leg := range legs {
+		leg.Amount = shares[i]
+		leg.User, err = GetUserByTelegramUsername(leg.Name, *bot)
+		if err != nil {
+			NewMessage(m, WithDuration(0, bot))
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape("@"+leg.Name)))
+			return ctx, err
+		}
+		if leg.User.ID == from.ID {
+			bot.trySendMessage(m.Sender, Translate(ctx, "tipYourselfMessage"))
+			return ctx, errors.Create(errors.SelfPaymentError)
+		}
+	}
+
+	batch := fmt.Sprintf("split:%d:%d", m.Chat.ID, m.ID)
+	if err := bot.sendSplit(from, legs, batch, m.Chat.ID, m.Chat.Title); err != nil {
+		log.Warnf("[/split] Split of %d sat by %s failed: %v", amount, GetUserStr(from.Telegram), err)
+		NewMessage(m, WithDuration(0, bot))
+		if err == errSplitBalance {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(splitBalanceMessage, amount))
+		} else {
+			bot.trySendMessage(m.Sender, splitFailedMessage)
+		}
+		return ctx, err
+	}
+	log.Infof("[💸 split] %s split %d sat between %d users (%s).", GetUserStr(from.Telegram), amount, len(legs), batch)
+
+	fromUserStrMd := GetUserStrMd(from.Telegram)
+	recipients := make([]string, len(legs))
+	for i, leg := range legs {
+		recipients[i] = fmt.Sprintf("%s (%d sat)", GetUserStrMd(leg.User.Telegram), leg.Amount)
+		bot.trySendMessage(leg.User.Telegram, fmt.Sprintf(splitReceivedMessage, fromUserStrMd, leg.Amount))
+		if len(memo) > 0 {
+			bot.trySendMessage(leg.User.Telegram, fmt.Sprintf("✉️ %s", str.MarkdownEscape(memo)))
+		}
+	}
+	summary := fmt.Sprintf(splitSummaryMessage, fromUserStrMd, amount, strings.Join(recipients, ", "))
+	if len(memo) > 0 {
+		summary += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(memo))
+	}
+	bot.trySendMessage(m.Chat, summary)
+	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "tipSentMessage"), amount, strings.Join(recipients, ", ")))
+	NewMessage(m, WithDuration(time.Second*time.Duration(internal.Configuration.Telegram.MessageDisposeDuration), bot))
+	return ctx, nil
+}
+
+var errSplitBalance = fmt.Errorf("balance too low")
+
+// splitEscrowTypes are the transactions between the sender of a split and
+// the bot wallet. They are hidden from /transactions, which lists the legs.
+var splitEscrowTypes = []string{"split escrow", "split escrow refund"}
+
+// sendSplit pays all legs or none. The total is moved to the bot wallet first,
+// so that spending limits and approvals apply once to the whole split. The
+// legs are paid out from there while the wallets of the sender and of all
+// recipients stay locked. If a leg fails, the paid legs are booked back to the
+// bot wallet and the total is refunded to the sender.
+func (bot *TipBot) sendSplit(from *lnbits.User, legs []*splitLeg, batch string, chatID int64, chatName string) error {
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return err
+	}
+	users := []*lnbits.User{from, me}
+	for _, leg := range legs {
+		users = append(users, leg.User)
+	}
+	unlock := bot.lockSplitWallets(users)
+	defer unlock()
+
+	var total int64
+	for _, leg := range legs {
+		total += leg.Amount
+	}
+	balance, err := bot.GetUserBalance(from)
+	if err != nil {
+		return err
+	}
+	if balance < total {
+		return errSplitBalance
+	}
+
+	fromUserStr := GetUserStr(from.Telegram)
+	t := NewTransaction(bot, from, me, total, TransactionType("split escrow"), TransactionBatch(batch),
+		TransactionIdempotencyKey(batch+":escrow"))
+	t.ChatID, t.ChatName = chatID, chatName
+	t.Memo = fmt.Sprintf("🏅 Split tip from %s to %d users.", fromUserStr, len(legs))
+	if success, err := t.send(); !success {
+		if err == nil {
+			err = fmt.Errorf("split of %d sat failed", total)
+		}
+		return err
+	}
+
+	unguarded := bot.withoutSpendingLimits()
+	var paid []*Transaction
+	for i, leg := range legs {
+		t := NewTransaction(unguarded, me, leg.User, leg.Amount, TransactionType("split"), TransactionBatch(batch),
+			TransactionIdempotencyKey(fmt.Sprintf("%s:%d", batch, i)))
+		t.ChatID, t.ChatName = chatID, chatName
+		// the legs are listed as tips of the sender, not of the bot wallet
+		t.FromId, t.FromUser = from.Telegram.ID, fromUserStr
+		t.Memo = fmt.Sprintf("🏅 Split tip from %s to %s.", fromUserStr, GetUserStr(leg.User.Telegram))
+		success, err := t.send()
+		if success {
+			paid = append(paid, t)
+			continue
+		}
+		if err == nil {
+			err = fmt.Errorf("leg %d failed", i)
+		}
+		refund := total
+		if stderrors.Is(err, lnbits.ErrPaymentPending) {
+			// the leg may still go through, its share stays in the bot wallet
+			log.Errorf("[sendSplit] Leg %s of %d sat is pending, keeping it in the bot wallet", t.IdempotencyKey, t.Amount)
+			refund -= leg.Amount
+		}
+		bot.revertSplit(me, from, paid, refund, batch)
+		return err
+	}
+	return nil
+}
+
+// revertSplit books the paid legs of a failed split back to the bot wallet and
+// refunds the sender from there. Like the legs, the refunds of the legs are
+// listed between the recipient and the sender.
+func (bot *TipBot) revertSplit(me, from *lnbits.User, paid []*Transaction, refund int64, batch string) {
+	unguarded := bot.withoutSpendingLimits()
+	for _, t := range paid {
+		r := NewTransaction(unguarded, t.To, me, t.Amount, TransactionType("split refund"), TransactionBatch(batch),
+			TransactionIdempotencyKey("refund:"+t.IdempotencyKey))
+		r.ChatID, r.ChatName = t.ChatID, t.ChatName
+		r.ToId, r.ToUser = from.Telegram.ID, GetUserStr(from.Telegram)
+		r.Memo = fmt.Sprintf("↩️ Refund of split tip to %s.", r.FromUser)
+		if success, err := r.send(); !success {
+			log.Errorf("[sendSplit] Could not take back leg %s of %d sat: %v", t.IdempotencyKey, t.Amount, err)
+			refund -= t.Amount
+		}
+	}
+	if refund < 1 {
+		return
+	}
+	r := NewTransaction(unguarded, me, from, refund, TransactionType("split escrow refund"), TransactionBatch(batch),
+		TransactionIdempotencyKey(batch+":refund"))
+	r.Memo = fmt.Sprintf("↩️ Refund of split tip from %s.", GetUserStr(from.Telegram))
+	if success, err := r.send(); !success {
+		log.Errorf("[sendSplit] Could not refund %d sat of split %s: %v", refund, batch, err)
+	}
+}
+
+// lockSplitWallets locks the wallets of all users. If a wallet was rotated
+// while waiting for the lock, the user is pointed to the new wallet and all
+// wallets are locked again.
+func (bot *TipBot) lockSplitWallets(users []*lnbits.User) func() {
+	for {
+		unlock := lockWallets(users...)
+		replaced := false
+		for _, u := range users {
+			if bot.walletReplaced(u) {
+				replaced = true
+			}
+		}
+		if !replaced {
+			return unlock
+		}
+		unlock()
+	}
+}
@@ -0,0 +1,76 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+)
+
+func TestTipBot_sendSplit(t *testing.T) {
+	fake := lnbits.NewFakeBackend()
+	bot := newTestBot(t, fake)
+	me := newTestUser(t, bot, fake, 1, 0)
+	alice := newTestUser(t, bot, fake, 2, 300)
+	bob := newTestUser(t, bot, fake, 3, 0)
+	carol := newTestUser(t, bot, fake, 4, 0)
+	// every leg is within the limit, the total is not
+	if err := bot.DB.Users.Create(&lnbits.Settings{ID: alice.ID, Limits: lnbits.LimitSettings{PerTransaction: 100}}).Error; err != nil {
+		t.Fatal(err)
+	}
+	bot.Client = newSpendingGuard(fake, *bot)
+	legs := func(amounts ...int64) []*splitLeg {
+		var legs []*splitLeg
+		for i, u := range []*lnbits.User{bob, carol}[:len(amounts)] {
+			legs = append(legs, &splitLeg{Name: u.Name, User: u, Amount: amounts[i]})
+		}
+		return legs
+	}
+	balances := func(want ...int64) {
+		t.Helper()
+		for i, u := range []*lnbits.User{alice, bob, carol, me} {
+			if got := balanceOf(t, bot, u); got != want[i] {
+				t.Errorf("balance of %s = %d, want %d", u.Name, got, want[i])
+			}
+		}
+	}
+
+	if err := bot.sendSplit(alice, legs(75, 75), "split:1:1", 1, ""); err == nil {
+		t.Fatal("split above the per transaction limit succeeded")
+	}
+	balances(300, 0, 0, 0)
+
+	if err := bot.sendSplit(alice, legs(50, 40), "split:1:2", 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	balances(210, 50, 40, 0)
+	// the legs are listed and exported as tips of the sender
+	out, _, err := bot.queryTransactions(alice, &TransactionFilter{Direction: "out"}, 0, 10)
+	if err != nil || len(out) != 2 || out[0].Type != "split" {
+		t.Errorf("outgoing transactions of alice = %+v, %v, want the 2 legs", out, err)
+	}
+	if to, _, _ := bot.queryTransactions(alice, &TransactionFilter{Direction: "out", Counterparty: bob.Telegram.Username}, 0, 10); len(to) != 1 || to[0].Amount != 50 {
+		t.Errorf("transactions of alice to bob = %+v, want the leg of 50 sat", to)
+	}
+	statement, err := bot.ExportStatement(alice, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GetUserStr(bob.Telegram) + ", " + GetUserStr(carol.Telegram)
+	exported := false
+	for _, row := range statement.Rows {
+		exported = exported || row.Type == "split" && row.Amount == -90 && row.Counterparty == want
+	}
+	if !exported {
+		t.Errorf("export of alice = %+v, want the split to %s", statement.Rows, want)
+	}
+
+	// a leg to a wallet that doesn't exist fails after the first leg was paid
+	wallet := carol.Wallet
+	carol.Wallet = &lnbits.Wallet{ID: "gone", Inkey: "gone", Adminkey: "gone"}
+	if err := bot.sendSplit(alice, legs(30, 30), "split:1:3", 1, ""); err == nil {
+		t.Fatal("split to a missing wallet succeeded")
+	}
+	carol.Wallet = wallet
+	balances(210, 50, 40, 0)
+}
@@ -15,6 +15,16 @@ import (
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
+// textHandler handles text in private chats and replies to the bot in groups.
+func (bot *TipBot) textHandler(ctx intercept.Context) (intercept.Context, error) {
+	if ctx.Message().Chat.Type == tb.ChatPrivate {
+		return bot.anyTextHandler(ctx)
+	}
+	// replies to bounties are claims
+	ctx, _, err := bot.claimBountyHandler(ctx)
+	return ctx, err
+}
+
 func (bot *TipBot) anyTextHandler(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
 	if m.Chat.Type != tb.ChatPrivate {
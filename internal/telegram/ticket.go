@@ -25,6 +25,13 @@ var defaultTicketDuration = time.Minute * 15
 // handler will create a new invoice and send it to the group chat.
 // a ticket callback timer function is stored in the blunt db.
 func (bot *TipBot) handleTelegramNewMember(ctx intercept.Context) (intercept.Context, error) {
+	if m := ctx.Message(); m != nil {
+		joined := m.UsersJoined
+		if m.UserJoined != nil {
+			joined = append(joined, *m.UserJoined)
+		}
+		bot.markRaffleNewcomers(ctx.Chat().ID, joined)
+	}
 	id := strconv.FormatInt(ctx.Chat().ID, 10)
 	group, err := bot.loadGroup(id)
 	if err != nil {
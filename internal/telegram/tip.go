@@ -42,6 +42,11 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 		return ctx, fmt.Errorf("user has no wallet")
 	}
 
+	// /tip <amount> @user1 @user2 splits the tip between the mentioned users
+	if args := strings.Fields(m.Text); !m.IsReply() && len(args) > 2 && strings.HasPrefix(args[2], "@") {
+		return bot.splitHandler(ctx)
+	}
+
 	// only if message is a reply
 	if !m.IsReply() {
 		bot.tryDeleteMessage(m)
@@ -108,7 +113,8 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 
 	// todo: user new get username function to get userStrings
 	transactionMemo := fmt.Sprintf("🏅 Tip from %s to %s.", fromUserStr, toUserStr)
-	t := NewTransaction(bot, from, to, amount, TransactionType("tip"), TransactionChat(m.Chat))
+	t := NewTransaction(bot, from, to, amount, TransactionType("tip"), TransactionChat(m.Chat),
+		TransactionIdempotencyKey(fmt.Sprintf("tip:%d:%d", m.Chat.ID, m.ID)))
 	t.Memo = transactionMemo
 	success, err := t.Send()
 	if !success {
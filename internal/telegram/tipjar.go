@@ -280,7 +280,8 @@ func (bot *TipBot) acceptInlineTipjarHandler(ctx intercept.Context) (intercept.C
 
 		// todo: user new get username function to get userStrings
 		transactionMemo := fmt.Sprintf("🍯 Tipjar from %s to %s.", fromUserStr, toUserStr)
-		t := NewTransaction(bot, from, to, inlineTipjar.PerUserAmount, TransactionType("tipjar"))
+		t := NewTransaction(bot, from, to, inlineTipjar.PerUserAmount, TransactionType("tipjar"),
+			TransactionIdempotencyKey(fmt.Sprintf("%s:%d", inlineTipjar.ID, from.Telegram.ID)))
 		t.Memo = transactionMemo
 
 		success, err := t.Send()
@@ -1,38 +1,56 @@
 package telegram
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/LightningTipBot/LightningTipBot/internal/database"
+	"github.com/LightningTipBot/LightningTipBot/internal/events"
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/webhooks"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
 
 type Transaction struct {
-	ID           uint           `gorm:"primarykey"`
-	Time         time.Time      `json:"time"`
-	Bot          *TipBot        `gorm:"-"`
-	From         *lnbits.User   `json:"from" gorm:"-"`
-	To           *lnbits.User   `json:"to" gorm:"-"`
-	FromId       int64          `json:"from_id" `
-	ToId         int64          `json:"to_id" `
-	FromUser     string         `json:"from_user"`
-	ToUser       string         `json:"to_user"`
-	Type         string         `json:"type"`
-	Amount       int64          `json:"amount"`
-	ChatID       int64          `json:"chat_id"`
-	ChatName     string         `json:"chat_name"`
-	Memo         string         `json:"memo"`
-	Success      bool           `json:"success"`
-	FromWallet   string         `json:"from_wallet"`
-	ToWallet     string         `json:"to_wallet"`
-	FromLNbitsID string         `json:"from_lnbits"`
-	ToLNbitsID   string         `json:"to_lnbits"`
-	Invoice      lnbits.Invoice `gorm:"embedded;embeddedPrefix:invoice_"`
+	ID             uint           `gorm:"primarykey"`
+	Time           time.Time      `json:"time"`
+	Bot            *TipBot        `gorm:"-"`
+	From           *lnbits.User   `json:"from" gorm:"-"`
+	To             *lnbits.User   `json:"to" gorm:"-"`
+	FromId         int64          `json:"from_id" gorm:"index"`
+	ToId           int64          `json:"to_id" gorm:"index"`
+	FromUser       string         `json:"from_user"`
+	ToUser         string         `json:"to_user"`
+	Type           string         `json:"type" gorm:"index"`
+	Amount         int64          `json:"amount"`
+	ChatID         int64          `json:"chat_id"`
+	ChatName       string         `json:"chat_name"`
+	Memo           string         `json:"memo"`
+	Success        bool           `json:"success"`
+	Status         string         `json:"status" gorm:"index"`
+	FromWallet     string         `json:"from_wallet"`
+	ToWallet       string         `json:"to_wallet"`
+	FromLNbitsID   string         `json:"from_lnbits"`
+	ToLNbitsID     string         `json:"to_lnbits"`
+	Invoice        lnbits.Invoice `gorm:"embedded;embeddedPrefix:invoice_"`
+	IdempotencyKey string         `json:"idempotency_key" gorm:"uniqueIndex:idx_transactions_idempotency"`
+	Fiat           FiatAmount     `json:"fiat" gorm:"embedded;embeddedPrefix:fiat_"`
+	BatchID        string         `json:"batch_id" gorm:"index"`
 }
 
+// A transaction is persisted as pending before any funds move and is
+// updated to paid or failed once the transfer returned. It stays pending if
+// the backend could not tell whether the funds moved, until the reconciler
+// found out.
+const (
+	TransactionStatusPending = "pending"
+	TransactionStatusPaid    = "paid"
+	TransactionStatusFailed  = "failed"
+)
+
 type TransactionOption func(t *Transaction)
 
 func TransactionChat(chat *tb.Chat) TransactionOption {
@@ -48,6 +66,30 @@ func TransactionType(transactionType string) TransactionOption {
 	}
 }
 
+// TransactionIdempotencyKey sets the key under which the transfer is booked.
+// Sending a transaction with a key that was already booked does not move funds again.
+func TransactionIdempotencyKey(key string) TransactionOption {
+	return func(t *Transaction) {
+		t.IdempotencyKey = key
+	}
+}
+
+// TransactionBatch marks the transaction as one leg of a payment to several users.
+func TransactionBatch(id string) TransactionOption {
+	return func(t *Transaction) {
+		t.BatchID = id
+	}
+}
+
+// TransactionFiat records the fiat amount and the locked rate the amount was converted at.
+func TransactionFiat(fiat *FiatAmount) TransactionOption {
+	return func(t *Transaction) {
+		if fiat != nil {
+			t.Fiat = *fiat
+		}
+	}
+}
+
 func NewTransaction(bot *TipBot, from *lnbits.User, to *lnbits.User, amount int64, opts ...TransactionOption) *Transaction {
 	t := &Transaction{
 		Bot:      bot,
@@ -65,23 +107,92 @@ func NewTransaction(bot *TipBot, from *lnbits.User, to *lnbits.User, amount int6
 	for _, opt := range opts {
 		opt(t)
 	}
+	if len(t.IdempotencyKey) == 0 {
+		t.IdempotencyKey = RandStringRunes(32)
+	}
 	return t
 
 }
 
+// Send books the transaction. Both wallets are locked while it runs. If a
+// transaction with the same idempotency key was already paid, Send returns
+// its result without moving funds again. A failed transaction is retried on
+// the same row, a pending one is reconciled first.
 func (t *Transaction) Send() (success bool, err error) {
+	unlock := t.lockWallets()
+	defer unlock()
+	return t.send()
+}
+
+// send books the transaction. The caller must hold the locks of both wallets.
+func (t *Transaction) send() (success bool, err error) {
+	if previous, err := t.Bot.getTransactionByIdempotencyKey(t.IdempotencyKey); err == nil {
+		if previous.Status == TransactionStatusPending {
+			t.Bot.reconcileTransaction(previous)
+		}
+		t.ID = previous.ID
+		switch previous.Status {
+		case TransactionStatusPending:
+			// we don't know whether the previous attempt moved funds
+			return false, fmt.Errorf("transaction %s is still pending", t.IdempotencyKey)
+		case TransactionStatusPaid:
+			log.Warnf("[Send] Transaction %s was already paid. Skipping.", t.IdempotencyKey)
+			t.Invoice = previous.Invoice
+			t.Success = true
+			t.Status = TransactionStatusPaid
+			return true, nil
+		}
+	}
+
+	// persist the pending transaction before moving any funds
+	t.Status = TransactionStatusPending
+	if err = t.save(); err != nil {
+		return false, err
+	}
+
 	success, err = t.SendTransaction(t.Bot, t.From, t.To, t.Amount, t.Memo)
+	t.Success = success
 	if success {
-		t.Success = success
+		t.Status = TransactionStatusPaid
+		// internal transfers don't reach the lnbits webhook
+		events.Publish(events.Event{User: t.To.Name, WalletID: t.ToWallet, Incoming: true, Amount: t.Amount, Memo: t.Memo, PaymentHash: t.Invoice.PaymentHash})
+		if t.Type == "tip" || t.Type == "tipjar" || t.Type == "split" {
+			webhooks.Publish(t.To.Name, webhooks.EventTipReceived, map[string]interface{}{
+				"amount":    t.Amount,
+				"from_user": t.FromUser,
+				"chat_id":   t.ChatID,
+				"chat_name": t.ChatName,
+			})
+		}
+	} else if !errors.Is(err, lnbits.ErrPaymentPending) {
+		t.Status = TransactionStatusFailed
+	}
+	if saveErr := t.save(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return success, err
+}
+
+// lockWallets locks the wallets of both users. If a wallet was rotated while
+// waiting for the lock, the user is pointed to the new wallet and locked again.
+func (t *Transaction) lockWallets() func() {
+	for {
+		unlock := lockWallets(t.From, t.To)
+		if !t.Bot.walletReplaced(t.From) && !t.Bot.walletReplaced(t.To) {
+			return unlock
+		}
+		unlock()
 	}
+}
 
-	// save transaction to db
+// save writes the transaction to the transactions database
+func (t *Transaction) save() error {
 	tx := t.Bot.DB.Transactions.Save(t)
 	if tx.Error != nil {
-		errMsg := fmt.Sprintf("Error: Could not log transaction: %s", err.Error())
-		log.Errorln(errMsg)
+		log.Errorf("[Transaction] Error: Could not log transaction %s: %s", t.IdempotencyKey, tx.Error.Error())
+		return tx.Error
 	}
-	return success, err
+	return nil
 }
 
 func (t *Transaction) SendTransaction(bot *TipBot, from *lnbits.User, to *lnbits.User, amount int64, memo string) (bool, error) {
@@ -90,58 +201,140 @@ func (t *Transaction) SendTransaction(bot *TipBot, from *lnbits.User, to *lnbits
 
 	t.FromWallet = from.Wallet.ID
 	t.FromLNbitsID = from.ID
-
-	// check if fromUser has balance
-	balance, err := bot.GetUserBalance(from)
-	if err != nil {
-		errmsg := fmt.Sprintf("could not get balance of user %s", fromUserStr)
-		log.Errorln(errmsg)
-		return false, err
-	}
-	// check if fromUser has balance
-	if balance < amount {
-		errmsg := fmt.Sprintf("balance too low.")
-		log.Warnf("Balance of user %s too low", fromUserStr)
-		return false, fmt.Errorf(errmsg)
-	}
-
-	t.ToWallet = to.ID
+	t.ToWallet = to.Wallet.ID
 	t.ToLNbitsID = to.ID
 
-	// generate invoice
-	invoice, err := to.Wallet.Invoice(
-		lnbits.InvoiceParams{
-			Amount: int64(amount),
-			Out:    false,
-			Memo:   memo},
-		bot.Client)
-	if err != nil {
-		errmsg := fmt.Sprintf("[Send] Error: Could not create invoice for user %s", toUserStr)
-		log.Errorln(errmsg)
-		return false, err
-	}
-	t.Invoice = invoice
-	// pay invoice
-	_, err = from.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}, bot.Client)
+	booking, err := bot.transfer(from, to, amount, memo, t.IdempotencyKey, t.Invoice, func(invoice lnbits.Invoice) {
+		// remember the invoice so that the reconciler can check it after a crash
+		t.Invoice = invoice
+		t.save()
+	})
+	t.Invoice = booking
 	if err != nil {
-		errmsg := fmt.Sprintf("[Send] Payment failed (%s to %s of %d sat): %s", fromUserStr, toUserStr, amount, err.Error())
+		errmsg := fmt.Sprintf("[Send] Transfer failed (%s to %s of %d sat): %s", fromUserStr, toUserStr, amount, err.Error())
 		log.Warnf(errmsg)
 		return false, err
 	}
+	if len(t.Fiat.Currency) > 0 {
+		bot.saveFiatQuote(booking.PaymentHash, amount, &t.Fiat)
+	}
 
-	// check if fromUser has balance
+	// update balances in cache
 	_, err = bot.GetUserBalance(from)
 	if err != nil {
 		errmsg := fmt.Sprintf("could not get balance of user %s", fromUserStr)
 		log.Errorln(errmsg)
-		return false, err
 	}
 	_, err = bot.GetUserBalance(to)
 	if err != nil {
-		errmsg := fmt.Sprintf("could not get balance of user %s", fromUserStr)
+		errmsg := fmt.Sprintf("could not get balance of user %s", toUserStr)
 		log.Errorln(errmsg)
-		return false, err
 	}
 
-	return true, err
+	// the transfer succeeded, a failed balance update must not mark it as failed
+	return true, nil
+}
+
+func (bot *TipBot) getTransactionByIdempotencyKey(key string) (*Transaction, error) {
+	t := &Transaction{}
+	tx := bot.DB.Transactions.Where("idempotency_key = ?", key).First(t)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	t.Bot = bot
+	return t, nil
+}
+
+// transactionWallet returns the wallet with the given id. Unlike the wallet
+// of the user, the id stored with a transaction still points to the same wallet
+// after the user switched wallets or rotated it.
+func (bot *TipBot) transactionWallet(id string) (lnbits.Wallet, error) {
+	user, err := database.FindUserByWallet(bot.DB.Users, id)
+	if err == nil && user.Wallet != nil && user.Wallet.ID == id {
+		return *user.Wallet, nil
+	}
+	retired := RetiredWallet{}
+	if tx := bot.DB.Users.Where("id = ?", id).First(&retired); tx.Error != nil {
+		return lnbits.Wallet{}, fmt.Errorf("unknown wallet %s: %w", id, tx.Error)
+	}
+	return lnbits.Wallet{ID: retired.ID, Inkey: retired.Inkey}, nil
+}
+
+// reconcileTransaction resolves a pending transaction. The caller must hold
+// the locks of both wallets. If the transfer got as far as an invoice, the
+// backend is asked whether it was paid. A payment that is still in flight
+// stays pending, and so does one whose state the backend couldn't tell, so
+// that a retry never pays a new invoice while the old one may still settle.
+// Otherwise no funds have moved and the transaction failed.
+func (bot *TipBot) reconcileTransaction(t *Transaction) {
+	t.Bot = bot
+	status := TransactionStatusFailed
+	if len(t.Invoice.PaymentHash) > 0 {
+		to, err := bot.transactionWallet(t.ToWallet)
+		if err != nil {
+			log.Errorf("[reconcileTransaction] Could not load recipient wallet of transaction %d: %v", t.ID, err)
+			return
+		}
+		payment, err := bot.Client.Payment(to, t.Invoice.PaymentHash)
+		if err != nil {
+			log.Errorf("[reconcileTransaction] Could not check payment of transaction %d: %v", t.ID, err)
+			return
+		}
+		if payment.Paid {
+			status = TransactionStatusPaid
+		} else {
+			from, err := bot.transactionWallet(t.FromWallet)
+			if err != nil {
+				log.Errorf("[reconcileTransaction] Could not load sender wallet of transaction %d: %v", t.ID, err)
+				return
+			}
+			// the sender's wallet has no payment if the invoice was never paid
+			sent, err := bot.Client.Payment(from, t.Invoice.PaymentHash)
+			if err != nil && !lnbits.PaymentNotFound(err) {
+				log.Errorf("[reconcileTransaction] Could not check outgoing payment of transaction %d: %v", t.ID, err)
+				return
+			}
+			if err == nil && sent.Details.Pending {
+				log.Infof("[reconcileTransaction] Payment of transaction %d (%s) is in flight.", t.ID, t.IdempotencyKey)
+				return
+			}
+		}
+	}
+	t.Status = status
+	t.Success = status == TransactionStatusPaid
+	log.Infof("[reconcileTransaction] Transaction %d (%s) is %s.", t.ID, t.IdempotencyKey, t.Status)
+	t.save()
+}
+
+// reconcilePendingTransactions resolves all transactions that were left pending,
+// for example because the bot crashed while a transfer was running or the
+// backend timed out.
+func (bot *TipBot) reconcilePendingTransactions() {
+	var pending []*Transaction
+	tx := bot.DB.Transactions.Where("status = ?", TransactionStatusPending).Find(&pending)
+	if tx.Error != nil {
+		log.Errorf("[reconcilePendingTransactions] %v", tx.Error)
+		return
+	}
+	for _, t := range pending {
+		unlock := lockWalletIDs(t.FromWallet, t.ToWallet)
+		// the transaction may have been resolved while we waited for the lock
+		if current, err := bot.getTransactionByIdempotencyKey(t.IdempotencyKey); err == nil && current.Status == TransactionStatusPending {
+			bot.reconcileTransaction(current)
+		}
+		unlock()
+	}
+}
+
+// reconcileTransactionsInterval is how often pending transactions are checked.
+const reconcileTransactionsInterval = 5 * time.Minute
+
+// startTransactionReconciler resolves pending transactions periodically.
+func (bot *TipBot) startTransactionReconciler() {
+	go func() {
+		for range time.Tick(reconcileTransactionsInterval) {
+			bot.reconcilePendingTransactions()
+			bot.reconcileTreasuryPayouts()
+		}
+	}()
 }
@@ -0,0 +1,243 @@
+package telegram
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/apikeys"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/lndhub/credentials"
+	"github.com/LightningTipBot/LightningTipBot/internal/rate"
+	"github.com/eko/gocache/store"
+	gocache "github.com/patrickmn/go-cache"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestBot returns a bot with in-memory databases and the given backend.
+func newTestBot(t *testing.T, backend lnbits.WalletBackend) *TipBot {
+	rate.Start()
+	open := func(name string, models ...interface{}) *gorm.DB {
+		db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), name)), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AutoMigrate(models...); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	return &TipBot{
+		DB: &Databases{
+			Users: open("users.db", &lnbits.User{}, &lnbits.Settings{}, &RetiredWallet{}, &lnbits.UserWallet{},
+				&credentials.Credential{}, &credentials.Token{}, &apikeys.Key{}),
+			Transactions: open("transactions.db", &Transaction{}, &Spend{}),
+			Groups:       open("groups.db", &Group{}),
+		},
+		Bunt:     createBunt(filepath.Join(t.TempDir(), "bunt.db")),
+		Telegram: newTestTelegramBot(t),
+		Client:   backend,
+		Cache:    Cache{GoCacheStore: store.NewGoCache(gocache.New(time.Minute, time.Minute), nil)},
+	}
+}
+
+// newTestTelegramBot returns a bot that talks to a Telegram API that accepts every request.
+func newTestTelegramBot(t *testing.T) *tb.Bot {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":1}}}`)
+	}))
+	t.Cleanup(api.Close)
+	bot, err := tb.NewBot(tb.Settings{URL: api.URL, Token: "test", Offline: true, Client: api.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.Me = &tb.User{ID: 1, Username: "test-bot", IsBot: true}
+	return bot
+}
+
+// newTestUser creates a user with a wallet of fake and deposits balance sat.
+// Like real users, it is named after its Telegram id.
+func newTestUser(t *testing.T, bot *TipBot, fake *lnbits.FakeBackend, id int64, balance int64) *lnbits.User {
+	name := strconv.FormatInt(id, 10)
+	u, err := fake.CreateUserWithInitialWallet(name, name, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallets, err := fake.Wallets(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &lnbits.User{
+		ID:           u.ID,
+		Name:         name,
+		Telegram:     &tb.User{ID: id, Username: fmt.Sprintf("user%d", id)},
+		Wallet:       &wallets[0],
+		AnonID:       name,
+		AnonIDSha256: name,
+		UUID:         name,
+		Initialized:  true,
+	}
+	if err := bot.DB.Users.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if balance > 0 {
+		if err := fake.Deposit(*user.Wallet, balance, "faucet"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+func balanceOf(t *testing.T, bot *TipBot, u *lnbits.User) int64 {
+	balance, err := bot.GetUserBalance(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+// flakyBackend loses the responses of the backend while it is down. It hides
+// the Transferer of the fake, so transfers go through invoices.
+type flakyBackend struct {
+	lnbits.WalletBackend
+	down bool
+	// payments still reach the backend while it is down
+	deliver bool
+	// lookups of payments of this wallet fail even while the backend is up
+	brokenWallet string
+}
+
+func (f *flakyBackend) Pay(w lnbits.Wallet, params lnbits.PaymentParams) (lnbits.Invoice, error) {
+	if !f.down {
+		return f.WalletBackend.Pay(w, params)
+	}
+	if f.deliver {
+		f.WalletBackend.Pay(w, params)
+	}
+	return lnbits.Invoice{}, fmt.Errorf("connection reset by peer")
+}
+
+func (f *flakyBackend) Payment(w lnbits.Wallet, paymentHash string) (lnbits.LNbitsPayment, error) {
+	if f.down || w.ID == f.brokenWallet {
+		return lnbits.LNbitsPayment{}, fmt.Errorf("connection refused")
+	}
+	return f.WalletBackend.Payment(w, paymentHash)
+}
+
+func TestTransaction_Send(t *testing.T) {
+	fake := lnbits.NewFakeBackend()
+	bot := newTestBot(t, fake)
+	alice := newTestUser(t, bot, fake, 1, 100)
+	bob := newTestUser(t, bot, fake, 2, 0)
+
+	for i := 0; i < 2; i++ {
+		success, err := NewTransaction(bot, alice, bob, 30, TransactionIdempotencyKey("tip:1")).Send()
+		if !success || err != nil {
+			t.Fatalf("attempt %d: Send() = %v, %v", i, success, err)
+		}
+	}
+	if got := balanceOf(t, bot, alice); got != 70 {
+		t.Errorf("balance of alice = %d, want 70", got)
+	}
+	if got := balanceOf(t, bot, bob); got != 30 {
+		t.Errorf("balance of bob = %d, want 30", got)
+	}
+	var count int64
+	bot.DB.Transactions.Model(&Transaction{}).Where("idempotency_key = ?", "tip:1").Count(&count)
+	if count != 1 {
+		t.Errorf("%d transactions with the same key, want 1", count)
+	}
+
+	success, err := NewTransaction(bot, alice, bob, 500, TransactionIdempotencyKey("tip:2")).Send()
+	if success || err == nil {
+		t.Errorf("Send() above balance = %v, %v", success, err)
+	}
+	if tx, _ := bot.getTransactionByIdempotencyKey("tip:2"); tx == nil || tx.Status != TransactionStatusFailed {
+		t.Errorf("transaction above balance is %v, want failed", tx)
+	}
+}
+
+func TestTransaction_Reconcile(t *testing.T) {
+	tests := []struct {
+		name string
+		// the lost payment reached the backend
+		deliver bool
+		want    string
+	}{
+		{name: "paid", deliver: true, want: TransactionStatusPaid},
+		{name: "failed", deliver: false, want: TransactionStatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := lnbits.NewFakeBackend()
+			backend := &flakyBackend{WalletBackend: fake}
+			bot := newTestBot(t, backend)
+			alice := newTestUser(t, bot, fake, 1, 100)
+			bob := newTestUser(t, bot, fake, 2, 0)
+
+			backend.down, backend.deliver = true, tt.deliver
+			success, err := NewTransaction(bot, alice, bob, 30, TransactionIdempotencyKey("tip")).Send()
+			if success || err == nil {
+				t.Fatalf("Send() while down = %v, %v", success, err)
+			}
+			tx, err := bot.getTransactionByIdempotencyKey("tip")
+			if err != nil || tx.Status != TransactionStatusPending {
+				t.Fatalf("transaction is %v, %v, want pending", tx, err)
+			}
+			// a retry can't decide either
+			if success, _ := NewTransaction(bot, alice, bob, 30, TransactionIdempotencyKey("tip")).Send(); success {
+				t.Fatal("retry of a pending transaction succeeded while down")
+			}
+
+			backend.down = false
+			bot.reconcilePendingTransactions()
+			tx, _ = bot.getTransactionByIdempotencyKey("tip")
+			if tx.Status != tt.want {
+				t.Fatalf("reconciled transaction is %s, want %s", tx.Status, tt.want)
+			}
+
+			// a retry completes a failed transaction and moves the funds only once
+			success, err = NewTransaction(bot, alice, bob, 30, TransactionIdempotencyKey("tip")).Send()
+			if !success || err != nil {
+				t.Fatalf("retry: Send() = %v, %v", success, err)
+			}
+			if got := balanceOf(t, bot, bob); got != 30 {
+				t.Errorf("balance of bob = %d, want 30", got)
+			}
+			if got := balanceOf(t, bot, alice); got != 70 {
+				t.Errorf("balance of alice = %d, want 70", got)
+			}
+		})
+	}
+}
+
+func TestTransaction_ReconcileLookupError(t *testing.T) {
+	fake := lnbits.NewFakeBackend()
+	backend := &flakyBackend{WalletBackend: fake}
+	bot := newTestBot(t, backend)
+	alice := newTestUser(t, bot, fake, 1, 100)
+	bob := newTestUser(t, bot, fake, 2, 0)
+
+	backend.down = true
+	if success, _ := NewTransaction(bot, alice, bob, 30, TransactionIdempotencyKey("tip")).Send(); success {
+		t.Fatal("Send() while down succeeded")
+	}
+	// the payee has no payment, the payer can't tell
+	backend.down, backend.brokenWallet = false, alice.Wallet.ID
+	bot.reconcilePendingTransactions()
+	if tx, _ := bot.getTransactionByIdempotencyKey("tip"); tx.Status != TransactionStatusPending {
+		t.Fatalf("transaction is %s after a failed lookup, want pending", tx.Status)
+	}
+	backend.brokenWallet = ""
+	bot.reconcilePendingTransactions()
+	if tx, _ := bot.getTransactionByIdempotencyKey("tip"); tx.Status != TransactionStatusFailed {
+		t.Fatalf("transaction is %s, want failed", tx.Status)
+	}
+}
@@ -2,6 +2,7 @@ package telegram
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
@@ -13,33 +14,229 @@ import (
 )
 
 type TransactionsList struct {
-	ID           string          `json:"id"`
-	User         *lnbits.User    `json:"from"`
-	Payments     lnbits.Payments `json:"payments"`
-	LanguageCode string          `json:"languagecode"`
-	CurrentPage  int             `json:"currentpage"`
-	MaxPages     int             `json:"maxpages"`
-	TxPerPage    int             `json:"txperpage"`
+	ID           string                 `json:"id"`
+	User         *lnbits.User           `json:"from"`
+	Payments     lnbits.Payments        `json:"payments"`
+	Fiat         map[string]*FiatAmount `json:"fiat"` // fiat amounts by payment hash
+	LanguageCode string                 `json:"languagecode"`
+	CurrentPage  int                    `json:"currentpage"`
+	MaxPages     int                    `json:"maxpages"`
+	TxPerPage    int                    `json:"txperpage"`
+	// filtered lists are loaded page by page from the transaction log
+	Filter       *TransactionFilter `json:"filter,omitempty"`
+	Transactions []Transaction      `json:"transactions,omitempty"`
+	Cursors      []uint             `json:"cursors,omitempty"` // id below which each page starts, 0 for the first page
+}
+
+// TransactionFilter selects transactions of a user from the transaction log.
+type TransactionFilter struct {
+	Direction    string    `json:"direction"` // "in", "out" or empty for both
+	Counterparty string    `json:"counterparty"`
+	Type         string    `json:"type"`
+	Min          int64     `json:"min"`
+	Max          int64     `json:"max"`
+	Since        time.Time `json:"since"`
+	Until        time.Time `json:"until"`
+	Memo         string    `json:"memo"`
+}
+
+var (
+	transactionsFilterHelpMessage = "🔎 *Search transactions:* `/transactions <filters>`\n" +
+		"`from:@user` `to:@user` `with:@user` `type:tip` `direction:in|out` `min:100` `max:1000` `since:2026-01-01` `until:2026-01-31` `memo:pizza`\n" +
+		"Words without a filter are searched in the memo. Example: `/transactions from:@alice type:tip since:2026-01-01`\n" +
+		"`/transactions wallet:<name>` lists the payments of one of your /wallets."
+	transactionsNotFoundMessage = "🔎 No transactions found."
+)
+
+// ParseTransactionFilter parses filters like from:@alice type:tip since:2026-01-01.
+func ParseTransactionFilter(args []string) (*TransactionFilter, error) {
+	f := &TransactionFilter{}
+	var memo []string
+	var since, until string
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, ":")
+		if !ok {
+			memo = append(memo, arg)
+			continue
+		}
+		if len(value) == 0 {
+			return nil, fmt.Errorf("empty filter %s", key)
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "from":
+			f.Direction, f.Counterparty = "in", strings.TrimPrefix(value, "@")
+		case "to":
+			f.Direction, f.Counterparty = "out", strings.TrimPrefix(value, "@")
+		case "with":
+			f.Counterparty = strings.TrimPrefix(value, "@")
+		case "direction":
+			f.Direction = strings.ToLower(value)
+			if f.Direction != "in" && f.Direction != "out" {
+				return nil, fmt.Errorf("invalid direction %s", value)
+			}
+		case "type":
+			f.Type = strings.ToLower(value)
+		case "min":
+			f.Min, err = GetAmount(value)
+		case "max":
+			f.Max, err = GetAmount(value)
+		case "since":
+			since = value
+		case "until":
+			until = value
+		case "memo":
+			memo = append(memo, value)
+		default:
+			return nil, fmt.Errorf("unknown filter %s", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if f.Max > 0 && f.Min > f.Max {
+		return nil, fmt.Errorf("min is larger than max")
+	}
+	var err error
+	if f.Since, f.Until, err = ParseExportRange(since, until); err != nil {
+		return nil, err
+	}
+	f.Memo = strings.Join(memo, " ")
+	return f, nil
+}
+
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
+// queryTransactions returns up to limit successful transactions of user
+// matching f with an id below cursor, newest first. A cursor of 0 starts at
+// the newest transaction. more is true if there are further transactions.
+func (bot *TipBot) queryTransactions(user *lnbits.User, f *TransactionFilter, cursor uint, limit int) (transactions []Transaction, more bool, err error) {
+	id := user.Telegram.ID
+	q := bot.DB.Transactions.Where("success = ? AND type NOT IN ?", true, splitEscrowTypes)
+	switch f.Direction {
+	case "in":
+		q = q.Where("to_id = ?", id)
+	case "out":
+		q = q.Where("from_id = ?", id)
+	default:
+		q = q.Where("(from_id = ? OR to_id = ?)", id, id)
+	}
+	if len(f.Counterparty) > 0 {
+		names := []string{"@" + f.Counterparty, f.Counterparty}
+		switch f.Direction {
+		case "in":
+			q = q.Where("from_user COLLATE NOCASE IN ?", names)
+		case "out":
+			q = q.Where("to_user COLLATE NOCASE IN ?", names)
+		default:
+			q = q.Where("((from_id = ? AND to_user COLLATE NOCASE IN ?) OR (to_id = ? AND from_user COLLATE NOCASE IN ?))", id, names, id, names)
+		}
+	}
+	if len(f.Type) > 0 {
+		q = q.Where("type = ?", f.Type)
+	}
+	if f.Min > 0 {
+		q = q.Where("amount >= ?", f.Min)
+	}
+	if f.Max > 0 {
+		q = q.Where("amount <= ?", f.Max)
+	}
+	// times are stored in local time
+	if !f.Since.IsZero() {
+		q = q.Where("time >= ?", f.Since.Local())
+	}
+	if !f.Until.IsZero() {
+		q = q.Where("time <= ?", f.Until.Local())
+	}
+	if len(f.Memo) > 0 {
+		q = q.Where("memo LIKE ? ESCAPE '\\'", "%"+likeEscaper.Replace(f.Memo)+"%")
+	}
+	if cursor > 0 {
+		q = q.Where("id < ?", cursor)
+	}
+	if err = q.Order("id desc").Limit(limit + 1).Find(&transactions).Error; err != nil {
+		return nil, false, err
+	}
+	if len(transactions) > limit {
+		return transactions[:limit], true, nil
+	}
+	return transactions, false, nil
+}
+
+// loadTransactionsPage loads the current page of a filtered list.
+func (bot *TipBot) loadTransactionsPage(txlist *TransactionsList) error {
+	transactions, more, err := bot.queryTransactions(txlist.User, txlist.Filter, txlist.Cursors[txlist.CurrentPage], txlist.TxPerPage)
+	if err != nil {
+		return err
+	}
+	txlist.Transactions = transactions
+	txlist.MaxPages = txlist.CurrentPage + 1
+	if more {
+		txlist.MaxPages++
+		if len(txlist.Cursors) == txlist.CurrentPage+1 {
+			txlist.Cursors = append(txlist.Cursors, transactions[len(transactions)-1].ID)
+		}
+	}
+	return nil
+}
+
+func (txlist *TransactionsList) printFilteredTransactions() string {
+	txstr := ""
+	me := txlist.User.Telegram.ID
+	for _, t := range txlist.Transactions {
+		incoming := t.ToId == me && t.FromId != me
+		counterparty := fmt.Sprintf("to %s", t.ToUser)
+		if incoming {
+			txstr += "🟢"
+			counterparty = fmt.Sprintf("from %s", t.FromUser)
+		} else {
+			txstr += "🔴"
+		}
+		txstr += fmt.Sprintf("` %s`", t.Time.UTC().Format("2 Jan 06 15:04"))
+		if incoming {
+			txstr += fmt.Sprintf("` +%d sat`", t.Amount)
+		} else {
+			txstr += fmt.Sprintf("` -%d sat`", t.Amount)
+		}
+		if len(t.Fiat.Currency) > 0 {
+			txstr += fmt.Sprintf(" _(%s)_", t.Fiat.String())
+		}
+		txstr += fmt.Sprintf(" %s _%s_", str.MarkdownEscape(counterparty), str.MarkdownEscape(t.Type))
+		if len(t.ChatName) > 0 {
+			txstr += fmt.Sprintf(" in %s", str.MarkdownEscape(t.ChatName))
+		}
+		memo := t.Memo
+		memo_maxlen := 50
+		if len(memo) > memo_maxlen {
+			memo = memo[:memo_maxlen] + "..."
+		}
+		if len(memo) > 0 {
+			txstr += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(memo))
+		}
+		txstr += "\n"
+	}
+	txstr += fmt.Sprintf("\nShowing %d transactions. Page %d.", len(txlist.Transactions), txlist.CurrentPage+1)
+	return txstr
 }
 
 func (txlist *TransactionsList) printTransactions(ctx intercept.Context) string {
+	if txlist.Filter != nil {
+		return txlist.printFilteredTransactions()
+	}
 	txstr := ""
 	// for _, p := range payments {
 	payments := txlist.Payments
 	pagenr := txlist.CurrentPage
 	tx_per_page := txlist.TxPerPage
-	if pagenr > (len(payments)+1)/tx_per_page {
+	if pagenr >= txlist.MaxPages {
 		pagenr = 0
 	}
-	if len(payments) < tx_per_page {
-		tx_per_page = len(payments)
-	}
-	start := pagenr * (tx_per_page - 1)
+	start := pagenr * tx_per_page
 	end := start + tx_per_page
-	if end >= len(payments) {
-		end = len(payments) - 1
+	if end > len(payments) {
+		end = len(payments)
 	}
-	for i := start; i <= end; i++ {
+	for i := start; i < end; i++ {
 		p := payments[i]
 		if p.Pending {
 			txstr += "🔄"
@@ -53,6 +250,9 @@ func (txlist *TransactionsList) printTransactions(ctx intercept.Context) string
 		timestr := time.Unix(int64(p.Time), 0).UTC().Format("2 Jan 06 15:04")
 		txstr += fmt.Sprintf("` %s`", timestr)
 		txstr += fmt.Sprintf("` %+d sat`", p.Amount/1000)
+		if fiat, ok := txlist.Fiat[p.PaymentHash]; ok {
+			txstr += fmt.Sprintf(" _(%s)_", fiat.String())
+		}
 		if p.Fee > 0 {
 			fee := p.Fee
 			if fee < 1000 {
@@ -107,20 +307,40 @@ func (bot *TipBot) makeTransactionsKeyboard(ctx intercept.Context, txlist Transa
 func (bot *TipBot) transactionsHandler(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
 	user := LoadUser(ctx)
-	payments, err := bot.Client.Payments(*user.Wallet)
+	args := strings.Fields(m.Text)[1:]
+	if len(args) == 1 && strings.HasPrefix(strings.ToLower(args[0]), "wallet:") {
+		// /transactions wallet:<name> lists one of the other wallets of the user
+		var err error
+		if user, err = bot.selectWalletByName(ctx, user, args[0][len("wallet:"):]); err != nil {
+			return ctx, err
+		}
+	} else if len(args) > 0 {
+		return bot.searchTransactionsHandler(ctx, args)
+	}
+	payments, err := bot.UserPayments(user)
 	if err != nil {
 		log.Errorf("[transactions] Error: %s", err.Error())
 		return ctx, err
 	}
+	fiat := make(map[string]*FiatAmount)
+	for _, p := range payments {
+		if quote := bot.getFiatQuote(p.PaymentHash); quote != nil {
+			fiat[p.PaymentHash] = quote
+		}
+	}
 	tx_per_page := 10
 	transactionsList := TransactionsList{
 		ID:           fmt.Sprintf("txlist:%d:%s", user.Telegram.ID, RandStringRunes(5)),
 		User:         user,
 		Payments:     payments,
+		Fiat:         fiat,
 		LanguageCode: ctx.Value("userLanguageCode").(string),
 		CurrentPage:  0,
 		TxPerPage:    tx_per_page,
-		MaxPages:     (len(payments)+1)/tx_per_page + 1,
+		MaxPages:     (len(payments) + tx_per_page - 1) / tx_per_page,
+	}
+	if transactionsList.MaxPages == 0 {
+		transactionsList.MaxPages = 1
 	}
 	bot.Cache.Set(fmt.Sprintf("%s_transactions", user.Name), transactionsList, &store.Options{Expiration: 1 * time.Minute})
 	txstr := transactionsList.printTransactions(ctx)
@@ -128,6 +348,36 @@ func (bot *TipBot) transactionsHandler(ctx intercept.Context) (intercept.Context
 	return ctx, nil
 }
 
+// searchTransactionsHandler lists the transactions matching the filters in args.
+func (bot *TipBot) searchTransactionsHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	filter, err := ParseTransactionFilter(args)
+	if err != nil {
+		bot.trySendMessage(m.Sender, transactionsFilterHelpMessage)
+		return ctx, err
+	}
+	transactionsList := TransactionsList{
+		ID:           fmt.Sprintf("txlist:%d:%s", user.Telegram.ID, RandStringRunes(5)),
+		User:         user,
+		LanguageCode: ctx.Value("userLanguageCode").(string),
+		TxPerPage:    10,
+		Filter:       filter,
+		Cursors:      []uint{0},
+	}
+	if err := bot.loadTransactionsPage(&transactionsList); err != nil {
+		log.Errorf("[transactions] Error: %s", err.Error())
+		return ctx, err
+	}
+	if len(transactionsList.Transactions) == 0 {
+		bot.trySendMessage(m.Sender, transactionsNotFoundMessage)
+		return ctx, nil
+	}
+	bot.Cache.Set(fmt.Sprintf("%s_transactions", user.Name), transactionsList, &store.Options{Expiration: 1 * time.Minute})
+	bot.trySendMessage(m.Sender, transactionsList.printTransactions(ctx), bot.makeTransactionsKeyboard(ctx, transactionsList))
+	return ctx, nil
+}
+
 func (bot *TipBot) transactionsScrollLeftHandler(ctx intercept.Context) (intercept.Context, error) {
 	c := ctx.Callback()
 	user := LoadUser(ctx)
@@ -144,6 +394,11 @@ func (bot *TipBot) transactionsScrollLeftHandler(ctx intercept.Context) (interce
 		} else {
 			return ctx, err
 		}
+		if transactionsList.Filter != nil {
+			if err := bot.loadTransactionsPage(&transactionsList); err != nil {
+				return ctx, err
+			}
+		}
 		bot.Cache.Set(fmt.Sprintf("%s_transactions", user.Name), transactionsList, &store.Options{Expiration: 1 * time.Minute})
 		bot.tryEditMessage(c.Message, transactionsList.printTransactions(ctx), bot.makeTransactionsKeyboard(ctx, transactionsList))
 	}
@@ -166,6 +421,11 @@ func (bot *TipBot) transactionsScrollRightHandler(ctx intercept.Context) (interc
 		} else {
 			return ctx, nil
 		}
+		if transactionsList.Filter != nil {
+			if err := bot.loadTransactionsPage(&transactionsList); err != nil {
+				return ctx, err
+			}
+		}
 		bot.Cache.Set(fmt.Sprintf("%s_transactions", user.Name), transactionsList, &store.Options{Expiration: 1 * time.Minute})
 		bot.tryEditMessage(c.Message, transactionsList.printTransactions(ctx), bot.makeTransactionsKeyboard(ctx, transactionsList))
 	}
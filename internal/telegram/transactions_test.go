@@ -0,0 +1,56 @@
+package telegram
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/price"
+)
+
+func TestParseTransactionFilter(t *testing.T) {
+	// amounts that are no satoshis are looked up as fiat amounts
+	price.NewPriceWatcher(price.WithSources(price.NewStaticSource(nil)))
+	day := func(s string) time.Time {
+		d, _ := time.Parse(exportDateLayout, s)
+		return d
+	}
+	tests := []struct {
+		name    string
+		args    string
+		want    TransactionFilter
+		wantErr bool
+	}{
+		{name: "plain word searches the memo", args: "pizza", want: TransactionFilter{Memo: "pizza"}},
+		{name: "plain words", args: "pizza party", want: TransactionFilter{Memo: "pizza party"}},
+		{name: "memo filter and words", args: "memo:pizza party", want: TransactionFilter{Memo: "pizza party"}},
+		{name: "from", args: "from:@alice", want: TransactionFilter{Direction: "in", Counterparty: "alice"}},
+		{name: "to", args: "to:bob", want: TransactionFilter{Direction: "out", Counterparty: "bob"}},
+		{name: "with", args: "with:@carol", want: TransactionFilter{Counterparty: "carol"}},
+		{name: "direction", args: "direction:OUT", want: TransactionFilter{Direction: "out"}},
+		{name: "type", args: "type:Tip", want: TransactionFilter{Type: "tip"}},
+		{name: "amounts", args: "min:100 max:1k", want: TransactionFilter{Min: 100, Max: 1000}},
+		{name: "dates", args: "since:2026-01-01 until:2026-01-31", want: TransactionFilter{Since: day("2026-01-01"), Until: day("2026-01-31").Add(24*time.Hour - time.Second)}},
+		{name: "combined", args: "from:@alice type:tip pizza", want: TransactionFilter{Direction: "in", Counterparty: "alice", Type: "tip", Memo: "pizza"}},
+		{name: "invalid direction", args: "direction:up", wantErr: true},
+		{name: "unknown filter", args: "color:red", wantErr: true},
+		{name: "wallet is not a filter", args: "wallet:savings pizza", wantErr: true},
+		{name: "empty filter", args: "type:", wantErr: true},
+		{name: "invalid amount", args: "min:lots", wantErr: true},
+		{name: "min above max", args: "min:1000 max:100", wantErr: true},
+		{name: "invalid date", args: "since:yesterday", wantErr: true},
+		{name: "until before since", args: "since:2026-02-01 until:2026-01-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTransactionFilter(strings.Fields(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTransactionFilter(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ParseTransactionFilter(%q) = %+v, want %+v", tt.args, *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package telegram
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	log "github.com/sirupsen/logrus"
+)
+
+// lockWallets locks the wallets of all given users in a stable order, so that
+// two transfers between the same wallets can't deadlock each other.
+// The returned function releases all locks.
+func lockWallets(users ...*lnbits.User) func() {
+	walletIDs := make([]string, 0, len(users))
+	for _, u := range users {
+		if u != nil && u.Wallet != nil {
+			walletIDs = append(walletIDs, u.Wallet.ID)
+		}
+	}
+	return lockWalletIDs(walletIDs...)
+}
+
+// lockWalletIDs locks the wallets with the given ids like lockWallets.
+func lockWalletIDs(walletIDs ...string) func() {
+	ids := make([]string, 0, len(walletIDs))
+	seen := make(map[string]bool)
+	for _, id := range walletIDs {
+		if len(id) == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, fmt.Sprintf("wallet:%s", id))
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		mutex.Lock(id)
+	}
+	return func() {
+		for i := len(ids) - 1; i >= 0; i-- {
+			mutex.Unlock(ids[i])
+		}
+	}
+}
+
+// transfer moves amount sat between two users of this bot. The caller must hold
+// the locks of both wallets (see lockWallets) so that the balance check and the
+// booking can't interleave with other transfers.
+// Backends that implement lnbits.Transferer book both legs atomically. All others
+// fall back to a Lightning invoice that is paid by the sender. invoice is the
+// invoice of an earlier attempt of the same transfer, if any, and onInvoice is
+// called with a new invoice before it is paid.
+func (bot *TipBot) transfer(from *lnbits.User, to *lnbits.User, amount int64, memo string, idempotencyKey string, invoice lnbits.Invoice, onInvoice func(lnbits.Invoice)) (lnbits.Invoice, error) {
+	balance, err := bot.GetUserBalance(from)
+	if err != nil {
+		return lnbits.Invoice{}, fmt.Errorf("could not get balance of user %s: %w", GetUserStr(from.Telegram), err)
+	}
+	if balance < amount {
+		log.Warnf("Balance of user %s too low", GetUserStr(from.Telegram))
+		return lnbits.Invoice{}, fmt.Errorf("balance too low.")
+	}
+
+	params := lnbits.TransferParams{
+		Memo:           memo,
+		NumSatoshis:    amount,
+		DestWalletId:   to.Wallet.ID,
+		IdempotencyKey: idempotencyKey,
+		Invoice:        invoice,
+		OnInvoice:      onInvoice,
+	}
+	if transferer, ok := bot.Client.(lnbits.Transferer); ok {
+		return transferer.Transfer(*from.Wallet, *to.Wallet, params)
+	}
+	return lnbits.TransferWithInvoice(bot.Client, *from.Wallet, *to.Wallet, params, false)
+}
@@ -0,0 +1,735 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/str"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+var (
+	treasuryPayoutMenu = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnApprovePayout   = treasuryPayoutMenu.Data("✅ Approve", "approve_payout")
+	btnRejectPayout    = treasuryPayoutMenu.Data("🚫 Reject", "reject_payout")
+)
+
+// treasuryPayoutTTL is how long signers can vote on a payout.
+const treasuryPayoutTTL = 7 * 24 * time.Hour
+
+const (
+	TreasuryPayoutProposed = "proposed"
+	TreasuryPayoutPaid     = "paid"
+	TreasuryPayoutFailed   = "failed"
+	TreasuryPayoutRejected = "rejected"
+	TreasuryPayoutExpired  = "expired"
+	TreasuryPayoutApplied  = "applied"
+	TreasuryPayoutPending  = "pending" // paid, but the payment hasn't settled yet
+)
+
+// TreasuryKindSigners marks a proposal that replaces the signers and the quorum.
+const TreasuryKindSigners = "signers"
+
+// Treasury is the wallet of a group. It belongs to the bot, payouts need the
+// approval of Quorum of its signers.
+type Treasury struct {
+	ChatID    int64  `gorm:"primaryKey"`
+	Title     string `json:"title"`
+	WalletID  string `json:"wallet_id"`
+	Quorum    int    `json:"quorum"`
+	CreatedAt time.Time
+}
+
+// TreasurySigner is a member of a group that votes on payouts.
+type TreasurySigner struct {
+	ChatID     int64  `gorm:"primaryKey;autoIncrement:false"`
+	TelegramID int64  `gorm:"primaryKey;autoIncrement:false"`
+	Username   string `json:"username"`
+}
+
+// TreasuryPayout is a proposed payment from a treasury to a user or an invoice.
+// Proposals of kind TreasuryKindSigners change the signers and the quorum instead.
+type TreasuryPayout struct {
+	ID         uint      `gorm:"primarykey"`
+	ChatID     int64     `gorm:"index"`
+	Kind       string    `json:"kind"` // empty for payouts
+	Proposer   string    `json:"proposer"`
+	Amount     int64     `json:"amount"`
+	Recipient  string    `json:"recipient"` // telegram username, empty for invoices
+	Invoice    string    `json:"invoice"`
+	Memo       string    `json:"memo"`
+	Quorum     int       `json:"quorum"`  // new quorum of a signers proposal
+	Signers    string    `json:"signers"` // json of the new signers of a signers proposal
+	Status     string    `json:"status" gorm:"index"`
+	CreatedAt  time.Time `json:"created_at"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+// TreasuryVote is the vote of a signer on a payout. A signer votes only once.
+type TreasuryVote struct {
+	PayoutID  uint  `gorm:"primaryKey;autoIncrement:false"`
+	SignerID  int64 `gorm:"primaryKey;autoIncrement:false"`
+	Approve   bool  `json:"approve"`
+	CreatedAt time.Time
+}
+
+var (
+	treasuryHelpMessage       = "🏦 *Group treasury:*\n`/treasury` 💰 Show the balance and the signers.\n`/treasury propose <amount> <@user|invoice> [<memo>]` 💸 Propose a payout. It is paid when enough signers approve.\n`/treasury log` 📜 Show the last payouts.\n`/treasury setup <quorum> @signer ...` ⚙️ Create the treasury (group owner only). Later changes of the signers need the approval of the quorum."
+	treasuryStatusMessage     = "🏦 *Treasury of %s*\n💰 Balance: %d sat\n✍️ Payouts need %d of %d signers: %s\n⚡️ Deposit: `%s`"
+	treasuryPendingMessage    = "\n⏳ %d proposals wait for approval."
+	treasuryNoTreasury        = "🏦 This group has no treasury. The group owner can create one with `/treasury setup <quorum> @signer ...`."
+	treasuryGroupOnly         = "🏦 Treasuries belong to groups. Use `/treasury` in a group."
+	treasurySetupMessage      = "🏦 Treasury ready. Payouts need %d of %d signers: %s"
+	treasurySetupInvalid      = "🚫 Use `/treasury setup <quorum> @signer ...` with a quorum between 1 and the number of signers. Signers need a wallet with the bot."
+	treasuryOwnerOnly         = "🚫 Only the group owner can set up the treasury."
+	treasuryProposeInvalid    = "🚫 Use `/treasury propose <amount> <@user|invoice> [<memo>]`."
+	treasuryProposalMessage   = "💸 *Payout #%d* proposed by %s\n%d sat to %s%s\n\n✍️ %d of %d approvals"
+	treasuryPaidMessage       = "✅ *Payout #%d* of %d sat to %s was paid."
+	treasuryFailedMessage     = "🚫 *Payout #%d* of %d sat to %s failed: %s"
+	treasuryInFlightMessage   = "⏳ *Payout #%d* of %d sat to %s is in flight."
+	treasuryRejectedMessage   = "🚫 *Payout #%d* of %d sat to %s was rejected."
+	treasuryExpiredMessage    = "⌛️ *Payout #%d* of %d sat to %s expired."
+	treasuryNotSignerMessage  = "Only signers can vote."
+	treasuryVotedMessage      = "You already voted."
+	treasuryLogMessage        = "📜 *Payouts of %s:*\n%s"
+	treasuryLogLineMessage    = "#%d %s %d sat to %s (%s)"
+	treasurySignersLogLine    = "#%d %s signers %d of %s (%s)"
+	treasurySignersProposal   = "⚙️ *Proposal #%d* by %s\nPayouts need %d of %d signers: %s\n\n✍️ %d of %d approvals"
+	treasurySignersApplied    = "✅ *Proposal #%d* applied. Payouts need %d of %d signers: %s"
+	treasurySignersRejected   = "🚫 *Proposal #%d* to change the signers was rejected."
+	treasurySignersExpired    = "⌛️ *Proposal #%d* to change the signers expired."
+	treasurySignersFailed     = "🚫 *Proposal #%d* to change the signers failed: %s"
+	treasuryLogEmptyMessage   = "📜 No payouts yet."
+	treasuryInvoiceRecipient  = "an invoice"
+	treasuryInsufficientFunds = "the treasury has only %d sat"
+)
+
+func (bot *TipBot) loadTreasury(chatID int64) (*Treasury, error) {
+	treasury := &Treasury{}
+	err := bot.DB.Groups.Where("chat_id = ?", chatID).First(treasury).Error
+	return treasury, err
+}
+
+func (bot *TipBot) treasurySigners(chatID int64) ([]TreasurySigner, error) {
+	signers := make([]TreasurySigner, 0)
+	err := bot.DB.Groups.Where("chat_id = ?", chatID).Find(&signers).Error
+	return signers, err
+}
+
+// treasuryWallet returns the bot user pointed to the wallet of treasury.
+func (bot *TipBot) treasuryWallet(treasury *Treasury) (*lnbits.User, error) {
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return nil, err
+	}
+	wallet := lnbits.UserWallet{}
+	if err := bot.DB.Users.Where("owner = ? AND id = ?", me.Name, treasury.WalletID).First(&wallet).Error; err != nil {
+		return nil, err
+	}
+	u := *me
+	u.UseWallet(wallet)
+	return &u, nil
+}
+
+// treasuryWalletName is the name of the wallet of a treasury in the wallets of the bot.
+func treasuryWalletName(chatID int64) string {
+	return fmt.Sprintf("treasury%d", chatID)
+}
+
+func (p TreasuryPayout) recipientStr() string {
+	if p.Recipient == "" {
+		return treasuryInvoiceRecipient
+	}
+	return "@" + p.Recipient
+}
+
+func (bot *TipBot) treasuryHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Chat, treasuryGroupOnly+"\n\n"+treasuryHelpMessage)
+		return ctx, nil
+	}
+	args := strings.Fields(m.Text)
+	if len(args) > 1 {
+		switch strings.ToLower(args[1]) {
+		case "setup":
+			return bot.setupTreasuryHandler(ctx, args[2:])
+		case "propose":
+			return bot.proposeTreasuryPayoutHandler(ctx, args[2:])
+		case "log":
+			return bot.treasuryLogHandler(ctx)
+		case "help":
+			bot.trySendMessage(m.Chat, treasuryHelpMessage)
+			return ctx, nil
+		}
+	}
+	return bot.treasuryStatusHandler(ctx)
+}
+
+func (bot *TipBot) treasuryStatusHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	treasury, err := bot.loadTreasury(m.Chat.ID)
+	if err != nil {
+		bot.trySendMessage(m.Chat, treasuryNoTreasury)
+		return ctx, nil
+	}
+	wallet, err := bot.treasuryWallet(treasury)
+	if err != nil {
+		return ctx, err
+	}
+	balance, err := bot.GetUserBalance(wallet)
+	if err != nil {
+		return ctx, err
+	}
+	signers, err := bot.treasurySigners(treasury.ChatID)
+	if err != nil {
+		return ctx, err
+	}
+	names := make([]string, len(signers))
+	for i, s := range signers {
+		names[i] = "@" + s.Username
+	}
+	msg := fmt.Sprintf(treasuryStatusMessage, str.MarkdownEscape(treasury.Title), balance, treasury.Quorum, len(signers),
+		str.MarkdownEscape(strings.Join(names, ", ")), bot.walletLightningAddress(wallet, treasuryWalletName(treasury.ChatID)))
+	var pending int64
+	bot.DB.Groups.Model(&TreasuryPayout{}).Where("chat_id = ? AND status = ?", treasury.ChatID, TreasuryPayoutProposed).Count(&pending)
+	if pending > 0 {
+		msg += fmt.Sprintf(treasuryPendingMessage, pending)
+	}
+	bot.trySendMessage(m.Chat, msg)
+	return ctx, nil
+}
+
+// setupTreasuryHandler creates the treasury of a group. Once it exists, a new
+// setup is proposed to the signers and applied when the quorum approved it.
+func (bot *TipBot) setupTreasuryHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if !bot.isOwner(m.Chat, user.Telegram) {
+		bot.trySendMessage(m.Chat, treasuryOwnerOnly)
+		return ctx, fmt.Errorf("not owner")
+	}
+	if len(args) < 2 {
+		bot.trySendMessage(m.Chat, treasurySetupInvalid)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	quorum, err := strconv.Atoi(args[0])
+	if err != nil || quorum < 1 || quorum > len(args)-1 {
+		bot.trySendMessage(m.Chat, treasurySetupInvalid)
+		return ctx, fmt.Errorf("invalid quorum")
+	}
+	signers := make([]TreasurySigner, 0, len(args)-1)
+	seen := make(map[int64]bool)
+	for _, arg := range args[1:] {
+		signer, err := GetUserByTelegramUsername(strings.TrimPrefix(arg, "@"), *bot)
+		if err != nil || !strings.HasPrefix(arg, "@") {
+			bot.trySendMessage(m.Chat, treasurySetupInvalid)
+			return ctx, fmt.Errorf("unknown signer %s", arg)
+		}
+		if seen[signer.Telegram.ID] {
+			continue
+		}
+		seen[signer.Telegram.ID] = true
+		signers = append(signers, TreasurySigner{ChatID: m.Chat.ID, TelegramID: signer.Telegram.ID, Username: signer.Telegram.Username})
+	}
+	if quorum > len(signers) {
+		bot.trySendMessage(m.Chat, treasurySetupInvalid)
+		return ctx, fmt.Errorf("quorum above number of signers")
+	}
+
+	lockID := fmt.Sprintf("treasury:%d", m.Chat.ID)
+	mutex.Lock(lockID)
+	defer mutex.Unlock(lockID)
+	treasury, err := bot.loadTreasury(m.Chat.ID)
+	if err == nil {
+		return bot.proposeTreasurySigners(ctx, treasury, quorum, signers)
+	}
+	treasury, err = bot.createTreasury(m.Chat)
+	if err != nil {
+		log.Errorf("[/treasury] could not create treasury of %d: %v", m.Chat.ID, err)
+		bot.trySendMessage(m.Chat, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	if err := bot.setTreasurySigners(treasury, quorum, signers, 0); err != nil {
+		return ctx, err
+	}
+	names := treasurySignerNames(signers)
+	audit.Record(audit.Entry{Actor: user.Name, Action: "treasury.setup", Target: treasuryWalletName(m.Chat.ID), Result: audit.ResultOk,
+		Detail: fmt.Sprintf("%d of %s", quorum, names)})
+	log.Infof("[/treasury] %s set up treasury of %d with %d of %d signers", GetUserStr(user.Telegram), m.Chat.ID, quorum, len(signers))
+	bot.trySendMessage(m.Chat, fmt.Sprintf(treasurySetupMessage, quorum, len(signers), str.MarkdownEscape(names)))
+	return ctx, nil
+}
+
+// proposeTreasurySigners proposes new signers and a new quorum to the current signers.
+func (bot *TipBot) proposeTreasurySigners(ctx intercept.Context, treasury *Treasury, quorum int, signers []TreasurySigner) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	encoded, err := json.Marshal(signers)
+	if err != nil {
+		return ctx, err
+	}
+	proposal := &TreasuryPayout{
+		ChatID:    treasury.ChatID,
+		Kind:      TreasuryKindSigners,
+		Proposer:  GetUserStr(user.Telegram),
+		Quorum:    quorum,
+		Signers:   string(encoded),
+		Status:    TreasuryPayoutProposed,
+		CreatedAt: time.Now(),
+	}
+	if err := bot.DB.Groups.Create(proposal).Error; err != nil {
+		return ctx, err
+	}
+	log.Infof("[/treasury] %s proposed %d of %d signers for treasury of %d", GetUserStr(user.Telegram), quorum, len(signers), treasury.ChatID)
+	text, menu := bot.treasuryPayoutMessage(treasury, proposal)
+	bot.trySendMessage(m.Chat, text, menu)
+	return ctx, nil
+}
+
+// setTreasurySigners replaces the signers and the quorum of treasury. The open
+// proposals except the one with id except are rejected, they were made for the
+// old signers.
+func (bot *TipBot) setTreasurySigners(treasury *Treasury, quorum int, signers []TreasurySigner, except uint) error {
+	treasury.Quorum = quorum
+	if err := bot.DB.Groups.Save(treasury).Error; err != nil {
+		return err
+	}
+	if err := bot.DB.Groups.Where("chat_id = ?", treasury.ChatID).Delete(&TreasurySigner{}).Error; err != nil {
+		return err
+	}
+	if err := bot.DB.Groups.Create(&signers).Error; err != nil {
+		return err
+	}
+	return bot.DB.Groups.Model(&TreasuryPayout{}).Where("chat_id = ? AND status = ? AND id <> ?", treasury.ChatID, TreasuryPayoutProposed, except).
+		Updates(map[string]interface{}{"status": TreasuryPayoutRejected, "resolved_at": time.Now()}).Error
+}
+
+// applyTreasurySigners applies an approved signers proposal.
+func (bot *TipBot) applyTreasurySigners(treasury *Treasury, proposal *TreasuryPayout) error {
+	signers := make([]TreasurySigner, 0)
+	err := json.Unmarshal([]byte(proposal.Signers), &signers)
+	if err == nil {
+		err = bot.setTreasurySigners(treasury, proposal.Quorum, signers, proposal.ID)
+	}
+	entry := audit.Entry{Actor: treasuryWalletName(treasury.ChatID), Action: "treasury.setup", Target: fmt.Sprintf("proposal:%d", proposal.ID),
+		Result: audit.ResultOk, Detail: fmt.Sprintf("%d of %s", proposal.Quorum, treasurySignerNames(signers))}
+	if err != nil {
+		log.Errorf("[treasury] could not apply proposal %d of %d: %v", proposal.ID, treasury.ChatID, err)
+		bot.resolveTreasuryPayout(proposal, TreasuryPayoutFailed)
+		entry.Result, entry.Detail = audit.ResultFailed, err.Error()
+	} else {
+		log.Infof("[treasury] applied proposal %d: %d of %d signers for %d", proposal.ID, proposal.Quorum, len(signers), treasury.ChatID)
+		bot.resolveTreasuryPayout(proposal, TreasuryPayoutApplied)
+	}
+	audit.Record(entry)
+	return err
+}
+
+func treasurySignerNames(signers []TreasurySigner) string {
+	names := make([]string, len(signers))
+	for i, s := range signers {
+		names[i] = "@" + s.Username
+	}
+	return strings.Join(names, ", ")
+}
+
+// proposedSigners returns the signers of a signers proposal.
+func (p TreasuryPayout) proposedSigners() []TreasurySigner {
+	signers := make([]TreasurySigner, 0)
+	json.Unmarshal([]byte(p.Signers), &signers)
+	return signers
+}
+
+// createTreasury creates the wallet of a group treasury. It is one of the wallets
+// of the bot, so that invoices to it reach the webhook.
+func (bot *TipBot) createTreasury(chat *tb.Chat) (*Treasury, error) {
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return nil, err
+	}
+	name := treasuryWalletName(chat.ID)
+	wallet, err := bot.Client.CreateWallet(me.ID, fmt.Sprintf("%s (%s)", name, chat.Title), internal.Configuration.Lnbits.AdminId)
+	if err != nil {
+		return nil, err
+	}
+	w := lnbits.UserWallet{ID: wallet.ID, Owner: me.Name, Name: name, Adminkey: wallet.Adminkey, Inkey: wallet.Inkey, CreatedAt: time.Now()}
+	if err := bot.DB.Users.Create(&w).Error; err != nil {
+		return nil, err
+	}
+	treasury := &Treasury{ChatID: chat.ID, Title: chat.Title, WalletID: wallet.ID, CreatedAt: time.Now()}
+	return treasury, bot.DB.Groups.Create(treasury).Error
+}
+
+func (bot *TipBot) proposeTreasuryPayoutHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	treasury, err := bot.loadTreasury(m.Chat.ID)
+	if err != nil {
+		bot.trySendMessage(m.Chat, treasuryNoTreasury)
+		return ctx, nil
+	}
+	if len(args) < 2 {
+		bot.trySendMessage(m.Chat, treasuryProposeInvalid)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	amount, err := GetAmount(args[0])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Chat, treasuryProposeInvalid)
+		return ctx, fmt.Errorf("invalid amount")
+	}
+	payout := &TreasuryPayout{
+		ChatID:    treasury.ChatID,
+		Proposer:  GetUserStr(user.Telegram),
+		Amount:    amount,
+		Memo:      strings.Join(args[2:], " "),
+		Status:    TreasuryPayoutProposed,
+		CreatedAt: time.Now(),
+	}
+	if strings.HasPrefix(args[1], "@") {
+		recipient, err := GetUserByTelegramUsername(strings.TrimPrefix(args[1], "@"), *bot)
+		if err != nil {
+			bot.trySendMessage(m.Chat, treasuryProposeInvalid)
+			return ctx, err
+		}
+		payout.Recipient = recipient.Telegram.Username
+	} else {
+		invoiceAmount, err := lnbits.InvoiceAmount(args[1])
+		if err != nil || invoiceAmount != amount {
+			bot.trySendMessage(m.Chat, treasuryProposeInvalid)
+			return ctx, fmt.Errorf("invalid invoice")
+		}
+		payout.Invoice = args[1]
+	}
+	if err := bot.DB.Groups.Create(payout).Error; err != nil {
+		return ctx, err
+	}
+	log.Infof("[/treasury] %s proposed payout %d of %d sat in %d", GetUserStr(user.Telegram), payout.ID, amount, treasury.ChatID)
+	text, menu := bot.treasuryPayoutMessage(treasury, payout)
+	bot.trySendMessage(m.Chat, text, menu)
+	return ctx, nil
+}
+
+// treasuryPayoutMessage returns the text and the vote buttons of a proposed payout.
+func (bot *TipBot) treasuryPayoutMessage(treasury *Treasury, payout *TreasuryPayout) (string, *tb.ReplyMarkup) {
+	var approvals int64
+	bot.DB.Groups.Model(&TreasuryVote{}).Where("payout_id = ? AND approve = ?", payout.ID, true).Count(&approvals)
+	memo := ""
+	if payout.Memo != "" {
+		memo = fmt.Sprintf("\n📝 %s", str.MarkdownEscape(payout.Memo))
+	}
+	text := fmt.Sprintf(treasuryProposalMessage, payout.ID, str.MarkdownEscape(payout.Proposer), payout.Amount,
+		str.MarkdownEscape(payout.recipientStr()), memo, approvals, treasury.Quorum)
+	if payout.Kind == TreasuryKindSigners {
+		signers := payout.proposedSigners()
+		text = fmt.Sprintf(treasurySignersProposal, payout.ID, str.MarkdownEscape(payout.Proposer), payout.Quorum, len(signers),
+			str.MarkdownEscape(treasurySignerNames(signers)), approvals, treasury.Quorum)
+	}
+	id := strconv.FormatUint(uint64(payout.ID), 10)
+	menu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	menu.Inline(menu.Row(
+		menu.Data(btnApprovePayout.Text, btnApprovePayout.Unique, id),
+		menu.Data(btnRejectPayout.Text, btnRejectPayout.Unique, id)))
+	return text, menu
+}
+
+func (bot *TipBot) approvePayoutHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.voteTreasuryPayout(ctx, true)
+}
+
+func (bot *TipBot) rejectPayoutHandler(ctx intercept.Context) (intercept.Context, error) {
+	return bot.voteTreasuryPayout(ctx, false)
+}
+
+// voteTreasuryPayout records the vote of a signer. The payout is paid as soon as
+// the quorum approved and rejected once the quorum can't be reached anymore.
+func (bot *TipBot) voteTreasuryPayout(ctx intercept.Context, approve bool) (intercept.Context, error) {
+	c := ctx.Callback()
+	id, err := strconv.ParseUint(ctx.Data(), 10, 64)
+	if err != nil {
+		return ctx, err
+	}
+	payout := &TreasuryPayout{}
+	if err := bot.DB.Groups.Where("id = ?", id).First(payout).Error; err != nil {
+		return ctx, err
+	}
+	lockID := fmt.Sprintf("treasury:%d", payout.ChatID)
+	mutex.Lock(lockID)
+	defer mutex.Unlock(lockID)
+	// reload, the payout could have been resolved while waiting for the lock
+	if err := bot.DB.Groups.Where("id = ?", id).First(payout).Error; err != nil {
+		return ctx, err
+	}
+	if payout.Status != TreasuryPayoutProposed {
+		bot.tryEditMessage(c.Message, bot.treasuryPayoutResult(payout, ""), &tb.ReplyMarkup{})
+		return ctx, nil
+	}
+	treasury, err := bot.loadTreasury(payout.ChatID)
+	if err != nil {
+		return ctx, err
+	}
+	if time.Since(payout.CreatedAt) > treasuryPayoutTTL {
+		bot.resolveTreasuryPayout(payout, TreasuryPayoutExpired)
+		bot.tryEditMessage(c.Message, bot.treasuryPayoutResult(payout, ""), &tb.ReplyMarkup{})
+		return ctx, nil
+	}
+	signers, err := bot.treasurySigners(payout.ChatID)
+	if err != nil {
+		return ctx, err
+	}
+	isSigner := false
+	for _, s := range signers {
+		isSigner = isSigner || s.TelegramID == c.Sender.ID
+	}
+	if !isSigner {
+		ctx.Context = context.WithValue(ctx, "callback_response", treasuryNotSignerMessage)
+		return ctx, fmt.Errorf("%s is not a signer", GetUserStr(c.Sender))
+	}
+	vote := TreasuryVote{PayoutID: payout.ID, SignerID: c.Sender.ID, Approve: approve, CreatedAt: time.Now()}
+	if err := bot.DB.Groups.Create(&vote).Error; err != nil {
+		ctx.Context = context.WithValue(ctx, "callback_response", treasuryVotedMessage)
+		return ctx, err
+	}
+	audit.Record(audit.Entry{Actor: strconv.FormatInt(c.Sender.ID, 10), Action: "treasury.vote", Target: fmt.Sprintf("payout:%d", payout.ID),
+		Amount: payout.Amount, Result: audit.ResultOk, Detail: fmt.Sprintf("approve=%t", approve)})
+
+	var approvals, rejections int64
+	bot.DB.Groups.Model(&TreasuryVote{}).Where("payout_id = ? AND approve = ?", payout.ID, true).Count(&approvals)
+	bot.DB.Groups.Model(&TreasuryVote{}).Where("payout_id = ? AND approve = ?", payout.ID, false).Count(&rejections)
+	switch {
+	case approvals >= int64(treasury.Quorum):
+		reason := ""
+		if payout.Kind == TreasuryKindSigners {
+			err = bot.applyTreasurySigners(treasury, payout)
+		} else {
+			err = bot.payTreasuryPayout(treasury, payout)
+		}
+		if err != nil {
+			reason = err.Error()
+		}
+		bot.tryEditMessage(c.Message, bot.treasuryPayoutResult(payout, reason), &tb.ReplyMarkup{})
+	case int64(len(signers))-rejections < int64(treasury.Quorum):
+		bot.resolveTreasuryPayout(payout, TreasuryPayoutRejected)
+		bot.tryEditMessage(c.Message, bot.treasuryPayoutResult(payout, ""), &tb.ReplyMarkup{})
+	default:
+		text, menu := bot.treasuryPayoutMessage(treasury, payout)
+		bot.tryEditMessage(c.Message, text, menu)
+	}
+	return ctx, nil
+}
+
+func (bot *TipBot) resolveTreasuryPayout(payout *TreasuryPayout, status string) {
+	payout.Status = status
+	payout.ResolvedAt = time.Now()
+	if err := bot.DB.Groups.Save(payout).Error; err != nil {
+		log.Errorf("[treasury] could not save payout %d: %v", payout.ID, err)
+	}
+}
+
+// treasuryPayoutResult returns the message of a resolved payout.
+func (bot *TipBot) treasuryPayoutResult(payout *TreasuryPayout, reason string) string {
+	if payout.Kind == TreasuryKindSigners {
+		switch payout.Status {
+		case TreasuryPayoutApplied:
+			signers := payout.proposedSigners()
+			return fmt.Sprintf(treasurySignersApplied, payout.ID, payout.Quorum, len(signers), str.MarkdownEscape(treasurySignerNames(signers)))
+		case TreasuryPayoutFailed:
+			return fmt.Sprintf(treasurySignersFailed, payout.ID, reason)
+		case TreasuryPayoutExpired:
+			return fmt.Sprintf(treasurySignersExpired, payout.ID)
+		}
+		return fmt.Sprintf(treasurySignersRejected, payout.ID)
+	}
+	recipient := str.MarkdownEscape(payout.recipientStr())
+	switch payout.Status {
+	case TreasuryPayoutPaid:
+		return fmt.Sprintf(treasuryPaidMessage, payout.ID, payout.Amount, recipient)
+	case TreasuryPayoutFailed:
+		return fmt.Sprintf(treasuryFailedMessage, payout.ID, payout.Amount, recipient, reason)
+	case TreasuryPayoutPending:
+		return fmt.Sprintf(treasuryInFlightMessage, payout.ID, payout.Amount, recipient)
+	case TreasuryPayoutExpired:
+		return fmt.Sprintf(treasuryExpiredMessage, payout.ID, payout.Amount, recipient)
+	}
+	return fmt.Sprintf(treasuryRejectedMessage, payout.ID, payout.Amount, recipient)
+}
+
+// payTreasuryPayout pays an approved payout from the treasury wallet. A payout
+// whose payment may still settle stays pending until reconcileTreasuryPayouts
+// found out, so that it is never proposed and paid again.
+func (bot *TipBot) payTreasuryPayout(treasury *Treasury, payout *TreasuryPayout) error {
+	entry := audit.Entry{Actor: treasuryWalletName(treasury.ChatID), Action: "treasury.payout", Target: payout.recipientStr(), Amount: payout.Amount}
+	err := bot.sendTreasuryPayout(treasury, payout)
+	switch {
+	case err == nil:
+		log.Infof("[treasury] paid payout %d of %d sat from %d", payout.ID, payout.Amount, treasury.ChatID)
+		bot.resolveTreasuryPayout(payout, TreasuryPayoutPaid)
+		entry.Result = audit.ResultOk
+		audit.Record(entry)
+	case errors.Is(err, lnbits.ErrPaymentPending):
+		log.Warnf("[treasury] payout %d of %d is pending: %v", payout.ID, treasury.ChatID, err)
+		payout.Status = TreasuryPayoutPending
+		if err := bot.DB.Groups.Save(payout).Error; err != nil {
+			log.Errorf("[treasury] could not save payout %d: %v", payout.ID, err)
+		}
+	default:
+		log.Errorf("[treasury] payout %d of %d failed: %v", payout.ID, treasury.ChatID, err)
+		bot.resolveTreasuryPayout(payout, TreasuryPayoutFailed)
+		entry.Result, entry.Detail = audit.ResultFailed, err.Error()
+		audit.Record(entry)
+	}
+	return err
+}
+
+// treasuryPayoutKey is the idempotency key of the transaction of a payout to a user.
+func treasuryPayoutKey(payout *TreasuryPayout) string {
+	return fmt.Sprintf("treasury-payout:%d", payout.ID)
+}
+
+// sendTreasuryPayout pays an invoice or books a transaction to a user of the bot.
+func (bot *TipBot) sendTreasuryPayout(treasury *Treasury, payout *TreasuryPayout) error {
+	wallet, err := bot.treasuryWallet(treasury)
+	if err != nil {
+		return err
+	}
+	if payout.Invoice == "" {
+		to, err := GetUserByTelegramUsername(payout.Recipient, *bot)
+		if err != nil {
+			return err
+		}
+		t := NewTransaction(bot, wallet, to, payout.Amount, TransactionType("treasury payout"),
+			TransactionIdempotencyKey(treasuryPayoutKey(payout)))
+		t.ChatID, t.ChatName = treasury.ChatID, treasury.Title
+		t.FromUser = treasuryWalletName(treasury.ChatID)
+		t.Memo = fmt.Sprintf("🏦 Payout from %s %s", treasury.Title, payout.Memo)
+		if success, err := t.Send(); !success {
+			if err == nil {
+				err = fmt.Errorf("transaction %s failed", t.IdempotencyKey)
+			}
+			return err
+		}
+		return nil
+	}
+	// invoices without an amount could drain the treasury
+	if amount, err := lnbits.InvoiceAmount(payout.Invoice); err != nil || amount != payout.Amount {
+		return fmt.Errorf("invoice amount does not match the payout")
+	}
+	balance, err := bot.GetUserBalance(wallet)
+	if err != nil {
+		return err
+	}
+	if balance < payout.Amount {
+		return fmt.Errorf(treasuryInsufficientFunds, balance)
+	}
+	_, err = wallet.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: payout.Invoice, Reference: treasuryPayoutKey(payout)}, bot.Client)
+	return err
+}
+
+// reconcileTreasuryPayouts resolves payouts whose payment was pending. Payouts
+// to users follow their transaction, invoices are checked with the backend.
+// If the state can't be found out, the payout stays pending.
+func (bot *TipBot) reconcileTreasuryPayouts() {
+	var pending []*TreasuryPayout
+	if err := bot.DB.Groups.Where("status = ?", TreasuryPayoutPending).Find(&pending).Error; err != nil {
+		log.Errorf("[reconcileTreasuryPayouts] %v", err)
+		return
+	}
+	for _, payout := range pending {
+		status, err := bot.treasuryPayoutStatus(payout)
+		if err != nil {
+			log.Errorf("[reconcileTreasuryPayouts] Could not check payout %d: %v", payout.ID, err)
+			continue
+		}
+		if status == TreasuryPayoutPending {
+			continue
+		}
+		entry := audit.Entry{Actor: treasuryWalletName(payout.ChatID), Action: "treasury.payout", Target: payout.recipientStr(), Amount: payout.Amount, Result: audit.ResultOk}
+		reason := ""
+		if status == TreasuryPayoutFailed {
+			reason = "the payment did not go through"
+			entry.Result, entry.Detail = audit.ResultFailed, reason
+		}
+		audit.Record(entry)
+		bot.resolveTreasuryPayout(payout, status)
+		log.Infof("[reconcileTreasuryPayouts] Payout %d of %d is %s.", payout.ID, payout.ChatID, status)
+		bot.trySendMessage(&tb.Chat{ID: payout.ChatID}, bot.treasuryPayoutResult(payout, reason))
+	}
+}
+
+// treasuryPayoutStatus returns the state of the payment of a pending payout.
+func (bot *TipBot) treasuryPayoutStatus(payout *TreasuryPayout) (string, error) {
+	if payout.Invoice == "" {
+		t, err := bot.getTransactionByIdempotencyKey(treasuryPayoutKey(payout))
+		if err != nil {
+			return "", err
+		}
+		switch t.Status {
+		case TransactionStatusPaid:
+			return TreasuryPayoutPaid, nil
+		case TransactionStatusFailed:
+			return TreasuryPayoutFailed, nil
+		}
+		return TreasuryPayoutPending, nil
+	}
+	treasury, err := bot.loadTreasury(payout.ChatID)
+	if err != nil {
+		return "", err
+	}
+	wallet, err := bot.treasuryWallet(treasury)
+	if err != nil {
+		return "", err
+	}
+	hash, err := lnbits.InvoicePaymentHash(payout.Invoice)
+	if err != nil {
+		return "", err
+	}
+	payment, err := bot.Client.Payment(*wallet.Wallet, hash)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case payment.Paid:
+		return TreasuryPayoutPaid, nil
+	case payment.Details.Pending:
+		return TreasuryPayoutPending, nil
+	}
+	return TreasuryPayoutFailed, nil
+}
+
+func (bot *TipBot) treasuryLogHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	treasury, err := bot.loadTreasury(m.Chat.ID)
+	if err != nil {
+		bot.trySendMessage(m.Chat, treasuryNoTreasury)
+		return ctx, nil
+	}
+	payouts := make([]TreasuryPayout, 0)
+	if err := bot.DB.Groups.Where("chat_id = ?", treasury.ChatID).Order("id desc").Limit(10).Find(&payouts).Error; err != nil {
+		return ctx, err
+	}
+	if len(payouts) == 0 {
+		bot.trySendMessage(m.Chat, treasuryLogEmptyMessage)
+		return ctx, nil
+	}
+	lines := make([]string, len(payouts))
+	for i, p := range payouts {
+		if p.Kind == TreasuryKindSigners {
+			lines[i] = fmt.Sprintf(treasurySignersLogLine, p.ID, p.Status, p.Quorum, str.MarkdownEscape(treasurySignerNames(p.proposedSigners())), p.CreatedAt.UTC().Format("2006-01-02"))
+			continue
+		}
+		lines[i] = fmt.Sprintf(treasuryLogLineMessage, p.ID, p.Status, p.Amount, str.MarkdownEscape(p.recipientStr()), p.CreatedAt.UTC().Format("2006-01-02"))
+	}
+	bot.trySendMessage(m.Chat, fmt.Sprintf(treasuryLogMessage, str.MarkdownEscape(treasury.Title), strings.Join(lines, "\n")))
+	return ctx, nil
+}
@@ -0,0 +1,111 @@
+package telegram
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestTipBot_applyTreasurySigners(t *testing.T) {
+	bot := newTestBot(t, lnbits.NewFakeBackend())
+	if err := bot.DB.Groups.AutoMigrate(&Treasury{}, &TreasurySigner{}, &TreasuryPayout{}, &TreasuryVote{}); err != nil {
+		t.Fatal(err)
+	}
+	treasury := &Treasury{ChatID: -1, Title: "group", CreatedAt: time.Now()}
+	if err := bot.DB.Groups.Create(treasury).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.setTreasurySigners(treasury, 1, []TreasurySigner{{ChatID: -1, TelegramID: 1, Username: "alice"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	payout := &TreasuryPayout{ChatID: -1, Amount: 100, Recipient: "bob", Status: TreasuryPayoutProposed, CreatedAt: time.Now()}
+	signers, _ := json.Marshal([]TreasurySigner{{ChatID: -1, TelegramID: 2, Username: "bob"}, {ChatID: -1, TelegramID: 3, Username: "carol"}})
+	proposal := &TreasuryPayout{ChatID: -1, Kind: TreasuryKindSigners, Quorum: 2, Signers: string(signers), Status: TreasuryPayoutProposed, CreatedAt: time.Now()}
+	for _, p := range []*TreasuryPayout{payout, proposal} {
+		if err := bot.DB.Groups.Create(p).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := bot.applyTreasurySigners(treasury, proposal); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ := bot.loadTreasury(-1)
+	current, _ := bot.treasurySigners(-1)
+	if loaded.Quorum != 2 || treasurySignerNames(current) != "@bob, @carol" {
+		t.Errorf("treasury needs %d of %s, want 2 of @bob, @carol", loaded.Quorum, treasurySignerNames(current))
+	}
+	if proposal.Status != TreasuryPayoutApplied {
+		t.Errorf("proposal status = %s, want %s", proposal.Status, TreasuryPayoutApplied)
+	}
+	// payouts proposed to the old signers are rejected
+	bot.DB.Groups.First(payout, payout.ID)
+	if payout.Status != TreasuryPayoutRejected {
+		t.Errorf("open payout status = %s, want %s", payout.Status, TreasuryPayoutRejected)
+	}
+}
+
+func TestTipBot_payTreasuryPayout(t *testing.T) {
+	fake := lnbits.NewFakeBackend()
+	bot := newTestBot(t, fake)
+	if err := bot.DB.Groups.AutoMigrate(&Treasury{}, &TreasurySigner{}, &TreasuryPayout{}, &TreasuryVote{}); err != nil {
+		t.Fatal(err)
+	}
+	newTestUser(t, bot, fake, 1, 0)
+	bob := newTestUser(t, bot, fake, 2, 0)
+	treasury, err := bot.createTreasury(&tb.Chat{ID: -1, Title: "group"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet, err := bot.treasuryWallet(treasury)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.Deposit(*wallet.Wallet, 100, "funding"); err != nil {
+		t.Fatal(err)
+	}
+
+	// payouts to users are booked as transactions
+	payout := &TreasuryPayout{ChatID: -1, Amount: 40, Recipient: bob.Telegram.Username, Status: TreasuryPayoutProposed, CreatedAt: time.Now()}
+	if err := bot.DB.Groups.Create(payout).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.payTreasuryPayout(treasury, payout); err != nil {
+		t.Fatal(err)
+	}
+	if payout.Status != TreasuryPayoutPaid || balanceOf(t, bot, bob) != 40 {
+		t.Errorf("payout is %s and bob has %d sat, want paid and 40 sat", payout.Status, balanceOf(t, bot, bob))
+	}
+	if tx, err := bot.getTransactionByIdempotencyKey(treasuryPayoutKey(payout)); err != nil || tx.Status != TransactionStatusPaid {
+		t.Errorf("transaction of the payout = %+v, %v", tx, err)
+	}
+
+	// a pending invoice payout is resolved once the payment settled
+	invoice, err := bob.Wallet.Invoice(lnbits.InvoiceParams{Amount: 30}, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settled := &TreasuryPayout{ChatID: -1, Amount: 30, Invoice: invoice.PaymentRequest, Status: TreasuryPayoutPending, CreatedAt: time.Now()}
+	unknown := &TreasuryPayout{ChatID: -1, Amount: 10, Recipient: bob.Telegram.Username, Status: TreasuryPayoutPending, CreatedAt: time.Now()}
+	for _, p := range []*TreasuryPayout{settled, unknown} {
+		if err := bot.DB.Groups.Create(p).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := wallet.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}, fake); err != nil {
+		t.Fatal(err)
+	}
+	bot.reconcileTreasuryPayouts()
+	bot.DB.Groups.First(settled, settled.ID)
+	bot.DB.Groups.First(unknown, unknown.ID)
+	if settled.Status != TreasuryPayoutPaid {
+		t.Errorf("settled payout is %s, want %s", settled.Status, TreasuryPayoutPaid)
+	}
+	// without a transaction, the state of the payout is unknown
+	if unknown.Status != TreasuryPayoutPending {
+		t.Errorf("unknown payout is %s, want %s", unknown.Status, TreasuryPayoutPending)
+	}
+}
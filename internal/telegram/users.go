@@ -62,6 +62,9 @@ func appendUinqueUsersToSlice(slice []*tb.User, i *tb.User) []*tb.User {
 }
 
 func (bot *TipBot) GetUserBalanceCached(user *lnbits.User) (amount int64, err error) {
+	if user.Wallet != nil && user.Wallet.Detached {
+		return bot.GetUserBalance(user)
+	}
 	u, err := bot.Cache.Get(fmt.Sprintf("%s_balance", user.Name))
 	if err != nil {
 		return bot.GetUserBalance(user)
@@ -89,6 +92,10 @@ func (bot *TipBot) GetUserBalance(user *lnbits.User) (amount int64, err error) {
 	// msat to sat
 	amount = int64(wallet.Balance) / 1000
 	log.Debugf("[GetUserBalance] %s's balance: %d sat\n", GetUserStr(user.Telegram), amount)
+	if user.Wallet.Detached {
+		// the cache holds the balance of the active wallet
+		return
+	}
 
 	// update user balance in cache
 	bot.Cache.Set(
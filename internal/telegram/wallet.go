@@ -0,0 +1,327 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/apikeys"
+	"github.com/LightningTipBot/LightningTipBot/internal/audit"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/lndhub/credentials"
+	"github.com/LightningTipBot/LightningTipBot/internal/runtime/mutex"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+)
+
+// retiredWalletGracePeriod is how long a retired wallet stays open. Payments to
+// its old invoices are swept to the current wallet until it is closed.
+const retiredWalletGracePeriod = 7 * 24 * time.Hour
+
+const sweepRetiredWalletsInterval = 10 * time.Minute
+
+// RetiredWallet is a wallet that was replaced by /wallet rotate. The keys are
+// kept until the wallet is closed after retiredWalletGracePeriod, then the wallet
+// is deleted and its payments are archived to show the transaction history.
+type RetiredWallet struct {
+	ID         string    `gorm:"primarykey"`
+	Owner      string    `gorm:"index"` // name of the lnbits.User
+	Inkey      string    `json:"-"`
+	Adminkey   string    `json:"-"`
+	ReplacedBy string    `gorm:"index"` // id of the new wallet
+	RetiredAt  time.Time `json:"retired_at"`
+	ClosedAt   time.Time `json:"closed_at"`
+	Payments   string    `json:"-"` // json of the payments when the wallet was closed
+}
+
+func (w RetiredWallet) wallet() lnbits.Wallet {
+	return lnbits.Wallet{ID: w.ID, Adminkey: w.Adminkey, Inkey: w.Inkey, Detached: true}
+}
+
+// RotateWallet moves the funds of user to a new wallet and points the user to it.
+// All LndHub credentials and API keys of the user are revoked. Funds that still
+// arrive on the old wallet are swept until it is closed, see sweepRetiredWallet.
+// It returns the amount that was moved.
+func (bot *TipBot) RotateWallet(user *lnbits.User) (int64, error) {
+	if user.Wallet == nil {
+		return 0, fmt.Errorf("user has no wallet")
+	}
+	old := *user.Wallet
+	from := *user
+	from.Wallet = &old
+
+	// no transfer or payment can run on the old wallet until the user points to the new one
+	unlock := lockWallets(&from)
+	defer unlock()
+	spendLock := spendLockID(user.Name)
+	mutex.Lock(spendLock)
+	defer mutex.Unlock(spendLock)
+
+	balance, err := bot.GetUserBalance(&from)
+	if err != nil {
+		return 0, err
+	}
+	wallet, err := bot.Client.CreateWallet(user.ID, old.Name, internal.Configuration.Lnbits.AdminId)
+	if err != nil {
+		return 0, fmt.Errorf("could not create wallet: %w", err)
+	}
+	to := *user
+	to.Wallet = &wallet
+	// the new wallet is only used once the user points to it
+	discard := func() {
+		if err := bot.Client.DeleteWallet(wallet); err != nil {
+			log.Errorf("[RotateWallet] could not delete unused wallet %s: %v", wallet.ID, err)
+		}
+	}
+	if balance > 0 {
+		// moving funds between wallets of the same user is not spending
+		unguarded := bot.withoutSpendingLimits()
+		t := NewTransaction(unguarded, &from, &to, balance, TransactionType("rotate"))
+		t.Memo = "Wallet rotation"
+		t.Status = TransactionStatusPending
+		if err := t.save(); err != nil {
+			discard()
+			return 0, err
+		}
+		success, err := t.SendTransaction(unguarded, &from, &to, balance, t.Memo)
+		t.Success = success
+		if success {
+			t.Status = TransactionStatusPaid
+		} else if !errors.Is(err, lnbits.ErrPaymentPending) {
+			t.Status = TransactionStatusFailed
+		}
+		t.save()
+		switch {
+		case errors.Is(err, lnbits.ErrPaymentPending):
+			// the funds may arrive in the new wallet, so the rotation has to go on.
+			// If the transfer fails, the sweeper moves them later.
+			log.Warnf("[RotateWallet] transfer from wallet %s to %s is pending: %v", old.ID, wallet.ID, err)
+		case !success:
+			discard()
+			return 0, fmt.Errorf("could not move funds: %w", err)
+		}
+	}
+
+	user.Wallet = &wallet
+	if err := UpdateUserRecord(user, *bot); err != nil {
+		// the funds are in the new wallet, this has to be fixed by hand
+		log.Errorf("[RotateWallet] could not point %s to wallet %s: %v", GetUserStr(user.Telegram), wallet.ID, err)
+		return balance, err
+	}
+	retired := RetiredWallet{ID: old.ID, Owner: user.Name, Inkey: old.Inkey, Adminkey: old.Adminkey, ReplacedBy: wallet.ID, RetiredAt: time.Now()}
+	if err := bot.DB.Users.Create(&retired).Error; err != nil {
+		log.Errorf("[RotateWallet] could not retire wallet %s: %v", old.ID, err)
+	}
+	// the wallet keeps its name in /wallets
+	if err := bot.DB.Users.Model(&lnbits.UserWallet{}).Where("id = ?", old.ID).
+		Updates(map[string]interface{}{"id": wallet.ID, "adminkey": wallet.Adminkey, "inkey": wallet.Inkey}).Error; err != nil {
+		log.Errorf("[RotateWallet] could not rename wallet %s: %v", old.ID, err)
+	}
+	// the spending limits continue with the new wallet
+	if err := bot.DB.Transactions.Model(&Spend{}).Where("wallet_id = ?", old.ID).Update("wallet_id", wallet.ID).Error; err != nil {
+		log.Errorf("[RotateWallet] could not move spends of wallet %s: %v", old.ID, err)
+	}
+	for _, name := range []string{user.Name, credentials.WalletName(user.Name, old.ID)} {
+		if err := credentials.Revoke(bot.DB.Users, name); err != nil {
+			log.Errorf("[RotateWallet] could not revoke LndHub credentials of %s: %v", GetUserStr(user.Telegram), err)
+		}
+	}
+	if err := apikeys.RevokeAll(bot.DB.Users, user.Name); err != nil {
+		log.Errorf("[RotateWallet] could not revoke api keys of %s: %v", GetUserStr(user.Telegram), err)
+	}
+	bot.GetUserBalance(user)
+	audit.Record(audit.Entry{Actor: user.Name, Action: "wallet.rotate", Target: wallet.ID, Amount: balance, Result: audit.ResultOk, Detail: fmt.Sprintf("from wallet %s", old.ID)})
+	log.Infof("[RotateWallet] %s rotated wallet %s to %s (%d sat)", GetUserStr(user.Telegram), old.ID, wallet.ID, balance)
+	return balance, nil
+}
+
+// walletReplaced reports whether the wallet of u was retired. If so, u is
+// pointed to its current wallet.
+func (bot *TipBot) walletReplaced(u *lnbits.User) bool {
+	if u == nil || u.Wallet == nil {
+		return false
+	}
+	var count int64
+	if bot.DB.Users.Model(&RetiredWallet{}).Where("id = ?", u.Wallet.ID).Count(&count); count == 0 {
+		return false
+	}
+	current := &lnbits.User{}
+	if err := bot.DB.Users.Where("name = ?", u.Name).First(current).Error; err != nil || current.Wallet == nil {
+		log.Errorf("[walletReplaced] could not reload %s: %v", u.Name, err)
+		return false
+	}
+	u.Wallet = current.Wallet
+	return true
+}
+
+// UserPayments returns the payments of the wallet of user and of all wallets
+// it replaced, newest first.
+func (bot *TipBot) UserPayments(user *lnbits.User) (lnbits.Payments, error) {
+	payments, err := bot.Client.Payments(*user.Wallet)
+	if err != nil {
+		return nil, err
+	}
+	var retired []RetiredWallet
+	bot.DB.Users.Where("owner = ?", user.Name).Find(&retired)
+	if len(retired) == 0 {
+		return payments, nil
+	}
+	// follow the rotations back from the current wallet
+	for id := user.Wallet.ID; ; {
+		found := false
+		for _, w := range retired {
+			if w.ReplacedBy != id {
+				continue
+			}
+			p, err := bot.retiredWalletPayments(w)
+			if err != nil {
+				log.Warnf("[UserPayments] could not load payments of retired wallet %s: %v", w.ID, err)
+			}
+			payments = append(payments, p...)
+			id, found = w.ID, true
+			break
+		}
+		if !found {
+			break
+		}
+	}
+	sort.SliceStable(payments, func(i, j int) bool { return payments[i].Time > payments[j].Time })
+	return payments, nil
+}
+
+// retiredWalletPayments returns the payments of a retired wallet, from the
+// archive if it was closed.
+func (bot *TipBot) retiredWalletPayments(w RetiredWallet) (lnbits.Payments, error) {
+	if w.ClosedAt.IsZero() {
+		return bot.Client.Payments(w.wallet())
+	}
+	payments := lnbits.Payments{}
+	if len(w.Payments) == 0 {
+		return payments, nil
+	}
+	err := json.Unmarshal([]byte(w.Payments), &payments)
+	return payments, err
+}
+
+// sweepRetiredWallet moves funds that arrived on a retired wallet, for example
+// for one of its old invoices, to the current wallet of the owner. After the
+// grace period the wallet is closed: its payments are archived, it is deleted
+// and its keys are dropped, so leaked keys can't be used anymore.
+func (bot *TipBot) sweepRetiredWallet(retired RetiredWallet) error {
+	owner := &lnbits.User{}
+	if err := bot.DB.Users.Where("name = ?", retired.Owner).First(owner).Error; err != nil || owner.Wallet == nil {
+		return fmt.Errorf("could not load owner %s: %v", retired.Owner, err)
+	}
+	old := retired.wallet()
+	from, to := *owner, *owner
+	from.Wallet = &old
+
+	unlock := lockWallets(&from, &to)
+	defer unlock()
+	spendLock := fmt.Sprintf("spend:%s", old.ID)
+	mutex.Lock(spendLock)
+	defer mutex.Unlock(spendLock)
+
+	info, err := bot.Client.Info(old)
+	if err != nil {
+		return err
+	}
+	if balance := int64(info.Balance) / 1000; balance > 0 {
+		// moving funds between wallets of the same user is not spending
+		unguarded := bot.withoutSpendingLimits()
+		t := NewTransaction(unguarded, &from, &to, balance, TransactionType("sweep"))
+		t.Memo = "Retired wallet sweep"
+		t.Status = TransactionStatusPending
+		if err := t.save(); err != nil {
+			return err
+		}
+		success, err := t.SendTransaction(unguarded, &from, &to, balance, t.Memo)
+		t.Success = success
+		if success {
+			t.Status = TransactionStatusPaid
+		} else if !errors.Is(err, lnbits.ErrPaymentPending) {
+			t.Status = TransactionStatusFailed
+		}
+		t.save()
+		if !success {
+			return fmt.Errorf("could not sweep %d sat: %w", balance, err)
+		}
+		audit.Record(audit.Entry{Actor: retired.Owner, Action: "wallet.sweep", Target: owner.Wallet.ID, Amount: balance, Result: audit.ResultOk, Detail: fmt.Sprintf("from retired wallet %s", old.ID)})
+		log.Infof("[sweepRetiredWallet] moved %d sat of %s from retired wallet %s to %s", balance, GetUserStr(owner.Telegram), old.ID, owner.Wallet.ID)
+		// the next sweep checks that the wallet is empty before closing it
+		return nil
+	}
+	if time.Since(retired.RetiredAt) < retiredWalletGracePeriod {
+		return nil
+	}
+	payments, err := bot.Client.Payments(old)
+	if err != nil {
+		return err
+	}
+	archive, err := json.Marshal(payments)
+	if err != nil {
+		return err
+	}
+	if err := bot.Client.DeleteWallet(old); err != nil {
+		return fmt.Errorf("could not delete wallet: %w", err)
+	}
+	err = bot.DB.Users.Model(&RetiredWallet{}).Where("id = ?", retired.ID).Updates(map[string]interface{}{
+		"inkey": "", "adminkey": "", "payments": string(archive), "closed_at": time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	log.Infof("[sweepRetiredWallet] closed retired wallet %s of %s", old.ID, GetUserStr(owner.Telegram))
+	return nil
+}
+
+// sweepRetiredWallets sweeps all retired wallets that are not closed yet.
+func (bot *TipBot) sweepRetiredWallets() {
+	var retired []RetiredWallet
+	if err := bot.DB.Users.Where("closed_at IS NULL OR closed_at = ?", time.Time{}).Find(&retired).Error; err != nil {
+		log.Errorf("[sweepRetiredWallets] could not load retired wallets: %v", err)
+		return
+	}
+	for _, w := range retired {
+		if err := bot.sweepRetiredWallet(w); err != nil {
+			log.Errorf("[sweepRetiredWallets] could not sweep retired wallet %s: %v", w.ID, err)
+		}
+	}
+}
+
+// startRetiredWalletSweeper sweeps the retired wallets periodically.
+func (bot *TipBot) startRetiredWalletSweeper() {
+	go func() {
+		for range time.Tick(sweepRetiredWalletsInterval) {
+			bot.sweepRetiredWallets()
+		}
+	}()
+}
+
+var (
+	walletHelpMessage    = "⚙️ *Wallet commands:*\n`/wallet rotate` 🔄 Move your funds to a new wallet with new keys. Use this if your keys leaked. Your LndHub link and API keys stop working, your Lightning address stays the same."
+	walletRotatedMessage = "🔄 *Wallet rotated.* %d sat were moved to your new wallet. Use /link and /api to connect your apps again."
+	walletRotateError    = "🚫 Couldn't rotate your wallet. Please try again later."
+)
+
+func (bot *TipBot) walletHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	args := strings.Fields(m.Text)
+	if len(args) < 2 || strings.ToLower(args[1]) != "rotate" {
+		bot.trySendMessage(m.Sender, walletHelpMessage)
+		return ctx, nil
+	}
+	user := LoadUser(ctx)
+	moved, err := bot.RotateWallet(user)
+	if err != nil {
+		log.Errorf("[/wallet] could not rotate wallet of %s: %v", GetUserStr(user.Telegram), err)
+		bot.trySendMessage(m.Sender, walletRotateError)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(walletRotatedMessage, moved))
+	return ctx, nil
+}
@@ -0,0 +1,98 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal/apikeys"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/lndhub/credentials"
+)
+
+func TestTipBot_sweepRetiredWallet(t *testing.T) {
+	fake := lnbits.NewFakeBackend()
+	bot := newTestBot(t, fake)
+	alice := newTestUser(t, bot, fake, 1, 100)
+	old := *alice.Wallet
+	credential, err := credentials.Ensure(bot.DB.Users, alice.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, refresh, err := credentials.Issue(bot.DB.Users, alice.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secret, err := apikeys.Create(bot.DB.Users, alice.Name, "test", []apikeys.Scope{apikeys.ScopeRead}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if moved, err := bot.RotateWallet(alice); moved != 100 || err != nil {
+		t.Fatalf("RotateWallet() = %d, %v", moved, err)
+	}
+	// the old credentials and keys no longer work
+	if _, err := credentials.Login(bot.DB.Users, credential.Login, credential.Password); err == nil {
+		t.Error("old LndHub login still works")
+	}
+	if _, err := credentials.Authenticate(bot.DB.Users, access); err == nil {
+		t.Error("old LndHub access token still works")
+	}
+	if _, _, _, err := credentials.Refresh(bot.DB.Users, refresh); err == nil {
+		t.Error("old LndHub refresh token still works")
+	}
+	if _, err := apikeys.Authenticate(bot.DB.Users, secret); err == nil {
+		t.Error("old api key still works")
+	}
+	// an old invoice is paid after the rotation
+	if err := fake.Deposit(old, 40, "late payment"); err != nil {
+		t.Fatal(err)
+	}
+	retired := RetiredWallet{}
+	if err := bot.DB.Users.First(&retired, "id = ?", old.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.sweepRetiredWallet(retired); err != nil {
+		t.Fatal(err)
+	}
+	if got := balanceOf(t, bot, alice); got != 140 {
+		t.Errorf("balance of alice = %d, want 140", got)
+	}
+	if info, _ := fake.Info(old); info.Balance != 0 {
+		t.Errorf("retired wallet kept %d msat", info.Balance)
+	}
+
+	// the wallet stays open during the grace period
+	if err := bot.sweepRetiredWallet(retired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.Info(old); err != nil {
+		t.Fatalf("retired wallet closed during the grace period: %v", err)
+	}
+
+	retired.RetiredAt = time.Now().Add(-retiredWalletGracePeriod)
+	if err := bot.sweepRetiredWallet(retired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.Info(old); err == nil {
+		t.Error("keys of the closed wallet still work")
+	}
+	closed := RetiredWallet{}
+	bot.DB.Users.First(&closed, "id = ?", old.ID)
+	if closed.ClosedAt.IsZero() || closed.Inkey != "" || closed.Adminkey != "" {
+		t.Errorf("closed wallet = %+v, want closed without keys", closed)
+	}
+	payments, err := bot.UserPayments(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived := 0
+	for _, p := range payments {
+		if p.WalletID == old.ID {
+			archived++
+		}
+	}
+	// deposit, rotation, late payment and sweep
+	if archived != 4 {
+		t.Errorf("%d payments of the closed wallet in the history, want 4", archived)
+	}
+}
@@ -0,0 +1,385 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/LightningTipBot/LightningTipBot/internal"
+	"github.com/LightningTipBot/LightningTipBot/internal/lnbits"
+	"github.com/LightningTipBot/LightningTipBot/internal/lndhub/credentials"
+	"github.com/LightningTipBot/LightningTipBot/internal/telegram/intercept"
+	lnurl "github.com/fiatjaf/go-lnurl"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// maxUserWallets is the number of wallets a user can have.
+const maxUserWallets = 10
+
+var walletNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,15}$`)
+
+var (
+	walletsHelpMessage    = "⚙️ *Wallet commands:*\n`/wallets add <name>` ✅ Create a new wallet.\n`/wallets use <name>` 👉 Switch your active wallet. It receives your tips and pays from /send, /pay and /tip.\n`/wallets rename <name> <new name>` ✏️ Rename a wallet.\n`/wallets move <amount> <from> <to>` 🔀 Move sats between your wallets, free and instant. Moves count towards your spending limits.\n`/wallets link <name> [rotate]` 🔗 Connect an app to a wallet with LndHub.\n`/wallets lnurl <name>` ⚡️ Show the LNURL of a wallet.\n`/balance <name>` and `/transactions wallet:<name>` show a single wallet."
+	walletsListMessage    = "👛 *Your wallets:*\n%s"
+	walletsLineMessage    = "%s `%s` %d sat\n      `%s`"
+	walletsCreatedMessage = "✅ Wallet `%s` created. Receive to `%s` or switch to it with `/wallets use %s`."
+	walletsActiveMessage  = "👉 `%s` is now your active wallet."
+	walletsRenamedMessage = "✏️ Wallet `%s` renamed to `%s`."
+	walletsBalanceMessage = "👛 *Wallet* `%s`: %d sat"
+	walletsMovedMessage   = "🔀 Moved %d sat from `%s` to `%s`."
+	walletsInvalidName    = "🚫 Wallet names have up to 16 lowercase letters, digits and dashes."
+	walletsNameTaken      = "🚫 You already have a wallet called `%s`."
+	walletsTooMany        = "🚫 You can't have more than %d wallets."
+	walletsNotFound       = "🚫 You have no wallet called `%s`."
+	walletsMoveInvalid    = "🚫 Invalid amount or wallets."
+	walletsMoveError      = "🚫 Couldn't move your sats. Please try again later."
+	walletsError          = "🚫 Something went wrong. Please try again later."
+)
+
+// userWallets returns the wallets of user, oldest first. The first time, the
+// active wallet of the user is added as "main".
+func (bot *TipBot) userWallets(user *lnbits.User) ([]lnbits.UserWallet, error) {
+	wallets := make([]lnbits.UserWallet, 0)
+	if err := bot.DB.Users.Where("owner = ?", user.Name).Order("created_at").Find(&wallets).Error; err != nil {
+		return nil, err
+	}
+	if user.Wallet == nil || user.Wallet.Detached {
+		return wallets, nil
+	}
+	for _, w := range wallets {
+		if w.ID == user.Wallet.ID {
+			return wallets, nil
+		}
+	}
+	main := lnbits.UserWallet{
+		ID:        user.Wallet.ID,
+		Owner:     user.Name,
+		Name:      "main",
+		Adminkey:  user.Wallet.Adminkey,
+		Inkey:     user.Wallet.Inkey,
+		CreatedAt: user.CreatedAt,
+	}
+	for _, w := range wallets {
+		if w.Name == main.Name {
+			main.Name = fmt.Sprintf("main-%.4s", main.ID)
+		}
+	}
+	if err := bot.DB.Users.Create(&main).Error; err != nil {
+		return nil, err
+	}
+	return append([]lnbits.UserWallet{main}, wallets...), nil
+}
+
+// findUserWallet returns the wallet of user with name.
+func (bot *TipBot) findUserWallet(user *lnbits.User, name string) (lnbits.UserWallet, error) {
+	wallets, err := bot.userWallets(user)
+	if err != nil {
+		return lnbits.UserWallet{}, err
+	}
+	for _, w := range wallets {
+		if w.Name == strings.ToLower(name) {
+			return w, nil
+		}
+	}
+	return lnbits.UserWallet{}, fmt.Errorf("wallet %s not found", name)
+}
+
+// selectWallet returns user with the wallet named in the second word of the
+// message. Without a name, user is returned as it is.
+func (bot *TipBot) selectWallet(ctx intercept.Context, user *lnbits.User) (*lnbits.User, error) {
+	args := strings.Fields(ctx.Message().Text)
+	if len(args) < 2 {
+		return user, nil
+	}
+	return bot.selectWalletByName(ctx, user, args[1])
+}
+
+// selectWalletByName returns user pointed to the wallet called name.
+func (bot *TipBot) selectWalletByName(ctx intercept.Context, user *lnbits.User, name string) (*lnbits.User, error) {
+	wallet, err := bot.findUserWallet(user, name)
+	if err != nil {
+		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(walletsNotFound, name))
+		return nil, err
+	}
+	selected := *user
+	selected.UseWallet(wallet)
+	return &selected, nil
+}
+
+// walletLightningAddress returns the Lightning address that pays to the wallet name of user.
+func (bot *TipBot) walletLightningAddress(user *lnbits.User, name string) string {
+	lnaddr, _ := bot.UserGetLightningAddress(user)
+	return strings.Replace(lnaddr, "@", "+"+name+"@", 1)
+}
+
+// CreateUserWallet adds a wallet with name to user.
+func (bot *TipBot) CreateUserWallet(user *lnbits.User, name string) (lnbits.UserWallet, error) {
+	wallets, err := bot.userWallets(user)
+	if err != nil {
+		return lnbits.UserWallet{}, err
+	}
+	if len(wallets) >= maxUserWallets {
+		return lnbits.UserWallet{}, fmt.Errorf("too many wallets")
+	}
+	wallet, err := bot.Client.CreateWallet(user.ID, fmt.Sprintf("%d (%s) %s", user.Telegram.ID, GetUserStr(user.Telegram), name), internal.Configuration.Lnbits.AdminId)
+	if err != nil {
+		return lnbits.UserWallet{}, err
+	}
+	w := lnbits.UserWallet{
+		ID:        wallet.ID,
+		Owner:     user.Name,
+		Name:      name,
+		Adminkey:  wallet.Adminkey,
+		Inkey:     wallet.Inkey,
+		CreatedAt: time.Now(),
+	}
+	return w, bot.DB.Users.Create(&w).Error
+}
+
+func (bot *TipBot) walletsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	args := strings.Fields(m.Text)
+	if len(args) > 1 {
+		switch strings.ToLower(args[1]) {
+		case "add":
+			return bot.addWalletHandler(ctx, args[2:])
+		case "use", "switch":
+			return bot.useWalletHandler(ctx, args[2:])
+		case "rename":
+			return bot.renameWalletHandler(ctx, args[2:])
+		case "move":
+			return bot.moveWalletHandler(ctx, args[2:])
+		case "link":
+			return bot.linkWalletHandler(ctx, args[2:])
+		case "lnurl":
+			return bot.lnurlWalletHandler(ctx, args[2:])
+		case "help":
+			bot.trySendMessage(m.Sender, walletsHelpMessage)
+			return ctx, nil
+		}
+	}
+	return bot.listWalletsHandler(ctx)
+}
+
+func (bot *TipBot) listWalletsHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	wallets, err := bot.userWallets(user)
+	if err != nil {
+		log.Errorf("[/wallets] could not load wallets of %s: %v", GetUserStr(user.Telegram), err)
+		bot.trySendMessage(m.Sender, walletsError)
+		return ctx, err
+	}
+	lines := make([]string, 0, len(wallets))
+	for _, w := range wallets {
+		var balance int64
+		if info, err := bot.Client.Info(*w.Wallet()); err == nil {
+			balance = info.Balance / 1000
+		} else {
+			log.Warnf("[/wallets] could not load balance of wallet %s: %v", w.ID, err)
+		}
+		mark := "▫️"
+		if w.ID == user.Wallet.ID {
+			mark = "👉"
+		}
+		lines = append(lines, fmt.Sprintf(walletsLineMessage, mark, w.Name, balance, bot.walletLightningAddress(user, w.Name)))
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(walletsListMessage, strings.Join(lines, "\n"))+"\n\n"+walletsHelpMessage)
+	return ctx, nil
+}
+
+func (bot *TipBot) addWalletHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if len(args) < 1 {
+		bot.trySendMessage(m.Sender, walletsHelpMessage)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	name := strings.ToLower(args[0])
+	if !walletNameRegex.MatchString(name) {
+		bot.trySendMessage(m.Sender, walletsInvalidName)
+		return ctx, fmt.Errorf("invalid wallet name %s", name)
+	}
+	if _, err := bot.findUserWallet(user, name); err == nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(walletsNameTaken, name))
+		return ctx, fmt.Errorf("wallet %s exists", name)
+	}
+	wallet, err := bot.CreateUserWallet(user, name)
+	if err != nil {
+		log.Errorf("[/wallets] could not create wallet %s for %s: %v", name, GetUserStr(user.Telegram), err)
+		if err.Error() == "too many wallets" {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(walletsTooMany, maxUserWallets))
+		} else {
+			bot.trySendMessage(m.Sender, walletsError)
+		}
+		return ctx, err
+	}
+	log.Infof("[/wallets] %s created wallet %s (%s)", GetUserStr(user.Telegram), name, wallet.ID)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(walletsCreatedMessage, name, bot.walletLightningAddress(user, name), name))
+	return ctx, nil
+}
+
+func (bot *TipBot) useWalletHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if len(args) < 1 {
+		bot.trySendMessage(m.Sender, walletsHelpMessage)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	wallet, err := bot.findUserWallet(user, args[0])
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(walletsNotFound, args[0]))
+		return ctx, err
+	}
+	// no payment can run on the active wallet while it changes
+	unlock := lockWallets(user)
+	defer unlock()
+	user.Wallet = wallet.Wallet()
+	if _, err := bot.GetUserBalance(user); err != nil {
+		bot.trySendMessage(m.Sender, walletsError)
+		return ctx, err
+	}
+	log.Infof("[/wallets] %s switched to wallet %s", GetUserStr(user.Telegram), wallet.Name)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(walletsActiveMessage, wallet.Name))
+	return ctx, nil
+}
+
+func (bot *TipBot) renameWalletHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if len(args) < 2 {
+		bot.trySendMessage(m.Sender, walletsHelpMessage)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	wallet, err := bot.findUserWallet(user, args[0])
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(walletsNotFound, args[0]))
+		return ctx, err
+	}
+	name := strings.ToLower(args[1])
+	if !walletNameRegex.MatchString(name) {
+		bot.trySendMessage(m.Sender, walletsInvalidName)
+		return ctx, fmt.Errorf("invalid wallet name %s", name)
+	}
+	if _, err := bot.findUserWallet(user, name); err == nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(walletsNameTaken, name))
+		return ctx, fmt.Errorf("wallet %s exists", name)
+	}
+	if err := bot.DB.Users.Model(&lnbits.UserWallet{}).Where("id = ?", wallet.ID).Update("name", name).Error; err != nil {
+		bot.trySendMessage(m.Sender, walletsError)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(walletsRenamedMessage, wallet.Name, name))
+	return ctx, nil
+}
+
+// moveWalletHandler moves sats between two wallets of the user. Moving is not
+// spending, the transfer bypasses the spending limits.
+func (bot *TipBot) moveWalletHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if len(args) < 3 {
+		bot.trySendMessage(m.Sender, walletsHelpMessage)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	amount, err := GetAmount(args[0])
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, walletsMoveInvalid)
+		return ctx, fmt.Errorf("invalid amount %s", args[0])
+	}
+	from, to := *user, *user
+	for i, u := range []*lnbits.User{&from, &to} {
+		wallet, err := bot.findUserWallet(user, args[1+i])
+		if err != nil {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(walletsNotFound, args[1+i]))
+			return ctx, err
+		}
+		u.UseWallet(wallet)
+	}
+	if from.Wallet.ID == to.Wallet.ID {
+		bot.trySendMessage(m.Sender, walletsMoveInvalid)
+		return ctx, fmt.Errorf("same wallet")
+	}
+	balance, err := bot.GetUserBalance(&from)
+	if err != nil || balance < amount {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "insufficientFundsMessage"), balance, amount))
+		return ctx, fmt.Errorf("insufficient balance")
+	}
+	// the move counts against the spending limits like a payment from the source wallet
+	t := NewTransaction(bot, &from, &to, amount, TransactionType("move"))
+	t.Memo = fmt.Sprintf("Move from %s to %s", args[1], args[2])
+	if success, err := t.Send(); !success {
+		log.Errorf("[/wallets] could not move %d sat for %s: %v", amount, GetUserStr(user.Telegram), err)
+		bot.trySendMessage(m.Sender, walletsMoveError)
+		return ctx, err
+	}
+	log.Infof("[/wallets] %s moved %d sat from %s to %s", GetUserStr(user.Telegram), amount, from.Wallet.Name, to.Wallet.Name)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(walletsMovedMessage, amount, from.Wallet.Name, to.Wallet.Name))
+	return ctx, nil
+}
+
+// linkWalletHandler sends the LndHub link of a wallet. Unlike /link, the link
+// stays with the wallet when the user switches to another one.
+func (bot *TipBot) linkWalletHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if len(args) < 1 {
+		bot.trySendMessage(m.Sender, walletsHelpMessage)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	if internal.Configuration.Bot.LNURLHostName == "" {
+		bot.trySendMessage(m.Sender, Translate(ctx, "couldNotLinkMessage"))
+		return ctx, fmt.Errorf("invalid configuration")
+	}
+	wallet, err := bot.findUserWallet(user, args[0])
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(walletsNotFound, args[0]))
+		return ctx, err
+	}
+	name := credentials.WalletName(user.Name, wallet.ID)
+	var credential credentials.Credential
+	if len(args) > 1 && strings.ToLower(args[1]) == "rotate" {
+		credential, err = credentials.Rotate(bot.DB.Users, name)
+		if err == nil {
+			bot.trySendMessage(m.Sender, Translate(ctx, "linkRotatedMessage"))
+		}
+	} else {
+		credential, err = credentials.Ensure(bot.DB.Users, name)
+	}
+	if err != nil {
+		log.Errorf("[/wallets] could not load LndHub credentials of wallet %s: %v", wallet.ID, err)
+		bot.trySendMessage(m.Sender, Translate(ctx, "couldNotLinkMessage"))
+		return ctx, err
+	}
+	return bot.sendLndHubLink(ctx, credential)
+}
+
+func (bot *TipBot) lnurlWalletHandler(ctx intercept.Context, args []string) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if len(args) < 1 {
+		bot.trySendMessage(m.Sender, walletsHelpMessage)
+		return ctx, fmt.Errorf("not enough arguments")
+	}
+	wallet, err := bot.findUserWallet(user, args[0])
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(walletsNotFound, args[0]))
+		return ctx, err
+	}
+	callback := fmt.Sprintf("%s/.well-known/lnurlp/%s+%s", internal.Configuration.Bot.LNURLHostName, user.UUID, wallet.Name)
+	lnurlEncode, err := lnurl.LNURLEncode(callback)
+	if err != nil {
+		return ctx, err
+	}
+	qr, err := qrcode.Encode(lnurlEncode, qrcode.Medium, 256)
+	if err != nil {
+		log.Errorf("[/wallets] Failed to create QR code for LNURL: %v", err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: fmt.Sprintf("`%s`", lnurlEncode)})
+	return ctx, nil
+}
@@ -0,0 +1,411 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Events that can be subscribed to.
+const (
+	EventInvoicePaid  = "invoice.paid"
+	EventPaymentSent  = "payment.sent"
+	EventTipReceived  = "tip.received"
+	EventShopSale     = "shop.sale"
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+var Events = []string{EventInvoicePaid, EventPaymentSent, EventTipReceived, EventShopSale}
+
+// Headers of a delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" with the secret of the endpoint.
+const (
+	HeaderEvent     = "X-LightningTipBot-Event"
+	HeaderDelivery  = "X-LightningTipBot-Delivery"
+	HeaderTimestamp = "X-LightningTipBot-Timestamp"
+	HeaderSignature = "X-LightningTipBot-Signature"
+)
+
+// Endpoint is a URL a user registered for some events.
+type Endpoint struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	Owner     string    `json:"-" gorm:"index"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    string    `json:"events"` // comma separated
+	CreatedAt time.Time `json:"created"`
+}
+
+func (e Endpoint) subscribed(event string) bool {
+	for _, ev := range strings.Split(e.Events, ",") {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is an event for an endpoint. Pending deliveries are the queue,
+// delivered and failed ones are the delivery log.
+type Delivery struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	EndpointID  uint      `json:"endpoint_id" gorm:"index"`
+	Event       string    `json:"event"`
+	Payload     string    `json:"payload"`
+	Status      string    `json:"status" gorm:"index"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt" gorm:"index"`
+	StatusCode  int       `json:"status_code"`
+	LastError   string    `json:"last_error"`
+	CreatedAt   time.Time `json:"created"`
+	UpdatedAt   time.Time `json:"updated"`
+}
+
+type Dispatcher struct {
+	db          *gorm.DB
+	client      *http.Client
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it doubles with every attempt
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is how often the queue is checked for due deliveries
+	PollInterval time.Duration
+	wake         chan struct{}
+}
+
+type Option func(d *Dispatcher)
+
+func WithMaxAttempts(n int) Option {
+	return func(d *Dispatcher) {
+		d.MaxAttempts = n
+	}
+}
+
+func WithBackoff(backoff time.Duration, max time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.Backoff = backoff
+		d.MaxBackoff = max
+	}
+}
+
+func WithPollInterval(interval time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.PollInterval = interval
+	}
+}
+
+// WithHTTPClient replaces the default client, which refuses to connect to
+// private networks. Meant for tests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// MaxEndpoints is the number of endpoints a user can register.
+const MaxEndpoints = 10
+
+// D is the dispatcher of the bot. Publish is a no-op until it is set by New.
+var D *Dispatcher
+
+// New migrates the endpoint and delivery tables in db and sets the dispatcher as D.
+func New(db *gorm.DB, opts ...Option) (*Dispatcher, error) {
+	err := db.AutoMigrate(&Endpoint{}, &Delivery{})
+	if err != nil {
+		return nil, err
+	}
+	d := &Dispatcher{
+		db:           db,
+		client:       newPublicClient(),
+		MaxAttempts:  8,
+		Backoff:      30 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+		PollInterval: 10 * time.Second,
+		wake:         make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	D = d
+	return d, nil
+}
+
+// newPublicClient returns a client that only connects to public addresses, so that
+// webhooks can't be used to reach the admin server or other internal services.
+func newPublicClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Sign returns the signature of a delivery body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateURL checks that a webhook URL is an absolute http(s) URL.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("webhook url must be an absolute http or https url")
+	}
+	return nil
+}
+
+// ValidateEvents checks a list of event names and returns it in the stored format.
+func ValidateEvents(events []string) (string, error) {
+	if len(events) == 0 {
+		return strings.Join(Events, ","), nil
+	}
+	for _, event := range events {
+		known := false
+		for _, e := range Events {
+			known = known || e == event
+		}
+		if !known {
+			return "", fmt.Errorf("unknown event %s", event)
+		}
+	}
+	return strings.Join(events, ","), nil
+}
+
+// Register adds an endpoint for user. The returned endpoint contains the secret.
+func (d *Dispatcher) Register(user string, rawURL string, events []string) (*Endpoint, error) {
+	if err := ValidateURL(rawURL); err != nil {
+		return nil, err
+	}
+	eventList, err := ValidateEvents(events)
+	if err != nil {
+		return nil, err
+	}
+	var count int64
+	if err := d.db.Model(&Endpoint{}).Where("owner = ?", user).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= MaxEndpoints {
+		return nil, fmt.Errorf("you can't register more than %d webhooks", MaxEndpoints)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	endpoint := &Endpoint{Owner: user, URL: rawURL, Secret: hex.EncodeToString(secret), Events: eventList}
+	return endpoint, d.db.Create(endpoint).Error
+}
+
+// Endpoints returns the endpoints of user without their secrets.
+func (d *Dispatcher) Endpoints(user string) ([]Endpoint, error) {
+	endpoints := make([]Endpoint, 0)
+	err := d.db.Where("owner = ?", user).Order("id asc").Find(&endpoints).Error
+	for i := range endpoints {
+		endpoints[i].Secret = ""
+	}
+	return endpoints, err
+}
+
+// Delete removes an endpoint of user and its pending deliveries.
+func (d *Dispatcher) Delete(user string, id uint) error {
+	tx := d.db.Where("id = ? AND owner = ?", id, user).Delete(&Endpoint{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return d.db.Where("endpoint_id = ? AND status = ?", id, DeliveryPending).Delete(&Delivery{}).Error
+}
+
+// Deliveries returns the latest deliveries of an endpoint of user.
+func (d *Dispatcher) Deliveries(user string, id uint, limit int) ([]Delivery, error) {
+	endpoint := Endpoint{}
+	if err := d.db.Where("id = ? AND owner = ?", id, user).First(&endpoint).Error; err != nil {
+		return nil, err
+	}
+	deliveries := make([]Delivery, 0)
+	err := d.db.Where("endpoint_id = ?", id).Order("id desc").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// Publish queues an event for all endpoints of user that subscribed to it.
+func (d *Dispatcher) Publish(user string, event string, data interface{}) error {
+	endpoints := make([]Endpoint, 0)
+	if err := d.db.Where("owner = ?", user).Find(&endpoints).Error; err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event": event,
+		"time":  time.Now().Unix(),
+		"data":  data,
+	})
+	if err != nil {
+		return err
+	}
+	queued := false
+	for _, endpoint := range endpoints {
+		if !endpoint.subscribed(event) {
+			continue
+		}
+		delivery := &Delivery{EndpointID: endpoint.ID, Event: event, Payload: string(payload), Status: DeliveryPending, NextAttempt: time.Now()}
+		if err := d.db.Create(delivery).Error; err != nil {
+			return err
+		}
+		queued = true
+	}
+	if queued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Publish queues an event on D. Errors are logged, webhooks must never stop the
+// action that triggered them.
+func Publish(user string, event string, data interface{}) {
+	if D == nil {
+		return
+	}
+	if err := D.Publish(user, event, data); err != nil {
+		log.Errorf("[webhooks] could not queue %s for %s: %v", event, user, err)
+	}
+}
+
+// Start delivers queued events until ctx is done. Deliveries that were
+// pending before a restart are picked up again.
+func (d *Dispatcher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(d.PollInterval)
+		defer ticker.Stop()
+		for {
+			d.deliverDue()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-d.wake:
+			}
+		}
+	}()
+}
+
+// deliverDue sends all pending deliveries whose next attempt is due.
+func (d *Dispatcher) deliverDue() {
+	due := make([]Delivery, 0)
+	err := d.db.Where("status = ? AND next_attempt <= ?", DeliveryPending, time.Now()).
+		Order("id asc").Limit(100).Find(&due).Error
+	if err != nil {
+		log.Errorf("[webhooks] could not load queue: %v", err)
+		return
+	}
+	for i := range due {
+		d.deliver(&due[i])
+	}
+}
+
+func (d *Dispatcher) deliver(delivery *Delivery) {
+	endpoint := Endpoint{}
+	if err := d.db.First(&endpoint, delivery.EndpointID).Error; err != nil {
+		delivery.Status, delivery.LastError = DeliveryFailed, "endpoint deleted"
+		d.db.Save(delivery)
+		return
+	}
+	delivery.Attempts++
+	delivery.StatusCode, delivery.LastError = 0, ""
+	err := d.post(endpoint, delivery)
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryDelivered
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status, delivery.LastError = DeliveryFailed, err.Error()
+		log.Warnf("[webhooks] giving up delivery %d to %s: %v", delivery.ID, endpoint.URL, err)
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttempt = time.Now().Add(d.backoff(delivery.Attempts))
+		log.Debugf("[webhooks] delivery %d to %s failed, retry at %s: %v", delivery.ID, endpoint.URL, delivery.NextAttempt, err)
+	}
+	if err := d.db.Save(delivery).Error; err != nil {
+		log.Errorf("[webhooks] could not update delivery %d: %v", delivery.ID, err)
+	}
+}
+
+func (d *Dispatcher) post(endpoint Endpoint, delivery *Delivery) error {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
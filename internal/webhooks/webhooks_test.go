@@ -0,0 +1,93 @@
+package webhooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(db, WithHTTPClient(http.DefaultClient), WithBackoff(time.Minute, time.Hour), WithMaxAttempts(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	d := newTestDispatcher(t)
+	fail := true
+	var endpoint *Endpoint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if r.Header.Get(HeaderSignature) != Sign(endpoint.Secret, timestamp, body) {
+			t.Errorf("invalid signature")
+		}
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	var err error
+	endpoint, err = d.Register("1", server.URL, []string{EventTipReceived})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Publish("1", EventTipReceived, map[string]int64{"amount": 21}); err != nil {
+		t.Fatal(err)
+	}
+	// not subscribed
+	if err := d.Publish("1", EventShopSale, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	d.deliverDue()
+	deliveries, _ := d.Deliveries("1", endpoint.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != DeliveryPending || deliveries[0].Attempts != 1 {
+		t.Fatalf("after failed attempt: %+v", deliveries)
+	}
+	if !deliveries[0].NextAttempt.After(time.Now()) {
+		t.Errorf("retry must be delayed")
+	}
+
+	// make the retry due
+	d.db.Model(&Delivery{}).Where("id = ?", deliveries[0].ID).Update("next_attempt", time.Now().Add(-time.Second))
+	fail = false
+	d.deliverDue()
+	deliveries, _ = d.Deliveries("1", endpoint.ID, 10)
+	if deliveries[0].Status != DeliveryDelivered || deliveries[0].StatusCode != http.StatusOK {
+		t.Errorf("after successful attempt: %+v", deliveries[0])
+	}
+	if _, err := d.Deliveries("2", endpoint.ID, 10); err == nil {
+		t.Errorf("other users must not see the deliveries")
+	}
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	d := &Dispatcher{Backoff: time.Minute, MaxBackoff: 5 * time.Minute}
+	for attempts, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute, 10: 5 * time.Minute} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestPublicClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	if _, err := newPublicClient().Get(server.URL); err == nil {
+		t.Errorf("loopback addresses must be refused")
+	}
+}
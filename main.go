@@ -3,6 +3,8 @@ package main
 import (
 	"net/http"
 	"runtime/debug"
+	"strings"
+	"time"
 
 	"github.com/LightningTipBot/LightningTipBot/internal"
 	"github.com/LightningTipBot/LightningTipBot/internal/api"
@@ -37,11 +39,44 @@ func main() {
 	setLogger()
 
 	defer withRecovery()
-	price.NewPriceWatcher().Start()
+	price.NewPriceWatcher(priceWatcherOptions()...).Start()
 	bot := telegram.NewBot()
 	startApiServer(&bot)
 	bot.Start()
 }
+
+// priceWatcherOptions configures the price watcher from the price section of config.yaml
+func priceWatcherOptions() []price.Option {
+	config := internal.Configuration.Price
+	sources := make([]price.PriceSource, 0, len(config.Sources))
+	for _, name := range config.Sources {
+		switch name {
+		case "coinbase":
+			sources = append(sources, price.NewCoinbaseSource())
+		case "bitfinex":
+			sources = append(sources, price.NewBitfinexSource())
+		case "static":
+			sources = append(sources, price.NewStaticSource(config.Static))
+		default:
+			log.Warnf("[PriceWatcher] unknown price source %s", name)
+		}
+	}
+	opts := []price.Option{
+		price.WithSources(sources...),
+		price.WithUpdateInterval(time.Second * time.Duration(config.UpdateInterval)),
+		price.WithMaxAge(time.Second * time.Duration(config.MaxAge)),
+		price.WithMaxDeviation(config.MaxDeviation),
+	}
+	if len(config.Currencies) > 0 {
+		currencies := make([]price.Currency, 0, len(config.Currencies))
+		for _, c := range config.Currencies {
+			currencies = append(currencies, price.Currency{Code: strings.ToUpper(c.Code), Symbol: c.Symbol})
+		}
+		opts = append(opts, price.WithCurrencies(currencies))
+	}
+	return opts
+}
+
 func startApiServer(bot *telegram.TipBot) {
 	// log errors from interceptors
 	bot.Telegram.OnError = func(err error, ctx tb.Context) {
@@ -66,9 +101,17 @@ func startApiServer(bot *telegram.TipBot) {
 
 	// append lndhub ctx functions
 	hub := lndhub.New(bot)
-	s.AppendAuthorizedRoute(`/lndhub/ext/auth`, api.AuthTypeNone, api.AccessKeyTypeNone, bot.DB.Users, hub.Handle)
-	s.AppendAuthorizedRoute(`/lndhub/ext/{.*}`, api.AuthTypeBearerBase64, api.AccessKeyTypeAdmin, bot.DB.Users, hub.Handle)
-	s.AppendAuthorizedRoute(`/lndhub/ext`, api.AuthTypeBearerBase64, api.AccessKeyTypeAdmin, bot.DB.Users, hub.Handle)
+	s.AppendRoute(`/lndhub/ext/auth`, hub.Auth, http.MethodPost)
+	s.AppendRoute(`/lndhub/ext/getinfo`, hub.Authorized(hub.GetInfo), http.MethodGet)
+	s.AppendRoute(`/lndhub/ext/balance`, hub.Authorized(hub.Balance), http.MethodGet)
+	s.AppendRoute(`/lndhub/ext/addinvoice`, hub.Authorized(hub.AddInvoice), http.MethodPost)
+	s.AppendRoute(`/lndhub/ext/payinvoice`, hub.Authorized(hub.PayInvoice), http.MethodPost)
+	s.AppendRoute(`/lndhub/ext/gettxs`, hub.Authorized(hub.GetTxs), http.MethodGet)
+	s.AppendRoute(`/lndhub/ext/getuserinvoices`, hub.Authorized(hub.GetUserInvoices), http.MethodGet)
+	s.AppendRoute(`/lndhub/ext/decodeinvoice`, hub.Authorized(hub.DecodeInvoice), http.MethodGet)
+	s.AppendRoute(`/lndhub/ext/checkpayment/{payment_hash}`, hub.Authorized(hub.CheckPayment), http.MethodGet)
+	s.AppendRoute(`/lndhub/ext/getbtc`, hub.Authorized(hub.GetBtc), http.MethodGet)
+	s.AppendRoute(`/lndhub/ext/getpending`, hub.Authorized(hub.GetPending), http.MethodGet)
 
 	// starting api service
 	apiService := api.Service{Bot: bot}
@@ -76,19 +119,31 @@ func startApiServer(bot *telegram.TipBot) {
 	s.AppendAuthorizedRoute(`/api/v1/invoicestatus/{payment_hash}`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.InvoiceStatus, http.MethodPost)
 	s.AppendAuthorizedRoute(`/api/v1/payinvoice`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.PayInvoice, http.MethodPost)
 	s.AppendAuthorizedRoute(`/api/v1/invoicestream`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.InvoiceStream, http.MethodGet)
-	s.AppendAuthorizedRoute(`/api/v1/createinvoice`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.CreateInvoice, http.MethodPost)
+	s.AppendAuthorizedRoute(`/api/v1/events`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.EventStream, http.MethodGet)
+	s.AppendAuthorizedRoute(`/api/v1/createinvoice`, api.AuthTypeBasic, api.AccessKeyTypeReceive, bot.DB.Users, apiService.CreateInvoice, http.MethodPost)
 	s.AppendAuthorizedRoute(`/api/v1/balance`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.Balance, http.MethodGet)
+	s.AppendAuthorizedRoute(`/api/v1/export`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.Export, http.MethodGet)
+	s.AppendAuthorizedRoute(`/api/v1/webhooks`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.RegisterWebhook, http.MethodPost)
+	s.AppendAuthorizedRoute(`/api/v1/webhooks`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.ListWebhooks, http.MethodGet)
+	s.AppendAuthorizedRoute(`/api/v1/webhooks/{id}`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.DeleteWebhook, http.MethodDelete)
+	s.AppendAuthorizedRoute(`/api/v1/webhooks/{id}/deliveries`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.WebhookDeliveries, http.MethodGet)
 
 	// start internal admin server
 	adminService := admin.New(bot)
 	internalAdminServer := api.NewServer(internal.Configuration.Bot.AdminAPIHost)
-	internalAdminServer.AppendRoute("/mutex", mutex.ServeHTTP)
-	internalAdminServer.AppendRoute("/mutex/unlock/{id}", mutex.UnlockHTTP)
-	internalAdminServer.AppendRoute("/admin/ban/{id}", adminService.BanUser)
-	internalAdminServer.AppendRoute("/admin/unban/{id}", adminService.UnbanUser)
-	internalAdminServer.AppendRoute("/admin/dalle/enable", adminService.EnableDalle)
-	internalAdminServer.AppendRoute("/admin/dalle/disable", adminService.DisableDalle)
-	internalAdminServer.PathPrefix("/debug/pprof/", http.DefaultServeMux)
+	internalAdminServer.AppendAdminRoute("/mutex", api.AdminScopeRead, mutex.ServeHTTP)
+	internalAdminServer.AppendAdminRoute("/mutex/unlock/{id}", api.AdminScopeModeration, mutex.UnlockHTTP)
+	internalAdminServer.AppendAdminRoute("/admin/ban/{id}", api.AdminScopeModeration, adminService.BanUser)
+	internalAdminServer.AppendAdminRoute("/admin/unban/{id}", api.AdminScopeModeration, adminService.UnbanUser)
+	internalAdminServer.AppendAdminRoute("/admin/dalle/enable", api.AdminScopeModeration, adminService.EnableDalle)
+	internalAdminServer.AppendAdminRoute("/admin/dalle/disable", api.AdminScopeModeration, adminService.DisableDalle)
+	internalAdminServer.AppendAdminRoute("/admin/users", api.AdminScopeRead, adminService.LookupUser, http.MethodGet)
+	internalAdminServer.AppendAdminRoute("/admin/users/{id}/transactions", api.AdminScopeRead, adminService.UserTransactions, http.MethodGet)
+	internalAdminServer.AppendAdminRoute("/admin/users/{id}/state/reset", api.AdminScopeModeration, adminService.ResetUserState, http.MethodPost)
+	internalAdminServer.AppendAdminRoute("/admin/users/{id}/balance", api.AdminScopeFunds, adminService.AdjustBalance, http.MethodPost)
+	internalAdminServer.AppendAdminRoute("/admin/audit", api.AdminScopeRead, adminService.AuditLog, http.MethodGet)
+	internalAdminServer.AppendAdminRoute("/admin/audit/verify", api.AdminScopeRead, adminService.VerifyAuditLog, http.MethodGet)
+	internalAdminServer.AdminPathPrefix("/debug/pprof/", api.AdminScopeRead, http.DefaultServeMux)
 
 }
 